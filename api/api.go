@@ -1,14 +1,21 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/auth"
 	"github.com/explodes/greenhouse-pi/controllers"
 	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/monitor"
 	"github.com/explodes/greenhouse-pi/sensors"
 	"github.com/explodes/greenhouse-pi/stats"
+	"github.com/explodes/greenhouse-pi/usage"
 	"github.com/gorilla/mux"
 )
 
@@ -16,6 +23,14 @@ const (
 	rwTimeout   = 15 * time.Second
 	idleTimeout = 60 * time.Second
 
+	defaultAuditPeriod = 24 * time.Hour
+
+	// maxImportSize is the largest request body Import accepts
+	maxImportSize = 64 << 20
+
+	// maxIngestSize is the largest request body Ingest accepts
+	maxIngestSize = 1 << 20
+
 	internalServerErrorMessage = `{"error":"internal server error"}`
 )
 
@@ -36,6 +51,35 @@ type Api struct {
 	Fan         *controllers.Controller
 	Thermometer sensors.Thermometer
 	Hygrometer  sensors.Hygrometer
+
+	// LightSensor is optional, if set its readings are reported by Status
+	LightSensor sensors.LightSensor
+
+	// CO2Sensor is optional, if set its readings are reported by Status
+	CO2Sensor sensors.CO2Sensor
+
+	// FlowMeter is optional, if set its readings are reported by Status
+	FlowMeter sensors.FlowMeter
+
+	// Usage accounts for how long units were on
+	Usage *usage.Accountant
+
+	// Backups is optional, if set backups can be made on demand
+	Backups *stats.Backups
+
+	// Watchdog is optional, if set its heartbeat is reported by Status
+	Watchdog *controllers.Watchdog
+
+	// Remote is optional, if set remote devices may submit
+	// readings to Ingest and are reported by Status
+	Remote *monitor.Remote
+
+	// Keys is optional, if set every request must be authenticated
+	Keys *auth.KeyStore
+
+	mu      sync.Mutex
+	servers []*http.Server
+	closed  bool
 }
 
 // KnownStat is a stats.Stat but we know what stats.StatType it is already
@@ -44,6 +88,15 @@ type KnownStat struct {
 	Value float64   `json:"value"`
 }
 
+// KnownAggregate is a stats.Aggregate but we know what stats.StatType it is already
+type KnownAggregate struct {
+	Start time.Time `json:"start"`
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"`
+}
+
 type varsHandler func(w http.ResponseWriter, r *http.Request, vars map[string]string)
 
 func (vh varsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -60,21 +113,94 @@ func New(storage stats.Storage, water, fan *controllers.Controller, thermometer
 		Fan:         fan,
 		Thermometer: thermometer,
 		Hygrometer:  hygrometer,
+		Usage:       usage.NewAccountant(storage),
 	}
 }
 
+// Handler returns the http.Handler serving this Api
+func (api *Api) Handler() http.Handler {
+	router := mux.NewRouter()
+	router.Methods(http.MethodGet).Path("/{stat}/history/{start}/{end}").Handler(api.require(auth.RoleViewer, varsHandler(api.History)))
+	router.Methods(http.MethodGet).Path("/{stat}/history/{start}/{end}/{bucket}").Handler(api.require(auth.RoleViewer, varsHandler(api.AggregatedHistory)))
+	router.Methods(http.MethodGet).Path("/{stat}/latest").Handler(api.require(auth.RoleViewer, varsHandler(api.Latest)))
+	router.Methods(http.MethodGet).Path("/{unit}/usage").Handler(api.require(auth.RoleViewer, varsHandler(api.UnitUsage)))
+	router.Methods(http.MethodGet).Path("/status").Handler(api.require(auth.RoleViewer, varsHandler(api.Status)))
+	router.Methods(http.MethodPost).Path("/{stat}/schedule/{start}/{end}").Handler(api.require(auth.RoleOperator, varsHandler(api.Schedule)))
+	router.Methods(http.MethodPost).Path("/{stat}/on/{end}").Handler(api.require(auth.RoleOperator, varsHandler(api.On)))
+	router.Methods(http.MethodPost).Path("/{stat}/off").Handler(api.require(auth.RoleOperator, varsHandler(api.Off)))
+	router.Methods(http.MethodGet).Path("/logs/{level}/{start}/{end}").Handler(api.require(auth.RoleViewer, varsHandler(api.Logs)))
+	router.Methods(http.MethodGet).Path("/export/stats").Handler(api.require(auth.RoleViewer, varsHandler(api.ExportStats)))
+	router.Methods(http.MethodGet).Path("/export/logs").Handler(api.require(auth.RoleViewer, varsHandler(api.ExportLogs)))
+	router.Methods(http.MethodPost).Path("/ingest").Handler(api.require(auth.RoleDevice, varsHandler(api.Ingest)))
+	router.Methods(http.MethodPost).Path("/import").Handler(api.require(auth.RoleAdmin, varsHandler(api.Import)))
+	router.Methods(http.MethodGet).Path("/audit").Handler(api.require(auth.RoleViewer, varsHandler(api.Audit)))
+	router.Methods(http.MethodGet).Path("/admin/keys").Handler(api.require(auth.RoleAdmin, varsHandler(api.ListKeys)))
+	router.Methods(http.MethodPost).Path("/admin/backup").Handler(api.require(auth.RoleAdmin, varsHandler(api.Backup)))
+	router.Methods(http.MethodPost).Path("/admin/co2/calibrate").Handler(api.require(auth.RoleAdmin, varsHandler(api.CalibrateCO2)))
+
+	return WrapHandlerInMiddleware(router, CORSMiddleware, CompressMiddleware, JSONContentTypeMiddleware, LoggingMiddleware, RecoveryMiddleware(internalServerErrorMessage), DeadlineMiddleware)
+}
+
 // Serve will run this server and bind to the given address
 func (api *Api) Serve(bind string) error {
+	srv, err := api.newServer(bind, api.Handler())
+	if err != nil {
+		return err
+	}
 
-	router := mux.NewRouter()
-	router.Methods(http.MethodGet).Path("/{stat}/history/{start}/{end}").Handler(varsHandler(api.History))
-	router.Methods(http.MethodGet).Path("/{stat}/latest").Handler(varsHandler(api.Latest))
-	router.Methods(http.MethodGet).Path("/status").Handler(varsHandler(api.Status))
-	router.Methods(http.MethodPost).Path("/{stat}/schedule/{start}/{end}").Handler(varsHandler(api.Status))
-	router.Methods(http.MethodGet).Path("/logs/{level}/{start}/{end}").Handler(varsHandler(api.Logs))
+	if _, err := api.Storage.Log(logging.LevelInfo, "serving on %s", bind); err != nil {
+		return fmt.Errorf("error logging server startup: %v", err)
+	}
 
-	handler := WrapHandlerInMiddleware(router, CORSMiddleware, CompressMiddleware, JSONContentTypeMiddleware, LoggingMiddleware, RecoveryMiddleware(internalServerErrorMessage))
+	return srv.ListenAndServe()
+}
 
+// ServeTLS will run this server over TLS and bind to the given address.
+// The certificate and key are reloaded whenever either file changes.
+func (api *Api) ServeTLS(bind, certFile, keyFile string) error {
+	certs, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	srv, err := api.newServer(bind, api.Handler())
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if _, err := api.Storage.Log(logging.LevelInfo, "serving tls on %s", bind); err != nil {
+		return fmt.Errorf("error logging server startup: %v", err)
+	}
+
+	return srv.ListenAndServeTLS("", "")
+}
+
+// ServeRedirect will redirect plain HTTP requests on the given
+// address to HTTPS on the port of the given TLS address
+func (api *Api) ServeRedirect(bind, tlsBind string) error {
+	_, port, err := net.SplitHostPort(tlsBind)
+	if err != nil {
+		return fmt.Errorf("invalid tls address %s: %v", tlsBind, err)
+	}
+
+	srv, err := api.newServer(bind, LoggingMiddleware(RedirectHandler(port)))
+	if err != nil {
+		return err
+	}
+
+	if _, err := api.Storage.Log(logging.LevelInfo, "redirecting %s to https port %s", bind, port); err != nil {
+		return fmt.Errorf("error logging redirect startup: %v", err)
+	}
+
+	return srv.ListenAndServe()
+}
+
+// newServer creates an http.Server that will be stopped by Shutdown
+func (api *Api) newServer(bind string, handler http.Handler) (*http.Server, error) {
 	srv := &http.Server{
 		Handler:      handler,
 		Addr:         bind,
@@ -83,9 +209,30 @@ func (api *Api) Serve(bind string) error {
 		IdleTimeout:  idleTimeout,
 	}
 
-	if _, err := api.Storage.Log(logging.LevelInfo, "serving on %s", bind); err != nil {
-		return fmt.Errorf("error logging server startup: %v", err)
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	if api.closed {
+		return nil, http.ErrServerClosed
 	}
+	api.servers = append(api.servers, srv)
+	return srv, nil
+}
 
-	return srv.ListenAndServe()
+// Shutdown stops accepting new connections and waits for
+// active requests to complete or for the context to expire.
+// Serve will return http.ErrServerClosed once this is called.
+func (api *Api) Shutdown(ctx context.Context) error {
+	api.mu.Lock()
+	servers := api.servers
+	api.closed = true
+	api.mu.Unlock()
+
+	var firstErr error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/explodes/greenhouse-pi/auth"
+	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/logging"
+)
+
+const (
+	headerAuthorization   = "Authorization"
+	headerWWWAuthenticate = "WWW-Authenticate"
+	bearerPrefix          = "Bearer "
+	basicRealm            = `Basic realm="greenhouse"`
+	anonymous             = "anonymous"
+
+	unauthorizedMessage = `{"error":"unauthorized"}`
+	forbiddenMessage    = `{"error":"forbidden"}`
+)
+
+// authenticate returns the Key for the credentials of a request,
+// given either as a bearer token or as HTTP basic credentials
+func (api *Api) authenticate(r *http.Request) (auth.Key, error) {
+	if name, token, ok := r.BasicAuth(); ok {
+		return api.Keys.AuthenticateBasic(name, token)
+	}
+	header := r.Header.Get(headerAuthorization)
+	if strings.HasPrefix(header, bearerPrefix) {
+		return api.Keys.Authenticate(strings.TrimPrefix(header, bearerPrefix))
+	}
+	return auth.Key{}, auth.ErrInvalidKey
+}
+
+// require only serves requests authenticated with at least the
+// given role, and audits mutating requests, including those that are
+// rejected. If the Api has no Keys, authentication is disabled and
+// every request is served.
+func (api *Api) require(role auth.Role, handler http.Handler) http.Handler {
+	authorized := func(w http.ResponseWriter, r *http.Request) {
+		if api.Keys == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		key, ok := auth.KeyFromContext(r.Context())
+		if !ok {
+			w.Header().Set(headerWWWAuthenticate, basicRealm)
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(unauthorizedMessage))
+			return
+		}
+		if !key.Role.Allows(role) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(forbiddenMessage))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}
+	audited := AuditMiddleware(api.Logger)(http.HandlerFunc(authorized))
+
+	// the key is found before auditing so that the audit log says who was rejected
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if api.Keys != nil {
+			key, err := api.authenticate(r)
+			if err == nil {
+				r = r.WithContext(auth.WithKey(r.Context(), key))
+			} else if err != auth.ErrInvalidKey {
+				log.Printf("error authenticating request: %v", err)
+			}
+		}
+		audited.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(handlerFunc)
+}
+
+// clientIdentity describes who made a request
+func clientIdentity(r *http.Request) string {
+	if key, ok := auth.KeyFromContext(r.Context()); ok {
+		return fmt.Sprintf("%s (%s)", key.Name, key.Role)
+	}
+	return anonymous
+}
+
+// apiCause is the audit trail cause of a command given by a request
+func apiCause(r *http.Request) controllers.Cause {
+	return controllers.APICause(fmt.Sprintf("%s from %s", clientIdentity(r), r.RemoteAddr))
+}
+
+// AuditMiddleware records every request that may change
+// the state of the system, who made it and its outcome
+func AuditMiddleware(logger logging.Logger) Middleware {
+	return func(fn http.Handler) http.Handler {
+		handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				fn.ServeHTTP(w, r)
+				return
+			}
+			recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			fn.ServeHTTP(recorder, r)
+			if _, err := logger.Log(logging.LevelInfo, "audit: %s %s by %s from %s: %d", r.Method, r.URL, clientIdentity(r), r.RemoteAddr, recorder.status); err != nil {
+				log.Printf("error writing audit log: %v", err)
+			}
+		}
+		return http.HandlerFunc(handlerFunc)
+	}
+}
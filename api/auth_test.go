@@ -0,0 +1,260 @@
+package api_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/api"
+	"github.com/explodes/greenhouse-pi/auth"
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/sensors"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+type authFixture struct {
+	api      *api.Api
+	handler  http.Handler
+	viewer   string
+	operator string
+	admin    string
+	device   string
+}
+
+func (f *authFixture) request(method, url, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, url, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	return w
+}
+
+func apiAuthTest(f func(t *testing.T, fixture *authFixture)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		keys, err := auth.OpenKeyStore(filepath.Join(dir, "keys.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fixture := &authFixture{}
+		for _, k := range []struct {
+			token *string
+			role  auth.Role
+		}{
+			{&fixture.viewer, auth.RoleViewer},
+			{&fixture.operator, auth.RoleOperator},
+			{&fixture.admin, auth.RoleAdmin},
+			{&fixture.device, auth.RoleDevice},
+		} {
+			if *k.token, err = keys.Create(k.role.String(), k.role); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		scheduler := controllers.NewScheduler()
+		defer scheduler.CancelAll()
+		storage := stats.NewFakeStatsStorage(10)
+		defer storage.Close()
+
+		water, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeWater), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fan, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeFan), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		therm := sensors.NewFakeThermometer(time.Hour, clock.Real)
+		defer therm.Close()
+
+		hygro := sensors.NewFakeHygrometer(time.Minute, clock.Real)
+		defer hygro.Close()
+
+		fixture.api = api.New(storage, water, fan, therm, hygro)
+		fixture.api.Keys = keys
+		fixture.handler = fixture.api.Handler()
+
+		f(t, fixture)
+	}
+
+	return name, testFunc
+}
+
+func TestApiAuth(t *testing.T) {
+	t.Parallel()
+	t.Run("Auth", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiAuthTest(auth_Unauthenticated))
+		t.Run(apiAuthTest(auth_InvalidToken))
+		t.Run(apiAuthTest(auth_Viewer))
+		t.Run(apiAuthTest(auth_Operator))
+		t.Run(apiAuthTest(auth_Admin))
+		t.Run(apiAuthTest(auth_Device))
+		t.Run(apiAuthTest(auth_Basic))
+		t.Run(apiAuthTest(auth_Audit))
+		t.Run(apiAuthTest(auth_AuditRejected))
+		t.Run(apiAuthTest(auth_Disabled))
+	})
+}
+
+func auth_Unauthenticated(t *testing.T, f *authFixture) {
+	w := f.request(http.MethodGet, "/status", "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("missing WWW-Authenticate header")
+	}
+}
+
+func auth_InvalidToken(t *testing.T, f *authFixture) {
+	w := f.request(http.MethodGet, "/status", "not-a-key")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+}
+
+func auth_Viewer(t *testing.T, f *authFixture) {
+	if w := f.request(http.MethodGet, "/status", f.viewer); w.Code != http.StatusOK {
+		t.Errorf("viewer could not read status: %d", w.Code)
+	}
+	if w := f.request(http.MethodPost, "/water/off", f.viewer); w.Code != http.StatusForbidden {
+		t.Errorf("viewer controlled a unit: %d", w.Code)
+	}
+	if w := f.request(http.MethodGet, "/admin/keys", f.viewer); w.Code != http.StatusForbidden {
+		t.Errorf("viewer used an admin endpoint: %d", w.Code)
+	}
+}
+
+func auth_Operator(t *testing.T, f *authFixture) {
+	if w := f.request(http.MethodPost, "/water/off", f.operator); w.Code != http.StatusNoContent {
+		t.Errorf("operator could not control a unit: %d", w.Code)
+	}
+	if w := f.request(http.MethodGet, "/admin/keys", f.operator); w.Code != http.StatusForbidden {
+		t.Errorf("operator used an admin endpoint: %d", w.Code)
+	}
+}
+
+func auth_Admin(t *testing.T, f *authFixture) {
+	w := f.request(http.MethodGet, "/admin/keys", f.admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("admin could not list keys: %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, role := range []string{"viewer", "operator", "admin"} {
+		if !strings.Contains(body, role) {
+			t.Errorf("%s missing from %s", role, body)
+		}
+	}
+	if strings.Contains(body, "hash") {
+		t.Errorf("key hashes exposed: %s", body)
+	}
+}
+
+func auth_Device(t *testing.T, f *authFixture) {
+	if w := f.request(http.MethodPost, "/ingest", f.device); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+		t.Errorf("device could not submit readings: %d", w.Code)
+	}
+	if w := f.request(http.MethodPost, "/ingest", f.operator); w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+		t.Errorf("operator could not submit readings: %d", w.Code)
+	}
+	if w := f.request(http.MethodPost, "/ingest", f.viewer); w.Code != http.StatusForbidden {
+		t.Errorf("viewer submitted readings: %d", w.Code)
+	}
+	if w := f.request(http.MethodPost, "/water/on/1h", f.device); w.Code != http.StatusForbidden {
+		t.Errorf("device controlled a unit: %d", w.Code)
+	}
+	if w := f.request(http.MethodGet, "/status", f.device); w.Code != http.StatusForbidden {
+		t.Errorf("device read status: %d", w.Code)
+	}
+}
+
+func auth_Basic(t *testing.T, f *authFixture) {
+	r := httptest.NewRequest(http.MethodPost, "/water/off", nil)
+	r.SetBasicAuth("operator", f.operator)
+	w := httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("basic authentication failed: %d", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/water/off", nil)
+	r.SetBasicAuth("admin", f.operator)
+	w = httptest.NewRecorder()
+	f.handler.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("basic authentication with the wrong name succeeded: %d", w.Code)
+	}
+}
+
+func auth_Audit(t *testing.T, f *authFixture) {
+	f.request(http.MethodGet, "/status", f.operator)
+	f.request(http.MethodPost, "/water/off", f.operator)
+
+	logs, err := f.api.Storage.Logs(logging.LevelDebug, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audits := 0
+	for _, entry := range logs {
+		if strings.HasPrefix(entry.Message, "audit:") {
+			audits++
+			if !strings.Contains(entry.Message, "POST /water/off by operator (operator)") {
+				t.Errorf("unexpected audit entry: %s", entry.Message)
+			}
+		}
+	}
+	if audits != 1 {
+		t.Errorf("unexpected number of audit entries: %d", audits)
+	}
+}
+
+func auth_AuditRejected(t *testing.T, f *authFixture) {
+	f.request(http.MethodPost, "/water/on/1h", "")
+	f.request(http.MethodPost, "/water/on/1h", f.viewer)
+
+	logs, err := f.api.Storage.Logs(logging.LevelDebug, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audits []string
+	for _, entry := range logs {
+		if strings.HasPrefix(entry.Message, "audit:") {
+			audits = append(audits, entry.Message)
+		}
+	}
+	if len(audits) != 2 {
+		t.Fatalf("unexpected audit entries: %v", audits)
+	}
+	if !strings.Contains(audits[0], "POST /water/on/1h by anonymous") || !strings.HasSuffix(audits[0], ": 401") {
+		t.Errorf("unexpected audit entry: %s", audits[0])
+	}
+	if !strings.Contains(audits[1], "POST /water/on/1h by viewer (viewer)") || !strings.HasSuffix(audits[1], ": 403") {
+		t.Errorf("unexpected audit entry: %s", audits[1])
+	}
+}
+
+func auth_Disabled(t *testing.T, f *authFixture) {
+	f.api.Keys = nil
+	if w := f.request(http.MethodPost, "/water/off", ""); w.Code != http.StatusNoContent {
+		t.Errorf("request rejected with authentication disabled: %d", w.Code)
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -58,9 +60,84 @@ func LoggingMiddleware(fn http.Handler) http.Handler {
 	return http.HandlerFunc(handlerFunc)
 }
 
+type responseControllerKey struct{}
+
+// DeadlineMiddleware makes the connection of a response available to
+// handlers through responseController, so they can extend its deadlines.
+// It must wrap every other Middleware, as the ResponseWriters
+// of some of them cannot be unwrapped.
+func DeadlineMiddleware(fn http.Handler) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), responseControllerKey{}, http.NewResponseController(w))
+		fn.ServeHTTP(w, r.WithContext(ctx))
+	}
+	return http.HandlerFunc(handlerFunc)
+}
+
+// responseController returns the controller of the connection
+// serving a request, as made available by DeadlineMiddleware
+func responseController(w http.ResponseWriter, r *http.Request) *http.ResponseController {
+	if rc, ok := r.Context().Value(responseControllerKey{}).(*http.ResponseController); ok {
+		return rc
+	}
+	return http.NewResponseController(w)
+}
+
+// deadlineWriter pushes back the write deadline of a response before
+// every write, so a long response is only cut off once the client
+// stops reading it instead of after the server's WriteTimeout
+type deadlineWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	dw.rc.SetWriteDeadline(time.Now().Add(rwTimeout))
+	return dw.w.Write(b)
+}
+
+// streamingWriter returns a writer for a response that
+// may take longer than the server's WriteTimeout to send
+func streamingWriter(w http.ResponseWriter, r *http.Request) io.Writer {
+	rc := responseController(w, r)
+	if err := rc.SetWriteDeadline(time.Now().Add(rwTimeout)); err != nil {
+		// there is no deadline to extend
+		return w
+	}
+	return &deadlineWriter{w: w, rc: rc}
+}
+
+// deadlineReader pushes back the read deadline of a request before
+// every read, so a long upload is only cut off once the client
+// stops sending it instead of after the server's ReadTimeout
+type deadlineReader struct {
+	r  io.ReadCloser
+	rc *http.ResponseController
+}
+
+func (dr *deadlineReader) Read(b []byte) (int, error) {
+	dr.rc.SetReadDeadline(time.Now().Add(rwTimeout))
+	return dr.r.Read(b)
+}
+
+func (dr *deadlineReader) Close() error {
+	return dr.r.Close()
+}
+
+// streamingBody returns the body of a request that may take
+// longer than the server's ReadTimeout to receive
+func streamingBody(w http.ResponseWriter, r *http.Request) io.ReadCloser {
+	rc := responseController(w, r)
+	if err := rc.SetReadDeadline(time.Now().Add(rwTimeout)); err != nil {
+		// there is no deadline to extend
+		return r.Body
+	}
+	return &deadlineReader{r: r.Body, rc: rc}
+}
+
 // CORSMiddleware will provide CORS support for requests
 func CORSMiddleware(fn http.Handler) http.Handler {
-	return handlers.CORS()(fn)
+	return handlers.CORS(handlers.AllowedHeaders([]string{headerAuthorization, headerContentType}))(fn)
 }
 
 // RecoveryMiddleware will recover from a panic during the response
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	selfSignedValidity = 10 * 365 * 24 * time.Hour
+	selfSignedOrg      = "greenhouse-pi"
+)
+
+// CertificateReloader serves a certificate and key pair from disk,
+// reloading them whenever either file changes so that renewed
+// certificates take effect without a restart
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertificateReloader loads a certificate and key pair
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	cr := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload reads the certificate and key if either changed
+func (cr *CertificateReloader) reload() error {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return fmt.Errorf("error reading certificate: %v", err)
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("error reading certificate key: %v", err)
+	}
+	if cr.cert != nil && certInfo.ModTime().Equal(cr.certMod) && keyInfo.ModTime().Equal(cr.keyMod) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate: %v", err)
+	}
+	cr.cert = &cert
+	cr.certMod = certInfo.ModTime()
+	cr.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for use as tls.Config.GetCertificate.
+// If the files changed but cannot be loaded, for example because only one of
+// them has been replaced so far, the previous certificate is served.
+func (cr *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if err := cr.reload(); err != nil && cr.cert == nil {
+		return nil, err
+	}
+	return cr.cert, nil
+}
+
+// EnsureCertificate generates a self-signed certificate for the given
+// hosts at certFile and keyFile, unless a certificate and its key already
+// exist there. The pair is generated again if either file is missing, and
+// an existing pair that cannot be loaded is an error rather than replaced.
+func EnsureCertificate(certFile, keyFile string, hosts []string) (bool, error) {
+	missing := false
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			missing = true
+		} else if err != nil {
+			return false, fmt.Errorf("error checking %s: %v", file, err)
+		}
+	}
+	if !missing {
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return false, fmt.Errorf("error loading certificate: %v", err)
+		}
+		return false, nil
+	}
+	if err := GenerateSelfSignedCertificate(certFile, keyFile, hosts); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GenerateSelfSignedCertificate creates a certificate valid for the given
+// host names and IP addresses, and writes it and its key as PEM files
+func GenerateSelfSignedCertificate(certFile, keyFile string, hosts []string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("error generating key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{selfSignedOrg}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return fmt.Errorf("error creating certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("error serializing key: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0755); err != nil {
+		return fmt.Errorf("error creating certificate directory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return fmt.Errorf("error creating key directory: %v", err)
+	}
+	// the key is written first so that a reloader never
+	// sees the new certificate alongside the old key
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return fmt.Errorf("error writing key: %v", err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("error writing certificate: %v", err)
+	}
+	return nil
+}
+
+// LocalHosts returns the host name of this machine, localhost,
+// and the addresses of its network interfaces
+func LocalHosts() []string {
+	hosts := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append([]string{hostname}, hosts...)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			hosts = append(hosts, ipNet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// RedirectHandler redirects every request to the same host and path over HTTPS on the given port
+func RedirectHandler(port string) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		target := *r.URL
+		target.Scheme = "https"
+		target.Host = net.JoinHostPort(host, port)
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	}
+	return http.HandlerFunc(handlerFunc)
+}
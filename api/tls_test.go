@@ -0,0 +1,251 @@
+package api_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/api"
+)
+
+type tlsFixture struct {
+	certFile string
+	keyFile  string
+}
+
+func tlsTest(f func(t *testing.T, fixture *tlsFixture)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "tls")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		f(t, &tlsFixture{
+			certFile: filepath.Join(dir, "tls", "cert.pem"),
+			keyFile:  filepath.Join(dir, "tls", "key.pem"),
+		})
+	}
+	return name, testFunc
+}
+
+func TestTLS(t *testing.T) {
+	t.Parallel()
+	t.Run("TLS", func(t *testing.T) {
+		t.Parallel()
+		t.Run(tlsTest(tls_GenerateSelfSigned))
+		t.Run(tlsTest(tls_EnsureKeepsExisting))
+		t.Run(tlsTest(tls_EnsureMissingKey))
+		t.Run(tlsTest(tls_EnsureMismatchedKey))
+		t.Run(tlsTest(tls_ReloaderPicksUpChanges))
+		t.Run(tlsTest(tls_ReloaderKeepsCertificateOnError))
+		t.Run(tlsTest(tls_Redirect))
+	})
+}
+
+func readCertificate(t *testing.T, certFile string) *x509.Certificate {
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		t.Fatal("certificate is not pem encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func tls_GenerateSelfSigned(t *testing.T, f *tlsFixture) {
+	generated, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"greenhouse.local", "192.168.1.20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !generated {
+		t.Fatal("certificate was not generated")
+	}
+
+	cert := readCertificate(t, f.certFile)
+	if err := cert.VerifyHostname("greenhouse.local"); err != nil {
+		t.Errorf("certificate not valid for host name: %v", err)
+	}
+	if err := cert.VerifyHostname("192.168.1.20"); err != nil {
+		t.Errorf("certificate not valid for address: %v", err)
+	}
+	if err := cert.VerifyHostname("example.com"); err == nil {
+		t.Error("certificate valid for unexpected host")
+	}
+
+	info, err := os.Stat(f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("unexpected key permissions: %v", perm)
+	}
+}
+
+func tls_EnsureKeepsExisting(t *testing.T, f *tlsFixture) {
+	if _, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	before := readCertificate(t, f.certFile)
+
+	generated, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if generated {
+		t.Error("existing certificate was replaced")
+	}
+	if after := readCertificate(t, f.certFile); after.SerialNumber.Cmp(before.SerialNumber) != 0 {
+		t.Error("certificate changed")
+	}
+}
+
+func tls_EnsureMissingKey(t *testing.T, f *tlsFixture) {
+	if _, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	before := readCertificate(t, f.certFile)
+	if err := os.Remove(f.keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !generated {
+		t.Error("certificate was not generated")
+	}
+	if after := readCertificate(t, f.certFile); after.SerialNumber.Cmp(before.SerialNumber) == 0 {
+		t.Error("certificate was not replaced")
+	}
+	if _, err := tls.LoadX509KeyPair(f.certFile, f.keyFile); err != nil {
+		t.Errorf("generated certificate does not match its key: %v", err)
+	}
+}
+
+func tls_EnsureMismatchedKey(t *testing.T, f *tlsFixture) {
+	if _, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(f.keyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := api.EnsureCertificate(f.certFile, f.keyFile, []string{"localhost"}); err == nil {
+		t.Error("expected an error")
+	}
+	if b, err := ioutil.ReadFile(f.keyFile); err != nil || string(b) != "garbage" {
+		t.Errorf("existing key was replaced: %v", err)
+	}
+}
+
+func tls_ReloaderPicksUpChanges(t *testing.T, f *tlsFixture) {
+	if err := api.GenerateSelfSignedCertificate(f.certFile, f.keyFile, []string{"first.local"}); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := api.NewCertificateReloader(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.GenerateSelfSignedCertificate(f.certFile, f.keyFile, []string{"second.local"}); err != nil {
+		t.Fatal(err)
+	}
+	// make sure the change is visible even on file systems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	for _, file := range []string{f.certFile, f.keyFile} {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	second, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second == first {
+		t.Fatal("certificate was not reloaded")
+	}
+	leaf, err := x509.ParseCertificate(second.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("second.local"); err != nil {
+		t.Errorf("reloaded the wrong certificate: %v", err)
+	}
+}
+
+func tls_ReloaderKeepsCertificateOnError(t *testing.T, f *tlsFixture) {
+	if err := api.GenerateSelfSignedCertificate(f.certFile, f.keyFile, []string{"localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := api.NewCertificateReloader(f.certFile, f.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(f.certFile, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(f.certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert != first {
+		t.Error("previous certificate was not kept")
+	}
+}
+
+func tls_Redirect(t *testing.T, f *tlsFixture) {
+	handler := api.RedirectHandler("8443")
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{"http://greenhouse.local:8095/status", "https://greenhouse.local:8443/status"},
+		{"http://greenhouse.local/water/latest?x=1", "https://greenhouse.local:8443/water/latest?x=1"},
+		{"http://192.168.1.20:8095/status", "https://192.168.1.20:8443/status"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: unexpected status: %d", tc.url, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != tc.expected {
+			t.Errorf("%s: expected redirect to %s, got %s", tc.url, tc.expected, location)
+		}
+	}
+}
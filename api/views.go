@@ -5,11 +5,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/export"
+	"github.com/explodes/greenhouse-pi/importer"
 	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/monitor"
+	"github.com/explodes/greenhouse-pi/sensors"
 	"github.com/explodes/greenhouse-pi/stats"
+	"github.com/explodes/greenhouse-pi/usage"
 )
 
 var (
@@ -17,18 +23,11 @@ var (
 )
 
 func validateStat(name string) (stats.StatType, error) {
-	switch name {
-	case stats.StatTypeTemperature.String():
-		return stats.StatTypeTemperature, nil
-	case stats.StatTypeHumidity.String():
-		return stats.StatTypeHumidity, nil
-	case stats.StatTypeWater.String():
-		return stats.StatTypeWater, nil
-	case stats.StatTypeFan.String():
-		return stats.StatTypeFan, nil
-	default:
+	statType, err := stats.ParseStatType(name)
+	if err != nil {
 		return stats.StatType(0), errInvalidStat
 	}
+	return statType, nil
 }
 
 func parseTime(s string) (time.Time, error) {
@@ -133,6 +132,86 @@ func (api *Api) History(w http.ResponseWriter, r *http.Request, vars map[string]
 	w.Write(body)
 }
 
+// maxAggregateBuckets is the most buckets AggregatedHistory summarizes at once
+const maxAggregateBuckets = 10000
+
+// AggregatedHistory returns the minimum, maximum and average of
+// a stat in buckets of time for a given date range
+func (api *Api) AggregatedHistory(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	// extract stat type
+	statType, err := validateStat(vars["stat"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid stat type"}`))
+		return
+	}
+
+	// extract dates
+	start, err := parseTime(vars["start"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid start time"}`))
+		return
+	}
+	end, err := parseTime(vars["end"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid end time"}`))
+		return
+	}
+	if end.Before(start) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"end time must come after start time"}`))
+		return
+	}
+
+	// extract bucket size, such as 15m, 1h or 24h
+	bucket, err := time.ParseDuration(vars["bucket"])
+	if err != nil || bucket < time.Second || bucket%time.Second != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid bucket"}`))
+		return
+	}
+	if end.Sub(start)/bucket >= maxAggregateBuckets {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"too many buckets"}`))
+		return
+	}
+
+	aggregates, err := stats.AggregateStats(api.Storage, statType, start, end, bucket)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error aggregating results: %v", err)))
+		return
+	}
+
+	items := make([]KnownAggregate, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		items = append(items, KnownAggregate{
+			Start: aggregate.Start,
+			Min:   aggregate.Min,
+			Max:   aggregate.Max,
+			Avg:   aggregate.Avg,
+			Count: aggregate.Count,
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"start":  start,
+		"end":    end,
+		"stat":   statType.String(),
+		"bucket": bucket.String(),
+		"items":  items,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
 // Latest returns the current value of a statistic
 func (api *Api) Latest(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 	// extract stat type
@@ -224,6 +303,42 @@ func (api *Api) Status(w http.ResponseWriter, r *http.Request, vars map[string]s
 	}
 	putSensorStatus("humidity", humidityErr, api.Hygrometer.Frequency(), humidity.Value)
 
+	if api.LightSensor != nil {
+		light, lightErr := api.Storage.Latest(stats.StatTypeLight)
+		if lightErr == stats.ErrNoStats {
+			lightErr = nil
+		}
+		putSensorStatus("light", lightErr, api.LightSensor.Frequency(), light.Value)
+	}
+
+	if api.CO2Sensor != nil {
+		co2, co2Err := api.Storage.Latest(stats.StatTypeCO2)
+		if co2Err == stats.ErrNoStats {
+			co2Err = nil
+		}
+		putSensorStatus("co2", co2Err, api.CO2Sensor.Frequency(), co2.Value)
+	}
+
+	if api.FlowMeter != nil {
+		flow, flowErr := api.Storage.Latest(stats.StatTypeFlow)
+		if flowErr == stats.ErrNoStats {
+			flowErr = nil
+		}
+		putSensorStatus("flow", flowErr, api.FlowMeter.Frequency(), flow.Value)
+	}
+
+	if api.Remote != nil {
+		results["devices"] = api.Remote.Devices()
+	}
+
+	if api.Watchdog != nil {
+		heartbeat, healthy := api.Watchdog.Heartbeat()
+		results["watchdog"] = map[string]interface{}{
+			"heartbeat": heartbeat,
+			"healthy":   healthy,
+		}
+	}
+
 	body, err := json.Marshal(results)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -246,13 +361,8 @@ func (api *Api) Schedule(w http.ResponseWriter, r *http.Request, vars map[string
 		return
 	}
 
-	var controller *controllers.Controller
-	switch statTypeRaw {
-	case stats.StatTypeWater.String():
-		controller = api.Water
-	case stats.StatTypeFan.String():
-		controller = api.Fan
-	default:
+	controller, err := api.unitController(statTypeRaw)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(`{"error":"invalid stat type"}`))
 		return
@@ -305,11 +415,130 @@ func (api *Api) Schedule(w http.ResponseWriter, r *http.Request, vars map[string
 	delay := start.Sub(now)
 	duration := end.Sub(start)
 
-	controller.TurnUnitOn(delay, duration)
+	if err := controller.TurnUnitOn(apiCause(r), delay, duration); err != nil {
+		writeUnitError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// On turns a unit on immediately until the given end time
+func (api *Api) On(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	// extract stat type
+	// input
+	statTypeRaw, ok := vars["stat"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing stat"}`))
+		return
+	}
+	// parse
+	controller, err := api.unitController(statTypeRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid stat type"}`))
+		return
+	}
+
+	// extract end date
+	// input
+	endRaw, ok := vars["end"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing end time"}`))
+		return
+	}
+	// parse
+	end, err := parseTime(endRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid end time"}`))
+		return
+	}
+
+	now := time.Now()
+	if end.Before(now) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"end time must be in the future"}`))
+		return
+	}
+
+	if err := controller.TurnUnitOn(apiCause(r), 0, end.Sub(now)); err != nil {
+		writeUnitError(w, err)
+		return
+	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Off turns a unit off immediately
+func (api *Api) Off(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	// extract stat type
+	// input
+	statTypeRaw, ok := vars["stat"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing stat"}`))
+		return
+	}
+	// parse
+	controller, err := api.unitController(statTypeRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid stat type"}`))
+		return
+	}
+
+	controller.TurnUnitOff(apiCause(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// unitController returns the controller for a unit's stat type
+func (api *Api) unitController(name string) (*controllers.Controller, error) {
+	switch name {
+	case stats.StatTypeWater.String():
+		return api.Water, nil
+	case stats.StatTypeFan.String():
+		return api.Fan, nil
+	default:
+		return nil, errInvalidStat
+	}
+}
+
+// writeUnitError writes the response for a unit that could not be
+// turned on. Interlocks that reject the action result in a conflict,
+// interlocks that queue the action result in it being accepted.
+func writeUnitError(w http.ResponseWriter, err error) {
+	interlockErr, ok := err.(*controllers.InterlockError)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("error controlling unit: %v", err)))
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"error":  "blocked by interlock",
+		"unit":   interlockErr.Unit,
+		"rule":   interlockErr.Rule,
+		"reason": interlockErr.Reason,
+		"policy": interlockErr.Policy,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	if interlockErr.Policy == controllers.InterlockQueue {
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusConflict)
+	}
+	w.Write(body)
+}
+
 // Logs returns a list of log entries for a given time range
 func (api *Api) Logs(w http.ResponseWriter, r *http.Request, vars map[string]string) {
 	// extract log level
@@ -403,3 +632,453 @@ func (api *Api) Logs(w http.ResponseWriter, r *http.Request, vars map[string]str
 	w.WriteHeader(http.StatusOK)
 	w.Write(bytes)
 }
+
+// ListKeys returns the api keys allowed to use this server, without their hashes
+func (api *Api) ListKeys(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	if api.Keys == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"authentication is disabled"}`))
+		return
+	}
+
+	keys, err := api.Keys.List()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to list keys: %v", err)))
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, map[string]interface{}{
+			"name":    key.Name,
+			"role":    key.Role.String(),
+			"created": key.Created,
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"items": results,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// exportQuery is the time frame and format of an export
+type exportQuery struct {
+	start, end time.Time
+	format     export.Format
+}
+
+// parseExportQuery parses the start, optional end and optional format
+// query parameters of an export, writing the response if they are invalid
+func parseExportQuery(w http.ResponseWriter, r *http.Request) (exportQuery, bool) {
+	query := r.URL.Query()
+
+	result := exportQuery{
+		end:    time.Now(),
+		format: export.FormatCSV,
+	}
+
+	startRaw := query.Get("start")
+	if startRaw == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing start time"}`))
+		return result, false
+	}
+	start, err := parseTime(startRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid start time"}`))
+		return result, false
+	}
+	result.start = start
+
+	if endRaw := query.Get("end"); endRaw != "" {
+		end, err := parseTime(endRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid end time"}`))
+			return result, false
+		}
+		result.end = end
+	}
+
+	if result.end.Before(result.start) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"end time must come after start time"}`))
+		return result, false
+	}
+
+	if formatRaw := query.Get("format"); formatRaw != "" {
+		format, err := export.ParseFormat(formatRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid format"}`))
+			return result, false
+		}
+		result.format = format
+	}
+
+	return result, true
+}
+
+// ExportStats streams every stat of the types given by the stat query
+// parameter, repeated or comma separated, from start until the optional
+// end as csv or ndjson, given by the optional format query parameter.
+// The export may take longer than the server's write timeout.
+func (api *Api) ExportStats(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	var statTypes []stats.StatType
+	for _, raw := range r.URL.Query()["stat"] {
+		for _, name := range strings.Split(raw, ",") {
+			statType, err := validateStat(name)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid stat type"}`))
+				return
+			}
+			statTypes = append(statTypes, statType)
+		}
+	}
+	if len(statTypes) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing stat"}`))
+		return
+	}
+
+	query, ok := parseExportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(headerContentType, query.format.ContentType())
+	w.WriteHeader(http.StatusOK)
+	// the response has already started, so failures can only be logged
+	if err := export.Stats(streamingWriter(w, r), api.Storage, query.format, statTypes, query.start, query.end); err != nil {
+		api.Logger.Log(logging.LevelError, "error streaming stats export: %v", err)
+	}
+}
+
+// ExportLogs streams every log entry of the optional minimum level query
+// parameter from start until the optional end as csv or ndjson, given
+// by the optional format query parameter.
+// The export may take longer than the server's write timeout.
+func (api *Api) ExportLogs(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	level := logging.LevelDebug
+	if levelRaw := r.URL.Query().Get("level"); levelRaw != "" {
+		parsed, err := logging.ParseLevel(levelRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid log level"}`))
+			return
+		}
+		level = parsed
+	}
+
+	query, ok := parseExportQuery(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set(headerContentType, query.format.ContentType())
+	w.WriteHeader(http.StatusOK)
+	// the response has already started, so failures can only be logged
+	if err := export.Logs(streamingWriter(w, r), api.Storage, query.format, level, query.start, query.end); err != nil {
+		api.Logger.Log(logging.LevelError, "error streaming logs export: %v", err)
+	}
+}
+
+// Import records the stats in the request body, given as csv or ndjson
+// by the optional format query parameter, and reports how many were
+// accepted, already recorded or rejected and why. The upload may take
+// longer than the server's read timeout. Rows are recorded in batches
+// as they are read, so if the import fails part way the rows accepted
+// before the failure stay recorded: the error response reports them and
+// sets partial if there were any. Importing the same body again is safe,
+// as rows already recorded are counted as duplicates.
+func (api *Api) Import(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	format := export.FormatCSV
+	if formatRaw := r.URL.Query().Get("format"); formatRaw != "" {
+		parsed, err := export.ParseFormat(formatRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid format"}`))
+			return
+		}
+		format = parsed
+	}
+
+	body := http.MaxBytesReader(w, streamingBody(w, r), maxImportSize)
+	defer body.Close()
+
+	report, err := importer.New(api.Storage).Import(body, format)
+	// the upload may have outlasted the server's write timeout too
+	out := streamingWriter(w, r)
+	if err != nil {
+		result, marshalErr := json.Marshal(map[string]interface{}{
+			"error":   err.Error(),
+			"report":  report,
+			"partial": report.Accepted > 0,
+		})
+		if marshalErr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			out.Write([]byte(fmt.Sprintf("unable to marshal json: %v", marshalErr)))
+			return
+		}
+		if _, ok := err.(*importer.StorageError); ok {
+			w.WriteHeader(http.StatusInternalServerError)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		out.Write(result)
+		return
+	}
+
+	result, err := json.Marshal(report)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		out.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	out.Write(result)
+}
+
+// Ingest records a batch of readings submitted by a remote device
+func (api *Api) Ingest(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	if api.Remote == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"ingestion is disabled"}`))
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxIngestSize)
+	defer body.Close()
+
+	var submission monitor.Submission
+	if err := json.NewDecoder(body).Decode(&submission); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid submission"}`))
+		return
+	}
+	if err := submission.Validate(); err != nil {
+		result, marshalErr := json.Marshal(map[string]interface{}{
+			"error": err.Error(),
+		})
+		if marshalErr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", marshalErr)))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(result)
+		return
+	}
+
+	receipt, err := api.Remote.Ingest(submission)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to record readings: %v", err)))
+		return
+	}
+
+	result, err := json.Marshal(receipt)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(result)
+}
+
+// Backup makes a backup of the storage now
+func (api *Api) Backup(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	if api.Backups == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"backups are disabled"}`))
+		return
+	}
+
+	path, err := api.Backups.Run()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to back up storage: %v", err)))
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"path": path,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(body)
+}
+
+// CalibrateCO2 sets the current CO2 reading as fresh air. The sensor
+// should have been in fresh air for at least 20 minutes beforehand.
+func (api *Api) CalibrateCO2(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	calibrator, ok := api.CO2Sensor.(sensors.Calibrator)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error":"no calibratable co2 sensor"}`))
+		return
+	}
+
+	if err := calibrator.CalibrateZero(); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to calibrate co2 sensor: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Audit returns the audit trail of commands given to units. The trail
+// is filtered by the optional start, end, unit and cause query
+// parameters, and covers the last day by default.
+func (api *Api) Audit(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	query := r.URL.Query()
+
+	filter := stats.AuditFilter{
+		End:   time.Now(),
+		Unit:  query.Get("unit"),
+		Cause: query.Get("cause"),
+	}
+	if endRaw := query.Get("end"); endRaw != "" {
+		end, err := parseTime(endRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid end time"}`))
+			return
+		}
+		filter.End = end
+	}
+	filter.Start = filter.End.Add(-defaultAuditPeriod)
+	if startRaw := query.Get("start"); startRaw != "" {
+		start, err := parseTime(startRaw)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid start time"}`))
+			return
+		}
+		filter.Start = start
+	}
+
+	if filter.End.Before(filter.Start) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"end time must come after start time"}`))
+		return
+	}
+
+	switch filter.Cause {
+	case "", stats.AuditCauseSchedule, stats.AuditCauseAPI, stats.AuditCausePolicy, stats.AuditCauseSafety:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid cause"}`))
+		return
+	}
+
+	entries, err := api.Storage.AuditTrail(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to collect audit trail: %v", err)))
+		return
+	}
+
+	results := make([]map[string]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		result := map[string]interface{}{
+			"when":      entry.When,
+			"unit":      entry.Unit,
+			"cause":     entry.Cause,
+			"source":    entry.Source,
+			"requested": entry.Requested,
+			"actual":    entry.Actual,
+		}
+		if entry.Error != "" {
+			result["error"] = entry.Error
+		}
+		results = append(results, result)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"items": results,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// UnitUsage returns how long a unit was on during the current day,
+// week and month, and the liters it used if its flow rate is known
+func (api *Api) UnitUsage(w http.ResponseWriter, r *http.Request, vars map[string]string) {
+	// extract unit
+	// input
+	unitRaw, ok := vars["unit"]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"missing unit"}`))
+		return
+	}
+	// parse
+	controller, err := api.unitController(unitRaw)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid unit"}`))
+		return
+	}
+	unit, _ := validateStat(unitRaw)
+
+	now := time.Now()
+	results := map[string]interface{}{
+		"unit": controller.Unit.Name(),
+	}
+	rate, hasRate := api.Usage.FlowRate(unit)
+	if hasRate {
+		results["flowrate"] = rate
+	}
+	for _, period := range usage.Periods {
+		u, err := api.Usage.Period(unit, period, now)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(fmt.Sprintf("unable to account usage: %v", err)))
+			return
+		}
+		result := map[string]interface{}{
+			"start":   u.Start,
+			"end":     u.End,
+			"seconds": u.OnTime.Seconds(),
+		}
+		if hasRate {
+			result["liters"] = u.Liters
+		}
+		results[period.String()] = result
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(fmt.Sprintf("unable to marshal json: %v", err)))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -1,13 +1,24 @@
 package api_test
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/explodes/greenhouse-pi/api"
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/importer"
 	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/monitor"
 	"github.com/explodes/greenhouse-pi/sensors"
 	"github.com/explodes/greenhouse-pi/stats"
 )
@@ -25,19 +36,19 @@ func apiViewTest(f func(t *testing.T, a *api.Api, w *responseWriterRecorder)) (s
 		storage := stats.NewFakeStatsStorage(10)
 		defer storage.Close()
 
-		water, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeWater, storage), storage, scheduler)
+		water, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeWater), storage, scheduler)
 		if err != nil {
 			t.Fatal(err)
 		}
-		fan, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeFan, storage), storage, scheduler)
+		fan, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeFan), storage, scheduler)
 		if err != nil {
 			t.Fatal(err)
 		}
 
-		therm := sensors.NewFakeThermometer(time.Hour)
+		therm := sensors.NewFakeThermometer(time.Hour, clock.Real)
 		defer therm.Close()
 
-		hygro := sensors.NewFakeHygrometer(time.Minute)
+		hygro := sensors.NewFakeHygrometer(time.Minute, clock.Real)
 		defer hygro.Close()
 
 		a := api.New(storage, water, fan, therm, hygro)
@@ -58,6 +69,9 @@ func TestApiView(t *testing.T) {
 		t.Run(apiViewTest(history_MissingStat))
 		t.Run(apiViewTest(history_MissingStart))
 		t.Run(apiViewTest(history_MissingEnd))
+		t.Run(apiViewTest(history_Aggregated))
+		t.Run(apiViewTest(history_AggregatedInvalidBucket))
+		t.Run(apiViewTest(history_AggregatedTooManyBuckets))
 	})
 	t.Run("Latest", func(t *testing.T) {
 		t.Parallel()
@@ -69,6 +83,8 @@ func TestApiView(t *testing.T) {
 		t.Parallel()
 		t.Run(apiViewTest(status_OK))
 		t.Run(apiViewTest(status_OKwithValues))
+		t.Run(apiViewTest(status_CO2))
+		t.Run(apiViewTest(status_Devices))
 	})
 	t.Run("Schedule", func(t *testing.T) {
 		t.Parallel()
@@ -78,6 +94,19 @@ func TestApiView(t *testing.T) {
 		t.Run(apiViewTest(schedule_MissingStart))
 		t.Run(apiViewTest(schedule_MissingEnd))
 	})
+	t.Run("On", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(on_OK))
+		t.Run(apiViewTest(on_Interlocked))
+		t.Run(apiViewTest(on_Queued))
+		t.Run(apiViewTest(on_invalidStat))
+		t.Run(apiViewTest(on_MissingEnd))
+	})
+	t.Run("Off", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(off_OK))
+		t.Run(apiViewTest(off_MissingStat))
+	})
 	t.Run("Logs", func(t *testing.T) {
 		t.Parallel()
 		t.Run(apiViewTest(logs_OK))
@@ -87,6 +116,53 @@ func TestApiView(t *testing.T) {
 		t.Run(apiViewTest(logs_MissingStart))
 		t.Run(apiViewTest(logs_MissingEnd))
 	})
+	t.Run("Usage", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(usage_OK))
+		t.Run(apiViewTest(usage_FlowRate))
+		t.Run(apiViewTest(usage_InvalidUnit))
+	})
+	t.Run("Audit", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(audit_OK))
+		t.Run(apiViewTest(audit_RecordsCommands))
+		t.Run(apiViewTest(audit_FilterUnit))
+		t.Run(apiViewTest(audit_InvalidCause))
+		t.Run(apiViewTest(audit_InvalidStart))
+	})
+	t.Run("Export", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(export_Stats))
+		t.Run(apiViewTest(export_StatsMissingStat))
+		t.Run(apiViewTest(export_StatsInvalidFormat))
+		t.Run(apiViewTest(export_Logs))
+		t.Run(apiViewTest(export_LogsMissingStart))
+		t.Run(apiViewTest(export_LongerThanWriteTimeout))
+	})
+	t.Run("Import", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(import_OK))
+		t.Run(apiViewTest(import_InvalidInput))
+		t.Run(apiViewTest(import_Partial))
+		t.Run(apiViewTest(import_LongerThanReadTimeout))
+	})
+	t.Run("Ingest", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(ingest_OK))
+		t.Run(apiViewTest(ingest_Duplicate))
+		t.Run(apiViewTest(ingest_Disabled))
+		t.Run(apiViewTest(ingest_InvalidBody))
+		t.Run(apiViewTest(ingest_MissingDevice))
+	})
+	t.Run("Backup", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(backup_Disabled))
+	})
+	t.Run("CalibrateCO2", func(t *testing.T) {
+		t.Parallel()
+		t.Run(apiViewTest(calibrateCO2_OK))
+		t.Run(apiViewTest(calibrateCO2_NoSensor))
+	})
 }
 
 func history_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
@@ -135,6 +211,69 @@ func history_OKwithValues(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 			}})
 }
 
+func history_Aggregated(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	hour := time.Now().Add(-2 * time.Hour).Truncate(time.Hour)
+	for i, value := range []float64{1, 3, 8} {
+		when := hour.Add(time.Duration(i*20) * time.Minute)
+		if i == 2 {
+			when = hour.Add(time.Hour)
+		}
+		a.Storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: when, Value: value})
+	}
+
+	a.AggregatedHistory(w, nil, map[string]string{
+		"stat":   "temperature",
+		"start":  hour.Add(time.Minute).Format(iso8601),
+		"end":    hour.Add(time.Hour).Format(iso8601),
+		"bucket": "1h",
+	})
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var body struct {
+		Bucket string               `json:"bucket"`
+		Items  []api.KnownAggregate `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Bucket != "1h0m0s" || len(body.Items) != 2 {
+		t.Fatalf("unexpected aggregates: %s", w.Body.String())
+	}
+	first, second := body.Items[0], body.Items[1]
+	if !first.Start.Equal(hour) || first.Min != 1 || first.Max != 3 || first.Avg != 2 || first.Count != 2 {
+		t.Errorf("unexpected first aggregate: %#v", first)
+	}
+	if !second.Start.Equal(hour.Add(time.Hour)) || second.Avg != 8 || second.Count != 1 {
+		t.Errorf("unexpected second aggregate: %#v", second)
+	}
+}
+
+func history_AggregatedInvalidBucket(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.AggregatedHistory(w, nil, map[string]string{
+		"stat":   "temperature",
+		"start":  time.Now().Add(-time.Hour).Format(iso8601),
+		"end":    time.Now().Format(iso8601),
+		"bucket": "1500ms",
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"invalid bucket"}`)
+}
+
+func history_AggregatedTooManyBuckets(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.AggregatedHistory(w, nil, map[string]string{
+		"stat":   "temperature",
+		"start":  time.Now().AddDate(-1, 0, 0).Format(iso8601),
+		"end":    time.Now().Format(iso8601),
+		"bucket": "1m",
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"too many buckets"}`)
+}
+
 func history_MissingStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(-time.Hour).Format(iso8601)
 	end := time.Now().Format(iso8601)
@@ -251,11 +390,54 @@ func status_OKwithValues(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 		})
 }
 
+func status_CO2(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.CO2Sensor = sensors.NewFakeCO2Sensor(time.Hour, clock.Real)
+	defer a.CO2Sensor.Close()
+	a.Storage.Record(stats.Stat{StatType: stats.StatTypeCO2, When: time.Now().Add(-time.Second), Value: 800})
+
+	a.Status(w, nil, nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		JsonBodyEquals(map[string]interface{}{
+			"water":       map[string]interface{}{"status": "off"},
+			"fan":         map[string]interface{}{"status": "off"},
+			"temperature": map[string]interface{}{"value": float64(0), "frequency": int64(time.Hour) / int64(time.Millisecond)},
+			"humidity":    map[string]interface{}{"value": float64(0), "frequency": int64(time.Minute) / int64(time.Millisecond)},
+			"co2":         map[string]interface{}{"value": float64(800), "frequency": int64(time.Hour) / int64(time.Millisecond)},
+		})
+}
+
+func status_Devices(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Remote = monitor.NewRemote(a.Storage)
+	battery := 87.5
+	if _, err := a.Remote.Ingest(monitor.Submission{Device: "coldframe", Sequence: 4, Battery: &battery}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Status(w, nil, nil)
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var status struct {
+		Devices []monitor.Device `json:"devices"`
+	}
+	if err := w.DeserializeJsonBody(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Devices) != 1 {
+		t.Fatalf("unexpected devices: %v", status.Devices)
+	}
+	device := status.Devices[0]
+	if device.ID != "coldframe" || device.Sequence != 4 || device.Battery == nil || *device.Battery != battery || device.LastSeen.IsZero() {
+		t.Errorf("unexpected device: %+v", device)
+	}
+}
+
 func schedule_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(time.Hour).Format(iso8601)
 	end := time.Now().Add(2 * time.Hour).Format(iso8601)
 
-	a.Schedule(w, nil, map[string]string{
+	a.Schedule(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
 		"stat":  "water",
 		"start": start,
 		"end":   end,
@@ -268,7 +450,7 @@ func schedule_MissingStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(time.Hour).Format(iso8601)
 	end := time.Now().Add(2 * time.Hour).Format(iso8601)
 
-	a.Schedule(w, nil, map[string]string{
+	a.Schedule(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
 		"start": start,
 		"end":   end,
 	})
@@ -282,7 +464,7 @@ func schedule_invalidStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(time.Hour).Format(iso8601)
 	end := time.Now().Add(2 * time.Hour).Format(iso8601)
 
-	a.Schedule(w, nil, map[string]string{
+	a.Schedule(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
 		"stat":  "temperature",
 		"start": start,
 		"end":   end,
@@ -296,7 +478,7 @@ func schedule_invalidStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 func schedule_MissingStart(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	end := time.Now().Add(2 * time.Hour).Format(iso8601)
 
-	a.Schedule(w, nil, map[string]string{
+	a.Schedule(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
 		"stat": "water",
 		"end":  end,
 	})
@@ -309,7 +491,7 @@ func schedule_MissingStart(t *testing.T, a *api.Api, w *responseWriterRecorder)
 func schedule_MissingEnd(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(time.Hour).Format(iso8601)
 
-	a.Schedule(w, nil, map[string]string{
+	a.Schedule(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
 		"stat":  "water",
 		"start": start,
 	})
@@ -319,6 +501,124 @@ func schedule_MissingEnd(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 		StringBodyEquals(`{"error":"missing end time"}`)
 }
 
+func useInterlocks(t *testing.T, a *api.Api, spec string) {
+	il, err := controllers.ParseInterlocks(spec, map[string]*controllers.Controller{
+		"water": a.Water,
+		"fan":   a.Fan,
+	}, a.Storage, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Water.Interlocks = il
+	a.Fan.Interlocks = il
+}
+
+func on_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	end := time.Now().Add(time.Hour).Format(iso8601)
+
+	a.On(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "water",
+		"end":  end,
+	})
+
+	w.Assert(t).StatusEquals(http.StatusNoContent)
+	if !a.Water.IsOn() {
+		t.Error("water was not turned on")
+	}
+}
+
+func on_Interlocked(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	useInterlocks(t, a, "exclusive(water,fan)")
+	if err := a.Fan.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().Add(time.Hour).Format(iso8601)
+
+	a.On(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "water",
+		"end":  end,
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusConflict).
+		JsonBodyEquals(map[string]interface{}{
+			"error":  "blocked by interlock",
+			"policy": "reject",
+			"reason": "fan is on",
+			"rule":   "exclusive(water,fan)",
+			"unit":   "water",
+		})
+}
+
+func on_Queued(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	useInterlocks(t, a, "exclusive(water,fan):queue")
+	if err := a.Fan.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().Add(time.Hour).Format(iso8601)
+
+	a.On(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "water",
+		"end":  end,
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusAccepted).
+		JsonBodyEquals(map[string]interface{}{
+			"error":  "blocked by interlock",
+			"policy": "queue",
+			"reason": "fan is on",
+			"rule":   "exclusive(water,fan)",
+			"unit":   "water",
+		})
+}
+
+func on_invalidStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	end := time.Now().Add(time.Hour).Format(iso8601)
+
+	a.On(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "temperature",
+		"end":  end,
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"invalid stat type"}`)
+}
+
+func on_MissingEnd(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.On(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "water",
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"missing end time"}`)
+}
+
+func off_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	if err := a.Water.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Off(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{
+		"stat": "water",
+	})
+
+	w.Assert(t).StatusEquals(http.StatusNoContent)
+	if a.Water.IsOn() {
+		t.Error("water was not turned off")
+	}
+}
+
+func off_MissingStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Off(w, httptest.NewRequest(http.MethodPost, "/", nil), map[string]string{})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"missing stat"}`)
+}
+
 func logs_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 	start := time.Now().Add(-time.Hour).Format(iso8601)
 	end := time.Now().Add(time.Hour).Format(iso8601)
@@ -416,3 +716,429 @@ func logs_MissingEnd(t *testing.T, a *api.Api, w *responseWriterRecorder) {
 		StatusEquals(http.StatusBadRequest).
 		StringBodyEquals(`{"error":"missing end time"}`)
 }
+
+func audit_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Audit(w, httptest.NewRequest(http.MethodGet, "/audit", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		JsonBodyEquals(map[string]interface{}{
+			"items": []map[string]interface{}{},
+		})
+}
+
+func audit_RecordsCommands(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	end := time.Now().Add(time.Hour).Format(iso8601)
+	on := httptest.NewRequest(http.MethodPost, "/water/on/"+end, nil)
+	a.On(NewResponseWriterRecorder(), on, map[string]string{
+		"stat": "water",
+		"end":  end,
+	})
+
+	a.Audit(w, httptest.NewRequest(http.MethodGet, "/audit?cause=api", nil), nil)
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var body struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := w.DeserializeJsonBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 {
+		t.Fatalf("unexpected audit trail: %v", body.Items)
+	}
+	item := body.Items[0]
+	if item["unit"] != "water" || item["cause"] != "api" || item["requested"] != "on" || item["actual"] != "on" {
+		t.Errorf("unexpected audit entry: %v", item)
+	}
+	if item["source"] != "anonymous from "+on.RemoteAddr {
+		t.Errorf("unexpected source: %v", item["source"])
+	}
+}
+
+func audit_FilterUnit(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	if err := a.Water.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Fan.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Audit(w, httptest.NewRequest(http.MethodGet, "/audit?unit=fan", nil), nil)
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var body struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := w.DeserializeJsonBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 || body.Items[0]["unit"] != "fan" {
+		t.Fatalf("unexpected audit trail: %v", body.Items)
+	}
+}
+
+func audit_InvalidCause(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Audit(w, httptest.NewRequest(http.MethodGet, "/audit?cause=gremlins", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		JsonBodyEquals(map[string]interface{}{
+			"error": "invalid cause",
+		})
+}
+
+func audit_InvalidStart(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Audit(w, httptest.NewRequest(http.MethodGet, "/audit?start=yesterday", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		JsonBodyEquals(map[string]interface{}{
+			"error": "invalid start time",
+		})
+}
+
+func export_Stats(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	when := time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+	a.Storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: when, Value: 21.5})
+	a.Storage.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: when.Add(time.Second), Value: 55})
+
+	a.ExportStats(w, httptest.NewRequest(http.MethodGet, "/export/stats?stat=temperature,humidity&start=2018-06-21&format=ndjson", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		StringBodyEquals(`{"stat":"temperature","value":21.5,"when":"2018-06-21T09:30:00Z"}` + "\n" +
+			`{"stat":"humidity","value":55,"when":"2018-06-21T09:30:01Z"}` + "\n")
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+}
+
+func export_StatsMissingStat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.ExportStats(w, httptest.NewRequest(http.MethodGet, "/export/stats?start=2018-06-21", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"missing stat"}`)
+}
+
+func export_StatsInvalidFormat(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.ExportStats(w, httptest.NewRequest(http.MethodGet, "/export/stats?stat=temperature&start=2018-06-21&format=xml", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"invalid format"}`)
+}
+
+func export_Logs(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Storage.Log(logging.LevelError, "broken")
+
+	start := time.Now().Add(-time.Minute).Format(iso8601)
+	a.ExportLogs(w, httptest.NewRequest(http.MethodGet, "/export/logs?level=error&start="+url.QueryEscape(start), nil), nil)
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+	body := w.Body.String()
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[1], ",error,broken") {
+		t.Errorf("unexpected export:\n%s", body)
+	}
+}
+
+func export_LogsMissingStart(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.ExportLogs(w, httptest.NewRequest(http.MethodGet, "/export/logs", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"missing start time"}`)
+}
+
+// slowStorage takes delay to read each stat
+type slowStorage struct {
+	stats.Storage
+	delay time.Duration
+}
+
+func (s *slowStorage) EachStat(statTypes []stats.StatType, start, end time.Time, fn func(stats.Stat) error) error {
+	return s.Storage.EachStat(statTypes, start, end, func(stat stats.Stat) error {
+		time.Sleep(s.delay)
+		return fn(stat)
+	})
+}
+
+func export_LongerThanWriteTimeout(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	when := time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		a.Storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: when.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	a.Storage = &slowStorage{Storage: a.Storage, delay: 30 * time.Millisecond}
+
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/export/stats?stat=temperature&start=2018-06-21")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("export was cut off: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 11 {
+		t.Errorf("unexpected export:\n%s", body)
+	}
+}
+
+func import_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	body := `{"stat":"temperature","when":"2018-06-21T09:30:00Z","value":21.5}` + "\n" +
+		`{"stat":"temperature","when":"2018-06-21T09:31:00Z","value":500}` + "\n"
+	a.Import(w, httptest.NewRequest(http.MethodPost, "/import?format=ndjson", strings.NewReader(body)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		JsonBodyEquals(map[string]interface{}{
+			"accepted":   float64(1),
+			"duplicates": float64(0),
+			"rejected":   float64(1),
+			"rejections": []interface{}{
+				map[string]interface{}{"line": float64(2), "reason": "temperature value 500 is outside of -60 to 80"},
+			},
+		})
+	if _, err := a.Storage.Latest(stats.StatTypeTemperature); err != nil {
+		t.Error(err)
+	}
+}
+
+func import_InvalidInput(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Import(w, httptest.NewRequest(http.MethodPost, "/import", strings.NewReader("stat\n")), nil)
+
+	w.Assert(t).StatusEquals(http.StatusBadRequest)
+}
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func import_Partial(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	when := time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+	var rows strings.Builder
+	for i := 0; i < importer.DefaultBatchSize+10; i++ {
+		fmt.Fprintf(&rows, `{"stat":"temperature","when":"%s","value":20}`+"\n", when.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+	}
+	body := io.MultiReader(strings.NewReader(rows.String()), failingReader{})
+	a.Import(w, httptest.NewRequest(http.MethodPost, "/import?format=ndjson", body), nil)
+
+	w.Assert(t).StatusEquals(http.StatusBadRequest)
+	var result struct {
+		Partial bool            `json:"partial"`
+		Report  importer.Report `json:"report"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Partial || result.Report.Accepted != importer.DefaultBatchSize {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func import_LongerThanReadTimeout(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	server := httptest.NewUnstartedServer(a.Handler())
+	server.Config.ReadTimeout = 100 * time.Millisecond
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	body, upload := io.Pipe()
+	go func() {
+		when := time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+		for i := 0; i < 10; i++ {
+			time.Sleep(30 * time.Millisecond)
+			fmt.Fprintf(upload, `{"stat":"temperature","when":"%s","value":20}`+"\n", when.Add(time.Duration(i)*time.Second).Format(time.RFC3339))
+		}
+		upload.Close()
+	}()
+
+	res, err := http.Post(server.URL+"/import?format=ndjson", "application/x-ndjson", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	result, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(result), `"accepted":10`) {
+		t.Errorf("unexpected response %d: %s", res.StatusCode, result)
+	}
+}
+
+func ingest_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Remote = monitor.NewRemote(a.Storage)
+	when := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	body := `{"device":"coldframe","sequence":1,"battery":90,"readings":[` +
+		`{"stat":"temperature","timestamp":"` + when + `","value":12.5},` +
+		`{"stat":"humidity","timestamp":"` + when + `","value":140}]}`
+	a.Ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		JsonBodyEquals(map[string]interface{}{
+			"duplicate": false,
+			"accepted":  float64(1),
+			"rejected": []interface{}{
+				map[string]interface{}{"index": float64(1), "reason": "humidity value 140 is outside of 0 to 100"},
+			},
+		})
+	latest, err := a.Storage.Latest(stats.StatTypeRemoteTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 12.5 {
+		t.Errorf("unexpected latest: %v", latest)
+	}
+	if latest, err := a.Storage.Latest(stats.StatTypeTemperature); err == nil && latest.Value == 12.5 {
+		t.Errorf("remote reading recorded as local: %v", latest)
+	}
+}
+
+func ingest_Duplicate(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Remote = monitor.NewRemote(a.Storage)
+	if _, err := a.Remote.Ingest(monitor.Submission{Device: "coldframe", Sequence: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"device":"coldframe","sequence":1,"readings":[]}`
+	a.Ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusOK).
+		JsonBodyEquals(map[string]interface{}{
+			"duplicate": true,
+			"accepted":  float64(0),
+			"rejected":  []interface{}{},
+		})
+}
+
+func ingest_Disabled(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{}`)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusNotFound).
+		StringBodyEquals(`{"error":"ingestion is disabled"}`)
+}
+
+func ingest_InvalidBody(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Remote = monitor.NewRemote(a.Storage)
+	a.Ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"device":`)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"invalid submission"}`)
+}
+
+func ingest_MissingDevice(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Remote = monitor.NewRemote(a.Storage)
+	a.Ingest(w, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"sequence":1}`)), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		StringBodyEquals(`{"error":"device is required"}`)
+}
+
+func backup_Disabled(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Backup(w, httptest.NewRequest(http.MethodPost, "/admin/backup", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusNotFound).
+		StringBodyEquals(`{"error":"backups are disabled"}`)
+}
+
+func calibrateCO2_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.CO2Sensor = sensors.NewFakeCO2Sensor(time.Hour, clock.Real)
+	defer a.CO2Sensor.Close()
+
+	a.CalibrateCO2(w, httptest.NewRequest(http.MethodPost, "/admin/co2/calibrate", nil), nil)
+
+	w.Assert(t).StatusEquals(http.StatusNoContent)
+}
+
+func calibrateCO2_NoSensor(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.CalibrateCO2(w, httptest.NewRequest(http.MethodPost, "/admin/co2/calibrate", nil), nil)
+
+	w.Assert(t).
+		StatusEquals(http.StatusNotFound).
+		JsonBodyEquals(map[string]interface{}{
+			"error": "no calibratable co2 sensor",
+		})
+}
+
+func usage_OK(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.UnitUsage(w, nil, map[string]string{
+		"unit": "water",
+	})
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var body map[string]interface{}
+	if err := w.DeserializeJsonBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["unit"] != "water" {
+		t.Errorf("unexpected unit: %v", body["unit"])
+	}
+	if _, ok := body["flowrate"]; ok {
+		t.Error("unexpected flow rate")
+	}
+	for _, period := range []string{"day", "week", "month"} {
+		usage, ok := body[period].(map[string]interface{})
+		if !ok {
+			t.Fatalf("missing %s usage: %v", period, body)
+		}
+		if usage["seconds"] != 0.0 {
+			t.Errorf("unexpected %s usage: %v", period, usage)
+		}
+	}
+}
+
+func usage_FlowRate(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.Usage.SetFlowRate(stats.StatTypeWater, 2)
+	if err := a.Storage.Record(stats.Stat{StatType: stats.StatTypeWater, When: time.Now().Add(-time.Second), Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	a.UnitUsage(w, nil, map[string]string{
+		"unit": "water",
+	})
+
+	w.Assert(t).StatusEquals(http.StatusOK)
+	var body map[string]interface{}
+	if err := w.DeserializeJsonBody(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["flowrate"] != 2.0 {
+		t.Errorf("unexpected flow rate: %v", body["flowrate"])
+	}
+	day := body["day"].(map[string]interface{})
+	if liters, ok := day["liters"].(float64); !ok || liters <= 0 {
+		t.Errorf("unexpected usage: %v", day)
+	}
+}
+
+func usage_InvalidUnit(t *testing.T, a *api.Api, w *responseWriterRecorder) {
+	a.UnitUsage(w, nil, map[string]string{
+		"unit": "temperature",
+	})
+
+	w.Assert(t).
+		StatusEquals(http.StatusBadRequest).
+		JsonBodyEquals(map[string]interface{}{
+			"error": "invalid unit",
+		})
+}
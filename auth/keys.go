@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tokenBytes = 32
+)
+
+var (
+	// ErrInvalidKey indicates that a token does not match any Key
+	ErrInvalidKey = errors.New("invalid api key")
+
+	// ErrKeyExists indicates that a Key with a name already exists
+	ErrKeyExists = errors.New("api key already exists")
+
+	// ErrNoKey indicates that there is no Key with a name
+	ErrNoKey = errors.New("no such api key")
+)
+
+// Key is an API key. Only a hash of the
+// token is stored, never the token itself.
+type Key struct {
+	Name    string    `json:"name"`
+	Role    Role      `json:"role"`
+	Hash    string    `json:"hash"`
+	Created time.Time `json:"created"`
+}
+
+// KeyStore is a set of Keys persisted to a file. The file is
+// reloaded whenever it changes, so Keys managed from the command
+// line take effect without restarting the server.
+type KeyStore struct {
+	path string
+
+	mu      *sync.Mutex
+	modTime time.Time
+	keys    map[string]Key
+}
+
+// OpenKeyStore loads the Keys stored at path. A missing
+// file is treated as an empty set of Keys.
+func OpenKeyStore(path string) (*KeyStore, error) {
+	ks := &KeyStore{
+		path: path,
+		mu:   &sync.Mutex{},
+		keys: make(map[string]Key),
+	}
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// reload reads the key file if it changed since it was last read
+func (ks *KeyStore) reload() error {
+	info, err := os.Stat(ks.path)
+	if os.IsNotExist(err) {
+		ks.keys = make(map[string]Key)
+		ks.modTime = time.Time{}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading api keys: %v", err)
+	}
+	if info.ModTime().Equal(ks.modTime) {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(ks.path)
+	if err != nil {
+		return fmt.Errorf("error reading api keys: %v", err)
+	}
+	var list []Key
+	if err := json.Unmarshal(b, &list); err != nil {
+		return fmt.Errorf("error parsing api keys: %v", err)
+	}
+	keys := make(map[string]Key, len(list))
+	for _, key := range list {
+		keys[key.Name] = key
+	}
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+// save atomically replaces the key file with the current Keys
+func (ks *KeyStore) save() error {
+	b, err := json.MarshalIndent(ks.list(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error serializing api keys: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(ks.path), ".keys")
+	if err != nil {
+		return fmt.Errorf("error saving api keys: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving api keys: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving api keys: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return fmt.Errorf("error saving api keys: %v", err)
+	}
+
+	info, err := os.Stat(ks.path)
+	if err != nil {
+		return fmt.Errorf("error saving api keys: %v", err)
+	}
+	ks.modTime = info.ModTime()
+	return nil
+}
+
+func (ks *KeyStore) list() []Key {
+	list := make([]Key, 0, len(ks.keys))
+	for _, key := range ks.keys {
+		list = append(list, key)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Create creates and stores a new Key, returning its token.
+// The token cannot be recovered later.
+func (ks *KeyStore) Create(name string, role Role) (string, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reload(); err != nil {
+		return "", err
+	}
+	if _, ok := ks.keys[name]; ok {
+		return "", ErrKeyExists
+	}
+
+	secret := make([]byte, tokenBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating api key: %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	ks.keys[name] = Key{
+		Name:    name,
+		Role:    role,
+		Hash:    hashToken(token),
+		Created: time.Now(),
+	}
+	if err := ks.save(); err != nil {
+		delete(ks.keys, name)
+		return "", err
+	}
+	return token, nil
+}
+
+// Revoke removes a Key
+func (ks *KeyStore) Revoke(name string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reload(); err != nil {
+		return err
+	}
+	key, ok := ks.keys[name]
+	if !ok {
+		return ErrNoKey
+	}
+	delete(ks.keys, name)
+	if err := ks.save(); err != nil {
+		ks.keys[name] = key
+		return err
+	}
+	return nil
+}
+
+// List returns every Key, sorted by name
+func (ks *KeyStore) List() ([]Key, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	return ks.list(), nil
+}
+
+// Authenticate returns the Key for a token
+func (ks *KeyStore) Authenticate(token string) (Key, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if err := ks.reload(); err != nil {
+		return Key{}, err
+	}
+	hash := []byte(hashToken(token))
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare(hash, []byte(key.Hash)) == 1 {
+			return key, nil
+		}
+	}
+	return Key{}, ErrInvalidKey
+}
+
+// AuthenticateBasic returns the Key for HTTP basic credentials,
+// where the username is the name of the Key and the password is its token
+func (ks *KeyStore) AuthenticateBasic(name, token string) (Key, error) {
+	key, err := ks.Authenticate(token)
+	if err != nil {
+		return Key{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(name), []byte(key.Name)) != 1 {
+		return Key{}, ErrInvalidKey
+	}
+	return key, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// WithKey returns a context carrying the authenticated Key
+func WithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// KeyFromContext returns the authenticated Key of a context, if any
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(Key)
+	return key, ok
+}
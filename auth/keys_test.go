@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func keyStoreTest(f func(t *testing.T, ks *KeyStore, path string)) (string, func(*testing.T)) {
+	return testFunctionName(f), func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "keys.json")
+		ks, err := OpenKeyStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f(t, ks, path)
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	t.Run("KeyStore", func(t *testing.T) {
+		t.Parallel()
+		t.Run(keyStoreTest(keyStore_Authenticate))
+		t.Run(keyStoreTest(keyStore_AuthenticateBasic))
+		t.Run(keyStoreTest(keyStore_Duplicate))
+		t.Run(keyStoreTest(keyStore_Revoke))
+		t.Run(keyStoreTest(keyStore_Reload))
+		t.Run(keyStoreTest(keyStore_HashedStorage))
+	})
+}
+
+func TestRole(t *testing.T) {
+	cases := []struct {
+		role     Role
+		required Role
+		allowed  bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleOperator, false},
+		{RoleOperator, RoleViewer, true},
+		{RoleOperator, RoleAdmin, false},
+		{RoleAdmin, RoleOperator, true},
+		{RoleDevice, RoleDevice, true},
+		{RoleDevice, RoleViewer, false},
+		{RoleDevice, RoleOperator, false},
+		{RoleViewer, RoleDevice, false},
+		{RoleOperator, RoleDevice, true},
+		{RoleAdmin, RoleDevice, true},
+	}
+	for _, c := range cases {
+		if c.role.Allows(c.required) != c.allowed {
+			t.Errorf("%s allows %s: need %v", c.role, c.required, c.allowed)
+		}
+	}
+
+	for _, role := range []Role{RoleViewer, RoleOperator, RoleAdmin, RoleDevice} {
+		parsed, err := ParseRole(role.String())
+		if err != nil || parsed != role {
+			t.Errorf("unable to parse %s: %v", role, err)
+		}
+	}
+	if _, err := ParseRole("root"); err == nil {
+		t.Error("parsed unknown role")
+	}
+}
+
+func keyStore_Authenticate(t *testing.T, ks *KeyStore, path string) {
+	token, err := ks.Create("greenhouse", RoleOperator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ks.Authenticate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Name != "greenhouse" || key.Role != RoleOperator {
+		t.Errorf("unexpected key: %#v", key)
+	}
+
+	if _, err := ks.Authenticate(token + "x"); err != ErrInvalidKey {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func keyStore_AuthenticateBasic(t *testing.T, ks *KeyStore, path string) {
+	token, err := ks.Create("greenhouse", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ks.AuthenticateBasic("greenhouse", token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.AuthenticateBasic("shed", token); err != ErrInvalidKey {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func keyStore_Duplicate(t *testing.T, ks *KeyStore, path string) {
+	if _, err := ks.Create("greenhouse", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Create("greenhouse", RoleAdmin); err != ErrKeyExists {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func keyStore_Revoke(t *testing.T, ks *KeyStore, path string) {
+	token, err := ks.Create("greenhouse", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Revoke("greenhouse"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Authenticate(token); err != ErrInvalidKey {
+		t.Errorf("revoked key still valid: %v", err)
+	}
+	if err := ks.Revoke("greenhouse"); err != ErrNoKey {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func keyStore_Reload(t *testing.T, ks *KeyStore, path string) {
+	other, err := OpenKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := other.Create("greenhouse", RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ks.Authenticate(token)
+	if err != nil {
+		t.Fatalf("key created elsewhere was not loaded: %v", err)
+	}
+	if key.Role != RoleAdmin {
+		t.Errorf("unexpected role: %s", key.Role)
+	}
+
+	list, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Name != "greenhouse" {
+		t.Errorf("unexpected keys: %#v", list)
+	}
+}
+
+func keyStore_HashedStorage(t *testing.T, ks *KeyStore, path string) {
+	token, err := ks.Create("greenhouse", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		t.Fatal("keys were not saved")
+	}
+	for i := 0; i+len(token) <= len(b); i++ {
+		if string(b[i:i+len(token)]) == token {
+			t.Fatal("token stored in plain text")
+		}
+	}
+}
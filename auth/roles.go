@@ -0,0 +1,68 @@
+package auth
+
+import "fmt"
+
+const (
+	// RoleViewer may read stats, logs and status
+	RoleViewer Role = 1 + iota
+	// RoleOperator may also control units
+	RoleOperator
+	// RoleAdmin may also use administrative endpoints
+	RoleAdmin
+	// RoleDevice may only submit readings, for keys given to remote
+	// devices. Operators and admins may submit readings too.
+	RoleDevice
+)
+
+// Role is the level of access granted to a Key. Each Role is allowed
+// everything the Roles below it are, except RoleDevice which stands apart.
+type Role uint8
+
+// ParseRole returns the Role with the given name
+func ParseRole(name string) (Role, error) {
+	for _, role := range []Role{RoleViewer, RoleOperator, RoleAdmin, RoleDevice} {
+		if role.String() == name {
+			return role, nil
+		}
+	}
+	return Role(0), fmt.Errorf("unknown role: %s", name)
+}
+
+// Allows returns whether or not this Role grants the access of another
+func (r Role) Allows(required Role) bool {
+	if required == RoleDevice {
+		return r == RoleDevice || r == RoleOperator || r == RoleAdmin
+	}
+	if r == RoleDevice {
+		return false
+	}
+	return r >= required
+}
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleOperator:
+		return "operator"
+	case RoleAdmin:
+		return "admin"
+	case RoleDevice:
+		return "device"
+	default:
+		return "unknown"
+	}
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Role) UnmarshalText(text []byte) error {
+	role, err := ParseRole(string(text))
+	if err != nil {
+		return err
+	}
+	*r = role
+	return nil
+}
@@ -0,0 +1,59 @@
+// Package clock tells the time, so that components waiting on it
+// can be given a Manual clock in tests instead of the real one
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and waits for it to pass
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// After waits for d to pass and then sends the time on the channel
+	After(d time.Duration) <-chan time.Time
+
+	// NewTicker returns a Ticker sending the time every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker sends the time at intervals
+type Ticker interface {
+	// C returns the channel on which the time is sent
+	C() <-chan time.Time
+
+	// Stop stops the Ticker, no more times are sent
+	Stop()
+}
+
+var (
+	// Real is the clock of the system
+	Real Clock = realClock{}
+)
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
@@ -0,0 +1,159 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manual is a Clock that only moves when it is advanced,
+// so that waiting on it takes no time and is deterministic
+type Manual struct {
+	mu      *sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	// changed is closed and replaced whenever a waiter is added
+	changed chan struct{}
+}
+
+// waiter is a channel waiting for the time to reach at,
+// again every period if it belongs to a Ticker
+type waiter struct {
+	at      time.Time
+	period  time.Duration
+	c       chan time.Time
+	stopped bool
+}
+
+// NewManual creates a Manual clock stopped at now
+func NewManual(now time.Time) *Manual {
+	return &Manual{
+		mu:      &sync.Mutex{},
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.now
+}
+
+func (m *Manual) After(d time.Duration) <-chan time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- m.now
+		return c
+	}
+	m.wait(&waiter{at: m.now.Add(d), c: c})
+	return c
+}
+
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{at: m.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	m.wait(w)
+	return &manualTicker{clock: m, waiter: w}
+}
+
+// wait adds a waiter, the lock must be held
+func (m *Manual) wait(w *waiter) {
+	m.waiters = append(m.waiters, w)
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Advance moves the clock forward by d, sending the time to everything
+// waiting on it along the way, in order, as the time they were due
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := m.now.Add(d)
+	for {
+		due := m.due(end)
+		if due == nil {
+			break
+		}
+		m.now = due.at
+		// like a time.Ticker, a tick is dropped if the last was not received
+		select {
+		case due.c <- due.at:
+		default:
+		}
+		if due.period > 0 {
+			due.at = due.at.Add(due.period)
+		} else {
+			m.remove(due)
+		}
+	}
+	m.now = end
+}
+
+// due returns the earliest waiter due by end, if any
+func (m *Manual) due(end time.Time) *waiter {
+	sort.SliceStable(m.waiters, func(i, j int) bool { return m.waiters[i].at.Before(m.waiters[j].at) })
+	if len(m.waiters) == 0 || m.waiters[0].at.After(end) {
+		return nil
+	}
+	return m.waiters[0]
+}
+
+// remove removes a waiter, the lock must be held
+func (m *Manual) remove(w *waiter) {
+	for i, waiting := range m.waiters {
+		if waiting == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Waiters returns how many timers and tickers are waiting on the clock
+func (m *Manual) Waiters() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.waiters)
+}
+
+// BlockUntil waits until at least n timers and tickers are waiting on
+// the clock, so that a test can be sure a goroutine is waiting on it
+// before advancing it
+func (m *Manual) BlockUntil(n int) {
+	for {
+		m.mu.Lock()
+		waiting, changed := len(m.waiters), m.changed
+		m.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+type manualTicker struct {
+	clock  *Manual
+	waiter *waiter
+}
+
+func (t *manualTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t *manualTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	if !t.waiter.stopped {
+		t.waiter.stopped = true
+		t.clock.remove(t.waiter)
+	}
+}
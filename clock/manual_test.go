@@ -0,0 +1,141 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var (
+	manualStart = time.Date(2018, time.June, 21, 6, 0, 0, 0, time.UTC)
+)
+
+func manualTest(f func(t *testing.T, m *Manual)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+		f(t, NewManual(manualStart))
+	}
+	return name, testFunc
+}
+
+func TestManual(t *testing.T) {
+	t.Parallel()
+	t.Run("Manual", func(t *testing.T) {
+		t.Parallel()
+		t.Run(manualTest(manual_Now))
+		t.Run(manualTest(manual_After))
+		t.Run(manualTest(manual_AfterNoDelay))
+		t.Run(manualTest(manual_AfterInOrder))
+		t.Run(manualTest(manual_Ticker))
+		t.Run(manualTest(manual_TickerStop))
+		t.Run(manualTest(manual_BlockUntil))
+	})
+}
+
+// received returns the time sent on c, if any
+func received(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case when := <-c:
+		return when, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func manual_Now(t *testing.T, m *Manual) {
+	if !m.Now().Equal(manualStart) {
+		t.Fatalf("unexpected time: %s", m.Now())
+	}
+	m.Advance(90 * time.Minute)
+	if !m.Now().Equal(manualStart.Add(90 * time.Minute)) {
+		t.Fatalf("unexpected time: %s", m.Now())
+	}
+}
+
+func manual_After(t *testing.T, m *Manual) {
+	c := m.After(time.Hour)
+	m.Advance(59 * time.Minute)
+	if _, ok := received(c); ok {
+		t.Fatal("received before due")
+	}
+	m.Advance(2 * time.Minute)
+	when, ok := received(c)
+	if !ok {
+		t.Fatal("not received when due")
+	}
+	if !when.Equal(manualStart.Add(time.Hour)) {
+		t.Errorf("expected the time it was due, got %s", when)
+	}
+	if m.Waiters() != 0 {
+		t.Errorf("unexpected waiters: %d", m.Waiters())
+	}
+}
+
+func manual_AfterNoDelay(t *testing.T, m *Manual) {
+	if when, ok := received(m.After(0)); !ok || !when.Equal(manualStart) {
+		t.Fatalf("expected to be received immediately, got %s %v", when, ok)
+	}
+}
+
+func manual_AfterInOrder(t *testing.T, m *Manual) {
+	late := m.After(3 * time.Hour)
+	early := m.After(time.Hour)
+	var seen []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		seen = append(seen, <-early, <-late)
+	}()
+	m.Advance(24 * time.Hour)
+	<-done
+	if !seen[0].Equal(manualStart.Add(time.Hour)) || !seen[1].Equal(manualStart.Add(3*time.Hour)) {
+		t.Errorf("unexpected times: %v", seen)
+	}
+}
+
+func manual_Ticker(t *testing.T, m *Manual) {
+	ticker := m.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		m.Advance(time.Hour)
+		when, ok := received(ticker.C())
+		if !ok || !when.Equal(manualStart.Add(time.Duration(i)*time.Hour)) {
+			t.Fatalf("unexpected tick %d: %s %v", i, when, ok)
+		}
+	}
+
+	// ticks are dropped while the last one has not been received
+	m.Advance(5 * time.Hour)
+	if when, ok := received(ticker.C()); !ok || !when.Equal(manualStart.Add(4*time.Hour)) {
+		t.Fatalf("unexpected tick: %s %v", when, ok)
+	}
+	if _, ok := received(ticker.C()); ok {
+		t.Fatal("ticks were not dropped")
+	}
+}
+
+func manual_TickerStop(t *testing.T, m *Manual) {
+	ticker := m.NewTicker(time.Hour)
+	ticker.Stop()
+	ticker.Stop()
+	m.Advance(2 * time.Hour)
+	if _, ok := received(ticker.C()); ok {
+		t.Fatal("stopped ticker ticked")
+	}
+	if m.Waiters() != 0 {
+		t.Errorf("unexpected waiters: %d", m.Waiters())
+	}
+}
+
+func manual_BlockUntil(t *testing.T, m *Manual) {
+	done := make(chan time.Time)
+	go func() {
+		done <- <-m.After(time.Minute)
+	}()
+	m.BlockUntil(1)
+	m.Advance(time.Minute)
+	if when := <-done; !when.Equal(manualStart.Add(time.Minute)) {
+		t.Errorf("unexpected time: %s", when)
+	}
+}
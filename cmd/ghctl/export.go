@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/cmd/builder"
+	"github.com/explodes/greenhouse-pi/export"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+var (
+	exportTimeFormats = []string{
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+)
+
+// parseExportTime parses a time given on the command line, in local time if it has no zone
+func parseExportTime(s string) (time.Time, error) {
+	for _, format := range exportTimeFormats {
+		if t, err := time.ParseInLocation(format, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, expected a date or RFC3339 time", s)
+}
+
+// runExport writes the history of stats or logs to a file
+func runExport(args []string) error {
+	if len(args) < 1 {
+		return errors.New("expected stats or logs")
+	}
+
+	flags := flag.NewFlagSet("export "+args[0], flag.ExitOnError)
+	db := flags.String("db", envDefault(envDatabase, defaultDatabase), fmt.Sprintf("Database connection string [%s]", envDatabase))
+	statNames := flags.String("stats", "", "Comma separated stat types to export, such as temperature,humidity")
+	levelName := flags.String("level", "debug", "Minimum level of logs to export")
+	startRaw := flags.String("start", "", "Start of the export, a date or RFC3339 time")
+	endRaw := flags.String("end", "", "End of the export, a date or RFC3339 time, now if not set")
+	formatName := flags.String("format", string(export.FormatCSV), "Format of the export: csv or ndjson")
+	output := flags.String("o", "", "File to write the export to, stdout if not set")
+	flags.Parse(args[1:])
+
+	if *startRaw == "" {
+		return errors.New("missing -start")
+	}
+	start, err := parseExportTime(*startRaw)
+	if err != nil {
+		return err
+	}
+	end := time.Now()
+	if *endRaw != "" {
+		if end, err = parseExportTime(*endRaw); err != nil {
+			return err
+		}
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	var write func(w io.Writer, storage stats.Storage) error
+	switch args[0] {
+	case "stats":
+		if *statNames == "" {
+			return errors.New("missing -stats")
+		}
+		var statTypes []stats.StatType
+		for _, name := range strings.Split(*statNames, ",") {
+			statType, err := stats.ParseStatType(name)
+			if err != nil {
+				return fmt.Errorf("%v: %s", err, name)
+			}
+			statTypes = append(statTypes, statType)
+		}
+		write = func(w io.Writer, storage stats.Storage) error {
+			return export.Stats(w, storage, format, statTypes, start, end)
+		}
+	case "logs":
+		level, err := logging.ParseLevel(*levelName)
+		if err != nil {
+			return err
+		}
+		write = func(w io.Writer, storage stats.Storage) error {
+			return export.Logs(w, storage, format, level, start, end)
+		}
+	default:
+		return fmt.Errorf("unknown export command %s, expected stats or logs", args[0])
+	}
+
+	storage, err := builder.CreateStorage(*db)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	if *output == "" {
+		return write(os.Stdout, storage)
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", *output, err)
+	}
+	if err := write(f, storage); err != nil {
+		f.Close()
+		os.Remove(*output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %v", *output, err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %s to %s\n", args[0], *output)
+	return nil
+}
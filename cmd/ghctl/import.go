@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/explodes/greenhouse-pi/cmd/builder"
+	"github.com/explodes/greenhouse-pi/export"
+	"github.com/explodes/greenhouse-pi/importer"
+)
+
+// runImport records the history of stats from a file
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	db := flags.String("db", envDefault(envDatabase, defaultDatabase), fmt.Sprintf("Database connection string [%s]", envDatabase))
+	formatName := flags.String("format", string(export.FormatCSV), "Format of the file: csv or ndjson")
+	input := flags.String("i", "", "File to import, stdin if not set")
+	batch := flags.Int("batch", importer.DefaultBatchSize, "How many rows to record per transaction")
+	flags.Parse(args)
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	if *batch < 1 {
+		return fmt.Errorf("invalid -batch %d", *batch)
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %v", *input, err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	storage, err := builder.CreateStorage(*db)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	imp := importer.New(storage)
+	imp.BatchSize = *batch
+	report, err := imp.Import(r, format)
+
+	fmt.Printf("accepted %d, duplicates %d, rejected %d\n", report.Accepted, report.Duplicates, report.Rejected)
+	for _, rejection := range report.Rejections {
+		fmt.Printf("  line %d: %s\n", rejection.Line, rejection.Reason)
+	}
+	if report.Rejected > len(report.Rejections) {
+		fmt.Printf("  and %d more\n", report.Rejected-len(report.Rejections))
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/explodes/greenhouse-pi/auth"
+)
+
+const (
+	envKeys     = "GH_KEYS"
+	defaultKeys = "/usr/local/greenhouse/keys.json"
+)
+
+// runKeys manages the api keys allowed to use the server
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		return errors.New("expected create, list or revoke")
+	}
+
+	flags := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	path := flags.String("keys", envDefault(envKeys, defaultKeys), fmt.Sprintf("API key file [%s]", envKeys))
+	name := flags.String("name", "", "Name of the key")
+	role := flags.String("role", auth.RoleViewer.String(), "Role of a new key: viewer, operator, admin or device")
+	flags.Parse(args[1:])
+
+	keys, err := auth.OpenKeyStore(*path)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "create":
+		if *name == "" {
+			return errors.New("missing -name")
+		}
+		parsedRole, err := auth.ParseRole(*role)
+		if err != nil {
+			return err
+		}
+		token, err := keys.Create(*name, parsedRole)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s key %s, it will not be shown again:\n%s\n", parsedRole, *name, token)
+	case "list":
+		list, err := keys.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tROLE\tCREATED")
+		for _, key := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, key.Role, key.Created.Format("2006-01-02 15:04:05"))
+		}
+		return w.Flush()
+	case "revoke":
+		if *name == "" {
+			return errors.New("missing -name")
+		}
+		if err := keys.Revoke(*name); err != nil {
+			return err
+		}
+		fmt.Printf("revoked key %s\n", *name)
+	default:
+		return fmt.Errorf("unknown keys command %s, expected create, list or revoke", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	envDatabase     = "GH_DATABASE"
+	defaultDatabase = "sqlite3:///usr/local/greenhouse/greenhouse.db"
+)
+
+// command is a subcommand of ghctl
+type command struct {
+	name        string
+	description string
+	run         func(args []string) error
+}
+
+var commands = []command{
+	{"keys", "manage api keys", runKeys},
+	{"export", "export the history of stats or logs", runExport},
+	{"import", "import the history of stats", runImport},
+	{"restore", "restore the database from a backup", runRestore},
+	{"migrate-data", "copy the history of stats and logs to another database", runMigrateData},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", name, err)
+			}
+			return
+		}
+	}
+
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [arguments]\n\ncommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// envDefault returns the value of an environment variable, or a default if it is not set
+func envDefault(env, value string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return value
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/explodes/greenhouse-pi/cmd/builder"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+// runMigrateData copies the history of stats and logs from one database to another
+func runMigrateData(args []string) error {
+	flags := flag.NewFlagSet("migrate-data", flag.ExitOnError)
+	from := flags.String("from", envDefault(envDatabase, defaultDatabase), fmt.Sprintf("Database connection string to copy from [%s]", envDatabase))
+	to := flags.String("to", "", "Database connection string to copy to")
+	batch := flags.Int("batch", stats.DefaultTransferBatchSize, "How many rows to copy per transaction")
+	verify := flags.Bool("verify", true, "Compare row counts and checksums after copying")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: migrate-data -from <database> -to <database>")
+		fmt.Fprintln(flags.Output(), "an interrupted copy resumes when run again")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *to == "" {
+		return errors.New("expected -to")
+	}
+	if *to == *from {
+		return errors.New("-from and -to are the same database")
+	}
+	if *batch < 1 {
+		return fmt.Errorf("invalid -batch %d", *batch)
+	}
+
+	src, err := builder.CreateStorage(*from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := builder.CreateStorage(*to)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	transfer := stats.NewTransfer(src, dst)
+	transfer.BatchSize = *batch
+	transfer.Progress = func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+	}
+
+	if err := transfer.Run(); err != nil {
+		return err
+	}
+	if !*verify {
+		return nil
+	}
+	if err := transfer.Verify(); err != nil {
+		return fmt.Errorf("verification failed: %v", err)
+	}
+	fmt.Println("verified")
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+// runRestore replaces the sqlite database with a backup
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	db := flags.String("db", envDefault(envDatabase, defaultDatabase), fmt.Sprintf("Database connection string of the database to replace [%s]", envDatabase))
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: restore [-db sqlite3:///path] <backup>")
+		fmt.Fprintln(flags.Output(), "the greenhouse daemon must be stopped while restoring")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return errors.New("expected the backup to restore")
+	}
+	backup := flags.Arg(0)
+
+	if !strings.HasPrefix(*db, "sqlite3://") {
+		return fmt.Errorf("only sqlite databases can be restored: %s", *db)
+	}
+	target := (*db)[len("sqlite3://"):]
+
+	kept, err := stats.RestoreSqlite(backup, target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", target, backup)
+	if kept != "" {
+		fmt.Printf("the replaced database was kept as %s\n", kept)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// shutdownStep is a single named step in the shutdown sequence
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+	// critical steps leave units in a safe state, they run even
+	// once the timeout has elapsed and are waited for until they finish
+	critical bool
+}
+
+// shutdown runs each step in order, logging its progress. Steps
+// that fail are logged and the sequence continues so that a broken
+// sensor cannot prevent units from being left in a safe state. If
+// the timeout elapses, the remaining steps are abandoned, except
+// for critical steps which still run.
+func shutdown(timeout time.Duration, steps []shutdownStep) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	failed, abandoned := 0, 0
+	for _, step := range steps {
+		if ctx.Err() != nil && !step.critical {
+			log.Printf("shutdown: %s abandoned", step.name)
+			abandoned++
+			continue
+		}
+		log.Printf("shutdown: %s", step.name)
+
+		stepCtx := ctx
+		if step.critical {
+			stepCtx = context.Background()
+		}
+		done := make(chan error, 1)
+		go func(step shutdownStep) {
+			done <- step.run(stepCtx)
+		}(step)
+
+		var err error
+		if step.critical {
+			err = <-done
+		} else {
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				log.Printf("shutdown: %s timed out", step.name)
+				abandoned++
+				continue
+			}
+		}
+		if err != nil {
+			log.Printf("shutdown: %s failed: %v", step.name, err)
+			failed++
+		}
+	}
+
+	if abandoned != 0 {
+		return fmt.Errorf("shutdown timed out after %s, abandoned %d steps", timeout, abandoned)
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d shutdown steps failed", failed)
+	}
+	log.Printf("shutdown: complete")
+	return nil
+}
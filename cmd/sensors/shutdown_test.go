@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Complete", func(t *testing.T) {
+		var ran []string
+		err := shutdown(time.Second, []shutdownStep{
+			{name: "first", run: func(ctx context.Context) error {
+				ran = append(ran, "first")
+				return nil
+			}},
+			{name: "second", run: func(ctx context.Context) error {
+				ran = append(ran, "second")
+				return nil
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(ran, ",") != "first,second" {
+			t.Errorf("unexpected steps: %v", ran)
+		}
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		safe := false
+		err := shutdown(time.Second, []shutdownStep{
+			{name: "broken", run: func(ctx context.Context) error {
+				return errors.New("broken")
+			}},
+			{name: "safe", run: func(ctx context.Context) error {
+				safe = true
+				return nil
+			}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "1 shutdown steps failed") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !safe {
+			t.Error("expected the step after a failure to run")
+		}
+	})
+
+	t.Run("TimedOut", func(t *testing.T) {
+		blocked := make(chan struct{})
+		defer close(blocked)
+		closed, safe := false, false
+		err := shutdown(10*time.Millisecond, []shutdownStep{
+			{name: "slow", run: func(ctx context.Context) error {
+				<-blocked
+				return nil
+			}},
+			{name: "close", run: func(ctx context.Context) error {
+				closed = true
+				return nil
+			}},
+			{name: "safe", critical: true, run: func(ctx context.Context) error {
+				if ctx.Err() != nil {
+					t.Errorf("unexpected context error: %v", ctx.Err())
+				}
+				safe = true
+				return nil
+			}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "abandoned 2 steps") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if closed {
+			t.Error("expected the step after the timeout to be abandoned")
+		}
+		if !safe {
+			t.Error("expected the critical step to run after the timeout")
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+// Cause describes what gave a command to a Controller,
+// so that it can be recorded in the audit trail
+type Cause struct {
+	// Kind is one of the stats.AuditCause constants
+	Kind string
+	// Source identifies what gave the command within its Kind
+	Source string
+}
+
+// APICause is a command given by an API client
+func APICause(client string) Cause {
+	return Cause{Kind: stats.AuditCauseAPI, Source: client}
+}
+
+// PolicyCause is a command given by an automatic control policy
+func PolicyCause(name string) Cause {
+	return Cause{Kind: stats.AuditCausePolicy, Source: name}
+}
+
+// SafetyCause is a command given by a safety override
+func SafetyCause(override string) Cause {
+	return Cause{Kind: stats.AuditCauseSafety, Source: override}
+}
+
+// scheduleCause is a command given by a scheduled action on behalf of
+// another cause. Actions that ran without delay keep the original cause.
+func scheduleCause(action *Action, origin Cause) Cause {
+	if action == nil {
+		return origin
+	}
+	return Cause{Kind: stats.AuditCauseSchedule, Source: fmt.Sprintf("%d (%s)", action.ID, origin)}
+}
+
+func (c Cause) String() string {
+	return fmt.Sprintf("%s %s", c.Kind, c.Source)
+}
+
+// audit records a command given to the Unit in the audit trail.
+// It must be called while holding the Controller lock.
+func (wc *Controller) audit(cause Cause, requested string, err error) {
+	entry := stats.AuditEntry{
+		When:      wc.Clock.Now(),
+		Unit:      wc.Unit.Name(),
+		Cause:     cause.Kind,
+		Source:    cause.Source,
+		Requested: requested,
+		Actual:    UnitStatusOff,
+	}
+	if wc.isOn {
+		entry.Actual = UnitStatusOn
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := wc.storage.Audit(entry); err != nil {
+		log.Printf("error recording audit entry for %s: %v", wc.Unit.Name(), err)
+	}
+}
@@ -3,8 +3,10 @@ package controllers
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/logging"
 	"github.com/explodes/greenhouse-pi/stats"
 )
@@ -15,8 +17,34 @@ type Controller struct {
 	storage   stats.Storage
 	scheduler *Scheduler
 
+	// SafeState is the state the Unit is forced
+	// into when the system is shutting down
+	SafeState UnitStatus
+
+	// Interlocks are checked before the Unit is turned on, if set
+	Interlocks *Interlocks
+
+	// Clock times how long the Unit is on, the Scheduler's unless replaced
+	Clock clock.Clock
+
+	// StatType is what the Unit switching on and off is recorded as, its
+	// level or 1 when on and 0 when off, so that how long it was on can be
+	// accounted for. It is found from the name of the Unit, and nothing is
+	// recorded if it is 0.
+	StatType stats.StatType
+
+	// switching is held while a command is sent to the Unit, so that
+	// commands reach it in order. mu only guards the state below and is
+	// never held while waiting on the Unit, which may be on another device.
+	switching *sync.Mutex
+	mu        *sync.Mutex
+
 	// isOn is whether or not the water Unit is known to be on
 	isOn bool
+	// turningOn is whether or not the Unit is being turned on
+	turningOn bool
+	// onSince is when the Unit was last seen turning on
+	onSince time.Time
 }
 
 func NewController(unit Unit, storage stats.Storage, scheduler *Scheduler) (*Controller, error) {
@@ -24,47 +52,335 @@ func NewController(unit Unit, storage stats.Storage, scheduler *Scheduler) (*Con
 	if err != nil {
 		return nil, fmt.Errorf("error creating controller for Unit %s: %v", unit.Name(), err)
 	}
+	// units without a StatType for a name are not recorded
+	statType, _ := stats.ParseStatType(unit.Name())
 	wc := &Controller{
 		scheduler: scheduler,
 		Unit:      unit,
 		storage:   storage,
+		SafeState: UnitStatusOff,
+		Clock:     scheduler.Clock,
+		StatType:  statType,
+		switching: &sync.Mutex{},
+		mu:        &sync.Mutex{},
 		isOn:      isOn == UnitStatusOn,
 	}
+	if wc.isOn {
+		wc.onSince = wc.Clock.Now()
+	}
 	return wc, nil
 }
 
-func (wc *Controller) TurnUnitOn(delay time.Duration, duration time.Duration) {
-	wc.scheduler.Schedule(fmt.Sprintf("turn on %s", wc.Unit.Name()), delay, func() {
-		wc.turnUnitOnNow()
+// TurnUnitOn turns the Unit on after delay and off again after duration.
+// If there is no delay the Unit is turned on immediately, and an
+// *InterlockError is returned if an interlock blocks it. A rejected
+// action is not scheduled at all, a queued action is retried until
+// the end of its duration. Every attempt is audited with the given cause.
+func (wc *Controller) TurnUnitOn(cause Cause, delay time.Duration, duration time.Duration) error {
+	until := wc.Clock.Now().Add(delay + duration)
+
+	var err error
+	if delay <= 0 {
+		err = wc.turnUnitOnNow(cause, until)
+		if ie, ok := err.(*InterlockError); ok && ie.Policy == InterlockReject {
+			return err
+		}
+	} else {
+		wc.scheduler.schedule(fmt.Sprintf("turn on %s", wc.Unit.Name()), delay, func(a *Action) {
+			wc.turnUnitOnNow(scheduleCause(a, cause), until)
+		})
+	}
+	wc.scheduler.schedule(fmt.Sprintf("turn off %s", wc.Unit.Name()), delay+duration, func(a *Action) {
+		wc.turnUnitOffNow(scheduleCause(a, cause))
 	})
-	wc.scheduler.Schedule(fmt.Sprintf("turn off %s", wc.Unit.Name()), delay+duration, func() {
-		wc.turnUnitOffNow()
+	return err
+}
+
+// turnUnitOnNow turns the Unit on if the interlocks allow it.
+// Queued actions are retried until the given time.
+func (wc *Controller) turnUnitOnNow(cause Cause, until time.Time) error {
+	wc.switching.Lock()
+	defer wc.switching.Unlock()
+
+	wasOn, blocked := wc.claimOn()
+	if blocked != nil {
+		go wc.logWithPrintout(logging.LevelWarn, "%v", blocked)
+		wc.mu.Lock()
+		wc.audit(cause, UnitStatusOn, blocked)
+		wc.mu.Unlock()
+		if blocked.Policy == InterlockQueue {
+			wc.retryTurnUnitOn(cause, until)
+		}
+		return blocked
+	}
+
+	var err error
+	if !wasOn {
+		err = wc.Unit.On()
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	wc.turningOn = false
+	if err != nil {
+		go wc.logWithPrintout(logging.LevelError, "error turning on %s: %v", wc.Unit.Name(), err)
+		wc.audit(cause, UnitStatusOn, err)
+		return err
+	}
+	if !wasOn {
+		go wc.logWithPrintout(logging.LevelInfo, "%s was turned on by %s", wc.Unit.Name(), cause)
+		wc.isOn = true
+		wc.onSince = wc.Clock.Now()
+		wc.record(1)
+	}
+	wc.audit(cause, UnitStatusOn, nil)
+	return nil
+}
+
+// claimOn checks the Interlocks and, if they allow the Unit to turn on,
+// marks it as turning on so that other Units see it as on while it is
+// switched. Returns whether or not the Unit was already on. The
+// Interlocks are only held while checking, not while the Unit is switched.
+func (wc *Controller) claimOn() (bool, *InterlockError) {
+	if wc.Interlocks != nil {
+		wc.Interlocks.mu.Lock()
+		defer wc.Interlocks.mu.Unlock()
+
+		if err := wc.Interlocks.check(wc); err != nil {
+			return false, err
+		}
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	wc.turningOn = !wc.isOn
+	return wc.isOn, nil
+}
+
+// retryTurnUnitOn schedules another attempt at turning
+// on the Unit if there is time left to do so
+func (wc *Controller) retryTurnUnitOn(cause Cause, until time.Time) {
+	retry := wc.Interlocks.Retry
+	if wc.Clock.Now().Add(retry).After(until) {
+		go wc.logWithPrintout(logging.LevelWarn, "giving up on turning on %s", wc.Unit.Name())
+		return
+	}
+	wc.scheduler.schedule(fmt.Sprintf("retry turn on %s", wc.Unit.Name()), retry, func(a *Action) {
+		wc.turnUnitOnNow(cause, until)
 	})
 }
 
-func (wc *Controller) turnUnitOnNow() {
-	if !wc.isOn {
-		if err := wc.Unit.On(); err != nil {
-			go wc.logWithPrintout(logging.LevelError, "error turning on water: %v", err)
-		} else {
-			go wc.logWithPrintout(logging.LevelInfo, "water was turned on")
-			wc.isOn = true
+// TurnUnitOff turns the Unit off immediately, auditing it with the given cause
+func (wc *Controller) TurnUnitOff(cause Cause) {
+	wc.turnUnitOffNow(cause)
+}
+
+func (wc *Controller) turnUnitOffNow(cause Cause) {
+	wc.switching.Lock()
+	defer wc.switching.Unlock()
+
+	wasOn := wc.IsOn()
+	var err error
+	if wasOn {
+		err = wc.Unit.Off()
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	if err != nil {
+		go wc.logWithPrintout(logging.LevelError, "error turning off %s: %v", wc.Unit.Name(), err)
+	} else if wasOn {
+		go wc.logWithPrintout(logging.LevelInfo, "%s was turned off by %s", wc.Unit.Name(), cause)
+		wc.isOn = false
+		wc.onSince = time.Time{}
+		wc.record(0)
+	}
+	wc.audit(cause, UnitStatusOff, err)
+}
+
+// SetLevel sets the output of a VariableUnit. Raising the level
+// above zero is subject to the Interlocks like turning the Unit on.
+// Only changes between off and on are audited, not every change of level.
+func (wc *Controller) SetLevel(cause Cause, level float64) error {
+	unit, ok := wc.Unit.(VariableUnit)
+	if !ok {
+		return fmt.Errorf("%s does not support variable output", wc.Unit.Name())
+	}
+
+	wc.switching.Lock()
+	defer wc.switching.Unlock()
+
+	var wasOn bool
+	if level > 0 {
+		var blocked *InterlockError
+		wasOn, blocked = wc.claimOn()
+		if blocked != nil {
+			wc.mu.Lock()
+			if !wc.isOn {
+				wc.audit(cause, UnitStatusOn, blocked)
+			}
+			wc.mu.Unlock()
+			return blocked
+		}
+	} else {
+		wasOn = wc.IsOn()
+	}
+
+	err := unit.SetLevel(level)
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	wc.turningOn = false
+	requested := UnitStatusOff
+	if level > 0 {
+		requested = UnitStatusOn
+	}
+	transition := wasOn != (level > 0)
+
+	if err != nil {
+		err = fmt.Errorf("error setting %s level: %v", wc.Unit.Name(), err)
+		if transition {
+			wc.audit(cause, requested, err)
 		}
+		return err
 	}
+	if level <= 0 {
+		wc.onSince = time.Time{}
+	} else if !wasOn {
+		wc.onSince = wc.Clock.Now()
+	}
+	wc.isOn = level > 0
+	if transition {
+		wc.record(level)
+		wc.audit(cause, requested, nil)
+	}
+	return nil
+}
+
+// IsOn returns whether or not the Unit is known to be on
+func (wc *Controller) IsOn() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	return wc.isOn
 }
 
-func (wc *Controller) TurnUnitOff() {
-	wc.turnUnitOffNow()
+// claimsOn returns whether or not the Unit is on or being turned on
+func (wc *Controller) claimsOn() bool {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	return wc.isOn || wc.turningOn
 }
 
-func (wc *Controller) turnUnitOffNow() {
+// Safe forces the Unit into its SafeState. Unlike TurnUnitOff, the
+// command is sent to the Unit even if it is already believed to be
+// in that state, since this is used when the Unit is about to be
+// left unattended.
+func (wc *Controller) Safe() error {
+	wc.switching.Lock()
+	defer wc.switching.Unlock()
+
+	var err error
+	if wc.SafeState == UnitStatusOn {
+		err = wc.Unit.On()
+	} else {
+		err = wc.Unit.Off()
+	}
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	cause := SafetyCause("shutdown")
+	if err != nil {
+		err = fmt.Errorf("error putting %s in safe state %s: %v", wc.Unit.Name(), wc.SafeState, err)
+		wc.audit(cause, string(wc.SafeState), err)
+		return err
+	}
+	wasOn := wc.isOn
+	wc.isOn = wc.SafeState == UnitStatusOn
 	if wc.isOn {
-		if err := wc.Unit.Off(); err != nil {
-			go wc.logWithPrintout(logging.LevelError, "error turning off water: %v", err)
-		} else {
-			go wc.logWithPrintout(logging.LevelInfo, "water was turned off")
-			wc.isOn = false
+		wc.onSince = wc.Clock.Now()
+	} else {
+		wc.onSince = time.Time{}
+	}
+	if wc.isOn && !wasOn {
+		wc.record(1)
+	} else if !wc.isOn && wasOn {
+		wc.record(0)
+	}
+	wc.audit(cause, string(wc.SafeState), nil)
+	return nil
+}
+
+// enforceMaxOnTime forces the Unit off if it has been on for longer
+// than limit. The Unit itself is queried rather than trusting isOn,
+// so a Unit that was turned on behind the Controller's back is
+// caught as well, timed from the first time it was seen on.
+// Returns whether or not the Unit was forced off, and an
+// *UnreachableError as is if the state of the Unit is unknown.
+func (wc *Controller) enforceMaxOnTime(limit time.Duration, now time.Time) (bool, error) {
+	status, err := wc.Unit.Status()
+	if _, ok := err.(*UnreachableError); ok {
+		return false, err
+	} else if err != nil {
+		return false, fmt.Errorf("error reading status of %s: %v", wc.Unit.Name(), err)
+	}
+
+	wc.mu.Lock()
+	if status != UnitStatusOn {
+		if wc.isOn {
+			wc.record(0)
+		}
+		wc.isOn = false
+		wc.onSince = time.Time{}
+		wc.mu.Unlock()
+		return false, nil
+	}
+	if wc.onSince.IsZero() {
+		wc.isOn = true
+		wc.onSince = now
+		wc.record(1)
+	}
+	if now.Sub(wc.onSince) < limit {
+		wc.mu.Unlock()
+		return false, nil
+	}
+	wc.mu.Unlock()
+
+	err = wc.Unit.Off()
+
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+
+	cause := SafetyCause(fmt.Sprintf("watchdog maximum on-time %s", limit))
+	if err != nil {
+		wc.audit(cause, UnitStatusOff, err)
+		if _, ok := err.(*UnreachableError); ok {
+			return false, err
 		}
+		return false, fmt.Errorf("error forcing off %s: %v", wc.Unit.Name(), err)
+	}
+	wc.isOn = false
+	wc.onSince = time.Time{}
+	wc.record(0)
+	wc.audit(cause, UnitStatusOff, nil)
+	return true, nil
+}
+
+// record records a change of the level of the Unit as its StatType.
+// It must be called while holding the Controller lock.
+func (wc *Controller) record(level float64) {
+	if wc.StatType == 0 {
+		return
+	}
+	stat := stats.Stat{StatType: wc.StatType, When: wc.Clock.Now(), Value: level}
+	if err := wc.storage.Record(stat); err != nil {
+		log.Printf("error recording %s: %v", wc.StatType, err)
 	}
 }
 
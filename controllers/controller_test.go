@@ -1,10 +1,12 @@
 package controllers
 
 import (
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/stats"
 )
 
@@ -36,6 +38,10 @@ func (u *TestUnit) Status() (UnitStatus, error) {
 	return UnitStatusOff, nil
 }
 
+func (u *TestUnit) Close() error {
+	return nil
+}
+
 func controllerTest(f func(t *testing.T, c *Controller, testUnit *TestUnit)) (string, func(*testing.T)) {
 	return testFunctionName(f), func(t *testing.T) {
 		t.Parallel()
@@ -43,6 +49,7 @@ func controllerTest(f func(t *testing.T, c *Controller, testUnit *TestUnit)) (st
 		unit := &TestUnit{wg: &sync.WaitGroup{}}
 		storage := stats.NewFakeStatsStorage(40)
 		scheduler := NewScheduler()
+		scheduler.Clock = clock.NewManual(schedulerStart)
 
 		c, err := NewController(unit, storage, scheduler)
 		if err != nil {
@@ -58,14 +65,20 @@ func TestController(t *testing.T) {
 	t.Run("Scheduler", func(t *testing.T) {
 		t.Parallel()
 		t.Run("New", controller_New)
+		t.Run("Switching", controller_Switching)
 		t.Run(controllerTest(controller_TurnUnitOff))
 		t.Run(controllerTest(controller_TurnUnitOn))
+		t.Run(controllerTest(controller_SafeOff))
+		t.Run(controllerTest(controller_SafeOn))
+		t.Run(controllerTest(controller_SafeUnknownState))
+		t.Run(controllerTest(controller_AuditScheduled))
+		t.Run(controllerTest(controller_AuditSafe))
 	})
 }
 
 func controller_New(t *testing.T) {
 	storage := stats.NewFakeStatsStorage(40)
-	unit := NewFakeUnit(stats.StatType("fake"), storage)
+	unit := NewFakeUnit(stats.StatTypeFan)
 	scheduler := NewScheduler()
 
 	c, err := NewController(unit, storage, scheduler)
@@ -77,10 +90,65 @@ func controller_New(t *testing.T) {
 	}
 }
 
+// slowUnit is a Unit that takes until it is released to turn on
+type slowUnit struct {
+	TestUnit
+	switching chan struct{}
+	release   chan struct{}
+}
+
+func (u *slowUnit) On() error {
+	close(u.switching)
+	<-u.release
+	u.status = true
+	return nil
+}
+
+func controller_Switching(t *testing.T) {
+	storage := stats.NewFakeStatsStorage(40)
+	scheduler := NewScheduler()
+	unit := &slowUnit{switching: make(chan struct{}), release: make(chan struct{})}
+	c, err := NewController(unit, storage, scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewController(NewFakeUnit(stats.StatTypeFan), storage, scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interlocks := NewInterlocks(&MutualExclusion{Controllers: []*Controller{c, other}, OnBlock: InterlockReject})
+	c.Interlocks = interlocks
+	other.Interlocks = interlocks
+
+	done := make(chan error)
+	go func() {
+		done <- c.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour))
+	}()
+	<-unit.switching
+
+	// neither the state of the Controller nor the
+	// interlocks wait on a Unit that is switching
+	if c.IsOn() {
+		t.Error("unit is on before it was switched")
+	}
+	err = other.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour))
+	if _, ok := err.(*InterlockError); !ok {
+		t.Errorf("expected the unit turning on to block the other: %v", err)
+	}
+
+	close(unit.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsOn() {
+		t.Error("unit is not on")
+	}
+}
+
 func controller_TurnUnitOff(t *testing.T, c *Controller, testUnit *TestUnit) {
 	testUnit.wg.Add(1)
 
-	c.turnUnitOffNow()
+	c.turnUnitOffNow(APICause("test"))
 
 	status, err := c.Unit.Status()
 	if err != nil {
@@ -92,14 +160,121 @@ func controller_TurnUnitOff(t *testing.T, c *Controller, testUnit *TestUnit) {
 }
 
 func controller_TurnUnitOn(t *testing.T, c *Controller, testUnit *TestUnit) {
-	testUnit.wg.Add(2)
-
-	c.TurnUnitOn(time.Millisecond, 10*time.Millisecond)
+	manual := c.Clock.(*clock.Manual)
+	if err := c.TurnUnitOn(APICause("test"), time.Hour, 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
 
+	testUnit.wg.Add(1)
+	manual.Advance(time.Hour)
 	testUnit.wg.Wait()
+	if !testUnit.status {
+		t.Error("test Unit did not turn on")
+	}
 
+	testUnit.wg.Add(1)
+	manual.Advance(3 * time.Hour)
+	testUnit.wg.Wait()
 	if testUnit.status {
 		t.Error("test Unit did not turn off")
 	}
+}
+
+func controller_SafeOff(t *testing.T, c *Controller, testUnit *TestUnit) {
+	testUnit.wg.Add(1)
+	c.turnUnitOnNow(APICause("test"), c.Clock.Now().Add(time.Hour))
+
+	testUnit.wg.Add(1)
+	if err := c.Safe(); err != nil {
+		t.Fatal(err)
+	}
+
+	if testUnit.status {
+		t.Error("controller did not put unit in safe state")
+	}
+}
+
+func controller_SafeOn(t *testing.T, c *Controller, testUnit *TestUnit) {
+	c.SafeState = UnitStatusOn
+
+	testUnit.wg.Add(1)
+	if err := c.Safe(); err != nil {
+		t.Fatal(err)
+	}
 
+	if !testUnit.status {
+		t.Error("controller did not put unit in safe state")
+	}
+}
+
+func controller_SafeUnknownState(t *testing.T, c *Controller, testUnit *TestUnit) {
+	// the controller believes the unit is off, but the unit
+	// was turned on behind its back
+	testUnit.status = true
+
+	testUnit.wg.Add(1)
+	if err := c.Safe(); err != nil {
+		t.Fatal(err)
+	}
+
+	if testUnit.status {
+		t.Error("controller did not force unit into safe state")
+	}
+}
+
+func controller_AuditScheduled(t *testing.T, c *Controller, testUnit *TestUnit) {
+	manual := c.Clock.(*clock.Manual)
+	if err := c.TurnUnitOn(APICause("tester"), time.Hour, 3*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	// the audit entries are written while switching the unit
+	testUnit.wg.Add(1)
+	manual.Advance(time.Hour)
+	testUnit.wg.Wait()
+	c.switching.Lock()
+	c.switching.Unlock()
+	testUnit.wg.Add(1)
+	manual.Advance(3 * time.Hour)
+	testUnit.wg.Wait()
+	c.switching.Lock()
+	c.switching.Unlock()
+
+	trail, err := c.storage.AuditTrail(stats.AuditFilter{Start: schedulerStart, End: c.Clock.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 2 {
+		t.Fatalf("unexpected audit trail: %#v", trail)
+	}
+	off, on := trail[0], trail[1]
+	if on.Requested != UnitStatusOn || on.Actual != UnitStatusOn || off.Requested != UnitStatusOff || off.Actual != UnitStatusOff {
+		t.Errorf("unexpected states: %#v", trail)
+	}
+	for _, entry := range trail {
+		if entry.Unit != "testunit" || entry.Cause != stats.AuditCauseSchedule || !strings.Contains(entry.Source, "api tester") {
+			t.Errorf("unexpected audit entry: %#v", entry)
+		}
+	}
+	if on.Source == off.Source {
+		t.Errorf("scheduled actions share an id: %s", on.Source)
+	}
+	if !on.When.Equal(schedulerStart.Add(time.Hour)) || !off.When.Equal(schedulerStart.Add(4*time.Hour)) {
+		t.Errorf("unexpected audit times: %s %s", on.When, off.When)
+	}
+}
+
+func controller_AuditSafe(t *testing.T, c *Controller, testUnit *TestUnit) {
+	testUnit.wg.Add(1)
+	if err := c.Safe(); err != nil {
+		t.Fatal(err)
+	}
+
+	trail, err := c.storage.AuditTrail(stats.AuditFilter{Start: c.Clock.Now().Add(-time.Hour), End: c.Clock.Now(), Cause: stats.AuditCauseSafety})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 1 || trail[0].Requested != UnitStatusOff || trail[0].Error != "" {
+		t.Fatalf("unexpected audit trail: %#v", trail)
+	}
 }
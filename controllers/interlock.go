@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// InterlockReject refuses to turn on a blocked Unit
+	InterlockReject InterlockPolicy = "reject"
+	// InterlockQueue retries turning on a blocked Unit
+	// until the end of the window it was scheduled for
+	InterlockQueue InterlockPolicy = "queue"
+
+	defaultInterlockRetry = 30 * time.Second
+)
+
+// InterlockPolicy is what happens to an action blocked by a Rule
+type InterlockPolicy string
+
+// Rule is an interlock that may prevent a Unit from being turned on
+type Rule interface {
+	// Check returns a reason the Unit of the given Controller
+	// may not be turned on, or an empty string if it may
+	Check(c *Controller) string
+
+	// Policy is what to do with a blocked action
+	Policy() InterlockPolicy
+
+	// String describes this Rule
+	String() string
+}
+
+// InterlockError is returned when a Rule blocks a Unit from turning on
+type InterlockError struct {
+	Unit   string
+	Rule   string
+	Reason string
+	Policy InterlockPolicy
+}
+
+func (e *InterlockError) Error() string {
+	return fmt.Sprintf("%s blocked by interlock %s (%s): %s", e.Unit, e.Rule, e.Policy, e.Reason)
+}
+
+// Interlocks is a set of Rules shared by Controllers whose Units
+// interact with one another. Rules are checked while holding a single
+// lock, and a Unit allowed to turn on counts as on until it has been
+// switched, so two Units in a mutual exclusion cannot both be turned on at once.
+type Interlocks struct {
+	// Retry is how often a queued action is retried
+	Retry time.Duration
+
+	mu    *sync.Mutex
+	rules []Rule
+}
+
+// NewInterlocks creates a set of interlocks from the given Rules
+func NewInterlocks(rules ...Rule) *Interlocks {
+	return &Interlocks{
+		Retry: defaultInterlockRetry,
+		mu:    &sync.Mutex{},
+		rules: rules,
+	}
+}
+
+// Add adds a Rule to this set of interlocks
+func (il *Interlocks) Add(rule Rule) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.rules = append(il.rules, rule)
+}
+
+// Rules returns the Rules in this set of interlocks
+func (il *Interlocks) Rules() []Rule {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	rules := make([]Rule, len(il.rules))
+	copy(rules, il.rules)
+	return rules
+}
+
+// check returns an error for the first Rule that blocks the
+// Controller's Unit from turning on. Must be called with il.mu held.
+func (il *Interlocks) check(c *Controller) *InterlockError {
+	for _, rule := range il.rules {
+		if reason := rule.Check(c); reason != "" {
+			return &InterlockError{
+				Unit:   c.Unit.Name(),
+				Rule:   rule.String(),
+				Reason: reason,
+				Policy: rule.Policy(),
+			}
+		}
+	}
+	return nil
+}
+
+// MutualExclusion prevents more than one of its Units from being on at a time
+type MutualExclusion struct {
+	Controllers []*Controller
+	OnBlock     InterlockPolicy
+}
+
+func (r *MutualExclusion) Check(c *Controller) string {
+	if !containsController(r.Controllers, c) {
+		return ""
+	}
+	for _, other := range r.Controllers {
+		if other != c && other.claimsOn() {
+			return fmt.Sprintf("%s is on", other.Unit.Name())
+		}
+	}
+	return ""
+}
+
+func (r *MutualExclusion) Policy() InterlockPolicy {
+	return r.OnBlock
+}
+
+func (r *MutualExclusion) String() string {
+	names := make([]string, 0, len(r.Controllers))
+	for _, c := range r.Controllers {
+		names = append(names, c.Unit.Name())
+	}
+	return fmt.Sprintf("exclusive(%s)", strings.Join(names, ","))
+}
+
+// RequiresOn only allows a Unit to turn on while another Unit is on
+type RequiresOn struct {
+	Controller *Controller
+	Requires   *Controller
+	OnBlock    InterlockPolicy
+}
+
+func (r *RequiresOn) Check(c *Controller) string {
+	if c != r.Controller {
+		return ""
+	}
+	if !r.Requires.IsOn() {
+		return fmt.Sprintf("%s is off", r.Requires.Unit.Name())
+	}
+	return ""
+}
+
+func (r *RequiresOn) Policy() InterlockPolicy {
+	return r.OnBlock
+}
+
+func (r *RequiresOn) String() string {
+	return fmt.Sprintf("requires(%s,%s)", r.Controller.Unit.Name(), r.Requires.Unit.Name())
+}
+
+// RequiresStat only allows a Unit to turn on while the latest value
+// of a Stat is above or below a threshold. Stats older than MaxAge,
+// such as those of a sensor that stopped reading, are treated as
+// unknown and block the Unit, unless MaxAge is zero.
+type RequiresStat struct {
+	Controller *Controller
+	Storage    stats.Storage
+	StatType   stats.StatType
+	// Above is whether the value must be above the
+	// threshold, otherwise it must be below it
+	Above     bool
+	Threshold float64
+	MaxAge    time.Duration
+	OnBlock   InterlockPolicy
+}
+
+func (r *RequiresStat) Check(c *Controller) string {
+	if c != r.Controller {
+		return ""
+	}
+	stat, err := r.Storage.Latest(r.StatType)
+	if err == stats.ErrNoStats {
+		return fmt.Sprintf("no %s readings", r.StatType)
+	} else if err != nil {
+		return fmt.Sprintf("error reading %s: %v", r.StatType, err)
+	}
+	if r.MaxAge > 0 && c.Clock.Now().Sub(stat.When) > r.MaxAge {
+		return fmt.Sprintf("%s reading from %s is stale", r.StatType, stat.When)
+	}
+	if r.Above && stat.Value <= r.Threshold {
+		return fmt.Sprintf("%s is %g, not above %g", r.StatType, stat.Value, r.Threshold)
+	}
+	if !r.Above && stat.Value >= r.Threshold {
+		return fmt.Sprintf("%s is %g, not below %g", r.StatType, stat.Value, r.Threshold)
+	}
+	return ""
+}
+
+func (r *RequiresStat) Policy() InterlockPolicy {
+	return r.OnBlock
+}
+
+func (r *RequiresStat) String() string {
+	op := "<"
+	if r.Above {
+		op = ">"
+	}
+	if r.MaxAge > 0 {
+		return fmt.Sprintf("stat(%s,%s%s%g,%s)", r.Controller.Unit.Name(), r.StatType, op, r.Threshold, r.MaxAge)
+	}
+	return fmt.Sprintf("stat(%s,%s%s%g)", r.Controller.Unit.Name(), r.StatType, op, r.Threshold)
+}
+
+func containsController(list []*Controller, c *Controller) bool {
+	for _, item := range list {
+		if item == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseInterlocks parses a semicolon separated list of Rules:
+//
+//	exclusive(water,mister)       only one of the units may be on
+//	requires(fan,vent)            fan may only turn on while vent is on
+//	stat(fan,temperature>25)      fan may only turn on while temperature is above 25
+//	stat(fan,temperature>25,5m)   and the latest reading is at most 5 minutes old
+//
+// Stat rules without a maximum age accept readings up to maxAge old,
+// which should be a few sensor periods. Each Rule may be followed by
+// :reject or :queue to set its policy, which defaults to reject.
+// Units are looked up by name in controllers.
+func ParseInterlocks(spec string, controllers map[string]*Controller, storage stats.Storage, maxAge time.Duration) (*Interlocks, error) {
+	il := NewInterlocks()
+	for _, raw := range strings.Split(spec, ";") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		rule, err := parseRule(raw, controllers, storage, maxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interlock %q: %v", raw, err)
+		}
+		il.Add(rule)
+	}
+	return il, nil
+}
+
+func parseRule(raw string, controllers map[string]*Controller, storage stats.Storage, maxAge time.Duration) (Rule, error) {
+	policy := InterlockReject
+	if i := strings.LastIndex(raw, ":"); i > strings.LastIndex(raw, ")") {
+		policy = InterlockPolicy(raw[i+1:])
+		raw = raw[:i]
+		if policy != InterlockReject && policy != InterlockQueue {
+			return nil, fmt.Errorf("unknown policy %s", policy)
+		}
+	}
+
+	open := strings.Index(raw, "(")
+	if open < 0 || !strings.HasSuffix(raw, ")") {
+		return nil, fmt.Errorf("expected kind(args)")
+	}
+	kind := raw[:open]
+	args := strings.Split(raw[open+1:len(raw)-1], ",")
+
+	lookup := func(name string) (*Controller, error) {
+		c, ok := controllers[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %s", name)
+		}
+		return c, nil
+	}
+
+	switch kind {
+	case "exclusive":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("exclusive needs at least two units")
+		}
+		rule := &MutualExclusion{OnBlock: policy}
+		for _, arg := range args {
+			c, err := lookup(arg)
+			if err != nil {
+				return nil, err
+			}
+			rule.Controllers = append(rule.Controllers, c)
+		}
+		return rule, nil
+	case "requires":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("requires needs exactly two units")
+		}
+		c, err := lookup(args[0])
+		if err != nil {
+			return nil, err
+		}
+		requires, err := lookup(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return &RequiresOn{Controller: c, Requires: requires, OnBlock: policy}, nil
+	case "stat":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, fmt.Errorf("stat needs a unit, a condition and an optional maximum age")
+		}
+		c, err := lookup(args[0])
+		if err != nil {
+			return nil, err
+		}
+		condition := strings.TrimSpace(args[1])
+		i := strings.IndexAny(condition, "<>")
+		if i < 0 {
+			return nil, fmt.Errorf("condition must be stat>value or stat<value")
+		}
+		statType, err := stats.ParseStatType(condition[:i])
+		if err != nil {
+			return nil, err
+		}
+		threshold, err := strconv.ParseFloat(condition[i+1:], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid threshold: %v", err)
+		}
+		if len(args) == 3 {
+			maxAge, err = time.ParseDuration(strings.TrimSpace(args[2]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid maximum age: %v", err)
+			}
+			if maxAge <= 0 {
+				return nil, fmt.Errorf("maximum age must be positive")
+			}
+		}
+		return &RequiresStat{
+			Controller: c,
+			Storage:    storage,
+			StatType:   statType,
+			Above:      condition[i] == '>',
+			Threshold:  threshold,
+			MaxAge:     maxAge,
+			OnBlock:    policy,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown interlock %s", kind)
+	}
+}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+// testStatMaxAge is how old a reading parsed stat rules accept by default
+const testStatMaxAge = 10 * time.Minute
+
+type interlockFixture struct {
+	clock     *clock.Manual
+	storage   stats.Storage
+	scheduler *Scheduler
+	water     *Controller
+	fan       *Controller
+}
+
+func (f *interlockFixture) controllers() map[string]*Controller {
+	return map[string]*Controller{
+		"water": f.water,
+		"fan":   f.fan,
+	}
+}
+
+func (f *interlockFixture) use(t *testing.T, spec string) {
+	il, err := ParseInterlocks(spec, f.controllers(), f.storage, testStatMaxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	il.Retry = time.Millisecond
+	f.water.Interlocks = il
+	f.fan.Interlocks = il
+}
+
+func interlockTest(f func(t *testing.T, fixture *interlockFixture)) (string, func(*testing.T)) {
+	return testFunctionName(f), func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(40)
+		manual := clock.NewManual(schedulerStart)
+		scheduler := NewScheduler()
+		scheduler.Clock = manual
+		defer scheduler.CancelAll()
+
+		water, err := NewController(NewFakeUnit(stats.StatTypeWater), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fan, err := NewController(NewFakeUnit(stats.StatTypeFan), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f(t, &interlockFixture{
+			clock:     manual,
+			storage:   storage,
+			scheduler: scheduler,
+			water:     water,
+			fan:       fan,
+		})
+	}
+}
+
+func TestInterlocks(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		t.Parallel()
+		t.Run(interlockTest(interlock_ParseRules))
+		t.Run(interlockTest(interlock_ParseInvalid))
+	})
+	t.Run("Rules", func(t *testing.T) {
+		t.Parallel()
+		t.Run(interlockTest(interlock_MutualExclusionReject))
+		t.Run(interlockTest(interlock_MutualExclusionQueue))
+		t.Run(interlockTest(interlock_RequiresOn))
+		t.Run(interlockTest(interlock_RequiresStat))
+		t.Run(interlockTest(interlock_RequiresStatMissing))
+		t.Run(interlockTest(interlock_RequiresStatStale))
+		t.Run(interlockTest(interlock_RequiresStatStaleByDefault))
+	})
+}
+
+func interlock_ParseRules(t *testing.T, f *interlockFixture) {
+	il, err := ParseInterlocks(" exclusive(water,fan):queue; requires(fan,water) ;stat(fan,temperature>25.5):reject;stat(water, humidity<80, 90s):queue", f.controllers(), f.storage, testStatMaxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := il.Rules()
+	if len(rules) != 4 {
+		t.Fatalf("unexpected rules: %v", rules)
+	}
+	expected := []struct {
+		name   string
+		policy InterlockPolicy
+	}{
+		{"exclusive(water,fan)", InterlockQueue},
+		{"requires(fan,water)", InterlockReject},
+		{"stat(fan,temperature>25.5,10m0s)", InterlockReject},
+		{"stat(water,humidity<80,1m30s)", InterlockQueue},
+	}
+	for i, e := range expected {
+		if rules[i].String() != e.name || rules[i].Policy() != e.policy {
+			t.Errorf("unexpected rule %d: %s %s", i, rules[i], rules[i].Policy())
+		}
+	}
+}
+
+func interlock_ParseInvalid(t *testing.T, f *interlockFixture) {
+	for _, spec := range []string{
+		"exclusive(water)",
+		"exclusive(water,mister)",
+		"requires(fan)",
+		"stat(fan,temperature=25)",
+		"stat(fan,sunshine>25)",
+		"stat(fan,temperature>warm)",
+		"stat(fan,temperature>25,soon)",
+		"stat(fan,temperature>25,-5m)",
+		"stat(fan,temperature>25,5m,10m)",
+		"exclusive(water,fan):sometimes",
+		"always(fan)",
+		"exclusive",
+	} {
+		if _, err := ParseInterlocks(spec, f.controllers(), f.storage, testStatMaxAge); err == nil {
+			t.Errorf("expected error parsing %s", spec)
+		}
+	}
+}
+
+func interlock_MutualExclusionReject(t *testing.T, f *interlockFixture) {
+	f.use(t, "exclusive(water,fan)")
+
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	err := f.water.TurnUnitOn(APICause("test"), 0, time.Hour)
+	interlockErr, ok := err.(*InterlockError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if interlockErr.Policy != InterlockReject || interlockErr.Unit != "water" {
+		t.Errorf("unexpected interlock error: %v", interlockErr)
+	}
+	if f.water.IsOn() {
+		t.Error("water was turned on")
+	}
+	for _, action := range f.scheduler.Actions() {
+		if action.Name == "turn off water" {
+			t.Error("rejected action was scheduled")
+		}
+	}
+}
+
+func interlock_MutualExclusionQueue(t *testing.T, f *interlockFixture) {
+	f.use(t, "exclusive(water,fan):queue")
+
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	err := f.water.TurnUnitOn(APICause("test"), 0, time.Hour)
+	if interlockErr, ok := err.(*InterlockError); !ok || interlockErr.Policy != InterlockQueue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.water.IsOn() {
+		t.Fatal("water was turned on")
+	}
+
+	f.fan.TurnUnitOff(APICause("test"))
+	f.clock.Advance(f.water.Interlocks.Retry)
+
+	deadline := time.Now().Add(time.Second)
+	for !f.water.IsOn() {
+		if time.Now().After(deadline) {
+			t.Fatal("queued action never ran")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func interlock_RequiresOn(t *testing.T, f *interlockFixture) {
+	f.use(t, "requires(fan,water)")
+
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err == nil {
+		t.Fatal("fan turned on without water")
+	}
+	if err := f.water.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func interlock_RequiresStat(t *testing.T, f *interlockFixture) {
+	f.use(t, "stat(fan,temperature>25)")
+
+	f.storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: f.clock.Now(), Value: 20})
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err == nil {
+		t.Fatal("fan turned on while cold")
+	}
+
+	f.storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: f.clock.Now().Add(time.Second), Value: 30})
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func interlock_RequiresStatMissing(t *testing.T, f *interlockFixture) {
+	f.use(t, "stat(fan,temperature<25)")
+
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err == nil {
+		t.Fatal("fan turned on without a temperature reading")
+	}
+}
+
+func interlock_RequiresStatStale(t *testing.T, f *interlockFixture) {
+	f.fan.Interlocks = NewInterlocks(&RequiresStat{
+		Controller: f.fan,
+		Storage:    f.storage,
+		StatType:   stats.StatTypeTemperature,
+		Above:      true,
+		Threshold:  25,
+		MaxAge:     10 * time.Minute,
+		OnBlock:    InterlockReject,
+	})
+
+	f.storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: f.clock.Now(), Value: 30})
+	f.clock.Advance(5 * time.Minute)
+	if err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	f.fan.TurnUnitOff(APICause("test"))
+
+	f.clock.Advance(6 * time.Minute)
+	err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour)
+	if interlockErr, ok := err.(*InterlockError); !ok || !strings.Contains(interlockErr.Reason, "stale") {
+		t.Fatalf("expected a stale reading to block the fan, got %v", err)
+	}
+}
+
+func interlock_RequiresStatStaleByDefault(t *testing.T, f *interlockFixture) {
+	f.use(t, "stat(fan,temperature>25)")
+
+	// the sensor stops reading while it is warm
+	f.storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: f.clock.Now(), Value: 30})
+	f.clock.Advance(testStatMaxAge + time.Second)
+
+	err := f.fan.TurnUnitOn(APICause("test"), 0, time.Hour)
+	if interlockErr, ok := err.(*InterlockError); !ok || !strings.Contains(interlockErr.Reason, "stale") {
+		t.Fatalf("expected a stale reading to block the fan, got %v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+// PID is a proportional-integral-derivative controller
+// producing an output between Min and Max
+type PID struct {
+	Kp float64
+	Ki float64
+	Kd float64
+
+	Min float64
+	Max float64
+
+	// Reverse acting controllers raise their output as the
+	// measurement rises above the setpoint, like a cooling fan
+	Reverse bool
+
+	// integral is the accumulated integral term, already scaled by Ki
+	integral float64
+	// lastMeasured is the previous measurement,
+	// used for the derivative term
+	lastMeasured float64
+	primed       bool
+}
+
+// NewPID creates a PID controller with the
+// given gains and an output between 0 and 1
+func NewPID(kp, ki, kd float64) *PID {
+	return &PID{
+		Kp:  kp,
+		Ki:  ki,
+		Kd:  kd,
+		Min: 0,
+		Max: 1,
+	}
+}
+
+// Update computes the next output for a measurement taken dt after the last
+func (p *PID) Update(setpoint, measured float64, dt time.Duration) float64 {
+	sign := 1.
+	if p.Reverse {
+		sign = -1.
+	}
+	err := sign * (setpoint - measured)
+	secs := dt.Seconds()
+
+	// the derivative is taken on the measurement rather than the
+	// error so that changing the setpoint does not kick the output
+	var derivative float64
+	if p.primed && secs > 0 {
+		derivative = -sign * (measured - p.lastMeasured) / secs
+	}
+	p.lastMeasured = measured
+	p.primed = true
+
+	// anti-windup: the integral term alone can never push
+	// the output past its limits, so it unwinds immediately
+	// once the error changes sign
+	p.integral = clamp(p.integral+p.Ki*err*secs, p.Min, p.Max)
+
+	return clamp(p.Kp*err+p.integral+p.Kd*derivative, p.Min, p.Max)
+}
+
+// Reset clears the accumulated state of this controller
+func (p *PID) Reset() {
+	p.integral = 0
+	p.lastMeasured = 0
+	p.primed = false
+}
+
+func clamp(value, min, max float64) float64 {
+	return math.Max(min, math.Min(max, value))
+}
+
+// PIDLoop drives the level of a Controller's VariableUnit
+// from the latest readings of a stat
+type PIDLoop struct {
+	Controller *Controller
+	PID        *PID
+	Setpoint   float64
+
+	// Input is the stat being controlled
+	Input stats.StatType
+	// Output is the stat the level of the Unit is recorded as
+	Output stats.StatType
+
+	// Clock times the updates, the Controller's unless replaced
+	Clock clock.Clock
+
+	storage  stats.Storage
+	interval time.Duration
+	last     time.Time
+	closed   chan struct{}
+}
+
+// NewPIDLoop creates a loop that holds temperature at the setpoint,
+// updating the level of the Controller's VariableUnit every interval
+func NewPIDLoop(controller *Controller, pid *PID, setpoint float64, storage stats.Storage, interval time.Duration) *PIDLoop {
+	return &PIDLoop{
+		Controller: controller,
+		PID:        pid,
+		Setpoint:   setpoint,
+		Input:      stats.StatTypeTemperature,
+		Output:     stats.StatTypeFanDuty,
+		Clock:      controller.Clock,
+		storage:    storage,
+		interval:   interval,
+		closed:     make(chan struct{}),
+	}
+}
+
+// Begin updates the Unit every interval until the loop is closed
+func (l *PIDLoop) Begin() {
+	ticker := l.Clock.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case now := <-ticker.C():
+			if err := l.step(now); err != nil {
+				l.logWithPrintout(logging.LevelWarn, "pid: %v", err)
+			}
+		}
+	}
+}
+
+// step reads the latest input and sets the level of the Unit
+func (l *PIDLoop) step(now time.Time) error {
+	input, err := l.storage.Latest(l.Input)
+	if err == stats.ErrNoStats {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading %s: %v", l.Input, err)
+	}
+
+	var dt time.Duration
+	if !l.last.IsZero() {
+		dt = now.Sub(l.last)
+	}
+	l.last = now
+
+	level := l.PID.Update(l.Setpoint, input.Value, dt)
+	if err := l.Controller.SetLevel(PolicyCause(fmt.Sprintf("pid %s", l.Input)), level); err != nil {
+		return err
+	}
+	return l.storage.Record(stats.Stat{StatType: l.Output, When: now, Value: level})
+}
+
+// Close stops the loop, leaving the Unit at its current level
+func (l *PIDLoop) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *PIDLoop) logWithPrintout(level logging.Level, format string, args ...interface{}) {
+	if _, err := l.storage.Log(level, format, args...); err != nil {
+		log.Printf(`error logging message "%s": %v`, fmt.Sprintf(format, args...), err)
+	}
+}
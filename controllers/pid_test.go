@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+func TestPID(t *testing.T) {
+	t.Run("PID", func(t *testing.T) {
+		t.Parallel()
+		t.Run("Proportional", pid_Proportional)
+		t.Run("Reverse", pid_Reverse)
+		t.Run("OutputLimits", pid_OutputLimits)
+		t.Run("AntiWindup", pid_AntiWindup)
+		t.Run("Derivative", pid_Derivative)
+	})
+	t.Run("Loop", func(t *testing.T) {
+		t.Parallel()
+		t.Run(controllerTest(pidLoop_NoReadings))
+		t.Run("Step", pidLoop_Step)
+		t.Run("Clock", pidLoop_Clock)
+	})
+}
+
+func assertClose(t *testing.T, name string, got, need float64) {
+	if math.Abs(got-need) > 1e-9 {
+		t.Errorf("unexpected %s: got %g need %g", name, got, need)
+	}
+}
+
+func pid_Proportional(t *testing.T) {
+	pid := NewPID(0.1, 0, 0)
+	assertClose(t, "output", pid.Update(25, 20, time.Second), 0.5)
+	assertClose(t, "output", pid.Update(25, 25, time.Second), 0)
+}
+
+func pid_Reverse(t *testing.T) {
+	pid := NewPID(0.1, 0, 0)
+	pid.Reverse = true
+	assertClose(t, "output", pid.Update(25, 30, time.Second), 0.5)
+	assertClose(t, "output", pid.Update(25, 20, time.Second), 0)
+}
+
+func pid_OutputLimits(t *testing.T) {
+	pid := NewPID(1, 0, 0)
+	pid.Min = 0.2
+	pid.Max = 0.8
+	assertClose(t, "output", pid.Update(100, 0, time.Second), 0.8)
+	assertClose(t, "output", pid.Update(0, 100, time.Second), 0.2)
+}
+
+func pid_AntiWindup(t *testing.T) {
+	pid := NewPID(0, 0.1, 0)
+	// a large error held for a long time saturates the output
+	for i := 0; i < 1000; i++ {
+		pid.Update(30, 20, time.Second)
+	}
+	assertClose(t, "saturated output", pid.Update(30, 20, time.Second), 1)
+
+	// once the error reverses the output must start falling
+	// right away instead of waiting for the windup to unwind
+	output := pid.Update(30, 40, time.Second)
+	if output >= 1 {
+		t.Errorf("integral wound up, output stuck at %g", output)
+	}
+}
+
+func pid_Derivative(t *testing.T) {
+	pid := NewPID(0, 0, 1)
+	pid.Max = 10
+	assertClose(t, "first output", pid.Update(25, 25, time.Second), 0)
+	// the measurement falling away from the setpoint pushes the output up
+	assertClose(t, "output", pid.Update(25, 23, time.Second), 2)
+	// changing the setpoint alone does not kick the output
+	assertClose(t, "setpoint change", pid.Update(30, 23, time.Second), 0)
+}
+
+func pidLoop_NoReadings(t *testing.T, c *Controller, testUnit *TestUnit) {
+	loop := NewPIDLoop(c, NewPID(1, 0, 0), 25, c.storage, time.Hour)
+	if err := loop.step(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pidLoop_Step(t *testing.T) {
+	t.Parallel()
+
+	storage := stats.NewFakeStatsStorage(40)
+	scheduler := NewScheduler()
+	defer scheduler.CancelAll()
+
+	unit := NewFakeVariableUnit(stats.StatTypeFan)
+	c, err := NewController(unit, storage, scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid := NewPID(0.1, 0, 0)
+	pid.Reverse = true
+	loop := NewPIDLoop(c, pid, 25, storage, time.Hour)
+
+	now := time.Now()
+	storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now, Value: 28})
+	if err := loop.step(now); err != nil {
+		t.Fatal(err)
+	}
+
+	level, err := unit.Level()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertClose(t, "level", level, 0.3)
+	if !c.IsOn() {
+		t.Error("controller does not know the fan is on")
+	}
+
+	duty, err := storage.Latest(stats.StatTypeFanDuty)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertClose(t, "recorded duty", duty.Value, 0.3)
+}
+
+func pidLoop_Clock(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2018, time.April, 21, 14, 0, 0, 0, time.Local)
+	manual := clock.NewManual(start)
+	storage := stats.NewFakeStatsStorage(40)
+	scheduler := NewScheduler()
+	scheduler.Clock = manual
+	defer scheduler.CancelAll()
+
+	c, err := NewController(NewFakeVariableUnit(stats.StatTypeFan), storage, scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := NewPID(0, 0.01, 0)
+	pid.Reverse = true
+	loop := NewPIDLoop(c, pid, 25, storage, time.Hour)
+	storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: start, Value: 28})
+
+	go loop.Begin()
+	defer loop.Close()
+	manual.BlockUntil(1)
+
+	// the first update has nothing to integrate over, the second an
+	// hour as timed by the clock, which saturates the integral
+	for i, need := range []float64{0, 1} {
+		manual.Advance(time.Hour)
+		when := start.Add(time.Duration(i+1) * time.Hour)
+		deadline := time.Now().Add(time.Second)
+		for {
+			duty, err := storage.Latest(stats.StatTypeFanDuty)
+			if err == nil && duty.When.Equal(when) {
+				assertClose(t, "duty", duty.Value, need)
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("duty not recorded at %s", when)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
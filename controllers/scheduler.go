@@ -6,18 +6,27 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
 )
 
 // Scheduler schedules actions and provides a way to view what is queued up
 type Scheduler struct {
+	// Clock times the actions, the real clock unless replaced
+	Clock clock.Clock
+
 	taskLock *sync.Mutex
 
 	// actions is a set of pending actions
 	actions map[*Action]bool
+	// lastID is the ID of the last scheduled action
+	lastID uint64
 }
 
 // Action is a function that is to be called some time in the future
 type Action struct {
+	// ID identifies this action among all those scheduled
+	ID uint64
 	// Name is the name of the action being performed
 	Name string
 	// Created is when this action was queued
@@ -34,16 +43,19 @@ type Action struct {
 // NewScheduler creates a new scheduler
 func NewScheduler() *Scheduler {
 	return &Scheduler{
+		Clock:    clock.Real,
 		taskLock: &sync.Mutex{},
 		actions:  make(map[*Action]bool),
 	}
 }
 
-// addAction puts an action in the set of pending actions
+// addAction assigns an ID to an action and puts it in the set of pending actions
 func (s *Scheduler) addAction(action *Action) {
 	s.taskLock.Lock()
 	defer s.taskLock.Unlock()
 
+	s.lastID++
+	action.ID = s.lastID
 	s.actions[action] = true
 }
 
@@ -58,30 +70,31 @@ func (s *Scheduler) removeAction(action *Action) {
 // Schedule executes an action in the future and returns that action.
 // If there is no delay, the action is executed immediately and no Action is returned.
 func (s *Scheduler) Schedule(name string, delay time.Duration, action func()) *Action {
+	return s.schedule(name, delay, func(*Action) {
+		action()
+	})
+}
+
+// schedule is like Schedule, but the action is given the
+// Action it was scheduled as, or nil if it had no delay
+func (s *Scheduler) schedule(name string, delay time.Duration, action func(a *Action)) *Action {
 
 	if delay <= 0 {
-		action()
+		action(nil)
 		return nil
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		select {
-		case <-time.After(delay):
-			action()
-		case <-ctx.Done():
-			break
-		}
-	}()
-
-	now := time.Now()
+	now := s.Clock.Now()
 	start := now.Add(delay)
 
+	ctx, cancel := context.WithCancel(context.Background())
 	a := &Action{
 		Name:    name,
 		Created: now,
 		Start:   start,
-		Perform: action,
+	}
+	a.Perform = func() {
+		action(a)
 	}
 	a.Cancel = func() {
 		cancel()
@@ -89,6 +102,21 @@ func (s *Scheduler) Schedule(name string, delay time.Duration, action func()) *A
 	}
 	s.addAction(a)
 
+	// waiting starts before returning, so the action is due
+	// no matter when the goroutine gets to run
+	due := s.Clock.After(delay)
+	go func() {
+		select {
+		case <-due:
+			// both may be ready if the action was cancelled as it came due
+			if ctx.Err() == nil {
+				a.Perform()
+			}
+		case <-ctx.Done():
+			break
+		}
+	}()
+
 	return a
 }
 
@@ -106,7 +134,7 @@ func (s *Scheduler) Actions() []*Action {
 
 // CancelAll cancels all pending actions in the scheduler
 func (s *Scheduler) CancelAll() {
-	for action := range s.actions {
+	for _, action := range s.Actions() {
 		action.Cancel()
 	}
 }
@@ -119,7 +147,7 @@ func (s *Scheduler) String() string {
 	actions := s.Actions()
 	for _, action := range actions {
 
-		buf.WriteString(fmt.Sprintf("{%s:%s}", action.Name, action.Start))
+		buf.WriteString(fmt.Sprintf("{%d:%s:%s}", action.ID, action.Name, action.Start))
 	}
 
 	buf.WriteRune('}')
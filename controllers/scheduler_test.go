@@ -5,12 +5,19 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+var (
+	schedulerStart = time.Date(2018, time.June, 21, 6, 0, 0, 0, time.Local)
 )
 
 func schedulerTest(f func(t *testing.T, s *Scheduler)) (string, func(*testing.T)) {
 	return testFunctionName(f), func(t *testing.T) {
 		t.Parallel()
 		s := NewScheduler()
+		s.Clock = clock.NewManual(schedulerStart)
 		f(t, s)
 		s.CancelAll()
 	}
@@ -24,8 +31,11 @@ func TestScheduler(t *testing.T) {
 		t.Run(schedulerTest(scheduler_CancelAll))
 		t.Run(schedulerTest(scheduler_Schedule))
 		t.Run(schedulerTest(scheduler_String))
+		t.Run(schedulerTest(scheduler_IDs))
 		t.Run(schedulerTest(scheduler_executesWithDelay))
 		t.Run(schedulerTest(scheduler_executesWithoutDelay))
+		t.Run(schedulerTest(scheduler_executesOverHours))
+		t.Run(schedulerTest(scheduler_cancelled))
 	})
 }
 
@@ -117,11 +127,79 @@ func scheduler_executesWithDelay(t *testing.T, s *Scheduler) {
 		defer wg.Done()
 		count++
 	}
-	s.Schedule("foo", time.Millisecond, foo)
+	action := s.Schedule("foo", time.Millisecond, foo)
+	if !action.Created.Equal(schedulerStart) || !action.Start.Equal(schedulerStart.Add(time.Millisecond)) {
+		t.Errorf("unexpected times: %s %s", action.Created, action.Start)
+	}
 
+	s.Clock.(*clock.Manual).Advance(time.Millisecond)
 	wg.Wait()
 
 	if count != 1 {
 		t.Errorf("unexpected count: %d", count)
 	}
 }
+
+func scheduler_IDs(t *testing.T, s *Scheduler) {
+	foo := func() {}
+	seen := make(map[uint64]bool)
+	for i := 0; i < 5; i++ {
+		action := s.Schedule("foo", time.Hour, foo)
+		if action.ID == 0 || seen[action.ID] {
+			t.Fatalf("unexpected id: %d", action.ID)
+		}
+		seen[action.ID] = true
+	}
+}
+
+func scheduler_executesOverHours(t *testing.T, s *Scheduler) {
+	manual := s.Clock.(*clock.Manual)
+	performed := make(chan string)
+	schedule := []struct {
+		name  string
+		delay time.Duration
+	}{
+		{"dusk", 12 * time.Hour},
+		{"dawn", time.Hour},
+		{"noon", 6 * time.Hour},
+	}
+	for _, action := range schedule {
+		name := action.name
+		s.Schedule(name, action.delay, func() { performed <- name })
+	}
+
+	manual.Advance(59 * time.Minute)
+	select {
+	case name := <-performed:
+		t.Fatalf("%s performed early", name)
+	default:
+	}
+
+	for _, step := range []struct {
+		advance time.Duration
+		name    string
+	}{
+		{time.Minute, "dawn"},
+		{5 * time.Hour, "noon"},
+		{6 * time.Hour, "dusk"},
+	} {
+		manual.Advance(step.advance)
+		if name := <-performed; name != step.name {
+			t.Fatalf("expected %s, got %s", step.name, name)
+		}
+	}
+}
+
+func scheduler_cancelled(t *testing.T, s *Scheduler) {
+	performed := false
+	action := s.Schedule("foo", time.Hour, func() { performed = true })
+	action.Cancel()
+
+	s.Clock.(*clock.Manual).Advance(2 * time.Hour)
+	if performed {
+		t.Error("cancelled action was performed")
+	}
+	if len(s.Actions()) != 0 {
+		t.Errorf("unexpected actions: %v", s.Actions())
+	}
+}
@@ -19,9 +19,24 @@ type Unit interface {
 	// Off turns off the Unit
 	Off() error
 
-	// Whether or not this Unit is on
+	// Whether or not this Unit is on. Units on another device
+	// report the state they last confirmed rather than waiting
+	// on the device, and an *UnreachableError if it is unknown.
 	Status() (UnitStatus, error)
 
 	// Close closes this Unit
 	Close() error
 }
+
+// VariableUnit is a Unit whose output can be set anywhere
+// between off and fully on, such as a PWM controlled fan
+type VariableUnit interface {
+	Unit
+
+	// SetLevel sets the output of this Unit,
+	// from 0 for off up to 1 for fully on
+	SetLevel(level float64) error
+
+	// Level returns the current output of this Unit
+	Level() (float64, error)
+}
@@ -2,44 +2,63 @@ package controllers
 
 import (
 	"log"
-	"time"
 
+	"github.com/explodes/greenhouse-pi/simulation"
 	"github.com/explodes/greenhouse-pi/stats"
 )
 
 type fakeUnit struct {
 	statType stats.StatType
 	on       bool
-	storage  stats.Storage
+	// greenhouse is acted on if set
+	greenhouse *simulation.Greenhouse
 }
 
-func NewFakeUnit(statType stats.StatType, storage stats.Storage) Unit {
+func NewFakeUnit(statType stats.StatType) Unit {
 	return &fakeUnit{
 		statType: statType,
 		on:       false,
-		storage:  storage,
+	}
+}
+
+// NewSimulatedUnit creates a Unit acting on a simulated greenhouse,
+// watering it for StatTypeWater or ventilating it for StatTypeFan
+func NewSimulatedUnit(statType stats.StatType, greenhouse *simulation.Greenhouse) Unit {
+	return &fakeUnit{
+		statType:   statType,
+		on:         false,
+		greenhouse: greenhouse,
+	}
+}
+
+// simulate acts on the simulated greenhouse, if any, at a level from 0 to 1
+func (u *fakeUnit) simulate(level float64) {
+	if u.greenhouse == nil {
+		return
+	}
+	switch u.statType {
+	case stats.StatTypeWater:
+		u.greenhouse.SetWater(level > 0)
+	case stats.StatTypeFan:
+		u.greenhouse.SetFan(level)
 	}
 }
 
 func (u *fakeUnit) Name() string {
-	return string(u.statType)
+	return u.statType.String()
 }
 
 func (u *fakeUnit) On() error {
 	log.Printf("%s on", u.statType)
 	u.on = true
-	if err := u.storage.Record(stats.Stat{StatType: u.statType, When: time.Now(), Value: 1}); err != nil {
-		return err
-	}
+	u.simulate(1)
 	return nil
 }
 
 func (u *fakeUnit) Off() error {
 	log.Printf("%s off", u.statType)
 	u.on = false
-	if err := u.storage.Record(stats.Stat{StatType: u.statType, When: time.Now(), Value: 0}); err != nil {
-		return err
-	}
+	u.simulate(0)
 	return nil
 }
 
@@ -53,3 +72,49 @@ func (u *fakeUnit) Status() (UnitStatus, error) {
 func (u *fakeUnit) Close() error {
 	return nil
 }
+
+type fakeVariableUnit struct {
+	fakeUnit
+	level float64
+}
+
+func NewFakeVariableUnit(statType stats.StatType) VariableUnit {
+	return &fakeVariableUnit{
+		fakeUnit: fakeUnit{
+			statType: statType,
+			on:       false,
+		},
+	}
+}
+
+// NewSimulatedVariableUnit creates a VariableUnit acting on a
+// simulated greenhouse, such as a fan ventilating it at any speed
+func NewSimulatedVariableUnit(statType stats.StatType, greenhouse *simulation.Greenhouse) VariableUnit {
+	return &fakeVariableUnit{
+		fakeUnit: fakeUnit{
+			statType:   statType,
+			on:         false,
+			greenhouse: greenhouse,
+		},
+	}
+}
+
+func (u *fakeVariableUnit) On() error {
+	return u.SetLevel(1)
+}
+
+func (u *fakeVariableUnit) Off() error {
+	return u.SetLevel(0)
+}
+
+func (u *fakeVariableUnit) SetLevel(level float64) error {
+	log.Printf("%s level %g", u.statType, level)
+	u.level = level
+	u.on = level > 0
+	u.simulate(level)
+	return nil
+}
+
+func (u *fakeVariableUnit) Level() (float64, error) {
+	return u.level, nil
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/simulation"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+func TestSimulatedUnit(t *testing.T) {
+	t.Parallel()
+
+	morning := time.Date(2018, time.April, 21, 10, 0, 0, 0, time.Local)
+	clk := clock.NewManual(morning)
+	still := simulation.NewGreenhouse(simulation.DefaultParams, clk)
+	greenhouse := simulation.NewGreenhouse(simulation.DefaultParams, clk)
+
+	fan := NewSimulatedVariableUnit(stats.StatTypeFan, greenhouse)
+	water := NewSimulatedUnit(stats.StatTypeWater, greenhouse)
+	if err := fan.SetLevel(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := water.On(); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(30 * time.Minute)
+	if err := water.Off(); err != nil {
+		t.Fatal(err)
+	}
+
+	if greenhouse.Temperature() >= still.Temperature() {
+		t.Errorf("expected fan to cool below %g, got %g", still.Temperature(), greenhouse.Temperature())
+	}
+	if greenhouse.SoilMoisture() <= still.SoilMoisture() {
+		t.Errorf("expected water to wet the soil above %g, got %g", still.SoilMoisture(), greenhouse.SoilMoisture())
+	}
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// remoteState is the body of requests to and responses from
+// a Unit on another device, such as {"status":"on"}
+type remoteState struct {
+	Status string `json:"status"`
+}
+
+// httpUnit is a Unit on another device, such as a relay board, reached
+// over HTTP. The device serves the state of the unit at its URL:
+//
+//	GET  url                     responds {"status":"on"} or {"status":"off"}
+//	PUT  url {"status":"on"}     switches the unit, responding with its new state
+//
+// A change is only successful once the device confirms the new state.
+// The last state confirmed by the device is kept for Status, and is
+// refreshed in the background every RemoteOptions.Poll.
+type httpUnit struct {
+	name   string
+	url    string
+	opts   RemoteOptions
+	client *http.Client
+
+	// requests is held for every request made of the
+	// device, so an older state never replaces a newer one
+	requests *sync.Mutex
+
+	mu     *sync.Mutex
+	status UnitStatus
+	err    error
+
+	closed chan struct{}
+}
+
+// NewHTTPUnit creates a Unit switched by the device serving its state at
+// url. Its state is requested once before returning, and the Unit reports
+// an error until the device is reached if the request fails.
+func NewHTTPUnit(name, url string, opts RemoteOptions) Unit {
+	u := &httpUnit{
+		name:     name,
+		url:      url,
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout},
+		requests: &sync.Mutex{},
+		mu:       &sync.Mutex{},
+		closed:   make(chan struct{}),
+	}
+	u.refresh()
+	go u.poll()
+	return u
+}
+
+// poll refreshes the state of the unit until it is closed
+func (u *httpUnit) poll() {
+	if u.opts.Poll <= 0 {
+		return
+	}
+	ticker := u.opts.clockOrReal().NewTicker(u.opts.Poll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.closed:
+			return
+		case <-ticker.C():
+			u.refresh()
+		}
+	}
+}
+
+// refresh requests the state of the unit from the device
+func (u *httpUnit) refresh() {
+	u.requests.Lock()
+	defer u.requests.Unlock()
+
+	var status UnitStatus
+	err := u.opts.retry(func() (bool, error) {
+		var retryable bool
+		var err error
+		status, retryable, err = u.request(http.MethodGet, nil)
+		return retryable, err
+	})
+	u.remember(status, err)
+}
+
+// remember keeps the state last reported by the device, or the error
+// that kept it from being known
+func (u *httpUnit) remember(status UnitStatus, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.status, u.err = status, err
+	if err != nil {
+		u.status = UnitStatusError
+	}
+}
+
+func (u *httpUnit) Name() string {
+	return u.name
+}
+
+func (u *httpUnit) On() error {
+	return u.set(UnitStatusOn)
+}
+
+func (u *httpUnit) Off() error {
+	return u.set(UnitStatusOff)
+}
+
+// set switches the unit, until the device confirms the new state
+func (u *httpUnit) set(status UnitStatus) error {
+	u.requests.Lock()
+	defer u.requests.Unlock()
+
+	var actual UnitStatus
+	err := u.opts.retry(func() (bool, error) {
+		body, err := json.Marshal(remoteState{Status: string(status)})
+		if err != nil {
+			return false, fmt.Errorf("error encoding state: %v", err)
+		}
+		var retryable bool
+		actual, retryable, err = u.request(http.MethodPut, bytes.NewReader(body))
+		if err != nil {
+			return retryable, err
+		}
+		if actual != status {
+			return true, fmt.Errorf("%s did not confirm %s, reported %s", u.name, status, actual)
+		}
+		return false, nil
+	})
+	if actual == UnitStatusOn || actual == UnitStatusOff {
+		// the device reported its state, even if not the one requested
+		u.remember(actual, nil)
+	} else {
+		u.remember(UnitStatusError, err)
+	}
+	return err
+}
+
+// Status returns the state last confirmed by the device without waiting
+// on it, or UnitStatusError if the device could not be reached last time
+func (u *httpUnit) Status() (UnitStatus, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.status, u.err
+}
+
+// request makes a request of the device, returning the state it reports
+// and whether or not a failure is worth retrying. Failures worth retrying
+// are an *UnreachableError.
+func (u *httpUnit) request(method string, body io.Reader) (UnitStatus, bool, error) {
+	req, err := http.NewRequest(method, u.url, body)
+	if err != nil {
+		return UnitStatusError, false, fmt.Errorf("error creating request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return UnitStatusError, true, &UnreachableError{Unit: u.name, Reason: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		message = bytes.TrimSpace(message)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return UnitStatusError, true, &UnreachableError{Unit: u.name, Reason: fmt.Sprintf("responded %s: %s", resp.Status, message)}
+		}
+		return UnitStatusError, false, fmt.Errorf("%s responded %s: %s", u.name, resp.Status, message)
+	}
+
+	var state remoteState
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024)).Decode(&state); err != nil {
+		return UnitStatusError, false, fmt.Errorf("error decoding state of %s: %v", u.name, err)
+	}
+	status, err := parseRemoteStatus(state.Status)
+	if err != nil {
+		return UnitStatusError, false, fmt.Errorf("%s reported %v", u.name, err)
+	}
+	return status, false, nil
+}
+
+func (u *httpUnit) Close() error {
+	close(u.closed)
+	u.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+var testRemoteOptions = RemoteOptions{
+	Timeout:    time.Second,
+	Retries:    2,
+	RetryDelay: time.Millisecond,
+}
+
+// relayServer serves a unit like a relay board would
+type relayServer struct {
+	*httptest.Server
+
+	mu       *sync.Mutex
+	status   string
+	failures int
+	confirm  bool
+	requests int
+	// served is sent to after every request if set
+	served chan struct{}
+}
+
+func newRelayServer() *relayServer {
+	s := &relayServer{mu: &sync.Mutex{}, status: UnitStatusOff, confirm: true}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *relayServer) fail(failures int, confirm bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = failures
+	s.confirm = confirm
+}
+
+func (s *relayServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *relayServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	if s.served != nil {
+		defer func() { s.served <- struct{}{} }()
+	}
+	if s.failures > 0 {
+		s.failures--
+		http.Error(w, "relay busy", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var state remoteState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.confirm {
+			s.status = state.Status
+		}
+	}
+	json.NewEncoder(w).Encode(remoteState{Status: s.status})
+}
+
+func TestHTTPUnit(t *testing.T) {
+	t.Parallel()
+
+	t.Run("OnOff", func(t *testing.T) {
+		server := newRelayServer()
+		defer server.Close()
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+
+		if unit.Name() != "water" {
+			t.Errorf("unexpected name: %s", unit.Name())
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOff {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		if err := unit.On(); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOn {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		if err := unit.Off(); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOff {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		server := newRelayServer()
+		defer server.Close()
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+		server.fail(2, true)
+		before := server.count()
+
+		if err := unit.On(); err != nil {
+			t.Fatal(err)
+		}
+		if requests := server.count() - before; requests != 3 {
+			t.Errorf("expected 3 requests, got %d", requests)
+		}
+	})
+
+	t.Run("RetriesExhausted", func(t *testing.T) {
+		server := newRelayServer()
+		defer server.Close()
+		server.fail(10, true)
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+
+		err := unit.On()
+		if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if status, err := unit.Status(); err == nil || status != UnitStatusError {
+			t.Errorf("unexpected status: %s %v", status, err)
+		}
+	})
+
+	t.Run("Unconfirmed", func(t *testing.T) {
+		server := newRelayServer()
+		defer server.Close()
+		server.fail(0, false)
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+
+		err := unit.On()
+		if err == nil || !strings.Contains(err.Error(), "did not confirm on") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		server := newRelayServer()
+		url := server.URL
+		server.Close()
+		unit := NewHTTPUnit("water", url, testRemoteOptions)
+		defer unit.Close()
+
+		status, err := unit.Status()
+		if status != UnitStatusError {
+			t.Errorf("unexpected status: %s", status)
+		}
+		if err == nil || !strings.Contains(err.Error(), "water is unreachable") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := unit.On(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("Cached", func(t *testing.T) {
+		server := newRelayServer()
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+		if err := unit.On(); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+
+		// the state confirmed last is reported without asking the device
+		if status, err := unit.Status(); err != nil || status != UnitStatusOn {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		unit.(*httpUnit).refresh()
+		status, err := unit.Status()
+		if _, ok := err.(*UnreachableError); !ok || status != UnitStatusError {
+			t.Errorf("unexpected status: %s %v", status, err)
+		}
+	})
+
+	t.Run("Poll", func(t *testing.T) {
+		server := newRelayServer()
+		defer server.Close()
+		clk := clock.NewManual(time.Now())
+		opts := testRemoteOptions
+		opts.Poll = time.Minute
+		opts.Clock = clk
+		unit := NewHTTPUnit("water", server.URL, opts)
+		defer unit.Close()
+		clk.BlockUntil(1)
+
+		// turned on at the device
+		served := make(chan struct{}, 1)
+		server.mu.Lock()
+		server.status = UnitStatusOn
+		server.served = served
+		server.mu.Unlock()
+
+		if status, _ := unit.Status(); status != UnitStatusOff {
+			t.Fatalf("state refreshed before polling: %s", status)
+		}
+		clk.Advance(time.Minute)
+		<-served
+		// wait for the response to be remembered
+		u := unit.(*httpUnit)
+		u.requests.Lock()
+		u.requests.Unlock()
+
+		if status, _ := unit.Status(); status != UnitStatusOn {
+			t.Fatalf("state was not refreshed: %s", status)
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no such unit", http.StatusNotFound)
+		}))
+		defer server.Close()
+		unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+		defer unit.Close()
+
+		err := unit.On()
+		if err == nil || strings.Contains(err.Error(), "attempts") || !strings.Contains(err.Error(), "no such unit") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
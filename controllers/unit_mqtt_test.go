@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/mqtt/mqtttest"
+)
+
+// mqttRelay starts a broker with a device switching
+// the unit published to topic, starting in state
+func mqttRelay(t *testing.T, topic, state string) *mqtttest.Broker {
+	broker, err := mqtttest.NewBroker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "" {
+		broker.Publish(topic, state, true)
+	}
+	broker.Handle(topic+"/set", func(msg mqtttest.Message) {
+		broker.Publish(topic, msg.Payload, true)
+	})
+	return broker
+}
+
+func TestMQTTUnit(t *testing.T) {
+	t.Parallel()
+
+	t.Run("OnOff", func(t *testing.T) {
+		broker := mqttRelay(t, "relay/water", UnitStatusOff)
+		defer broker.Close()
+		unit, err := NewMQTTUnit("water", broker.URL(), "relay/water", testRemoteOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unit.Close()
+
+		if unit.Name() != "water" {
+			t.Errorf("unexpected name: %s", unit.Name())
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOff {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		if err := unit.On(); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOn {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		if err := unit.Off(); err != nil {
+			t.Fatal(err)
+		}
+		if status, err := unit.Status(); err != nil || status != UnitStatusOff {
+			t.Fatalf("unexpected status: %s %v", status, err)
+		}
+		if len(broker.Published("relay/water/set")) != 2 {
+			t.Errorf("expected 2 commands, got %v", broker.Published("relay/water/set"))
+		}
+	})
+
+	t.Run("NoState", func(t *testing.T) {
+		broker := mqttRelay(t, "relay/water", "")
+		defer broker.Close()
+		opts := testRemoteOptions
+		opts.Timeout = 100 * time.Millisecond
+
+		_, err := NewMQTTUnit("water", broker.URL(), "relay/water", opts)
+		if err == nil || !strings.Contains(err.Error(), "no state published") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Unconfirmed", func(t *testing.T) {
+		broker, err := mqtttest.NewBroker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer broker.Close()
+		broker.Publish("relay/water", UnitStatusOff, true)
+		opts := testRemoteOptions
+		opts.Timeout = 100 * time.Millisecond
+		unit, err := NewMQTTUnit("water", broker.URL(), "relay/water", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unit.Close()
+
+		err = unit.On()
+		if err == nil || !strings.Contains(err.Error(), "did not confirm on") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Offline", func(t *testing.T) {
+		broker := mqttRelay(t, "relay/water", UnitStatusOn)
+		defer broker.Close()
+		unit, err := NewMQTTUnit("water", broker.URL(), "relay/water", testRemoteOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unit.Close()
+
+		// the last will of the device
+		broker.Publish("relay/water", "offline", true)
+		deadline := time.Now().Add(time.Second)
+		for {
+			status, err := unit.Status()
+			if status == UnitStatusError {
+				if err == nil || !strings.Contains(err.Error(), "offline") {
+					t.Errorf("unexpected error: %v", err)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected status: %s", status)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		broker := mqttRelay(t, "relay/water", UnitStatusOff)
+		unit, err := NewMQTTUnit("water", broker.URL(), "relay/water", testRemoteOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unit.Close()
+		broker.Close()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			status, err := unit.Status()
+			if status == UnitStatusError {
+				if err == nil || !strings.Contains(err.Error(), "water is unreachable") {
+					t.Errorf("unexpected error: %v", err)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected status: %s", status)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := unit.On(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPWMPeriod is 25kHz, the standard for 4-pin fans
+	DefaultPWMPeriod = 40 * time.Microsecond
+
+	pwmExportWait = 2 * time.Second
+)
+
+// pwmUnit is a VariableUnit driven by the linux sysfs PWM interface,
+// for example /sys/class/pwm/pwmchip0/pwm0
+type pwmUnit struct {
+	name   string
+	dir    string
+	period time.Duration
+
+	mu *sync.Mutex
+}
+
+// NewPWMUnit exports and configures a PWM channel of a chip, such as
+// /sys/class/pwm/pwmchip0, running at the given period. The
+// channel starts enabled with a duty cycle of zero.
+func NewPWMUnit(name string, chip string, channel int, period time.Duration) (VariableUnit, error) {
+	dir := filepath.Join(chip, fmt.Sprintf("pwm%d", channel))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := writeSysfs(filepath.Join(chip, "export"), strconv.Itoa(channel)); err != nil {
+			return nil, fmt.Errorf("error exporting pwm channel %d: %v", channel, err)
+		}
+		if err := waitForSysfs(dir, pwmExportWait); err != nil {
+			return nil, err
+		}
+	}
+
+	unit := &pwmUnit{
+		name:   name,
+		dir:    dir,
+		period: period,
+		mu:     &sync.Mutex{},
+	}
+	// duty cycle must never exceed the period, so clear it
+	// before changing the period in case it was left set
+	if err := unit.write("duty_cycle", 0); err != nil {
+		return nil, err
+	}
+	if err := unit.write("period", int64(period)); err != nil {
+		return nil, err
+	}
+	if err := unit.write("enable", 1); err != nil {
+		return nil, err
+	}
+	return unit, nil
+}
+
+func (u *pwmUnit) write(file string, value int64) error {
+	if err := writeSysfs(filepath.Join(u.dir, file), strconv.FormatInt(value, 10)); err != nil {
+		return fmt.Errorf("error writing pwm %s: %v", file, err)
+	}
+	return nil
+}
+
+func (u *pwmUnit) Name() string {
+	return u.name
+}
+
+func (u *pwmUnit) On() error {
+	return u.SetLevel(1)
+}
+
+func (u *pwmUnit) Off() error {
+	return u.SetLevel(0)
+}
+
+func (u *pwmUnit) SetLevel(level float64) error {
+	level = math.Max(0, math.Min(1, level))
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	duty := int64(math.Round(level * float64(u.period)))
+	return u.write("duty_cycle", duty)
+}
+
+func (u *pwmUnit) Level() (float64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(u.dir, "duty_cycle"))
+	if err != nil {
+		return 0, fmt.Errorf("error reading pwm duty_cycle: %v", err)
+	}
+	duty, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("error parsing pwm duty_cycle: %v", err)
+	}
+	return float64(duty) / float64(u.period), nil
+}
+
+func (u *pwmUnit) Status() (UnitStatus, error) {
+	level, err := u.Level()
+	if err != nil {
+		return UnitStatusError, err
+	}
+	if level > 0 {
+		return UnitStatusOn, nil
+	}
+	return UnitStatusOff, nil
+}
+
+func (u *pwmUnit) Close() error {
+	return nil
+}
+
+func writeSysfs(path, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// waitForSysfs waits for the kernel to create an exported sysfs directory
+func waitForSysfs(dir string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", dir)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
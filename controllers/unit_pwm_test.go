@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePWMChip creates a directory laid out like a
+// sysfs pwm chip with an already exported channel
+func fakePWMChip(t *testing.T) (string, func()) {
+	chip, err := ioutil.TempDir("", "pwmchip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := filepath.Join(chip, "pwm0")
+	if err := os.Mkdir(channel, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"period", "duty_cycle", "enable"} {
+		if err := ioutil.WriteFile(filepath.Join(channel, file), []byte("0\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return chip, func() { os.RemoveAll(chip) }
+}
+
+func readSysfs(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestPWMUnit(t *testing.T) {
+	t.Parallel()
+
+	chip, cleanup := fakePWMChip(t)
+	defer cleanup()
+
+	unit, err := NewPWMUnit("fan", chip, 0, 40*time.Microsecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unit.Close()
+
+	if readSysfs(t, filepath.Join(chip, "pwm0", "period")) != "40000" {
+		t.Error("period not configured")
+	}
+	if readSysfs(t, filepath.Join(chip, "pwm0", "enable")) != "1" {
+		t.Error("channel not enabled")
+	}
+
+	if err := unit.SetLevel(0.25); err != nil {
+		t.Fatal(err)
+	}
+	if duty := readSysfs(t, filepath.Join(chip, "pwm0", "duty_cycle")); duty != "10000" {
+		t.Errorf("unexpected duty cycle: %s", duty)
+	}
+	level, err := unit.Level()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != 0.25 {
+		t.Errorf("unexpected level: %g", level)
+	}
+	if status, err := unit.Status(); err != nil || status != UnitStatusOn {
+		t.Errorf("unexpected status: %s %v", status, err)
+	}
+
+	if err := unit.SetLevel(2); err != nil {
+		t.Fatal(err)
+	}
+	if duty := readSysfs(t, filepath.Join(chip, "pwm0", "duty_cycle")); duty != "40000" {
+		t.Errorf("level was not limited: %s", duty)
+	}
+
+	if err := unit.Off(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := unit.Status(); err != nil || status != UnitStatusOff {
+		t.Errorf("unexpected status: %s %v", status, err)
+	}
+}
+
+func TestPWMUnit_Export(t *testing.T) {
+	t.Parallel()
+
+	chip, err := ioutil.TempDir("", "pwmchip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(chip)
+	if err := ioutil.WriteFile(filepath.Join(chip, "export"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing creates the channel directory in
+	// response to the export, so this must time out
+	if _, err := NewPWMUnit("fan", chip, 1, DefaultPWMPeriod); err == nil {
+		t.Fatal("expected error waiting for export")
+	}
+	if readSysfs(t, filepath.Join(chip, "export")) != "1" {
+		t.Error("channel was not exported")
+	}
+}
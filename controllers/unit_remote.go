@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+// RemoteOptions configure how a Unit on another device is reached
+type RemoteOptions struct {
+	// Timeout is how long each attempt may take
+	Timeout time.Duration
+	// Retries is how many more attempts are made after a failure
+	Retries int
+	// RetryDelay is the wait before the first retry, doubling every retry
+	RetryDelay time.Duration
+	// Poll is how often the state of a Unit that is not told of changes
+	// is refreshed in the background, never if zero
+	Poll time.Duration
+	// Clock times retries and polls, the real clock if nil
+	Clock clock.Clock
+}
+
+var (
+	// DefaultRemoteOptions suit a device on the local network
+	DefaultRemoteOptions = RemoteOptions{
+		Timeout:    5 * time.Second,
+		Retries:    2,
+		RetryDelay: 500 * time.Millisecond,
+		Poll:       10 * time.Second,
+		Clock:      clock.Real,
+	}
+)
+
+// UnreachableError is returned by a Unit on another device that could not
+// be reached, when the state of the Unit is unknown rather than wrong
+type UnreachableError struct {
+	Unit   string
+	Reason string
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("%s is unreachable: %s", e.Unit, e.Reason)
+}
+
+// clockOrReal returns the Clock of these options, or the real clock if there is none
+func (opts RemoteOptions) clockOrReal() clock.Clock {
+	if opts.Clock == nil {
+		return clock.Real
+	}
+	return opts.Clock
+}
+
+// retry calls attempt until it succeeds, fails without being worth
+// retrying, or has been retried as many times as allowed
+func (opts RemoteOptions) retry(attempt func() (bool, error)) error {
+	delay := opts.RetryDelay
+	for i := 0; ; i++ {
+		retryable, err := attempt()
+		if err == nil || !retryable || i >= opts.Retries {
+			if unreachable, ok := err.(*UnreachableError); ok && i > 0 {
+				unreachable.Reason = fmt.Sprintf("%s, after %d attempts", unreachable.Reason, i+1)
+				return unreachable
+			} else if err != nil && i > 0 {
+				return fmt.Errorf("%v, after %d attempts", err, i+1)
+			}
+			return err
+		}
+		<-opts.clockOrReal().After(delay)
+		delay *= 2
+	}
+}
+
+// parseRemoteStatus returns the UnitStatus reported by a device
+func parseRemoteStatus(status string) (UnitStatus, error) {
+	switch status {
+	case UnitStatusOn, UnitStatusOff:
+		return UnitStatus(status), nil
+	default:
+		return UnitStatusError, fmt.Errorf("unexpected status %q", status)
+	}
+}
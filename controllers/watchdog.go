@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// watchdogFeed is written to the watchdog device to keep it from firing
+	watchdogFeed = "1"
+	// watchdogMagicClose is written to the watchdog device to disarm it
+	// on a clean shutdown, if the driver supports it
+	watchdogMagicClose = "V"
+)
+
+// Watchdog is a safety layer that forces Units off when they have
+// been on for too long, no matter what is scheduled, and feeds a
+// hardware watchdog for as long as it is able to do so. If the
+// daemon hangs, the hardware watchdog stops being fed and resets
+// the system. A Unit on another device that cannot be reached is
+// logged as unknown rather than keeping the hardware watchdog from
+// being fed, since resetting the system cannot bring back the device.
+type Watchdog struct {
+	// Clock times the checks, the real clock unless replaced
+	Clock clock.Clock
+
+	device   *os.File
+	interval time.Duration
+	storage  stats.Storage
+
+	mu        *sync.Mutex
+	guards    []*guard
+	heartbeat time.Time
+	healthy   bool
+
+	closed chan struct{}
+}
+
+// guard is a Controller and the maximum
+// time its Unit may be continuously on
+type guard struct {
+	controller *Controller
+	maxOnTime  time.Duration
+	// unknown is whether or not the Unit could not be reached last check
+	unknown bool
+}
+
+// NewWatchdog creates a Watchdog that checks its guarded Units
+// every interval. If path is not empty, it is opened as the
+// hardware watchdog device, usually /dev/watchdog.
+func NewWatchdog(path string, interval time.Duration, storage stats.Storage) (*Watchdog, error) {
+	w := &Watchdog{
+		Clock:    clock.Real,
+		interval: interval,
+		storage:  storage,
+		mu:       &sync.Mutex{},
+		closed:   make(chan struct{}),
+	}
+	if path != "" {
+		device, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("error opening watchdog device %s: %v", path, err)
+		}
+		w.device = device
+	}
+	return w, nil
+}
+
+// Guard enforces a maximum continuous on-time for a Controller's Unit.
+// A maxOnTime of zero or less leaves the Unit unguarded.
+func (w *Watchdog) Guard(controller *Controller, maxOnTime time.Duration) {
+	if maxOnTime <= 0 {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.guards = append(w.guards, &guard{controller: controller, maxOnTime: maxOnTime})
+}
+
+// Begin checks the guarded Units at every interval until the Watchdog is closed
+func (w *Watchdog) Begin() {
+	ticker := w.Clock.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.closed:
+			return
+		case now := <-ticker.C():
+			w.check(now)
+		}
+	}
+}
+
+// check enforces every guard and feeds the hardware watchdog only if
+// every guard could be enforced or its Unit could not be reached
+func (w *Watchdog) check(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	healthy := true
+	for _, g := range w.guards {
+		forced, err := g.controller.enforceMaxOnTime(g.maxOnTime, now)
+		if _, ok := err.(*UnreachableError); ok {
+			if !g.unknown {
+				w.logWithPrintout(logging.LevelWarn, "watchdog: state of %s is unknown: %v", g.controller.Unit.Name(), err)
+			}
+			g.unknown = true
+			continue
+		}
+		if g.unknown && err == nil {
+			w.logWithPrintout(logging.LevelInfo, "watchdog: %s can be reached again", g.controller.Unit.Name())
+			g.unknown = false
+		}
+		if err != nil {
+			healthy = false
+			w.logWithPrintout(logging.LevelError, "watchdog: %v", err)
+			continue
+		}
+		if forced {
+			w.logWithPrintout(logging.LevelError, "watchdog: %s exceeded its maximum on-time of %s and was forced off", g.controller.Unit.Name(), g.maxOnTime)
+		}
+	}
+
+	if healthy && w.device != nil {
+		if _, err := w.device.WriteString(watchdogFeed); err != nil {
+			healthy = false
+			w.logWithPrintout(logging.LevelError, "watchdog: error feeding watchdog: %v", err)
+		}
+	}
+
+	w.healthy = healthy
+	if healthy {
+		w.heartbeat = now
+	}
+}
+
+// Heartbeat returns the last time every guarded Unit was
+// successfully checked and whether or not the last check succeeded
+func (w *Watchdog) Heartbeat() (time.Time, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.heartbeat, w.healthy
+}
+
+// Close stops checking Units and disarms the hardware watchdog
+func (w *Watchdog) Close() error {
+	close(w.closed)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.device == nil {
+		return nil
+	}
+	if _, err := w.device.WriteString(watchdogMagicClose); err != nil {
+		w.device.Close()
+		return fmt.Errorf("error disarming watchdog: %v", err)
+	}
+	return w.device.Close()
+}
+
+func (w *Watchdog) logWithPrintout(level logging.Level, format string, args ...interface{}) {
+	if _, err := w.storage.Log(level, format, args...); err != nil {
+		log.Printf(`error logging message "%s": %v`, fmt.Sprintf(format, args...), err)
+	}
+}
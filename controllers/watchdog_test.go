@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+type brokenUnit struct{}
+
+func (brokenUnit) Name() string {
+	return "broken"
+}
+
+func (brokenUnit) On() error {
+	return errors.New("broken")
+}
+
+func (brokenUnit) Off() error {
+	return errors.New("broken")
+}
+
+func (brokenUnit) Status() (UnitStatus, error) {
+	return UnitStatusError, errors.New("broken")
+}
+
+func (brokenUnit) Close() error {
+	return nil
+}
+
+func watchdogTest(f func(t *testing.T, w *Watchdog, c *Controller, device string)) (string, func(*testing.T)) {
+	return testFunctionName(f), func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "watchdog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		device := filepath.Join(dir, "watchdog")
+		if err := ioutil.WriteFile(device, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		storage := stats.NewFakeStatsStorage(40)
+		scheduler := NewScheduler()
+		defer scheduler.CancelAll()
+
+		c, err := NewController(NewFakeUnit(stats.StatTypeWater), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, err := NewWatchdog(device, time.Hour, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.device.Close()
+
+		f(t, w, c, device)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	t.Run("Watchdog", func(t *testing.T) {
+		t.Parallel()
+		t.Run(watchdogTest(watchdog_UnderLimit))
+		t.Run(watchdogTest(watchdog_OverLimit))
+		t.Run(watchdogTest(watchdog_TurnedOnElsewhere))
+		t.Run(watchdogTest(watchdog_Unguarded))
+		t.Run(watchdogTest(watchdog_Unhealthy))
+		t.Run(watchdogTest(watchdog_Unreachable))
+		t.Run(watchdogTest(watchdog_Close))
+	})
+}
+
+func readDevice(t *testing.T, device string) string {
+	b, err := ioutil.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func unitStatus(t *testing.T, c *Controller) UnitStatus {
+	status, err := c.Unit.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func watchdog_UnderLimit(t *testing.T, w *Watchdog, c *Controller, device string) {
+	w.Guard(c, time.Minute)
+	c.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour))
+
+	w.check(time.Now().Add(30 * time.Second))
+
+	if unitStatus(t, c) != UnitStatusOn {
+		t.Error("unit was turned off before its limit")
+	}
+	if readDevice(t, device) != watchdogFeed {
+		t.Error("watchdog was not fed")
+	}
+}
+
+func watchdog_OverLimit(t *testing.T, w *Watchdog, c *Controller, device string) {
+	w.Guard(c, time.Minute)
+	c.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour))
+
+	w.check(time.Now().Add(2 * time.Minute))
+
+	if unitStatus(t, c) != UnitStatusOff {
+		t.Error("unit was not forced off")
+	}
+	if readDevice(t, device) != watchdogFeed {
+		t.Error("watchdog was not fed")
+	}
+	if _, healthy := w.Heartbeat(); !healthy {
+		t.Error("watchdog is not healthy")
+	}
+}
+
+func watchdog_TurnedOnElsewhere(t *testing.T, w *Watchdog, c *Controller, device string) {
+	w.Guard(c, time.Minute)
+	if err := c.Unit.On(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	w.check(now)
+	if unitStatus(t, c) != UnitStatusOn {
+		t.Fatal("unit was turned off before its limit")
+	}
+
+	w.check(now.Add(2 * time.Minute))
+	if unitStatus(t, c) != UnitStatusOff {
+		t.Error("unit was not forced off")
+	}
+}
+
+func watchdog_Unguarded(t *testing.T, w *Watchdog, c *Controller, device string) {
+	w.Guard(c, 0)
+	c.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour))
+
+	w.check(time.Now().Add(24 * time.Hour))
+
+	if unitStatus(t, c) != UnitStatusOn {
+		t.Error("unguarded unit was turned off")
+	}
+}
+
+func watchdog_Unhealthy(t *testing.T, w *Watchdog, c *Controller, device string) {
+	w.Guard(&Controller{Unit: brokenUnit{}, mu: &sync.Mutex{}}, time.Minute)
+
+	w.check(time.Now())
+
+	if readDevice(t, device) != "" {
+		t.Error("watchdog was fed while unhealthy")
+	}
+	if heartbeat, healthy := w.Heartbeat(); healthy || !heartbeat.IsZero() {
+		t.Errorf("unexpected heartbeat: %s %v", heartbeat, healthy)
+	}
+}
+
+func watchdog_Unreachable(t *testing.T, w *Watchdog, c *Controller, device string) {
+	server := newRelayServer()
+	unit := NewHTTPUnit("water", server.URL, testRemoteOptions)
+	defer unit.Close()
+	remote, err := NewController(unit, c.storage, c.scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Guard(remote, time.Minute)
+	if err := remote.turnUnitOnNow(APICause("test"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+	unit.(*httpUnit).refresh()
+
+	manual := clock.NewManual(time.Now())
+	w.Clock = manual
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Begin()
+	}()
+	defer func() {
+		w.Close()
+		<-done
+	}()
+
+	// checked twice, the second time well past the maximum on-time
+	manual.BlockUntil(1)
+	for i := 0; i < 2; i++ {
+		manual.Advance(time.Hour)
+		deadline := time.Now().Add(time.Second)
+		for {
+			if heartbeat, healthy := w.Heartbeat(); healthy && heartbeat.Equal(manual.Now()) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("watchdog was not fed on check %d", i+1)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if fed := readDevice(t, device); fed != watchdogFeed+watchdogFeed {
+		t.Errorf("unexpected feeds: %q", fed)
+	}
+}
+
+func watchdog_Close(t *testing.T, w *Watchdog, c *Controller, device string) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Begin()
+	}()
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if readDevice(t, device) != watchdogMagicClose {
+		t.Error("watchdog was not disarmed")
+	}
+}
@@ -0,0 +1,151 @@
+package derived
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// DefaultMaxSkew is the longest time between a temperature and
+	// a humidity reading for them to be considered simultaneous
+	DefaultMaxSkew = 30 * time.Second
+)
+
+// Climate is a Storage that derives the dew point, vapor pressure
+// deficit and heat index from the temperature and humidity readings
+// recorded through it. Every temperature reading is paired with the
+// humidity reading closest after or before it within MaxSkew, and
+// the derived stats are recorded at the time of the later reading.
+type Climate struct {
+	stats.Storage
+
+	// MaxSkew is the longest time between paired readings
+	MaxSkew time.Duration
+
+	mu          *sync.Mutex
+	temperature *stats.Stat
+	humidity    *stats.Stat
+}
+
+// NewClimate creates a Climate recording to storage
+func NewClimate(storage stats.Storage) *Climate {
+	return &Climate{
+		Storage: storage,
+		MaxSkew: DefaultMaxSkew,
+		mu:      &sync.Mutex{},
+	}
+}
+
+// Record puts a Stat record in the Storage, and the stats
+// derived from it once it can be paired with another reading
+func (c *Climate) Record(stat stats.Stat) error {
+	if err := c.Storage.Record(stat); err != nil {
+		return err
+	}
+
+	temperature, humidity, ok := c.pair(stat)
+	if !ok {
+		return nil
+	}
+	when := temperature.When
+	if humidity.When.After(when) {
+		when = humidity.When
+	}
+
+	t, rh := temperature.Value, humidity.Value
+	derived := []stats.Stat{
+		{StatType: stats.StatTypeVPD, When: when, Value: VPD(t, rh)},
+		{StatType: stats.StatTypeHeatIndex, When: when, Value: HeatIndex(t, rh)},
+	}
+	// the dew point is undefined for completely dry air
+	if rh > 0 {
+		derived = append(derived, stats.Stat{StatType: stats.StatTypeDewPoint, When: when, Value: DewPoint(t, rh)})
+	}
+	for _, d := range derived {
+		if err := c.Storage.Record(d); err != nil {
+			return fmt.Errorf("error recording %s: %v", d.StatType, err)
+		}
+	}
+	return nil
+}
+
+// pair returns the temperature and humidity readings to derive stats
+// from, if stat completes a pair. Paired readings are not used again.
+func (c *Climate) pair(stat stats.Stat) (stats.Stat, stats.Stat, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var other *stats.Stat
+	switch stat.StatType {
+	case stats.StatTypeTemperature:
+		c.temperature = &stat
+		other = c.humidity
+	case stats.StatTypeHumidity:
+		c.humidity = &stat
+		other = c.temperature
+	default:
+		return stats.Stat{}, stats.Stat{}, false
+	}
+	if other == nil {
+		return stats.Stat{}, stats.Stat{}, false
+	}
+
+	skew := stat.When.Sub(other.When)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > c.MaxSkew {
+		return stats.Stat{}, stats.Stat{}, false
+	}
+
+	temperature, humidity := *c.temperature, *c.humidity
+	c.temperature, c.humidity = nil, nil
+	return temperature, humidity, true
+}
+
+// saturationVaporPressure returns the saturation vapor pressure
+// in kPa of air at a temperature in celsius, by Tetens' equation
+func saturationVaporPressure(t float64) float64 {
+	return 0.6108 * math.Exp(17.27*t/(t+237.3))
+}
+
+// VPD returns the vapor pressure deficit in kPa of air at a
+// temperature in celsius and a relative humidity in percent
+func VPD(t, rh float64) float64 {
+	return saturationVaporPressure(t) * (1 - rh/100)
+}
+
+// DewPoint returns the dew point in celsius of air at a temperature
+// in celsius and a relative humidity in percent, by the Magnus formula
+func DewPoint(t, rh float64) float64 {
+	const b, c = 17.62, 243.12
+	gamma := math.Log(rh/100) + b*t/(c+t)
+	return c * gamma / (b - gamma)
+}
+
+// HeatIndex returns the apparent temperature in celsius of air at a
+// temperature in celsius and a relative humidity in percent, by the
+// regression of the US National Weather Service
+func HeatIndex(t, rh float64) float64 {
+	f := t*9/5 + 32
+
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh -
+			0.22475541*f*rh - 0.00683783*f*f -
+			0.05481717*rh*rh + 0.00122874*f*f*rh +
+			0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		switch {
+		case rh < 13 && f >= 80 && f <= 112:
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		case rh > 85 && f >= 80 && f <= 87:
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+
+	return (hi - 32) * 5 / 9
+}
@@ -0,0 +1,135 @@
+package derived
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+func climateTest(f func(t *testing.T, c *Climate, storage stats.Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(20)
+		defer storage.Close()
+
+		f(t, NewClimate(storage), storage)
+	}
+	return name, testFunc
+}
+
+func TestClimate(t *testing.T) {
+	t.Parallel()
+	t.Run("Formulas", func(t *testing.T) {
+		t.Parallel()
+		t.Run(testFunctionName(formulas_VPD), formulas_VPD)
+		t.Run(testFunctionName(formulas_DewPoint), formulas_DewPoint)
+		t.Run(testFunctionName(formulas_HeatIndex), formulas_HeatIndex)
+	})
+	t.Run("Climate", func(t *testing.T) {
+		t.Parallel()
+		t.Run(climateTest(climate_RecordsDerived))
+		t.Run(climateTest(climate_SkewTooLarge))
+		t.Run(climateTest(climate_PairsOnce))
+		t.Run(climateTest(climate_DryAir))
+	})
+}
+
+func assertClose(t *testing.T, name string, expected, actual, tolerance float64) {
+	t.Helper()
+	if math.Abs(expected-actual) > tolerance {
+		t.Errorf("unexpected %s: expected %g, got %g", name, expected, actual)
+	}
+}
+
+func formulas_VPD(t *testing.T) {
+	assertClose(t, "vpd", 1.267, VPD(25, 60), 0.005)
+	assertClose(t, "vpd", 0, VPD(25, 100), 1e-9)
+}
+
+func formulas_DewPoint(t *testing.T) {
+	assertClose(t, "dew point", 16.7, DewPoint(25, 60), 0.1)
+	assertClose(t, "dew point", 20, DewPoint(20, 100), 1e-9)
+}
+
+func formulas_HeatIndex(t *testing.T) {
+	// mild air feels like its temperature
+	assertClose(t, "heat index", 20, HeatIndex(20, 50), 1)
+	// 90°F at 70% feels like 106°F
+	assertClose(t, "heat index", 41.1, HeatIndex(32.2, 70), 0.5)
+}
+
+func latest(t *testing.T, storage stats.Storage, statType stats.StatType) stats.Stat {
+	t.Helper()
+	stat, err := storage.Latest(statType)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", statType, err)
+	}
+	return stat
+}
+
+func climate_RecordsDerived(t *testing.T, c *Climate, storage stats.Storage) {
+	now := time.Now()
+	if err := c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now, Value: 25}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Latest(stats.StatTypeVPD); err != stats.ErrNoStats {
+		t.Fatalf("derived before paired: %v", err)
+	}
+	if err := c.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: now.Add(time.Second), Value: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	latest(t, storage, stats.StatTypeTemperature)
+	latest(t, storage, stats.StatTypeHumidity)
+	vpd := latest(t, storage, stats.StatTypeVPD)
+	assertClose(t, "vpd", VPD(25, 60), vpd.Value, 1e-9)
+	if !vpd.When.Equal(now.Add(time.Second)) {
+		t.Errorf("unexpected time: %v", vpd.When)
+	}
+	assertClose(t, "dew point", DewPoint(25, 60), latest(t, storage, stats.StatTypeDewPoint).Value, 1e-9)
+	assertClose(t, "heat index", HeatIndex(25, 60), latest(t, storage, stats.StatTypeHeatIndex).Value, 1e-9)
+}
+
+func climate_SkewTooLarge(t *testing.T, c *Climate, storage stats.Storage) {
+	now := time.Now()
+	c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now, Value: 25})
+	c.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: now.Add(c.MaxSkew + time.Second), Value: 60})
+
+	if _, err := storage.Latest(stats.StatTypeVPD); err != stats.ErrNoStats {
+		t.Fatalf("unexpected derived stat: %v", err)
+	}
+
+	// a newer temperature pairs with the waiting humidity
+	c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now.Add(c.MaxSkew), Value: 20})
+	assertClose(t, "vpd", VPD(20, 60), latest(t, storage, stats.StatTypeVPD).Value, 1e-9)
+}
+
+func climate_PairsOnce(t *testing.T, c *Climate, storage stats.Storage) {
+	now := time.Now()
+	c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now, Value: 25})
+	c.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: now, Value: 60})
+	c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now.Add(time.Second), Value: 26})
+
+	vpds, err := storage.Fetch(stats.StatTypeVPD, now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vpds) != 1 {
+		t.Errorf("unexpected vpd readings: %v", vpds)
+	}
+}
+
+func climate_DryAir(t *testing.T, c *Climate, storage stats.Storage) {
+	now := time.Now()
+	c.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: now, Value: 25})
+	c.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: now, Value: 0})
+
+	latest(t, storage, stats.StatTypeVPD)
+	if _, err := storage.Latest(stats.StatTypeDewPoint); err != stats.ErrNoStats {
+		t.Errorf("unexpected dew point: %v", err)
+	}
+}
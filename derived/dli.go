@@ -0,0 +1,160 @@
+package derived
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// DefaultLuxToPPFD converts the illuminance of sunlight in lux to
+	// photosynthetic photon flux density in µmol/m²/s
+	DefaultLuxToPPFD = 0.0185
+
+	// DefaultMaxGap is the longest gap between light readings that is
+	// interpolated across, longer gaps are treated as missing data
+	DefaultMaxGap = 15 * time.Minute
+
+	// DefaultBackfill is how many days before yesterday are computed
+	// when there is no daily light integral recorded yet
+	DefaultBackfill = 7
+
+	micro = 1e-6
+)
+
+// DLI computes the daily light integral, the photosynthetic photons
+// received in a day in mol/m²/day, from the light history. It is
+// recorded once per complete day as a StatTypeDLI stat at the
+// midnight that started the day.
+type DLI struct {
+	storage stats.Storage
+
+	// LuxToPPFD converts lux to µmol/m²/s for the light source
+	LuxToPPFD float64
+	// MaxGap is the longest gap between light readings that is interpolated across
+	MaxGap time.Duration
+	// Backfill is how many days before yesterday are computed at most
+	Backfill int
+	// Clock tells when days are complete, the real clock unless replaced
+	Clock clock.Clock
+
+	interval time.Duration
+	closed   chan struct{}
+}
+
+// NewDLI creates a DLI service checking for complete days every interval
+func NewDLI(storage stats.Storage, interval time.Duration) *DLI {
+	return &DLI{
+		storage:   storage,
+		LuxToPPFD: DefaultLuxToPPFD,
+		MaxGap:    DefaultMaxGap,
+		Backfill:  DefaultBackfill,
+		Clock:     clock.Real,
+		interval:  interval,
+		closed:    make(chan struct{}),
+	}
+}
+
+// Begin records the daily light integral of every complete day
+// that does not have one yet, until closed
+func (d *DLI) Begin() {
+	ticker := d.Clock.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	if err := d.update(d.Clock.Now()); err != nil {
+		d.logWithPrintout(logging.LevelWarn, "dli: %v", err)
+	}
+	for {
+		select {
+		case <-d.closed:
+			return
+		case now := <-ticker.C():
+			if err := d.update(now); err != nil {
+				d.logWithPrintout(logging.LevelWarn, "dli: %v", err)
+			}
+		}
+	}
+}
+
+// update records the daily light integral of every complete day
+// since the last one recorded, up to and including yesterday
+func (d *DLI) update(now time.Time) error {
+	today := startOfDay(now)
+	day := today.AddDate(0, 0, -1-d.Backfill)
+
+	latest, err := d.storage.Latest(stats.StatTypeDLI)
+	if err != nil && err != stats.ErrNoStats {
+		return fmt.Errorf("error reading latest daily light integral: %v", err)
+	}
+	if err == nil {
+		if next := startOfDay(latest.When.In(now.Location())).AddDate(0, 0, 1); next.After(day) {
+			day = next
+		}
+	}
+
+	for ; day.Before(today); day = day.AddDate(0, 0, 1) {
+		dli, ok, err := d.Compute(day)
+		if err != nil {
+			return err
+		}
+		// days without any light readings are not recorded,
+		// since the light received those days is unknown
+		if !ok {
+			continue
+		}
+		if err := d.storage.Record(stats.Stat{StatType: stats.StatTypeDLI, When: day, Value: dli}); err != nil {
+			return fmt.Errorf("error recording daily light integral: %v", err)
+		}
+	}
+	return nil
+}
+
+// Compute returns the daily light integral in mol/m²/day of the
+// day containing t, and whether or not there were any light readings
+func (d *DLI) Compute(t time.Time) (float64, bool, error) {
+	start := startOfDay(t)
+	end := start.AddDate(0, 0, 1)
+
+	readings, err := stats.FetchAll(d.storage, stats.StatTypeLight, start, end)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(readings) == 0 {
+		return 0, false, nil
+	}
+
+	// trapezoidal integration of the photon flux over time
+	var photons float64
+	for i := 1; i < len(readings); i++ {
+		prev, next := readings[i-1], readings[i]
+		gap := next.When.Sub(prev.When)
+		if gap > d.MaxGap {
+			continue
+		}
+		ppfd := (prev.Value + next.Value) / 2 * d.LuxToPPFD
+		photons += ppfd * gap.Seconds()
+	}
+	return photons * micro, true, nil
+}
+
+// Close stops recording the daily light integral
+func (d *DLI) Close() error {
+	close(d.closed)
+	return nil
+}
+
+func (d *DLI) logWithPrintout(level logging.Level, format string, args ...interface{}) {
+	if _, err := d.storage.Log(level, format, args...); err != nil {
+		log.Printf(`error logging message "%s": %v`, fmt.Sprintf(format, args...), err)
+	}
+}
+
+// startOfDay returns the midnight that started the day containing t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,153 @@
+package derived
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+var (
+	dliNow = time.Date(2018, time.June, 21, 9, 30, 0, 0, time.Local)
+)
+
+func dliTest(f func(t *testing.T, d *DLI, storage stats.Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(200)
+		defer storage.Close()
+
+		f(t, NewDLI(storage, time.Hour), storage)
+	}
+	return name, testFunc
+}
+
+func TestDLI(t *testing.T) {
+	t.Parallel()
+	t.Run("DLI", func(t *testing.T) {
+		t.Parallel()
+		t.Run(dliTest(dli_Compute))
+		t.Run(dliTest(dli_ComputeSkipsGaps))
+		t.Run(dliTest(dli_ComputeNoReadings))
+		t.Run(dliTest(dli_UpdateRecordsOncePerDay))
+		t.Run(dliTest(dli_BeginClock))
+	})
+}
+
+// recordLight records a constant illuminance every minute for an hour starting at start
+func recordLight(t *testing.T, storage stats.Storage, start time.Time, lux float64) {
+	for i := 0; i <= 60; i++ {
+		stat := stats.Stat{StatType: stats.StatTypeLight, When: start.Add(time.Duration(i) * time.Minute), Value: lux}
+		if err := storage.Record(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dli_Compute(t *testing.T, d *DLI, storage stats.Storage) {
+	noon := startOfDay(dliNow).Add(12 * time.Hour)
+	recordLight(t, storage, noon, 1000)
+
+	dli, ok, err := d.Compute(noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("readings not found")
+	}
+	// 1000 lux is 18.5 µmol/m²/s, for an hour
+	expected := 1000 * DefaultLuxToPPFD * 3600 * 1e-6
+	if math.Abs(dli-expected) > 1e-9 {
+		t.Errorf("expected %g, got %g", expected, dli)
+	}
+}
+
+func dli_ComputeSkipsGaps(t *testing.T, d *DLI, storage stats.Storage) {
+	noon := startOfDay(dliNow).Add(12 * time.Hour)
+	for _, when := range []time.Time{noon, noon.Add(time.Hour)} {
+		if err := storage.Record(stats.Stat{StatType: stats.StatTypeLight, When: when, Value: 1000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dli, ok, err := d.Compute(noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || dli != 0 {
+		t.Errorf("unexpected daily light integral across a gap: %g", dli)
+	}
+}
+
+func dli_ComputeNoReadings(t *testing.T, d *DLI, storage stats.Storage) {
+	if _, ok, err := d.Compute(dliNow); err != nil || ok {
+		t.Errorf("unexpected result without readings: %v %v", ok, err)
+	}
+}
+
+func dli_UpdateRecordsOncePerDay(t *testing.T, d *DLI, storage stats.Storage) {
+	yesterday := startOfDay(dliNow).AddDate(0, 0, -1)
+	recordLight(t, storage, yesterday.Add(12*time.Hour), 1000)
+	// today is not complete and must not be recorded
+	recordLight(t, storage, startOfDay(dliNow).Add(6*time.Hour), 1000)
+
+	for i := 0; i < 2; i++ {
+		if err := d.update(dliNow); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorded, err := storage.Fetch(stats.StatTypeDLI, yesterday.AddDate(0, 0, -10), dliNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 1 {
+		t.Fatalf("unexpected daily light integrals: %#v", recorded)
+	}
+	if !recorded[0].When.Equal(yesterday) {
+		t.Errorf("recorded at %s, expected %s", recorded[0].When, yesterday)
+	}
+}
+
+// waitForDLI waits for n daily light integrals to be recorded
+func waitForDLI(t *testing.T, storage stats.Storage, n int) []stats.Stat {
+	deadline := time.Now().Add(time.Second)
+	for {
+		recorded, err := storage.Fetch(stats.StatTypeDLI, dliNow.AddDate(0, 0, -10), dliNow.AddDate(0, 0, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recorded) >= n {
+			return recorded
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d daily light integrals, got %d", n, len(recorded))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func dli_BeginClock(t *testing.T, d *DLI, storage stats.Storage) {
+	manual := clock.NewManual(dliNow)
+	d.Clock = manual
+	d.interval = 24 * time.Hour
+	today := startOfDay(dliNow)
+	recordLight(t, storage, today.AddDate(0, 0, -1).Add(12*time.Hour), 1000)
+	recordLight(t, storage, today.Add(12*time.Hour), 1000)
+
+	go d.Begin()
+	defer d.Close()
+	waitForDLI(t, storage, 1)
+
+	// today is complete once the clock reaches tomorrow
+	manual.BlockUntil(1)
+	manual.Advance(24 * time.Hour)
+	recorded := waitForDLI(t, storage, 2)
+	if !recorded[1].When.Equal(today) {
+		t.Errorf("recorded at %s, expected %s", recorded[1].When, today)
+	}
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// FormatCSV is comma separated values with a header row
+	FormatCSV Format = "csv"
+	// FormatNDJSON is one JSON object per line
+	FormatNDJSON Format = "ndjson"
+
+	// timeFormat is how times are written in every format
+	timeFormat = time.RFC3339Nano
+)
+
+var (
+	// ErrUnknownFormat indicates that a name
+	// does not correspond to any Format
+	ErrUnknownFormat = errors.New("unknown export format")
+)
+
+// Format is a file format that history can be exported to
+type Format string
+
+// ParseFormat returns the Format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case FormatCSV:
+		return FormatCSV, nil
+	case FormatNDJSON:
+		return FormatNDJSON, nil
+	default:
+		return Format(""), ErrUnknownFormat
+	}
+}
+
+// ContentType returns the MIME type of the Format
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// rowWriter writes the rows of an export one at a time
+type rowWriter interface {
+	write(row map[string]interface{}) error
+	flush() error
+}
+
+// newRowWriter creates a rowWriter writing rows with the given columns
+func newRowWriter(w io.Writer, format Format, columns []string) (rowWriter, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return nil, fmt.Errorf("error writing header: %v", err)
+		}
+		return &csvRowWriter{w: cw, columns: columns}, nil
+	case FormatNDJSON:
+		return &ndjsonRowWriter{encoder: json.NewEncoder(w)}, nil
+	default:
+		return nil, ErrUnknownFormat
+	}
+}
+
+type csvRowWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (cw *csvRowWriter) write(row map[string]interface{}) error {
+	record := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		switch value := row[column].(type) {
+		case float64:
+			record[i] = strconv.FormatFloat(value, 'g', -1, 64)
+		default:
+			record[i] = fmt.Sprint(value)
+		}
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvRowWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonRowWriter struct {
+	encoder *json.Encoder
+}
+
+func (nw *ndjsonRowWriter) write(row map[string]interface{}) error {
+	return nw.encoder.Encode(row)
+}
+
+func (nw *ndjsonRowWriter) flush() error {
+	return nil
+}
+
+// Stats writes every Stat of the given types in a time frame to w
+// in chronological order, streaming them from the storage
+func Stats(w io.Writer, storage stats.Storage, format Format, statTypes []stats.StatType, start, end time.Time) error {
+	rows, err := newRowWriter(w, format, []string{"stat", "when", "value"})
+	if err != nil {
+		return err
+	}
+	err = storage.EachStat(statTypes, start, end, func(stat stats.Stat) error {
+		return rows.write(map[string]interface{}{
+			"stat":  stat.StatType.String(),
+			"when":  stat.When.Format(timeFormat),
+			"value": stat.Value,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error exporting stats: %v", err)
+	}
+	return rows.flush()
+}
+
+// Logs writes every log entry with a given minimum level in a time frame
+// to w in chronological order, streaming them from the storage
+func Logs(w io.Writer, storage stats.Storage, format Format, level logging.Level, start, end time.Time) error {
+	rows, err := newRowWriter(w, format, []string{"when", "level", "message"})
+	if err != nil {
+		return err
+	}
+	err = storage.EachLog(level, start, end, func(entry logging.LogEntry) error {
+		return rows.write(map[string]interface{}{
+			"when":    entry.When.Format(timeFormat),
+			"level":   entry.Level.String(),
+			"message": entry.Message,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error exporting logs: %v", err)
+	}
+	return rows.flush()
+}
@@ -0,0 +1,113 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+var (
+	exportStart = time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+)
+
+func exportTest(f func(t *testing.T, storage stats.Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(20)
+		defer storage.Close()
+
+		for i, stat := range []stats.Stat{
+			{StatType: stats.StatTypeHumidity, When: exportStart.Add(time.Second), Value: 55},
+			{StatType: stats.StatTypeTemperature, When: exportStart, Value: 21.5},
+			{StatType: stats.StatTypeFan, When: exportStart, Value: 1},
+			{StatType: stats.StatTypeTemperature, When: exportStart.Add(time.Hour), Value: 30},
+		} {
+			if err := storage.Record(stat); err != nil {
+				t.Fatalf("error recording stat %d: %v", i, err)
+			}
+		}
+
+		f(t, storage)
+	}
+	return name, testFunc
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+	t.Run("Format", func(t *testing.T) {
+		t.Parallel()
+		t.Run(testFunctionName(format_Parse), format_Parse)
+	})
+	t.Run("Stats", func(t *testing.T) {
+		t.Parallel()
+		t.Run(exportTest(stats_CSV))
+		t.Run(exportTest(stats_NDJSON))
+	})
+	t.Run("Logs", func(t *testing.T) {
+		t.Parallel()
+		t.Run(exportTest(logs_CSV))
+	})
+}
+
+func format_Parse(t *testing.T) {
+	for _, format := range []Format{FormatCSV, FormatNDJSON} {
+		parsed, err := ParseFormat(string(format))
+		if err != nil || parsed != format {
+			t.Errorf("unexpected format %s: %v", parsed, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err != ErrUnknownFormat {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func stats_CSV(t *testing.T, storage stats.Storage) {
+	var buf bytes.Buffer
+	statTypes := []stats.StatType{stats.StatTypeTemperature, stats.StatTypeHumidity}
+	if err := Stats(&buf, storage, FormatCSV, statTypes, exportStart, exportStart.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "stat,when,value\n" +
+		"temperature,2018-06-21T09:30:00Z,21.5\n" +
+		"humidity,2018-06-21T09:30:01Z,55\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected export:\n%s", buf.String())
+	}
+}
+
+func stats_NDJSON(t *testing.T, storage stats.Storage) {
+	var buf bytes.Buffer
+	statTypes := []stats.StatType{stats.StatTypeTemperature}
+	if err := Stats(&buf, storage, FormatNDJSON, statTypes, exportStart, exportStart.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"stat":"temperature","value":21.5,"when":"2018-06-21T09:30:00Z"}` + "\n" +
+		`{"stat":"temperature","value":30,"when":"2018-06-21T10:30:00Z"}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected export:\n%s", buf.String())
+	}
+}
+
+func logs_CSV(t *testing.T, storage stats.Storage) {
+	start := time.Now().Add(-time.Second)
+	storage.Log(logging.LevelDebug, "ignored")
+	storage.Log(logging.LevelWarn, "dry, %s", "very dry")
+
+	var buf bytes.Buffer
+	if err := Logs(&buf, storage, FormatCSV, logging.LevelInfo, start, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || lines[0] != "when,level,message" || !strings.HasSuffix(lines[1], `,warning,"dry, very dry"`) {
+		t.Errorf("unexpected export:\n%s", buf.String())
+	}
+}
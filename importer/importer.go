@@ -0,0 +1,311 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/export"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// DefaultBatchSize is how many rows are recorded per transaction
+	DefaultBatchSize = 500
+
+	// maxRejections is how many rejected rows are reported with their reasons
+	maxRejections = 100
+)
+
+var (
+	// timestampColumns are the accepted names of the timestamp column
+	timestampColumns = []string{"timestamp", "when"}
+)
+
+// Rejection is a row that was not imported and why
+type Rejection struct {
+	// Line is the line of the row in the input, starting at 1
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// Report is the outcome of an import
+type Report struct {
+	// Accepted is how many rows were recorded
+	Accepted int `json:"accepted"`
+	// Duplicates is how many valid rows were already recorded
+	Duplicates int `json:"duplicates"`
+	// Rejected is how many rows were invalid
+	Rejected int `json:"rejected"`
+	// Rejections are the first invalid rows and why they were rejected
+	Rejections []Rejection `json:"rejections"`
+}
+
+func (r *Report) reject(line int, format string, args ...interface{}) {
+	r.Rejected++
+	if len(r.Rejections) < maxRejections {
+		r.Rejections = append(r.Rejections, Rejection{Line: line, Reason: fmt.Sprintf(format, args...)})
+	}
+}
+
+// StorageError is a failure of the storage during an import,
+// as opposed to a failure to read the input
+type StorageError struct {
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("error importing stats: %v", e.Err)
+}
+
+// row is a raw row of the input
+type row struct {
+	line                   int
+	stat, timestamp, value string
+}
+
+// invalidRowError is a row that cannot be read, but
+// that does not stop the rest of the input from being read
+type invalidRowError struct {
+	error
+}
+
+// rowReader reads the rows of an input one at a time
+type rowReader interface {
+	// next returns the next row, or io.EOF when there are none left. A
+	// row that cannot be read is returned with an invalidRowError, any
+	// other error means that the input cannot be read any further.
+	next() (row, error)
+}
+
+// Importer records stats read from CSV or NDJSON in batches
+type Importer struct {
+	storage stats.Storage
+
+	// BatchSize is how many rows are recorded per transaction
+	BatchSize int
+}
+
+// New creates an Importer recording to storage
+func New(storage stats.Storage) *Importer {
+	return &Importer{
+		storage:   storage,
+		BatchSize: DefaultBatchSize,
+	}
+}
+
+// Import records every valid row of the input, which has stat, timestamp
+// and value fields. CSV input has a header row naming its columns. Rows
+// that are invalid are rejected and rows already recorded are skipped.
+// An error is returned if the storage fails, along with what was imported.
+func (imp *Importer) Import(r io.Reader, format export.Format) (Report, error) {
+	report := Report{Rejections: []Rejection{}}
+
+	var rows rowReader
+	switch format {
+	case export.FormatCSV:
+		csvRows, err := newCSVRowReader(r)
+		if err != nil {
+			return report, err
+		}
+		rows = csvRows
+	case export.FormatNDJSON:
+		rows = newNDJSONRowReader(r)
+	default:
+		return report, export.ErrUnknownFormat
+	}
+
+	batch := make([]stats.Stat, 0, imp.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		recorded, err := imp.storage.RecordBatch(batch)
+		if err != nil {
+			return &StorageError{Err: err}
+		}
+		report.Accepted += recorded
+		report.Duplicates += len(batch) - recorded
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		raw, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if invalid, ok := err.(invalidRowError); ok {
+			report.reject(raw.line, "%v", invalid.error)
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("error reading input: %v", err)
+		}
+		stat, err := parseRow(raw)
+		if err != nil {
+			report.reject(raw.line, "%v", err)
+			continue
+		}
+		batch = append(batch, stat)
+		if len(batch) >= imp.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, flush()
+}
+
+// parseRow validates a raw row
+func parseRow(raw row) (stats.Stat, error) {
+	statType, err := stats.ParseStatType(raw.stat)
+	if err != nil {
+		return stats.Stat{}, fmt.Errorf("unknown stat type %q", raw.stat)
+	}
+	when, err := parseTimestamp(raw.timestamp)
+	if err != nil {
+		return stats.Stat{}, err
+	}
+	value, err := strconv.ParseFloat(raw.value, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return stats.Stat{}, fmt.Errorf("invalid value %q", raw.value)
+	}
+	if min, max := statType.Range(); value < min || value > max {
+		return stats.Stat{}, fmt.Errorf("%s value %g is outside of %g to %g", statType, value, min, max)
+	}
+	return stats.Stat{StatType: statType, When: when, Value: value}, nil
+}
+
+// parseTimestamp parses an RFC3339 time or a unix time in seconds
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(seconds) && !math.IsInf(seconds, 0) {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, expected RFC3339 or unix seconds", s)
+}
+
+type csvRowReader struct {
+	r                      *csv.Reader
+	stat, timestamp, value int
+}
+
+// newCSVRowReader reads the header of a CSV input
+func newCSVRowReader(r io.Reader) (*csvRowReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("missing csv header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading csv header: %v", err)
+	}
+
+	rows := &csvRowReader{r: cr, stat: -1, timestamp: -1, value: -1}
+	for i, column := range header {
+		switch column := strings.ToLower(strings.TrimSpace(column)); {
+		case column == "stat":
+			rows.stat = i
+		case column == "value":
+			rows.value = i
+		case contains(timestampColumns, column):
+			rows.timestamp = i
+		}
+	}
+	if rows.stat < 0 || rows.timestamp < 0 || rows.value < 0 {
+		return nil, fmt.Errorf("csv header must name stat, timestamp and value columns: %s", strings.Join(header, ","))
+	}
+	return rows, nil
+}
+
+func (cr *csvRowReader) next() (row, error) {
+	record, err := cr.r.Read()
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		return row{line: parseErr.StartLine}, invalidRowError{parseErr.Err}
+	}
+	if err != nil {
+		return row{}, err
+	}
+	line, _ := cr.r.FieldPos(0)
+	raw := row{line: line}
+	for _, i := range []int{cr.stat, cr.timestamp, cr.value} {
+		if i >= len(record) {
+			return raw, invalidRowError{fmt.Errorf("expected %d fields, got %d", i+1, len(record))}
+		}
+	}
+	raw.stat = record[cr.stat]
+	raw.timestamp = record[cr.timestamp]
+	raw.value = record[cr.value]
+	return raw, nil
+}
+
+type ndjsonRowReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONRowReader(r io.Reader) *ndjsonRowReader {
+	return &ndjsonRowReader{scanner: bufio.NewScanner(r)}
+}
+
+func (nr *ndjsonRowReader) next() (row, error) {
+	for nr.scanner.Scan() {
+		nr.line++
+		text := strings.TrimSpace(nr.scanner.Text())
+		if text == "" {
+			continue
+		}
+		raw := row{line: nr.line}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			return raw, invalidRowError{fmt.Errorf("invalid json: %v", err)}
+		}
+		raw.stat = jsonField(fields, "stat")
+		raw.value = jsonField(fields, "value")
+		for _, column := range timestampColumns {
+			if timestamp := jsonField(fields, column); timestamp != "" {
+				raw.timestamp = timestamp
+			}
+		}
+		return raw, nil
+	}
+	if err := nr.scanner.Err(); err != nil {
+		return row{}, err
+	}
+	return row{}, io.EOF
+}
+
+// jsonField returns a field of a JSON object as a string
+func jsonField(fields map[string]interface{}, name string) string {
+	switch value := fields[name].(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return ""
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/export"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+func importerTest(f func(t *testing.T, imp *Importer, storage stats.Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(20)
+		defer storage.Close()
+
+		imp := New(storage)
+		imp.BatchSize = 2
+		f(t, imp, storage)
+	}
+	return name, testFunc
+}
+
+func TestImporter(t *testing.T) {
+	t.Parallel()
+	t.Run("Importer", func(t *testing.T) {
+		t.Parallel()
+		t.Run(importerTest(importer_CSV))
+		t.Run(importerTest(importer_CSVRejects))
+		t.Run(importerTest(importer_CSVMissingColumn))
+		t.Run(importerTest(importer_NDJSON))
+		t.Run(importerTest(importer_Duplicates))
+		t.Run(importerTest(importer_ReadError))
+	})
+}
+
+func fetchAll(t *testing.T, storage stats.Storage, statType stats.StatType) []stats.Stat {
+	t.Helper()
+	all, err := stats.FetchAll(storage, statType, time.Unix(0, 0), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return all
+}
+
+func importer_CSV(t *testing.T, imp *Importer, storage stats.Storage) {
+	input := "Value,Timestamp,Stat\n" +
+		"21.5,2018-06-21T09:30:00Z,temperature\n" +
+		"55,1529573401,humidity\n" +
+		"22,2018-06-21T09:31:00+00:00,temperature\n"
+
+	report, err := imp.Import(strings.NewReader(input), export.FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 3 || report.Rejected != 0 || report.Duplicates != 0 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	temperatures := fetchAll(t, storage, stats.StatTypeTemperature)
+	if len(temperatures) != 2 || temperatures[0].Value != 21.5 || temperatures[1].Value != 22 {
+		t.Errorf("unexpected temperatures: %v", temperatures)
+	}
+	humidities := fetchAll(t, storage, stats.StatTypeHumidity)
+	if len(humidities) != 1 || !humidities[0].When.Equal(time.Date(2018, time.June, 21, 9, 30, 1, 0, time.UTC)) {
+		t.Errorf("unexpected humidities: %v", humidities)
+	}
+}
+
+func importer_CSVRejects(t *testing.T, imp *Importer, storage stats.Storage) {
+	input := "stat,timestamp,value\n" +
+		"rain,2018-06-21T09:30:00Z,1\n" +
+		"humidity,yesterday,50\n" +
+		"humidity,2018-06-21T09:30:00Z,120\n" +
+		"humidity,2018-06-21T09:30:00Z,NaN\n" +
+		"humidity,2018-06-21T09:30:00Z\n" +
+		"humidity,2018-06-21T09:30:00Z,50\n"
+
+	report, err := imp.Import(strings.NewReader(input), export.FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 1 || report.Rejected != 5 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	expected := []Rejection{
+		{Line: 2, Reason: `unknown stat type "rain"`},
+		{Line: 3, Reason: `invalid timestamp "yesterday", expected RFC3339 or unix seconds`},
+		{Line: 4, Reason: "humidity value 120 is outside of 0 to 100"},
+		{Line: 5, Reason: `invalid value "NaN"`},
+		{Line: 6, Reason: "expected 3 fields, got 2"},
+	}
+	for i, rejection := range report.Rejections {
+		if rejection != expected[i] {
+			t.Errorf("unexpected rejection %d: %+v", i, rejection)
+		}
+	}
+}
+
+func importer_CSVMissingColumn(t *testing.T, imp *Importer, storage stats.Storage) {
+	if _, err := imp.Import(strings.NewReader("stat,value\n"), export.FormatCSV); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func importer_NDJSON(t *testing.T, imp *Importer, storage stats.Storage) {
+	input := `{"stat":"temperature","when":"2018-06-21T09:30:00Z","value":21.5}` + "\n" +
+		"\n" +
+		`{"stat":"temperature","timestamp":1529573460,"value":"22"}` + "\n" +
+		`{"stat":` + "\n"
+
+	report, err := imp.Import(strings.NewReader(input), export.FormatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 2 || report.Rejected != 1 || report.Rejections[0].Line != 4 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	if temperatures := fetchAll(t, storage, stats.StatTypeTemperature); len(temperatures) != 2 {
+		t.Errorf("unexpected temperatures: %v", temperatures)
+	}
+}
+
+func importer_Duplicates(t *testing.T, imp *Importer, storage stats.Storage) {
+	when := time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+	if err := storage.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: when, Value: 20}); err != nil {
+		t.Fatal(err)
+	}
+	input := "stat,when,value\n" +
+		"temperature,2018-06-21T09:30:00Z,21.5\n" +
+		"temperature,2018-06-21T09:31:00Z,22\n" +
+		"temperature,2018-06-21T09:31:00Z,22\n"
+
+	report, err := imp.Import(strings.NewReader(input), export.FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Accepted != 1 || report.Duplicates != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	temperatures := fetchAll(t, storage, stats.StatTypeTemperature)
+	if len(temperatures) != 2 || temperatures[0].Value != 20 {
+		t.Errorf("unexpected temperatures: %v", temperatures)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(b []byte) (int, error) {
+	return 0, errors.New("disconnected")
+}
+
+func importer_ReadError(t *testing.T, imp *Importer, storage stats.Storage) {
+	if _, err := imp.Import(failingReader{}, export.FormatNDJSON); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,385 @@
+package influx
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// DefaultBatchSize is how many points are written at once
+	DefaultBatchSize = 500
+	// DefaultMaxBuffered is how many points are held while the endpoint cannot be reached
+	DefaultMaxBuffered = 10000
+	// DefaultMaxBackoff is the longest wait between retries
+	DefaultMaxBackoff = 5 * time.Minute
+
+	// MeasurementStats is the measurement of stats, tagged with their type
+	MeasurementStats = "stats"
+	// MeasurementLogs is the measurement of log entries, tagged with their level
+	MeasurementLogs = "logs"
+)
+
+var (
+	// ErrBadTags indicates that tags are not comma separated key=value pairs
+	ErrBadTags = errors.New("tags must be comma separated key=value pairs")
+)
+
+// Sink is a Storage that mirrors every Stat and log entry recorded through
+// it to an InfluxDB compatible HTTP write endpoint in line protocol.
+//
+// Points are written in gzipped batches every interval, or sooner when
+// a batch fills up. Points that cannot be written are retried with an
+// exponential backoff, holding at most MaxBuffered of them and dropping
+// the oldest beyond that. Batches recorded with RecordBatch and
+// RecordLogs, such as imports, are not mirrored.
+type Sink struct {
+	stats.Storage
+
+	// Clock times writes and retries, the real clock unless replaced
+	Clock clock.Clock
+
+	// Token is sent as the Authorization token if set
+	Token string
+	// Tags are added to every point, such as a zone
+	Tags map[string]string
+	// Sensors names the sensor that reads each StatType, tagged as sensor
+	Sensors map[stats.StatType]string
+	// BatchSize is how many points are written at once
+	BatchSize int
+	// MaxBuffered is how many points are held while they cannot be written
+	MaxBuffered int
+	// MaxBackoff is the longest wait between retries
+	MaxBackoff time.Duration
+	// Client makes the write requests
+	Client *http.Client
+
+	url      string
+	interval time.Duration
+
+	mu      *sync.Mutex
+	lines   []string
+	dropped int
+	backoff time.Duration
+	retryAt time.Time
+	failing bool
+
+	flushMu *sync.Mutex
+	full    chan struct{}
+	closed  chan struct{}
+	once    *sync.Once
+}
+
+// NewSink creates a Sink recording to storage and writing
+// to the write endpoint at url every interval
+func NewSink(storage stats.Storage, url string, interval time.Duration) *Sink {
+	return &Sink{
+		Storage:     storage,
+		Clock:       clock.Real,
+		BatchSize:   DefaultBatchSize,
+		MaxBuffered: DefaultMaxBuffered,
+		MaxBackoff:  DefaultMaxBackoff,
+		Client:      &http.Client{Timeout: 30 * time.Second},
+		url:         url,
+		interval:    interval,
+		mu:          &sync.Mutex{},
+		flushMu:     &sync.Mutex{},
+		full:        make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+		once:        &sync.Once{},
+	}
+}
+
+// ParseTags parses comma separated key=value pairs, such as zone=north,site=backyard
+func ParseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	if s == "" {
+		return tags, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, ErrBadTags
+		}
+		tags[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return tags, nil
+}
+
+// Record puts a Stat record in the Storage and mirrors it
+func (s *Sink) Record(stat stats.Stat) error {
+	if err := s.Storage.Record(stat); err != nil {
+		return err
+	}
+	// line protocol has no representation of NaN or infinity
+	if math.IsNaN(stat.Value) || math.IsInf(stat.Value, 0) {
+		return nil
+	}
+	tags := map[string]string{"stat": stat.StatType.String()}
+	if sensor := s.Sensors[stat.StatType]; sensor != "" {
+		tags["sensor"] = sensor
+	}
+	s.enqueue(s.line(MeasurementStats, tags, "value="+strconv.FormatFloat(stat.Value, 'g', -1, 64), stat.When))
+	return nil
+}
+
+// Log records a message in the Storage and mirrors it
+func (s *Sink) Log(level logging.Level, format string, args ...interface{}) (logging.LogEntry, error) {
+	entry, err := s.Storage.Log(level, format, args...)
+	tags := map[string]string{"level": entry.Level.String()}
+	s.enqueue(s.line(MeasurementLogs, tags, "message="+quoteField(entry.Message), entry.When))
+	return entry, err
+}
+
+// line formats a point in line protocol, with the Sink's tags
+func (s *Sink) line(measurement string, tags map[string]string, fields string, when time.Time) string {
+	for k, v := range s.Tags {
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	// influx prefers tags sorted by key
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(escape(measurement, ", "))
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(escape(k, ",= "))
+		b.WriteByte('=')
+		b.WriteString(escape(tags[k], ",= "))
+	}
+	b.WriteByte(' ')
+	b.WriteString(fields)
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(when.UnixNano(), 10))
+	return b.String()
+}
+
+// escape escapes the special characters of a name or tag with backslashes
+func escape(s, special string) string {
+	if !strings.ContainsAny(s, special+"\n") {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if r == '\n' {
+			// newlines end a point and cannot be escaped
+			r = ' '
+		}
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quoteField quotes a string field value
+func quoteField(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// enqueue buffers a point, dropping the oldest when there are too many
+func (s *Sink) enqueue(line string) {
+	s.mu.Lock()
+	s.lines = append(s.lines, line)
+	if over := len(s.lines) - s.MaxBuffered; over > 0 {
+		s.lines = s.lines[over:]
+		s.dropped += over
+	}
+	full := len(s.lines) >= s.BatchSize
+	s.mu.Unlock()
+
+	if full {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Buffered returns how many points are waiting to be written
+func (s *Sink) Buffered() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.lines)
+}
+
+// Dropped returns how many points were dropped, because too many
+// were buffered or the endpoint refused them
+func (s *Sink) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Begin writes points every interval, or when a batch fills up, until closed
+func (s *Sink) Begin() {
+	ticker := s.Clock.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C():
+			s.Flush()
+		case <-s.full:
+			s.Flush()
+		}
+	}
+}
+
+// Flush writes the buffered points in batches, unless it is not yet
+// time to retry. Points that fail to write are kept to be retried.
+func (s *Sink) Flush() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	wait := s.Clock.Now().Before(s.retryAt)
+	s.mu.Unlock()
+	if wait {
+		return
+	}
+	for s.flushBatch() {
+	}
+}
+
+// flushBatch writes a batch of points, returning
+// whether or not there may be more to write
+func (s *Sink) flushBatch() bool {
+	s.mu.Lock()
+	n := len(s.lines)
+	if n > s.BatchSize {
+		n = s.BatchSize
+	}
+	batch := s.lines[:n:n]
+	s.lines = s.lines[n:]
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return false
+	}
+
+	retry, err := s.write(batch)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case err == nil:
+		if s.failing {
+			s.Storage.Log(logging.LevelInfo, "influx: writing again")
+		}
+		s.failing = false
+		s.backoff = 0
+		s.retryAt = time.Time{}
+		return true
+	case retry:
+		// keep the batch ahead of points buffered since, within the limit
+		s.lines = append(batch, s.lines...)
+		if over := len(s.lines) - s.MaxBuffered; over > 0 {
+			s.lines = s.lines[over:]
+			s.dropped += over
+		}
+		if s.backoff == 0 {
+			s.backoff = s.interval
+		} else {
+			s.backoff *= 2
+		}
+		if s.backoff > s.MaxBackoff {
+			s.backoff = s.MaxBackoff
+		}
+		s.retryAt = s.Clock.Now().Add(s.backoff)
+		if !s.failing {
+			s.Storage.Log(logging.LevelWarn, "influx: %v, retrying", err)
+		}
+		s.failing = true
+		return false
+	default:
+		s.dropped += len(batch)
+		s.Storage.Log(logging.LevelError, "influx: %v, dropped %d points", err, len(batch))
+		return true
+	}
+}
+
+// write sends points to the endpoint, returning
+// whether or not a failure is worth retrying
+func (s *Sink) write(lines []string) (bool, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	for _, line := range lines {
+		io.WriteString(gz, line)
+		io.WriteString(gz, "\n")
+	}
+	if err := gz.Close(); err != nil {
+		return false, fmt.Errorf("error compressing points: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return false, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Content-Encoding", "gzip")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("error writing points: %v", err)
+	}
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("error writing points: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("points refused: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+}
+
+// Close writes the buffered points, without waiting to retry,
+// and closes the underlying Storage
+func (s *Sink) Close() error {
+	s.once.Do(func() {
+		close(s.closed)
+	})
+
+	s.mu.Lock()
+	s.retryAt = time.Time{}
+	s.mu.Unlock()
+	s.Flush()
+
+	if n := s.Buffered(); n > 0 {
+		s.mu.Lock()
+		s.lines = nil
+		s.dropped += n
+		s.mu.Unlock()
+		s.Storage.Log(logging.LevelError, "influx: dropped %d points on close", n)
+	}
+	return s.Storage.Close()
+}
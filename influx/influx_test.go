@@ -0,0 +1,368 @@
+package influx
+
+import (
+	"bufio"
+	"compress/gzip"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+var (
+	influxStart = time.Date(2018, time.June, 21, 9, 30, 0, 0, time.UTC)
+)
+
+// influxServer is a write endpoint that keeps the points written to it
+type influxServer struct {
+	*httptest.Server
+
+	mu       *sync.Mutex
+	statuses []int
+	requests int
+	lines    []string
+	headers  http.Header
+}
+
+// respond sets the statuses of the next requests, after which requests succeed
+func (s *influxServer) respond(statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = statuses
+}
+
+func (s *influxServer) written() (int, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, append([]string(nil), s.lines...)
+}
+
+func (s *influxServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	s.headers = r.Header
+	if len(s.statuses) > 0 {
+		status := s.statuses[0]
+		s.statuses = s.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		s.lines = append(s.lines, scanner.Text())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func influxTest(f func(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		server := &influxServer{mu: &sync.Mutex{}}
+		server.Server = httptest.NewServer(server)
+		defer server.Close()
+
+		clk := clock.NewManual(influxStart)
+		sink := NewSink(stats.NewFakeStatsStorage(100), server.URL, time.Hour)
+		sink.Clock = clk
+		sink.MaxBackoff = time.Minute
+		defer sink.Close()
+
+		f(t, server, sink, clk)
+	}
+	return name, testFunc
+}
+
+func TestInflux(t *testing.T) {
+	t.Parallel()
+	t.Run("Tags", func(t *testing.T) {
+		t.Parallel()
+		t.Run(testFunctionName(tags_Parse), tags_Parse)
+		t.Run(testFunctionName(tags_ParseBad), tags_ParseBad)
+	})
+	t.Run("Sink", func(t *testing.T) {
+		t.Parallel()
+		t.Run(influxTest(sink_Record))
+		t.Run(influxTest(sink_RecordNaN))
+		t.Run(influxTest(sink_Log))
+		t.Run(influxTest(sink_Escaping))
+		t.Run(influxTest(sink_Batches))
+		t.Run(influxTest(sink_Begin))
+		t.Run(influxTest(sink_BeginInterval))
+		t.Run(influxTest(sink_Retry))
+		t.Run(influxTest(sink_Refused))
+		t.Run(influxTest(sink_MaxBuffered))
+		t.Run(influxTest(sink_Close))
+	})
+}
+
+func tags_Parse(t *testing.T) {
+	tags, err := ParseTags("zone=north, site=backyard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"zone": "north", "site": "backyard"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+
+	tags, err = ParseTags("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("unexpected tags: %v", tags)
+	}
+}
+
+func tags_ParseBad(t *testing.T) {
+	for _, s := range []string{"zone", "zone=", "=north", "zone=north,"} {
+		if _, err := ParseTags(s); err != ErrBadTags {
+			t.Errorf("unexpected error parsing %q: %v", s, err)
+		}
+	}
+}
+
+func sink_Record(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	sink.Token = "secret"
+	sink.Tags = map[string]string{"zone": "north"}
+	sink.Sensors = map[stats.StatType]string{stats.StatTypeTemperature: "therm-1"}
+
+	if err := sink.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: influxStart, Value: 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: influxStart, Value: 55}); err != nil {
+		t.Fatal(err)
+	}
+	sink.Flush()
+
+	_, lines := server.written()
+	expected := []string{
+		"stats,sensor=therm-1,stat=temperature,zone=north value=21.5 1529573400000000000",
+		"stats,stat=humidity,zone=north value=55 1529573400000000000",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if auth := server.headers.Get("Authorization"); auth != "Token secret" {
+		t.Errorf("unexpected authorization: %q", auth)
+	}
+
+	// the stats are still recorded in the storage
+	latest, err := sink.Latest(stats.StatTypeTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 21.5 {
+		t.Errorf("unexpected latest: %v", latest)
+	}
+}
+
+func sink_RecordNaN(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	for _, value := range []float64{math.NaN(), math.Inf(1)} {
+		if err := sink.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: influxStart, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := sink.Buffered(); n != 0 {
+		t.Errorf("unexpected buffered points: %d", n)
+	}
+}
+
+func sink_Log(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	entry, err := sink.Log(logging.LevelWarn, "fan %s", "stuck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Flush()
+
+	_, lines := server.written()
+	expected := []string{`logs,level=warning message="fan stuck" ` + formatNanos(entry.When)}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func sink_Escaping(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	sink.Tags = map[string]string{"zone name": "north,east=1"}
+	entry, err := sink.Log(logging.LevelInfo, `said "hi" \ bye`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Flush()
+
+	_, lines := server.written()
+	expected := []string{`logs,level=info,zone\ name=north\,east\=1 message="said \"hi\" \\ bye" ` + formatNanos(entry.When)}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func sink_Batches(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	sink.BatchSize = 2
+	recordStats(t, sink, 0, 5)
+	sink.Flush()
+
+	requests, lines := server.written()
+	if requests != 3 {
+		t.Errorf("unexpected requests: %d", requests)
+	}
+	if len(lines) != 5 {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func sink_Begin(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	sink.BatchSize = 3
+	go sink.Begin()
+
+	// a full batch is written without waiting for the interval
+	recordStats(t, sink, 0, 3)
+	waitFor(t, func() bool {
+		_, lines := server.written()
+		return len(lines) == 3
+	})
+}
+
+func sink_BeginInterval(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	go sink.Begin()
+	clk.BlockUntil(1)
+
+	recordStats(t, sink, 0, 1)
+	if requests, _ := server.written(); requests != 0 {
+		t.Fatalf("written before the interval: %d", requests)
+	}
+	clk.Advance(time.Hour)
+	waitFor(t, func() bool {
+		_, lines := server.written()
+		return len(lines) == 1
+	})
+}
+
+func sink_Retry(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	server.respond(http.StatusInternalServerError, http.StatusTooManyRequests)
+	recordStats(t, sink, 0, 3)
+
+	sink.Flush()
+	if n := sink.Buffered(); n != 3 {
+		t.Errorf("unexpected buffered points after failure: %d", n)
+	}
+	recordStats(t, sink, 3, 4)
+
+	// nothing is written until it is time to retry
+	sink.Flush()
+	if requests, _ := server.written(); requests != 1 {
+		t.Errorf("retried too soon: %d", requests)
+	}
+	clk.Advance(sink.MaxBackoff)
+	sink.Flush()
+	clk.Advance(sink.MaxBackoff)
+	sink.Flush()
+
+	if n := sink.Buffered(); n != 0 {
+		t.Errorf("unexpected buffered points: %d", n)
+	}
+	requests, lines := server.written()
+	if requests != 3 {
+		t.Errorf("unexpected requests: %d", requests)
+	}
+	if len(lines) != 4 {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if n := sink.Dropped(); n != 0 {
+		t.Errorf("unexpected dropped points: %d", n)
+	}
+}
+
+func sink_Refused(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	server.respond(http.StatusBadRequest)
+	recordStats(t, sink, 0, 3)
+
+	sink.Flush()
+	if n := sink.Buffered(); n != 0 {
+		t.Errorf("unexpected buffered points: %d", n)
+	}
+	if n := sink.Dropped(); n != 3 {
+		t.Errorf("unexpected dropped points: %d", n)
+	}
+}
+
+func sink_MaxBuffered(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	sink.MaxBuffered = 3
+	recordStats(t, sink, 0, 5)
+
+	if n := sink.Buffered(); n != 3 {
+		t.Errorf("unexpected buffered points: %d", n)
+	}
+	if n := sink.Dropped(); n != 2 {
+		t.Errorf("unexpected dropped points: %d", n)
+	}
+
+	sink.Flush()
+	_, lines := server.written()
+	// the oldest are dropped
+	expected := []string{
+		"stats,stat=temperature value=2 1529573402000000000",
+		"stats,stat=temperature value=3 1529573403000000000",
+		"stats,stat=temperature value=4 1529573404000000000",
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func sink_Close(t *testing.T, server *influxServer, sink *Sink, clk *clock.Manual) {
+	go sink.Begin()
+	recordStats(t, sink, 0, 2)
+
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, lines := server.written()
+	if len(lines) != 2 {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+// recordStats records temperatures from..to-1, a second apart
+func recordStats(t *testing.T, sink *Sink, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		stat := stats.Stat{StatType: stats.StatTypeTemperature, When: influxStart.Add(time.Duration(i) * time.Second), Value: float64(i)}
+		if err := sink.Record(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func formatNanos(when time.Time) string {
+	return strconv.FormatInt(when.UnixNano(), 10)
+}
+
+func waitFor(t *testing.T, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,6 +1,9 @@
 package logging
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 const (
 	LevelDebug Level = iota
@@ -17,6 +20,12 @@ type LogEntry struct {
 
 type Level uint8
 
+var (
+	// ErrUnknownLevel indicates that a name
+	// does not correspond to any Level
+	ErrUnknownLevel = errors.New("unknown log level")
+)
+
 type Logger interface {
 	// Log records a message at a given log level
 	Log(level Level, fmt string, args ...interface{}) (LogEntry, error)
@@ -36,3 +45,19 @@ func (level Level) String() string {
 		return "unknown"
 	}
 }
+
+// ParseLevel returns the Level with the given name
+func ParseLevel(name string) (Level, error) {
+	switch name {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return Level(0), ErrUnknownLevel
+	}
+}
@@ -1,39 +1,183 @@
 package monitor
 
 import (
+	"fmt"
+	"log"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
 	"github.com/explodes/greenhouse-pi/sensors"
 	"github.com/explodes/greenhouse-pi/stats"
 )
 
+// Pump is a unit whose flow is measured by a FlowMeter
+type Pump interface {
+	// IsOn returns whether or not the pump is known to be on
+	IsOn() bool
+}
+
 type Monitor struct {
 	Thermometer sensors.Thermometer
 	Hygrometer  sensors.Hygrometer
 
+	// LightSensor is optional
+	LightSensor sensors.LightSensor
+
+	// CO2Sensor is optional
+	CO2Sensor sensors.CO2Sensor
+
+	// FlowMeter is optional, if set it measures the flow of the Pump
+	FlowMeter sensors.FlowMeter
+	Pump      Pump
+	// LowFlow is the flow in liters per minute below which an alert
+	// fires if it stays there for LowFlowDelay while the Pump is on,
+	// which indicates a dry or blocked line
+	LowFlow      float64
+	LowFlowDelay time.Duration
+
 	Storage stats.Storage
+
+	// Clock timestamps the readings, the real clock if not set
+	Clock clock.Clock
+
+	// lowFlowSince is when the flow was first seen low while the Pump was on
+	lowFlowSince time.Time
+	// lowFlowAlert is whether or not the low flow alert has fired
+	lowFlowAlert bool
+}
+
+// now returns the time of the Clock
+func (m *Monitor) now() time.Time {
+	if m.Clock == nil {
+		return clock.Real.Now()
+	}
+	return m.Clock.Now()
 }
 
+// Begin records sensor readings until every sensor has been closed
 func (m *Monitor) Begin() {
 	tempStream := m.Thermometer.Read()
 
 	humidityStream := m.Hygrometer.Read()
 
-	for {
+	var flowStream <-chan sensors.Flow
+	if m.FlowMeter != nil {
+		flowStream = m.FlowMeter.Read()
+	}
+
+	var lightStream <-chan sensors.Lux
+	if m.LightSensor != nil {
+		lightStream = m.LightSensor.Read()
+	}
+
+	var co2Stream <-chan sensors.CO2
+	if m.CO2Sensor != nil {
+		co2Stream = m.CO2Sensor.Read()
+	}
+
+	for tempStream != nil || humidityStream != nil || flowStream != nil || lightStream != nil || co2Stream != nil {
 		select {
-		case temp := <-tempStream:
+		case temp, ok := <-tempStream:
+			if !ok {
+				tempStream = nil
+				continue
+			}
 			m.Storage.Record(stats.Stat{
 				StatType: stats.StatTypeTemperature,
-				When:     time.Now(),
+				When:     m.now(),
 				Value:    float64(temp),
 			})
-		case humidity := <-humidityStream:
+		case humidity, ok := <-humidityStream:
+			if !ok {
+				humidityStream = nil
+				continue
+			}
 			m.Storage.Record(stats.Stat{
 				StatType: stats.StatTypeHumidity,
-				When:     time.Now(),
+				When:     m.now(),
 				Value:    float64(humidity),
 			})
-
+		case lux, ok := <-lightStream:
+			if !ok {
+				lightStream = nil
+				continue
+			}
+			m.Storage.Record(stats.Stat{
+				StatType: stats.StatTypeLight,
+				When:     m.now(),
+				Value:    float64(lux),
+			})
+		case ppm, ok := <-co2Stream:
+			if !ok {
+				co2Stream = nil
+				continue
+			}
+			m.Storage.Record(stats.Stat{
+				StatType: stats.StatTypeCO2,
+				When:     m.now(),
+				Value:    float64(ppm),
+			})
+		case flow, ok := <-flowStream:
+			if !ok {
+				flowStream = nil
+				continue
+			}
+			now := m.now()
+			m.Storage.Record(stats.Stat{
+				StatType: stats.StatTypeFlow,
+				When:     now,
+				Value:    flow.Rate,
+			})
+			m.Storage.Record(stats.Stat{
+				StatType: stats.StatTypeFlowTotal,
+				When:     now,
+				Value:    flow.Total,
+			})
+			m.checkFlow(flow, now)
 		}
 	}
 }
+
+// checkFlow fires an alert when the flow has stayed below LowFlow
+// for longer than LowFlowDelay while the Pump was on, and resolves
+// it once the flow recovers or the Pump is turned off
+func (m *Monitor) checkFlow(flow sensors.Flow, now time.Time) {
+	if m.Pump == nil || m.LowFlow <= 0 {
+		return
+	}
+
+	if !m.Pump.IsOn() {
+		m.lowFlowSince = time.Time{}
+		m.resolveLowFlow("pump is off")
+		return
+	}
+	if flow.Rate >= m.LowFlow {
+		m.lowFlowSince = time.Time{}
+		m.resolveLowFlow(fmt.Sprintf("flow recovered to %.2f L/min", flow.Rate))
+		return
+	}
+	if m.lowFlowSince.IsZero() {
+		m.lowFlowSince = now
+	}
+
+	if m.lowFlowAlert || now.Sub(m.lowFlowSince) < m.LowFlowDelay {
+		return
+	}
+	m.lowFlowAlert = true
+	m.logWithPrintout(logging.LevelError, "alert: pump is on but flow is %.2f L/min, below %.2f L/min, the line may be dry or blocked", flow.Rate, m.LowFlow)
+}
+
+func (m *Monitor) resolveLowFlow(reason string) {
+	if !m.lowFlowAlert {
+		return
+	}
+	m.lowFlowAlert = false
+	m.logWithPrintout(logging.LevelInfo, "alert resolved: %s", reason)
+}
+
+func (m *Monitor) logWithPrintout(level logging.Level, format string, args ...interface{}) {
+	if _, err := m.Storage.Log(level, format, args...); err != nil {
+		log.Printf(`error logging message "%s": %v`, fmt.Sprintf(format, args...), err)
+	}
+}
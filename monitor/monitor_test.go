@@ -0,0 +1,144 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/sensors"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+type fakePump struct {
+	on bool
+}
+
+func (p *fakePump) IsOn() bool {
+	return p.on
+}
+
+func lowFlowMonitor() (*Monitor, *fakePump) {
+	pump := &fakePump{}
+	return &Monitor{
+		Pump:         pump,
+		LowFlow:      1,
+		LowFlowDelay: time.Minute,
+		Storage:      stats.NewFakeStatsStorage(40),
+	}, pump
+}
+
+func alerts(t *testing.T, m *Monitor) []string {
+	logs, err := m.Storage.Logs(logging.LevelDebug, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, entry := range logs {
+		if strings.HasPrefix(entry.Message, "alert") {
+			messages = append(messages, entry.Message)
+		}
+	}
+	return messages
+}
+
+func TestMonitor_LowFlowAlert(t *testing.T) {
+	m, pump := lowFlowMonitor()
+	now := time.Now()
+
+	pump.on = true
+	m.checkFlow(sensors.Flow{Rate: 0.2}, now)
+	if len(alerts(t, m)) != 0 {
+		t.Fatal("alert fired before the delay")
+	}
+
+	m.checkFlow(sensors.Flow{Rate: 0.2}, now.Add(2*time.Minute))
+	m.checkFlow(sensors.Flow{Rate: 0.2}, now.Add(3*time.Minute))
+	if messages := alerts(t, m); len(messages) != 1 {
+		t.Fatalf("unexpected alerts: %v", messages)
+	}
+
+	m.checkFlow(sensors.Flow{Rate: 2}, now.Add(4*time.Minute))
+	if messages := alerts(t, m); len(messages) != 2 || !strings.HasPrefix(messages[1], "alert resolved") {
+		t.Fatalf("unexpected alerts: %v", messages)
+	}
+}
+
+func TestMonitor_LowFlowPumpOff(t *testing.T) {
+	m, _ := lowFlowMonitor()
+	now := time.Now()
+
+	m.checkFlow(sensors.Flow{Rate: 0}, now)
+	m.checkFlow(sensors.Flow{Rate: 0}, now.Add(time.Hour))
+	if messages := alerts(t, m); len(messages) != 0 {
+		t.Fatalf("unexpected alerts: %v", messages)
+	}
+}
+
+func TestMonitor_LowFlowRecovers(t *testing.T) {
+	m, pump := lowFlowMonitor()
+	now := time.Now()
+
+	pump.on = true
+	m.checkFlow(sensors.Flow{Rate: 0.2}, now)
+	m.checkFlow(sensors.Flow{Rate: 2}, now.Add(30*time.Second))
+	m.checkFlow(sensors.Flow{Rate: 0.2}, now.Add(90*time.Second))
+	if messages := alerts(t, m); len(messages) != 0 {
+		t.Fatalf("alert fired although flow recovered: %v", messages)
+	}
+}
+
+// notifyingStorage sends every Stat it records
+type notifyingStorage struct {
+	stats.Storage
+	recorded chan stats.Stat
+}
+
+func (s *notifyingStorage) Record(stat stats.Stat) error {
+	if err := s.Storage.Record(stat); err != nil {
+		return err
+	}
+	s.recorded <- stat
+	return nil
+}
+
+func TestMonitor_Clock(t *testing.T) {
+	start := time.Date(2018, time.June, 21, 6, 0, 0, 0, time.Local)
+	manual := clock.NewManual(start)
+	storage := &notifyingStorage{Storage: stats.NewFakeStatsStorage(40), recorded: make(chan stats.Stat)}
+	m := &Monitor{
+		Thermometer: sensors.NewFakeThermometer(time.Minute, manual),
+		Hygrometer:  sensors.NewFakeHygrometer(time.Minute, manual),
+		Storage:     storage,
+		Clock:       manual,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Begin()
+	}()
+	defer func() {
+		m.Thermometer.Close()
+		m.Hygrometer.Close()
+		<-done
+	}()
+
+	for i := 1; i <= 3; i++ {
+		// both sensors are waiting for their next reading
+		manual.BlockUntil(2)
+		manual.Advance(time.Minute)
+
+		seen := make(map[stats.StatType]bool)
+		for len(seen) < 2 {
+			stat := <-storage.recorded
+			if !stat.When.Equal(start.Add(time.Duration(i) * time.Minute)) {
+				t.Fatalf("unexpected time of %s: %s", stat.StatType, stat.When)
+			}
+			seen[stat.StatType] = true
+		}
+		if !seen[stats.StatTypeTemperature] || !seen[stats.StatTypeHumidity] {
+			t.Fatalf("unexpected readings: %v", seen)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// remoteSequences is how many recent sequence numbers
+	// of each device are remembered to detect duplicates
+	remoteSequences = 256
+	// maxRemoteSkew is how far ahead of our clock a reading may be
+	maxRemoteSkew = 5 * time.Minute
+)
+
+var (
+	// remoteStatTypes are the readings remote devices may submit, and
+	// what they are recorded as. They are kept apart from the readings
+	// of the greenhouse sensors that the units are controlled by, so a
+	// reading from elsewhere never drives the units in the greenhouse.
+	remoteStatTypes = map[stats.StatType]stats.StatType{
+		stats.StatTypeTemperature: stats.StatTypeRemoteTemperature,
+		stats.StatTypeHumidity:    stats.StatTypeRemoteHumidity,
+		stats.StatTypeLight:       stats.StatTypeRemoteLight,
+		stats.StatTypeCO2:         stats.StatTypeRemoteCO2,
+	}
+)
+
+var (
+	// ErrNoDevice indicates that a Submission does not name its device
+	ErrNoDevice = errors.New("device is required")
+	// ErrInvalidBattery indicates that a battery level is not a percentage
+	ErrInvalidBattery = errors.New("battery must be between 0 and 100")
+)
+
+// Submission is a batch of readings from a remote device
+type Submission struct {
+	// Device identifies the device
+	Device string `json:"device"`
+	// Sequence numbers the submissions of the device. A submission
+	// with a sequence number seen recently is a retry and is ignored,
+	// so devices should keep counting across restarts. Sequence numbers
+	// are only remembered in memory, so a retry received after the Remote
+	// is restarted is recorded again.
+	Sequence uint64 `json:"sequence"`
+	// Battery is the charge of the device in percent, if it has one
+	Battery  *float64  `json:"battery,omitempty"`
+	Readings []Reading `json:"readings"`
+}
+
+// Reading is a reading taken by a remote device. Stat is temperature,
+// humidity, light or co2, recorded as remotetemperature, remotehumidity,
+// remotelight or remoteco2.
+type Reading struct {
+	Stat      string    `json:"stat"`
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// Rejection is a reading that was not recorded and why
+type Rejection struct {
+	// Index is the position of the reading in the Submission
+	Index  int    `json:"index"`
+	Reason string `json:"reason"`
+}
+
+// Receipt is the outcome of a Submission
+type Receipt struct {
+	// Accepted is how many readings were recorded
+	Accepted int `json:"accepted"`
+	// Duplicate is whether or not the Submission was already received
+	Duplicate bool `json:"duplicate"`
+	// Rejected are the invalid readings and why they were rejected
+	Rejected []Rejection `json:"rejected"`
+}
+
+// Device is what is known about a remote device
+type Device struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	// Battery is the last reported charge in percent, if any
+	Battery  *float64 `json:"battery,omitempty"`
+	Sequence uint64   `json:"sequence"`
+}
+
+type remoteDevice struct {
+	Device
+	// sequences are the recently seen sequence numbers, oldest first
+	sequences []uint64
+}
+
+func (d *remoteDevice) seen(sequence uint64) bool {
+	for _, seen := range d.sequences {
+		if seen == sequence {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *remoteDevice) forget(sequence uint64) {
+	for i, seen := range d.sequences {
+		if seen == sequence {
+			d.sequences = append(d.sequences[:i], d.sequences[i+1:]...)
+			return
+		}
+	}
+}
+
+// Remote records readings submitted by remote devices, such as boards
+// that cannot be wired to the sensors, as StatTypes of their own so
+// they are never taken for readings of the greenhouse sensors. Stats
+// do not record which device they came from, so readings of every
+// remote device share those StatTypes.
+type Remote struct {
+	Storage stats.Storage
+	// Clock is when readings are received, the real clock unless replaced
+	Clock clock.Clock
+
+	mu      *sync.Mutex
+	devices map[string]*remoteDevice
+}
+
+// NewRemote creates a Remote recording to storage
+func NewRemote(storage stats.Storage) *Remote {
+	return &Remote{
+		Storage: storage,
+		Clock:   clock.Real,
+		mu:      &sync.Mutex{},
+		devices: make(map[string]*remoteDevice),
+	}
+}
+
+// Validate checks the parts of a Submission that
+// apply to all of it rather than to a single reading
+func (s Submission) Validate() error {
+	if s.Device == "" {
+		return ErrNoDevice
+	}
+	if s.Battery != nil && !(*s.Battery >= 0 && *s.Battery <= 100) {
+		return ErrInvalidBattery
+	}
+	return nil
+}
+
+// parseReading validates a Reading, received at now
+func parseReading(reading Reading, now time.Time) (stats.Stat, error) {
+	statType, err := stats.ParseStatType(reading.Stat)
+	if err != nil {
+		return stats.Stat{}, fmt.Errorf("unknown stat type %q", reading.Stat)
+	}
+	recorded, ok := remoteStatTypes[statType]
+	if !ok {
+		return stats.Stat{}, fmt.Errorf("%s cannot be submitted by remote devices", statType)
+	}
+	if reading.Timestamp.IsZero() {
+		return stats.Stat{}, errors.New("timestamp is required")
+	}
+	if reading.Timestamp.After(now.Add(maxRemoteSkew)) {
+		return stats.Stat{}, fmt.Errorf("timestamp %s is in the future", reading.Timestamp.Format(time.RFC3339))
+	}
+	value := reading.Value
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return stats.Stat{}, fmt.Errorf("invalid value %g", value)
+	}
+	if min, max := statType.Range(); value < min || value > max {
+		return stats.Stat{}, fmt.Errorf("%s value %g is outside of %g to %g", statType, value, min, max)
+	}
+	return stats.Stat{StatType: recorded, When: reading.Timestamp, Value: value}, nil
+}
+
+// Ingest records the valid readings of a Submission, unless it was
+// already received. An error is returned if the Submission is invalid
+// or if the Storage fails, in which case the Submission may be retried.
+func (r *Remote) Ingest(submission Submission) (Receipt, error) {
+	receipt := Receipt{Rejected: []Rejection{}}
+	if err := submission.Validate(); err != nil {
+		return receipt, err
+	}
+	now := r.Clock.Now()
+
+	r.mu.Lock()
+	device, ok := r.devices[submission.Device]
+	if !ok {
+		device = &remoteDevice{Device: Device{ID: submission.Device}}
+		r.devices[submission.Device] = device
+	}
+	device.LastSeen = now
+	if submission.Battery != nil {
+		battery := *submission.Battery
+		device.Battery = &battery
+	}
+	if device.seen(submission.Sequence) {
+		r.mu.Unlock()
+		receipt.Duplicate = true
+		return receipt, nil
+	}
+	// claimed before recording so that a concurrent retry is a duplicate
+	device.sequences = append(device.sequences, submission.Sequence)
+	if len(device.sequences) > remoteSequences {
+		device.sequences = device.sequences[1:]
+	}
+	device.Sequence = submission.Sequence
+	r.mu.Unlock()
+
+	records := make([]stats.Stat, 0, len(submission.Readings))
+	for i, reading := range submission.Readings {
+		stat, err := parseReading(reading, now)
+		if err != nil {
+			receipt.Rejected = append(receipt.Rejected, Rejection{Index: i, Reason: err.Error()})
+			continue
+		}
+		records = append(records, stat)
+	}
+
+	// in the order they were taken, so that the latest reading is the last recorded
+	sort.SliceStable(records, func(i, j int) bool { return records[i].When.Before(records[j].When) })
+	for _, stat := range records {
+		if err := r.Storage.Record(stat); err != nil {
+			r.mu.Lock()
+			device.forget(submission.Sequence)
+			r.mu.Unlock()
+			return receipt, fmt.Errorf("error recording %s: %v", stat.StatType, err)
+		}
+		receipt.Accepted++
+	}
+	return receipt, nil
+}
+
+// Devices returns every device that has submitted readings, by ID
+func (r *Remote) Devices() []Device {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	devices := make([]Device, 0, len(r.devices))
+	for _, device := range r.devices {
+		d := device.Device
+		if d.Battery != nil {
+			battery := *d.Battery
+			d.Battery = &battery
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
@@ -0,0 +1,242 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+type failingStorage struct {
+	stats.Storage
+}
+
+func (failingStorage) Record(stats.Stat) error {
+	return errors.New("disk full")
+}
+
+func battery(level float64) *float64 {
+	return &level
+}
+
+func TestRemote_Ingest(t *testing.T) {
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+	now := time.Now().Round(0)
+	local := stats.Stat{StatType: stats.StatTypeTemperature, When: now.Add(-time.Hour), Value: 24}
+	if err := remote.Storage.Record(local); err != nil {
+		t.Fatal(err)
+	}
+
+	receipt, err := remote.Ingest(Submission{
+		Device:   "coldframe",
+		Sequence: 1,
+		Readings: []Reading{
+			{Stat: "humidity", Timestamp: now, Value: 80},
+			{Stat: "temperature", Timestamp: now.Add(-time.Minute), Value: 12.5},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Duplicate || receipt.Accepted != 2 || len(receipt.Rejected) != 0 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+
+	latest, err := remote.Storage.Latest(stats.StatTypeRemoteTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 12.5 || !latest.When.Equal(now.Add(-time.Minute)) {
+		t.Errorf("unexpected latest: %v", latest)
+	}
+
+	// the greenhouse sensors are not replaced by the newer remote reading
+	latest, err = remote.Storage.Latest(stats.StatTypeTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != local.Value || !latest.When.Equal(local.When) {
+		t.Errorf("unexpected latest local reading: %v", latest)
+	}
+	if _, err := remote.Storage.Latest(stats.StatTypeHumidity); err != stats.ErrNoStats {
+		t.Errorf("remote reading recorded as local: %v", err)
+	}
+}
+
+func TestRemote_Duplicate(t *testing.T) {
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+	submission := Submission{
+		Device:   "coldframe",
+		Sequence: 7,
+		Readings: []Reading{{Stat: "temperature", Timestamp: time.Now(), Value: 12.5}},
+	}
+
+	if _, err := remote.Ingest(submission); err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := remote.Ingest(submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !receipt.Duplicate || receipt.Accepted != 0 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	// sequence numbers are per device
+	submission.Device = "potting-bench"
+	receipt, err = remote.Ingest(submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Duplicate || receipt.Accepted != 1 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+
+	stored, err := remote.Storage.Fetch(stats.StatTypeRemoteTemperature, time.Now().Add(-time.Hour), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("unexpected stats: %v", stored)
+	}
+}
+
+func TestRemote_Rejected(t *testing.T) {
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+	now := time.Now()
+
+	receipt, err := remote.Ingest(Submission{
+		Device:   "coldframe",
+		Sequence: 1,
+		Readings: []Reading{
+			{Stat: "temperature", Timestamp: now, Value: 12.5},
+			{Stat: "soil", Timestamp: now, Value: 1},
+			{Stat: "humidity", Timestamp: now, Value: 101},
+			{Stat: "humidity", Value: 50},
+			{Stat: "humidity", Timestamp: now.Add(time.Hour), Value: 50},
+			{Stat: "fan", Timestamp: now, Value: 1},
+			{Stat: "remotetemperature", Timestamp: now, Value: 12.5},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Accepted != 1 || len(receipt.Rejected) != 6 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	expected := []string{
+		`unknown stat type "soil"`,
+		"humidity value 101 is outside of 0 to 100",
+		"timestamp is required",
+	}
+	for i, reason := range expected {
+		if rejection := receipt.Rejected[i]; rejection.Index != i+1 || rejection.Reason != reason {
+			t.Errorf("unexpected rejection: %+v", rejection)
+		}
+	}
+	if index := receipt.Rejected[3].Index; index != 4 {
+		t.Errorf("unexpected rejection: %+v", receipt.Rejected[3])
+	}
+	for i, reason := range []string{
+		"fan cannot be submitted by remote devices",
+		"remotetemperature cannot be submitted by remote devices",
+	} {
+		if rejection := receipt.Rejected[4+i]; rejection.Index != 5+i || rejection.Reason != reason {
+			t.Errorf("unexpected rejection: %+v", rejection)
+		}
+	}
+}
+
+func TestRemote_Invalid(t *testing.T) {
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+
+	if _, err := remote.Ingest(Submission{Sequence: 1}); err != ErrNoDevice {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := remote.Ingest(Submission{Device: "coldframe", Battery: battery(120)}); err != ErrInvalidBattery {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if devices := remote.Devices(); len(devices) != 0 {
+		t.Errorf("unexpected devices: %v", devices)
+	}
+}
+
+func TestRemote_StorageError(t *testing.T) {
+	remote := NewRemote(failingStorage{stats.NewFakeStatsStorage(40)})
+	submission := Submission{
+		Device:   "coldframe",
+		Sequence: 1,
+		Readings: []Reading{{Stat: "temperature", Timestamp: time.Now(), Value: 12.5}},
+	}
+
+	if _, err := remote.Ingest(submission); err == nil {
+		t.Fatal("expected error")
+	}
+
+	// the submission may be retried
+	remote.Storage = stats.NewFakeStatsStorage(40)
+	receipt, err := remote.Ingest(submission)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Duplicate || receipt.Accepted != 1 {
+		t.Errorf("unexpected receipt: %+v", receipt)
+	}
+}
+
+func TestRemote_Devices(t *testing.T) {
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+
+	for i, submission := range []Submission{
+		{Device: "potting-bench", Sequence: 3},
+		{Device: "coldframe", Sequence: 1, Battery: battery(90)},
+		{Device: "coldframe", Sequence: 2},
+	} {
+		if _, err := remote.Ingest(submission); err != nil {
+			t.Fatalf("error ingesting submission %d: %v", i, err)
+		}
+	}
+
+	devices := remote.Devices()
+	if len(devices) != 2 {
+		t.Fatalf("unexpected devices: %v", devices)
+	}
+	coldframe, bench := devices[0], devices[1]
+	if coldframe.ID != "coldframe" || coldframe.Sequence != 2 || coldframe.Battery == nil || *coldframe.Battery != 90 {
+		t.Errorf("unexpected device: %+v", coldframe)
+	}
+	if bench.ID != "potting-bench" || bench.Sequence != 3 || bench.Battery != nil {
+		t.Errorf("unexpected device: %+v", bench)
+	}
+	if time.Since(coldframe.LastSeen) > time.Minute {
+		t.Errorf("unexpected last seen: %v", coldframe.LastSeen)
+	}
+}
+
+func TestRemote_Clock(t *testing.T) {
+	start := time.Date(2018, time.June, 21, 6, 0, 0, 0, time.UTC)
+	manual := clock.NewManual(start)
+	remote := NewRemote(stats.NewFakeStatsStorage(40))
+	remote.Clock = manual
+	manual.Advance(time.Hour)
+
+	receipt, err := remote.Ingest(Submission{
+		Device:   "coldframe",
+		Sequence: 1,
+		Readings: []Reading{
+			{Stat: "temperature", Timestamp: start.Add(64 * time.Minute), Value: 12.5},
+			{Stat: "temperature", Timestamp: start.Add(66 * time.Minute), Value: 12.5},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Accepted != 1 || len(receipt.Rejected) != 1 || receipt.Rejected[0].Index != 1 {
+		t.Fatalf("unexpected receipt: %+v", receipt)
+	}
+	if devices := remote.Devices(); len(devices) != 1 || !devices[0].LastSeen.Equal(start.Add(time.Hour)) {
+		t.Fatalf("unexpected devices: %+v", devices)
+	}
+}
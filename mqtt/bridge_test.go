@@ -0,0 +1,235 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/mqtt/mqtttest"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	bridgeTimeout = 5 * time.Second
+)
+
+func bridgeTest(f func(t *testing.T, broker *mqtttest.Broker, bridge *Bridge)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		broker, err := mqtttest.NewBroker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer broker.Close()
+
+		storage := stats.NewFakeStatsStorage(100)
+		if err := storage.Record(stats.Stat{StatType: stats.StatTypeHumidity, When: time.Now(), Value: 55}); err != nil {
+			t.Fatal(err)
+		}
+
+		scheduler := controllers.NewScheduler()
+		defer scheduler.CancelAll()
+		water, err := controllers.NewController(controllers.NewFakeUnit(stats.StatTypeWater), storage, scheduler)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bridge, err := NewBridge(storage, broker.URL(), 10*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bridge.Stats = []stats.StatType{stats.StatTypeTemperature, stats.StatTypeHumidity}
+		bridge.Units = map[string]*controllers.Controller{"water": water}
+		defer bridge.Close()
+
+		go bridge.Begin()
+		if err := broker.WaitRetained("greenhouse/status", PayloadOnline, bridgeTimeout); err != nil {
+			t.Fatal(err)
+		}
+
+		f(t, broker, bridge)
+	}
+	return name, testFunc
+}
+
+func TestMQTT(t *testing.T) {
+	t.Parallel()
+	t.Run("Bridge", func(t *testing.T) {
+		t.Parallel()
+		t.Run(testFunctionName(bridge_BadURL), bridge_BadURL)
+		t.Run(bridgeTest(bridge_Discovery))
+		t.Run(bridgeTest(bridge_Latest))
+		t.Run(bridgeTest(bridge_Record))
+		t.Run(bridgeTest(bridge_UnitState))
+		t.Run(bridgeTest(bridge_Command))
+		t.Run(bridgeTest(bridge_StopCommands))
+		t.Run(bridgeTest(bridge_Reconnect))
+		t.Run(bridgeTest(bridge_Close))
+	})
+}
+
+func bridge_BadURL(t *testing.T) {
+	for _, u := range []string{"http://localhost", "::"} {
+		if _, err := NewBridge(stats.NewFakeStatsStorage(1), u, time.Second); err == nil {
+			t.Errorf("expected error for %q", u)
+		}
+	}
+}
+
+func bridge_Discovery(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	msg, ok := broker.Retained("homeassistant/sensor/greenhouse/temperature/config")
+	if !ok {
+		t.Fatal("temperature config not retained")
+	}
+	var sensor discoveryConfig
+	if err := json.Unmarshal([]byte(msg.Payload), &sensor); err != nil {
+		t.Fatal(err)
+	}
+	if sensor.StateTopic != "greenhouse/stats/temperature" || sensor.UnitOfMeasurement != "°C" || sensor.DeviceClass != "temperature" {
+		t.Errorf("unexpected sensor config: %s", msg.Payload)
+	}
+	if sensor.AvailabilityTopic != "greenhouse/status" || sensor.UniqueID != "greenhouse_temperature" {
+		t.Errorf("unexpected sensor config: %s", msg.Payload)
+	}
+
+	msg, ok = broker.Retained("homeassistant/switch/greenhouse/water/config")
+	if !ok {
+		t.Fatal("water config not retained")
+	}
+	var unit discoveryConfig
+	if err := json.Unmarshal([]byte(msg.Payload), &unit); err != nil {
+		t.Fatal(err)
+	}
+	if unit.StateTopic != "greenhouse/units/water" || unit.CommandTopic != "greenhouse/units/water/set" {
+		t.Errorf("unexpected switch config: %s", msg.Payload)
+	}
+	if unit.PayloadOn != PayloadOn || unit.PayloadOff != PayloadOff {
+		t.Errorf("unexpected switch config: %s", msg.Payload)
+	}
+
+	if _, ok := broker.Retained("homeassistant/sensor/greenhouse/co2/config"); ok {
+		t.Error("unexpected co2 config")
+	}
+}
+
+func bridge_Latest(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	// stats recorded before connecting are published on connect
+	if err := broker.WaitRetained("greenhouse/stats/humidity", "55", bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOff, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bridge_Record(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	if err := bridge.Record(stats.Stat{StatType: stats.StatTypeTemperature, When: time.Now(), Value: 21.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.WaitRetained("greenhouse/stats/temperature", "21.5", bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stat is still recorded in the storage
+	latest, err := bridge.Latest(stats.StatTypeTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 21.5 {
+		t.Errorf("unexpected latest: %v", latest)
+	}
+}
+
+func bridge_UnitState(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	water := bridge.Units["water"]
+	if err := water.TurnUnitOn(controllers.APICause("test"), 0, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOn, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	water.TurnUnitOff(controllers.APICause("test"))
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOff, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bridge_Command(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	if err := broker.WaitSubscribed("greenhouse/units/water/set", bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.Publish("greenhouse/units/water/set", PayloadOn, false)
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOn, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if !bridge.Units["water"].IsOn() {
+		t.Error("water is not on")
+	}
+
+	trail, err := bridge.AuditTrail(stats.AuditFilter{Start: time.Now().Add(-time.Hour), End: time.Now(), Unit: "water"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) == 0 || trail[0].Cause != stats.AuditCauseAPI || trail[0].Source != "mqtt" {
+		t.Errorf("unexpected audit trail: %v", trail)
+	}
+
+	broker.Publish("greenhouse/units/water/set", PayloadOff, false)
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOff, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if bridge.Units["water"].IsOn() {
+		t.Error("water is not off")
+	}
+}
+
+func bridge_StopCommands(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	if err := broker.WaitSubscribed("greenhouse/units/water/set", bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	bridge.StopCommands()
+	broker.Publish("greenhouse/units/water/set", PayloadOn, false)
+	time.Sleep(100 * time.Millisecond)
+	if bridge.Units["water"].IsOn() {
+		t.Error("water was turned on")
+	}
+}
+
+func bridge_Reconnect(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	broker.Disconnect()
+
+	// the last will announces that the bridge went away
+	if err := broker.WaitPublished("greenhouse/status", PayloadOffline, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.WaitRetained("greenhouse/status", PayloadOnline, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Connects(); n != 2 {
+		t.Errorf("unexpected connects: %d", n)
+	}
+
+	// commands are subscribed to again
+	if err := broker.WaitSubscribed("greenhouse/units/water/set", bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+	broker.Publish("greenhouse/units/water/set", PayloadOn, false)
+	if err := broker.WaitRetained("greenhouse/units/water", PayloadOn, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func bridge_Close(t *testing.T, broker *mqtttest.Broker, bridge *Bridge) {
+	if err := bridge.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.WaitRetained("greenhouse/status", PayloadOffline, bridgeTimeout); err != nil {
+		t.Fatal(err)
+	}
+}
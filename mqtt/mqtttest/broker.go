@@ -0,0 +1,569 @@
+// Package mqtttest provides an in-process MQTT broker for tests
+package mqtttest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetUnsubscribe = 10
+	packetUnsuback    = 11
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+)
+
+var (
+	errMalformed = errors.New("malformed packet")
+)
+
+// Message is a message published to the Broker
+type Message struct {
+	Topic   string
+	Payload string
+	Retain  bool
+}
+
+// Broker is a minimal MQTT 3.1.1 broker supporting QoS 0 and 1,
+// retained messages, wildcards and last wills. Sessions are not kept.
+type Broker struct {
+	listener net.Listener
+
+	mu         *sync.Mutex
+	clients    map[*client]bool
+	retained   map[string]Message
+	published  []Message
+	connects   int
+	handlers   []handler
+	publishedC chan struct{}
+	wg         *sync.WaitGroup
+}
+
+type handler struct {
+	filter string
+	fn     func(Message)
+}
+
+type client struct {
+	conn net.Conn
+	id   string
+
+	mu     *sync.Mutex
+	subs   map[string]byte
+	will   *Message
+	nextID uint16
+}
+
+// NewBroker starts a Broker listening on a random local port
+func NewBroker() (*Broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("error listening: %v", err)
+	}
+	b := &Broker{
+		listener:   listener,
+		mu:         &sync.Mutex{},
+		clients:    make(map[*client]bool),
+		retained:   make(map[string]Message),
+		publishedC: make(chan struct{}),
+		wg:         &sync.WaitGroup{},
+	}
+	b.wg.Add(1)
+	go b.accept()
+	return b, nil
+}
+
+// URL is the address clients connect to
+func (b *Broker) URL() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// Close stops the Broker and drops every client
+func (b *Broker) Close() error {
+	err := b.listener.Close()
+	b.Disconnect()
+	b.wg.Wait()
+	return err
+}
+
+// Disconnect drops every client without a word,
+// as if the network failed, so their wills are published
+func (b *Broker) Disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for c := range b.clients {
+		c.conn.Close()
+	}
+}
+
+// Connects returns how many times clients have connected
+func (b *Broker) Connects() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connects
+}
+
+// Publish publishes a message as if from a client
+func (b *Broker) Publish(topic, payload string, retain bool) {
+	b.route(Message{Topic: topic, Payload: payload, Retain: retain}, 1)
+}
+
+// Handle calls fn with every message published on topics matching
+// a filter, such as to act as a device answering commands
+func (b *Broker) Handle(filter string, fn func(Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler{filter: filter, fn: fn})
+}
+
+// Retained returns the message retained on a topic
+func (b *Broker) Retained(topic string) (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg, ok := b.retained[topic]
+	return msg, ok
+}
+
+// Published returns every message published to the Broker
+// on topics matching a filter, oldest first
+func (b *Broker) Published(filter string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var msgs []Message
+	for _, msg := range b.published {
+		if Match(filter, msg.Topic) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// WaitRetained waits until the message retained on a topic has a payload
+func (b *Broker) WaitRetained(topic, payload string, timeout time.Duration) error {
+	return b.wait(timeout, func() bool {
+		msg, ok := b.retained[topic]
+		return ok && msg.Payload == payload
+	}, func() string {
+		return fmt.Sprintf("retained %q on %s", b.retained[topic].Payload, topic)
+	})
+}
+
+// WaitPublished waits until a message with a payload is published on a topic
+func (b *Broker) WaitPublished(topic, payload string, timeout time.Duration) error {
+	return b.wait(timeout, func() bool {
+		for _, msg := range b.published {
+			if msg.Topic == topic && msg.Payload == payload {
+				return true
+			}
+		}
+		return false
+	}, func() string {
+		return fmt.Sprintf("no %q on %s", payload, topic)
+	})
+}
+
+// WaitSubscribed waits until a client subscribes to a filter
+func (b *Broker) WaitSubscribed(filter string, timeout time.Duration) error {
+	return b.wait(timeout, func() bool {
+		for c := range b.clients {
+			c.mu.Lock()
+			_, ok := c.subs[filter]
+			c.mu.Unlock()
+			if ok {
+				return true
+			}
+		}
+		return false
+	}, func() string {
+		return fmt.Sprintf("no subscription to %s", filter)
+	})
+}
+
+// wait waits for done to be true, checking it while holding the lock
+// every time something is published or a client subscribes
+func (b *Broker) wait(timeout time.Duration, done func() bool, describe func() string) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		b.mu.Lock()
+		if done() {
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.publishedC
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-deadline.C:
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			return fmt.Errorf("timed out: %s", describe())
+		}
+	}
+}
+
+// notify wakes waiters, it must be called while holding the lock
+func (b *Broker) notify() {
+	close(b.publishedC)
+	b.publishedC = make(chan struct{})
+}
+
+func (b *Broker) accept() {
+	defer b.wg.Done()
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.wg.Add(1)
+		go b.serve(conn)
+	}
+}
+
+func (b *Broker) serve(conn net.Conn) {
+	defer b.wg.Done()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	kind, _, body, err := readPacket(r)
+	if err != nil || kind != packetConnect {
+		return
+	}
+	c, err := parseConnect(conn, body)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	b.clients[c] = true
+	b.connects++
+	b.mu.Unlock()
+	c.write(packetConnack<<4, []byte{0, 0})
+
+	clean := b.read(c, r)
+
+	b.mu.Lock()
+	delete(b.clients, c)
+	b.mu.Unlock()
+	if !clean && c.will != nil {
+		b.route(*c.will, 1)
+	}
+}
+
+// read handles packets from a client until it disconnects,
+// returning whether or not it disconnected cleanly
+func (b *Broker) read(c *client, r *bufio.Reader) bool {
+	for {
+		kind, flags, body, err := readPacket(r)
+		if err != nil {
+			return false
+		}
+		switch kind {
+		case packetPublish:
+			msg, qos, id, err := parsePublish(flags, body)
+			if err != nil {
+				return false
+			}
+			if qos > 0 {
+				c.write(packetPuback<<4, id)
+			}
+			b.route(msg, qos)
+		case packetPuback:
+		case packetSubscribe:
+			if len(body) < 2 {
+				return false
+			}
+			id, rest := body[:2], body[2:]
+			var granted []byte
+			var filters []string
+			for len(rest) > 0 {
+				filter, n, err := readString(rest)
+				if err != nil || len(rest) < n+1 {
+					return false
+				}
+				qos := rest[n]
+				if qos > 1 {
+					qos = 1
+				}
+				rest = rest[n+1:]
+				c.mu.Lock()
+				c.subs[filter] = qos
+				c.mu.Unlock()
+				granted = append(granted, qos)
+				filters = append(filters, filter)
+			}
+			c.write(packetSuback<<4, append(append([]byte{}, id...), granted...))
+			b.sendRetained(c, filters)
+			b.mu.Lock()
+			b.notify()
+			b.mu.Unlock()
+		case packetUnsubscribe:
+			if len(body) < 2 {
+				return false
+			}
+			id, rest := body[:2], body[2:]
+			for len(rest) > 0 {
+				filter, n, err := readString(rest)
+				if err != nil {
+					return false
+				}
+				rest = rest[n:]
+				c.mu.Lock()
+				delete(c.subs, filter)
+				c.mu.Unlock()
+			}
+			c.write(packetUnsuback<<4, id)
+		case packetPingreq:
+			c.write(packetPingresp<<4, nil)
+		case packetDisconnect:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// route retains a message and delivers it to the matching subscribers
+func (b *Broker) route(msg Message, qos byte) {
+	b.mu.Lock()
+	if msg.Retain {
+		if msg.Payload == "" {
+			delete(b.retained, msg.Topic)
+		} else {
+			b.retained[msg.Topic] = msg
+		}
+	}
+	b.published = append(b.published, msg)
+	b.notify()
+	clients := make([]*client, 0, len(b.clients))
+	for c := range b.clients {
+		clients = append(clients, c)
+	}
+	var handlers []handler
+	for _, h := range b.handlers {
+		if Match(h.filter, msg.Topic) {
+			handlers = append(handlers, h)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		h.fn(msg)
+	}
+
+	// messages are delivered without the retain flag to existing subscribers
+	msg.Retain = false
+	for _, c := range clients {
+		if subQoS, ok := c.subscribed(msg.Topic); ok {
+			if subQoS < qos {
+				c.publish(msg, subQoS)
+			} else {
+				c.publish(msg, qos)
+			}
+		}
+	}
+}
+
+// sendRetained delivers the retained messages matching new subscriptions
+func (b *Broker) sendRetained(c *client, filters []string) {
+	b.mu.Lock()
+	var msgs []Message
+	for _, msg := range b.retained {
+		for _, filter := range filters {
+			if Match(filter, msg.Topic) {
+				msgs = append(msgs, msg)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	for _, msg := range msgs {
+		qos, _ := c.subscribed(msg.Topic)
+		c.publish(msg, qos)
+	}
+}
+
+// Match returns whether or not a topic matches a filter with + and # wildcards
+func Match(filter, topic string) bool {
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+	for i, f := range filters {
+		if f == "#" {
+			return true
+		}
+		if i >= len(topics) {
+			return false
+		}
+		if f != "+" && f != topics[i] {
+			return false
+		}
+	}
+	return len(filters) == len(topics)
+}
+
+func (c *client) subscribed(topic string) (byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var qos byte
+	found := false
+	for filter, q := range c.subs {
+		if Match(filter, topic) {
+			found = true
+			if q > qos {
+				qos = q
+			}
+		}
+	}
+	return qos, found
+}
+
+func (c *client) publish(msg Message, qos byte) {
+	header := byte(packetPublish << 4)
+	if msg.Retain {
+		header |= 1
+	}
+	body := appendString(nil, msg.Topic)
+	if qos > 0 {
+		header |= qos << 1
+		c.mu.Lock()
+		c.nextID++
+		if c.nextID == 0 {
+			c.nextID++
+		}
+		id := c.nextID
+		c.mu.Unlock()
+		body = append(body, byte(id>>8), byte(id))
+	}
+	body = append(body, msg.Payload...)
+	c.write(header, body)
+}
+
+func (c *client) write(header byte, body []byte) {
+	packet := []byte{header}
+	n := len(body)
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.Write(packet)
+}
+
+func readPacket(r *bufio.Reader) (byte, byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		multiplier *= 128
+		if digit&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, 0, nil, errMalformed
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return header >> 4, header & 0x0f, body, nil
+}
+
+func parseConnect(conn net.Conn, body []byte) (*client, error) {
+	protocol, n, err := readString(body)
+	if err != nil || protocol != "MQTT" || len(body) < n+4 {
+		return nil, errMalformed
+	}
+	flags := body[n+1]
+	rest := body[n+4:]
+
+	c := &client{conn: conn, mu: &sync.Mutex{}, subs: make(map[string]byte)}
+	if c.id, n, err = readString(rest); err != nil {
+		return nil, err
+	}
+	rest = rest[n:]
+	if flags&0x04 != 0 {
+		topic, n, err := readString(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+		payload, n, err := readString(rest)
+		if err != nil {
+			return nil, err
+		}
+		c.will = &Message{Topic: topic, Payload: payload, Retain: flags&0x20 != 0}
+	}
+	// usernames and passwords are accepted without checking
+	return c, nil
+}
+
+func parsePublish(flags byte, body []byte) (Message, byte, []byte, error) {
+	topic, n, err := readString(body)
+	if err != nil {
+		return Message{}, 0, nil, err
+	}
+	qos := (flags >> 1) & 0x03
+	if qos > 1 {
+		return Message{}, 0, nil, errMalformed
+	}
+	rest := body[n:]
+	var id []byte
+	if qos > 0 {
+		if len(rest) < 2 {
+			return Message{}, 0, nil, errMalformed
+		}
+		id, rest = rest[:2], rest[2:]
+	}
+	return Message{Topic: topic, Payload: string(rest), Retain: flags&0x01 != 0}, qos, id, nil
+}
+
+// readString reads a length prefixed string, returning it and the bytes it took
+func readString(b []byte) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, errMalformed
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", 0, errMalformed
+	}
+	return string(b[2 : 2+n]), 2 + n, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
@@ -0,0 +1,30 @@
+package sensors
+
+import "time"
+
+// CO2Sensor is a sensor made for reading carbon dioxide concentration
+type CO2Sensor interface {
+	// Read returns a channel on which
+	// sensor data can be read from
+	Read() <-chan CO2
+
+	// Frequency returns the frequency at
+	// which  this sensor is reading values
+	Frequency() time.Duration
+
+	// Close the underlying connection
+	// to the sensor. Read will no longer
+	// be a valid channel
+	Close() error
+}
+
+// Calibrator is a sensor that can be calibrated to a known reference
+type Calibrator interface {
+	// CalibrateZero sets the current reading as the zero point
+	// of the sensor, which for CO2 sensors is 400ppm fresh air
+	CalibrateZero() error
+}
+
+// CO2 is the value of CO2Sensor data
+// represented in parts per million
+type CO2 float64
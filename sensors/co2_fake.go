@@ -0,0 +1,77 @@
+package sensors
+
+import (
+	"log"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+const (
+	// fakeCO2SensorAmbient is the concentration of fresh air
+	fakeCO2SensorAmbient = 420
+	fakeCO2SensorMin     = 350
+	fakeCO2SensorMax     = 1500
+	fakeCO2SensorStep    = 25
+)
+
+type fakeCO2Sensor struct {
+	frq    time.Duration
+	clock  clock.Clock
+	closed chan struct{}
+	last   CO2
+}
+
+// NewFakeCO2Sensor creates a CO2Sensor reading a random walk around fresh air
+func NewFakeCO2Sensor(frq time.Duration, c clock.Clock) CO2Sensor {
+	fake := &fakeCO2Sensor{
+		frq:    frq,
+		clock:  c,
+		closed: make(chan struct{}),
+		last:   fakeCO2SensorAmbient,
+	}
+	return fake
+}
+
+func (f *fakeCO2Sensor) nextValue() CO2 {
+	next := f.last + CO2((theRand.Float64()*2-1)*fakeCO2SensorStep)
+	if next < fakeCO2SensorMin {
+		next = fakeCO2SensorMin
+	}
+	if next > fakeCO2SensorMax {
+		next = fakeCO2SensorMax
+	}
+	f.last = next
+	log.Printf("co2: %g", next)
+	return next
+}
+
+func (f *fakeCO2Sensor) Read() <-chan CO2 {
+	results := make(chan CO2)
+	go func() {
+		defer close(results)
+		for {
+			select {
+			case <-f.closed:
+				return
+			case <-f.clock.After(f.frq):
+				results <- f.nextValue()
+			}
+		}
+	}()
+	return results
+}
+
+func (f *fakeCO2Sensor) Frequency() time.Duration {
+	return f.frq
+}
+
+func (f *fakeCO2Sensor) CalibrateZero() error {
+	f.last = fakeCO2SensorAmbient
+	return nil
+}
+
+func (f *fakeCO2Sensor) Close() error {
+	close(f.closed)
+	return nil
+}
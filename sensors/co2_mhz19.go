@@ -0,0 +1,146 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// MHZ19Baud is the baud rate of the MH-Z19 serial interface
+	MHZ19Baud = 9600
+
+	// MHZ19Timeout is how long to wait for an MH-Z19 to respond
+	MHZ19Timeout = 2 * time.Second
+
+	mhz19FrameLength    = 9
+	mhz19Start          = 0xff
+	mhz19SensorNumber   = 0x01
+	mhz19CommandRead    = 0x86
+	mhz19CommandZeroCal = 0x87
+)
+
+var (
+	// ErrBadChecksum indicates that a sensor response was corrupted
+	ErrBadChecksum = errors.New("bad checksum")
+)
+
+// MHZ19 reads an MH-Z19 CO2 sensor over a serial port
+type MHZ19 struct {
+	port io.ReadWriter
+	frq  time.Duration
+
+	mu        *sync.Mutex
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewMHZ19 creates a CO2Sensor speaking the MH-Z19 protocol over a
+// serial port, which must be configured for MHZ19Baud 8N1. If the
+// port is an io.Closer, it is closed when the sensor is closed.
+func NewMHZ19(port io.ReadWriter, frq time.Duration) *MHZ19 {
+	return &MHZ19{
+		port:      port,
+		frq:       frq,
+		mu:        &sync.Mutex{},
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// mhz19Checksum is the checksum of a frame, which is
+// the negated sum of every byte but the first and last
+func mhz19Checksum(frame []byte) byte {
+	var sum byte
+	for _, b := range frame[1 : mhz19FrameLength-1] {
+		sum += b
+	}
+	return 0xff - sum + 1
+}
+
+// command builds a command frame with an empty payload
+func mhz19Command(command byte) []byte {
+	frame := make([]byte, mhz19FrameLength)
+	frame[0] = mhz19Start
+	frame[1] = mhz19SensorNumber
+	frame[2] = command
+	frame[mhz19FrameLength-1] = mhz19Checksum(frame)
+	return frame
+}
+
+// measure requests and reads the current CO2 concentration
+func (s *MHZ19) measure() (CO2, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.port.Write(mhz19Command(mhz19CommandRead)); err != nil {
+		return 0, fmt.Errorf("error writing to mh-z19: %v", err)
+	}
+	frame := make([]byte, mhz19FrameLength)
+	if _, err := io.ReadFull(s.port, frame); err != nil {
+		return 0, fmt.Errorf("error reading from mh-z19: %v", err)
+	}
+	if frame[0] != mhz19Start || frame[1] != mhz19CommandRead {
+		return 0, fmt.Errorf("unexpected mh-z19 response: % x", frame)
+	}
+	if frame[mhz19FrameLength-1] != mhz19Checksum(frame) {
+		return 0, ErrBadChecksum
+	}
+	return CO2(int(frame[2])<<8 | int(frame[3])), nil
+}
+
+// CalibrateZero sets the current concentration as 400ppm. The sensor
+// should have been in fresh air for at least 20 minutes beforehand.
+func (s *MHZ19) CalibrateZero() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the sensor does not respond to calibration commands
+	if _, err := s.port.Write(mhz19Command(mhz19CommandZeroCal)); err != nil {
+		return fmt.Errorf("error calibrating mh-z19: %v", err)
+	}
+	return nil
+}
+
+func (s *MHZ19) Read() <-chan CO2 {
+	results := make(chan CO2)
+	go func() {
+		defer close(results)
+		for {
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(s.frq):
+				ppm, err := s.measure()
+				if err != nil {
+					log.Printf("co2: %v", err)
+					continue
+				}
+				select {
+				case results <- ppm:
+				case <-s.closed:
+					return
+				}
+			}
+		}
+	}()
+	return results
+}
+
+func (s *MHZ19) Frequency() time.Duration {
+	return s.frq
+}
+
+func (s *MHZ19) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if closer, ok := s.port.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
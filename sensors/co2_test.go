@@ -0,0 +1,124 @@
+package sensors
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// scriptedPort is a serial port that records what is written
+// to it and replies with a scripted response
+type scriptedPort struct {
+	written  bytes.Buffer
+	response bytes.Buffer
+	closed   bool
+}
+
+func newScriptedPort(response ...byte) *scriptedPort {
+	port := &scriptedPort{}
+	port.response.Write(response)
+	return port
+}
+
+func (p *scriptedPort) Write(b []byte) (int, error) {
+	return p.written.Write(b)
+}
+
+func (p *scriptedPort) Read(b []byte) (int, error) {
+	return p.response.Read(b)
+}
+
+func (p *scriptedPort) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestMHZ19_Measure(t *testing.T) {
+	// 0x02 0x58 is 600ppm
+	port := newScriptedPort(0xff, 0x86, 0x02, 0x58, 0x47, 0x00, 0x00, 0x00, 0xd9)
+	sensor := NewMHZ19(port, time.Second)
+
+	ppm, err := sensor.measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ppm != 600 {
+		t.Errorf("unexpected concentration: %g", ppm)
+	}
+
+	expected := []byte{0xff, 0x01, 0x86, 0x00, 0x00, 0x00, 0x00, 0x00, 0x79}
+	if !bytes.Equal(port.written.Bytes(), expected) {
+		t.Errorf("unexpected command: % x", port.written.Bytes())
+	}
+
+	if err := sensor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !port.closed {
+		t.Error("port was not closed")
+	}
+}
+
+func TestMHZ19_BadChecksum(t *testing.T) {
+	port := newScriptedPort(0xff, 0x86, 0x02, 0x58, 0x47, 0x00, 0x00, 0x00, 0xda)
+	sensor := NewMHZ19(port, time.Second)
+
+	if _, err := sensor.measure(); err != ErrBadChecksum {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMHZ19_UnexpectedResponse(t *testing.T) {
+	port := newScriptedPort(0xff, 0x87, 0x02, 0x58, 0x47, 0x00, 0x00, 0x00, 0xd8)
+	sensor := NewMHZ19(port, time.Second)
+
+	if _, err := sensor.measure(); err == nil || err == ErrBadChecksum {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMHZ19_ShortResponse(t *testing.T) {
+	port := newScriptedPort(0xff, 0x86, 0x02)
+	sensor := NewMHZ19(port, time.Second)
+
+	if _, err := sensor.measure(); err == nil {
+		t.Error("expected an error")
+	}
+	if _, err := port.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("response was not consumed: %v", err)
+	}
+}
+
+func TestMHZ19_CalibrateZero(t *testing.T) {
+	port := newScriptedPort()
+	sensor := NewMHZ19(port, time.Second)
+
+	if err := sensor.CalibrateZero(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{0xff, 0x01, 0x87, 0x00, 0x00, 0x00, 0x00, 0x00, 0x78}
+	if !bytes.Equal(port.written.Bytes(), expected) {
+		t.Errorf("unexpected command: % x", port.written.Bytes())
+	}
+}
+
+func TestMHZ19_Read(t *testing.T) {
+	port := newScriptedPort(
+		// a corrupted reading is skipped
+		0xff, 0x86, 0x01, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xff, 0x86, 0x01, 0x90, 0x00, 0x00, 0x00, 0x00, 0xe9,
+	)
+	sensor := NewMHZ19(port, time.Millisecond)
+	defer sensor.Close()
+
+	select {
+	case ppm := <-sensor.Read():
+		if ppm != 400 {
+			t.Errorf("unexpected concentration: %g", ppm)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reading")
+	}
+}
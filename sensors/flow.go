@@ -0,0 +1,113 @@
+package sensors
+
+import (
+	"sync"
+	"time"
+)
+
+// FlowMeter is a sensor made for reading the flow of water
+type FlowMeter interface {
+	// Read returns a channel on which
+	// sensor data can be read from
+	Read() <-chan Flow
+
+	// Frequency returns the frequency at
+	// which  this sensor is reading values
+	Frequency() time.Duration
+
+	// Close the underlying connection
+	// to the sensor. Read will no longer
+	// be a valid channel
+	Close() error
+}
+
+// Flow is the value of FlowMeter data
+type Flow struct {
+	// Rate is the flow in liters per minute
+	Rate float64
+	// Total is the volume in liters that has
+	// flowed since the FlowMeter was opened
+	Total float64
+}
+
+// PulseSource reports the pulses of a pulse-counting
+// meter, such as the edges of a GPIO line
+type PulseSource interface {
+	// Pulses returns a channel on which the time
+	// of every pulse is sent. The channel is
+	// closed when the PulseSource is closed.
+	Pulses() <-chan time.Time
+
+	// Close the underlying connection to the source
+	Close() error
+}
+
+type pulseFlowMeter struct {
+	source         PulseSource
+	pulsesPerLiter float64
+	frq            time.Duration
+	closed         chan struct{}
+	closeOnce      *sync.Once
+}
+
+// NewPulseFlowMeter creates a FlowMeter counting pulses of a
+// hall-effect meter that gives pulsesPerLiter pulses for every liter
+func NewPulseFlowMeter(source PulseSource, pulsesPerLiter float64, frq time.Duration) FlowMeter {
+	return &pulseFlowMeter{
+		source:         source,
+		pulsesPerLiter: pulsesPerLiter,
+		frq:            frq,
+		closed:         make(chan struct{}),
+		closeOnce:      &sync.Once{},
+	}
+}
+
+func (m *pulseFlowMeter) Read() <-chan Flow {
+	results := make(chan Flow)
+	go func() {
+		defer close(results)
+
+		pulses := m.source.Pulses()
+		ticker := time.NewTicker(m.frq)
+		defer ticker.Stop()
+
+		var count, total uint64
+		last := time.Now()
+		for {
+			select {
+			case <-m.closed:
+				return
+			case _, ok := <-pulses:
+				if !ok {
+					return
+				}
+				count++
+			case now := <-ticker.C:
+				total += count
+				flow := Flow{
+					Rate:  float64(count) / m.pulsesPerLiter / now.Sub(last).Minutes(),
+					Total: float64(total) / m.pulsesPerLiter,
+				}
+				count = 0
+				last = now
+				select {
+				case results <- flow:
+				case <-m.closed:
+					return
+				}
+			}
+		}
+	}()
+	return results
+}
+
+func (m *pulseFlowMeter) Frequency() time.Duration {
+	return m.frq
+}
+
+func (m *pulseFlowMeter) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
+	return m.source.Close()
+}
@@ -0,0 +1,79 @@
+package sensors
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	fakeFlowMeterPulsesPerLiter = 450
+	fakeFlowMeterMin            = 1.5
+	fakeFlowMeterMax            = 2.5
+)
+
+// FakePulseSource is a PulseSource whose pulses are sent by hand
+type FakePulseSource struct {
+	pulses    chan time.Time
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewFakePulseSource creates a PulseSource that only pulses when Pulse is called
+func NewFakePulseSource() *FakePulseSource {
+	return &FakePulseSource{
+		pulses:    make(chan time.Time),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// Pulse sends n pulses, blocking until each has been received
+func (s *FakePulseSource) Pulse(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case s.pulses <- time.Now():
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *FakePulseSource) Pulses() <-chan time.Time {
+	return s.pulses
+}
+
+func (s *FakePulseSource) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
+
+type fakeFlowMeter struct {
+	FlowMeter
+	source *FakePulseSource
+}
+
+// NewFakeFlowMeter creates a FlowMeter reading a random flow
+func NewFakeFlowMeter(frq time.Duration) FlowMeter {
+	source := NewFakePulseSource()
+	fake := &fakeFlowMeter{
+		FlowMeter: NewPulseFlowMeter(source, fakeFlowMeterPulsesPerLiter, frq),
+		source:    source,
+	}
+	go fake.pulse(frq)
+	return fake
+}
+
+// pulse sends pulses for a random flow every frq until closed
+func (f *fakeFlowMeter) pulse(frq time.Duration) {
+	for {
+		select {
+		case <-f.source.closed:
+			return
+		case <-time.After(frq):
+			rate := theRand.Float64()*(fakeFlowMeterMax-fakeFlowMeterMin) + fakeFlowMeterMin
+			f.source.Pulse(int(rate * frq.Minutes() * fakeFlowMeterPulsesPerLiter))
+		}
+	}
+}
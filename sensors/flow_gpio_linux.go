@@ -0,0 +1,99 @@
+//go:build linux
+// +build linux
+
+package sensors
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// from linux/gpio.h
+	gpioHandleRequestInput     = 1 << 0
+	gpioEventRequestRisingEdge = 1 << 0
+	gpioGetLineEventIoctl      = 0xc030b404
+	gpioEventDataSize          = 16
+	gpioConsumerLabel          = "greenhouse-flow"
+	gpioConsumerLabelMaxLength = 32
+)
+
+// gpioEventRequest is struct gpioevent_request from linux/gpio.h
+type gpioEventRequest struct {
+	lineOffset    uint32
+	handleFlags   uint32
+	eventFlags    uint32
+	consumerLabel [gpioConsumerLabelMaxLength]byte
+	fd            int32
+}
+
+type gpioPulseSource struct {
+	events    *os.File
+	pulses    chan time.Time
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewGPIOPulseSource creates a PulseSource sending a pulse for every
+// rising edge of a line of a GPIO character device, such as /dev/gpiochip0
+func NewGPIOPulseSource(chip string, line int) (PulseSource, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening gpio chip %s: %v", chip, err)
+	}
+	defer f.Close()
+
+	req := gpioEventRequest{
+		lineOffset:  uint32(line),
+		handleFlags: gpioHandleRequestInput,
+		eventFlags:  gpioEventRequestRisingEdge,
+	}
+	copy(req.consumerLabel[:], gpioConsumerLabel)
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), gpioGetLineEventIoctl, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return nil, fmt.Errorf("error requesting events of %s line %d: %v", chip, line, errno)
+	}
+
+	source := &gpioPulseSource{
+		events:    os.NewFile(uintptr(req.fd), fmt.Sprintf("%s:%d", chip, line)),
+		pulses:    make(chan time.Time),
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+	go source.read()
+	return source, nil
+}
+
+// read sends a pulse for every event until the line is closed
+func (s *gpioPulseSource) read() {
+	defer close(s.pulses)
+
+	buf := make([]byte, gpioEventDataSize)
+	for {
+		// every read blocks until the next gpioevent_data
+		if _, err := s.events.Read(buf); err != nil {
+			return
+		}
+		select {
+		case s.pulses <- time.Now():
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+func (s *gpioPulseSource) Pulses() <-chan time.Time {
+	return s.pulses
+}
+
+func (s *gpioPulseSource) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		err = s.events.Close()
+	})
+	return err
+}
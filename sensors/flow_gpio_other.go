@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package sensors
+
+import "fmt"
+
+// NewGPIOPulseSource creates a PulseSource sending a pulse for every
+// rising edge of a line of a GPIO character device, such as /dev/gpiochip0
+func NewGPIOPulseSource(chip string, line int) (PulseSource, error) {
+	return nil, fmt.Errorf("gpio character devices are only supported on linux")
+}
@@ -0,0 +1,53 @@
+package sensors
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPulseFlowMeter_Read(t *testing.T) {
+	source := NewFakePulseSource()
+	meter := NewPulseFlowMeter(source, 450, 20*time.Millisecond)
+	defer meter.Close()
+
+	flows := meter.Read()
+	go source.Pulse(45)
+
+	timeout := time.After(5 * time.Second)
+	var rated bool
+	for {
+		select {
+		case flow := <-flows:
+			if flow.Rate > 0 {
+				rated = true
+			}
+			if flow.Total > 0.1+1e-9 {
+				t.Fatalf("unexpected total: %g", flow.Total)
+			}
+			if flow.Total > 0.1-1e-9 {
+				if !rated {
+					t.Error("flow was never reported")
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for pulses to be counted")
+		}
+	}
+}
+
+func TestPulseFlowMeter_Close(t *testing.T) {
+	source := NewFakePulseSource()
+	meter := NewPulseFlowMeter(source, 450, time.Millisecond)
+	flows := meter.Read()
+
+	if err := meter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a second close must not panic
+	if err := meter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range flows {
+	}
+}
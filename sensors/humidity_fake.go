@@ -3,6 +3,9 @@ package sensors
 import (
 	"log"
 	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/simulation"
 )
 
 const (
@@ -13,19 +16,39 @@ const (
 
 type fakeHygrometer struct {
 	frq    time.Duration
+	clock  clock.Clock
 	closed chan struct{}
+	// greenhouse is read from if set
+	greenhouse *simulation.Greenhouse
 }
 
-func NewFakeHygrometer(frq time.Duration) Hygrometer {
+func NewFakeHygrometer(frq time.Duration, c clock.Clock) Hygrometer {
 	fake := &fakeHygrometer{
 		frq:    frq,
+		clock:  c,
 		closed: make(chan struct{}),
 	}
 	return fake
 }
 
+// NewSimulatedHygrometer creates a Hygrometer reading the relative humidity of a simulated greenhouse
+func NewSimulatedHygrometer(greenhouse *simulation.Greenhouse, frq time.Duration, c clock.Clock) Hygrometer {
+	fake := &fakeHygrometer{
+		frq:        frq,
+		clock:      c,
+		closed:     make(chan struct{}),
+		greenhouse: greenhouse,
+	}
+	return fake
+}
+
 func (f *fakeHygrometer) nextValue() Humidity {
-	hum := Humidity(theRand.Float64()*(fakeHygrometerMax-fakeHygrometerMin) + fakeHygrometerMin)
+	var hum Humidity
+	if f.greenhouse != nil {
+		hum = Humidity(f.greenhouse.Humidity())
+	} else {
+		hum = Humidity(theRand.Float64()*(fakeHygrometerMax-fakeHygrometerMin) + fakeHygrometerMin)
+	}
 	log.Printf("humidity: %g", hum)
 	return hum
 }
@@ -38,7 +61,7 @@ func (f *fakeHygrometer) Read() <-chan Humidity {
 			select {
 			case <-f.closed:
 				return
-			case <-time.After(f.frq):
+			case <-f.clock.After(f.frq):
 				results <- f.nextValue()
 			}
 		}
@@ -0,0 +1,13 @@
+package sensors
+
+// I2CDevice is a device at a fixed address on an I2C bus
+type I2CDevice interface {
+	// Write writes bytes to the device
+	Write(b []byte) error
+
+	// Read reads len(b) bytes from the device
+	Read(b []byte) error
+
+	// Close the underlying connection to the bus
+	Close() error
+}
@@ -0,0 +1,48 @@
+//go:build linux
+// +build linux
+
+package sensors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+const (
+	// from linux/i2c-dev.h
+	i2cSlaveIoctl = 0x0703
+)
+
+type i2cDevice struct {
+	bus *os.File
+}
+
+// NewI2CDevice opens the device at an address
+// of an I2C bus, such as /dev/i2c-1
+func NewI2CDevice(bus string, addr uint16) (I2CDevice, error) {
+	f, err := os.OpenFile(bus, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening i2c bus %s: %v", bus, err)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlaveIoctl, uintptr(addr)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("error selecting i2c address %#x on %s: %v", addr, bus, errno)
+	}
+	return &i2cDevice{bus: f}, nil
+}
+
+func (d *i2cDevice) Write(b []byte) error {
+	_, err := d.bus.Write(b)
+	return err
+}
+
+func (d *i2cDevice) Read(b []byte) error {
+	_, err := io.ReadFull(d.bus, b)
+	return err
+}
+
+func (d *i2cDevice) Close() error {
+	return d.bus.Close()
+}
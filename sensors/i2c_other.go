@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package sensors
+
+import "fmt"
+
+// NewI2CDevice opens the device at an address
+// of an I2C bus, such as /dev/i2c-1
+func NewI2CDevice(bus string, addr uint16) (I2CDevice, error) {
+	return nil, fmt.Errorf("i2c buses are only supported on linux")
+}
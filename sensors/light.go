@@ -0,0 +1,23 @@
+package sensors
+
+import "time"
+
+// LightSensor is a sensor made for reading illuminance data
+type LightSensor interface {
+	// Read returns a channel on which
+	// sensor data can be read from
+	Read() <-chan Lux
+
+	// Frequency returns the frequency at
+	// which  this sensor is reading values
+	Frequency() time.Duration
+
+	// Close the underlying connection
+	// to the sensor. Read will no longer
+	// be a valid channel
+	Close() error
+}
+
+// Lux is the value of LightSensor data
+// represented as illuminance in lux
+type Lux float64
@@ -0,0 +1,97 @@
+package sensors
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// BH1750Address is the I2C address of a BH1750 with its ADDR pin low
+	BH1750Address = 0x23
+	// BH1750AddressHigh is the I2C address of a BH1750 with its ADDR pin high
+	BH1750AddressHigh = 0x5c
+
+	bh1750PowerOn           = 0x01
+	bh1750ContinuousHighRes = 0x10
+	bh1750MeasurementTime   = 180 * time.Millisecond
+	// bh1750CountsPerLux is the measurement accuracy in high resolution mode
+	bh1750CountsPerLux = 1.2
+)
+
+type bh1750 struct {
+	device    I2CDevice
+	frq       time.Duration
+	closed    chan struct{}
+	closeOnce *sync.Once
+}
+
+// NewBH1750 creates a LightSensor reading a BH1750 ambient light
+// sensor, putting it in continuous high resolution mode
+func NewBH1750(device I2CDevice, frq time.Duration) (LightSensor, error) {
+	if err := device.Write([]byte{bh1750PowerOn}); err != nil {
+		return nil, fmt.Errorf("error powering on bh1750: %v", err)
+	}
+	if err := device.Write([]byte{bh1750ContinuousHighRes}); err != nil {
+		return nil, fmt.Errorf("error setting bh1750 mode: %v", err)
+	}
+	return &bh1750{
+		device:    device,
+		frq:       frq,
+		closed:    make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}, nil
+}
+
+// measure reads the latest measurement of the sensor
+func (s *bh1750) measure() (Lux, error) {
+	buf := make([]byte, 2)
+	if err := s.device.Read(buf); err != nil {
+		return 0, fmt.Errorf("error reading bh1750: %v", err)
+	}
+	raw := uint16(buf[0])<<8 | uint16(buf[1])
+	return Lux(float64(raw) / bh1750CountsPerLux), nil
+}
+
+func (s *bh1750) Read() <-chan Lux {
+	results := make(chan Lux)
+	go func() {
+		defer close(results)
+		// wait for the first measurement to complete
+		select {
+		case <-s.closed:
+			return
+		case <-time.After(bh1750MeasurementTime):
+		}
+		for {
+			select {
+			case <-s.closed:
+				return
+			case <-time.After(s.frq):
+				lux, err := s.measure()
+				if err != nil {
+					log.Printf("light: %v", err)
+					continue
+				}
+				select {
+				case results <- lux:
+				case <-s.closed:
+					return
+				}
+			}
+		}
+	}()
+	return results
+}
+
+func (s *bh1750) Frequency() time.Duration {
+	return s.frq
+}
+
+func (s *bh1750) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return s.device.Close()
+}
@@ -0,0 +1,85 @@
+package sensors
+
+import (
+	"log"
+	"math"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/simulation"
+)
+
+const (
+	// fakeLightSensorMax is the illuminance at noon on a bright day
+	fakeLightSensorMax   = 60000
+	fakeLightSensorNoise = 0.1
+)
+
+type fakeLightSensor struct {
+	frq    time.Duration
+	clock  clock.Clock
+	closed chan struct{}
+	// greenhouse is read from if set
+	greenhouse *simulation.Greenhouse
+}
+
+// NewFakeLightSensor creates a LightSensor reading daylight
+// that rises at 6am, peaks at noon and sets at 6pm
+func NewFakeLightSensor(frq time.Duration, c clock.Clock) LightSensor {
+	fake := &fakeLightSensor{
+		frq:    frq,
+		clock:  c,
+		closed: make(chan struct{}),
+	}
+	return fake
+}
+
+// NewSimulatedLightSensor creates a LightSensor reading the daylight of a simulated greenhouse
+func NewSimulatedLightSensor(greenhouse *simulation.Greenhouse, frq time.Duration, c clock.Clock) LightSensor {
+	fake := &fakeLightSensor{
+		frq:        frq,
+		clock:      c,
+		closed:     make(chan struct{}),
+		greenhouse: greenhouse,
+	}
+	return fake
+}
+
+func (f *fakeLightSensor) nextValue(now time.Time) Lux {
+	if f.greenhouse != nil {
+		lux := Lux(f.greenhouse.Light())
+		log.Printf("light: %g", lux)
+		return lux
+	}
+	hours := float64(now.Hour()) + float64(now.Minute())/60
+	daylight := math.Max(0, math.Sin(math.Pi*(hours-6)/12))
+	noise := 1 + (theRand.Float64()*2-1)*fakeLightSensorNoise
+	lux := Lux(daylight * fakeLightSensorMax * noise)
+	log.Printf("light: %g", lux)
+	return lux
+}
+
+func (f *fakeLightSensor) Read() <-chan Lux {
+	results := make(chan Lux)
+	go func() {
+		defer close(results)
+		for {
+			select {
+			case <-f.closed:
+				return
+			case now := <-f.clock.After(f.frq):
+				results <- f.nextValue(now)
+			}
+		}
+	}()
+	return results
+}
+
+func (f *fakeLightSensor) Frequency() time.Duration {
+	return f.frq
+}
+
+func (f *fakeLightSensor) Close() error {
+	close(f.closed)
+	return nil
+}
@@ -0,0 +1,90 @@
+package sensors
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeI2CDevice struct {
+	written [][]byte
+	reading []byte
+	closed  bool
+}
+
+func (d *fakeI2CDevice) Write(b []byte) error {
+	d.written = append(d.written, append([]byte(nil), b...))
+	return nil
+}
+
+func (d *fakeI2CDevice) Read(b []byte) error {
+	if len(d.reading) < len(b) {
+		return errors.New("nothing to read")
+	}
+	copy(b, d.reading)
+	return nil
+}
+
+func (d *fakeI2CDevice) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestBH1750_Init(t *testing.T) {
+	device := &fakeI2CDevice{}
+	if _, err := NewBH1750(device, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{{bh1750PowerOn}, {bh1750ContinuousHighRes}}
+	if len(device.written) != len(expected) {
+		t.Fatalf("unexpected commands: %v", device.written)
+	}
+	for i := range expected {
+		if !bytes.Equal(device.written[i], expected[i]) {
+			t.Errorf("unexpected command %d: %v", i, device.written[i])
+		}
+	}
+}
+
+func TestBH1750_Measure(t *testing.T) {
+	// 0x01 0x2c is 300 counts, 250 lux
+	device := &fakeI2CDevice{reading: []byte{0x01, 0x2c}}
+	sensor, err := NewBH1750(device, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lux, err := sensor.(*bh1750).measure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lux != 250 {
+		t.Errorf("unexpected illuminance: %g", lux)
+	}
+
+	if err := sensor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !device.closed {
+		t.Error("device was not closed")
+	}
+}
+
+func TestBH1750_Read(t *testing.T) {
+	device := &fakeI2CDevice{reading: []byte{0x00, 0x0c}}
+	sensor, err := NewBH1750(device, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sensor.Close()
+
+	select {
+	case lux := <-sensor.Read():
+		if lux != 10 {
+			t.Errorf("unexpected illuminance: %g", lux)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reading")
+	}
+}
@@ -2,6 +2,7 @@ package sensors
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -10,5 +11,27 @@ var (
 )
 
 func init() {
-	theRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	theRand = rand.New(&lockedSource{
+		mu:     &sync.Mutex{},
+		source: rand.NewSource(time.Now().UnixNano()),
+	})
+}
+
+// lockedSource is a rand.Source that the fake
+// sensors can read from at the same time
+type lockedSource struct {
+	mu     *sync.Mutex
+	source rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source.Seed(seed)
 }
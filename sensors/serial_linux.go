@@ -0,0 +1,63 @@
+//go:build linux
+// +build linux
+
+package sensors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// from asm-generic/termbits.h, missing from syscall
+	serialCBaud = 0x100f
+)
+
+var (
+	serialBauds = map[int]uint32{
+		9600:   syscall.B9600,
+		19200:  syscall.B19200,
+		38400:  syscall.B38400,
+		57600:  syscall.B57600,
+		115200: syscall.B115200,
+	}
+)
+
+// OpenSerialPort opens a serial port, such as /dev/serial0, in raw 8N1
+// mode at a baud rate. Reads fail if nothing is received within timeout.
+func OpenSerialPort(path string, baud int, timeout time.Duration) (io.ReadWriteCloser, error) {
+	speed, ok := serialBauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate: %d", baud)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening serial port %s: %v", path, err)
+	}
+
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("error reading serial port settings of %s: %v", path, errno)
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | serialCBaud
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	t.Ispeed = speed
+	t.Ospeed = speed
+	// return whatever has been received once the timeout passes
+	t.Cc[syscall.VMIN] = 0
+	t.Cc[syscall.VTIME] = uint8(timeout / (100 * time.Millisecond))
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("error configuring serial port %s: %v", path, errno)
+	}
+	return f, nil
+}
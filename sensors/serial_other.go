@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package sensors
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// OpenSerialPort opens a serial port, such as /dev/serial0, in raw 8N1
+// mode at a baud rate. Reads fail if nothing is received within timeout.
+func OpenSerialPort(path string, baud int, timeout time.Duration) (io.ReadWriteCloser, error) {
+	return nil, fmt.Errorf("serial ports are only supported on linux")
+}
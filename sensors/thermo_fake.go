@@ -3,6 +3,9 @@ package sensors
 import (
 	"log"
 	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/simulation"
 )
 
 const (
@@ -12,19 +15,39 @@ const (
 
 type fakeThermometer struct {
 	frq    time.Duration
+	clock  clock.Clock
 	closed chan struct{}
+	// greenhouse is read from if set
+	greenhouse *simulation.Greenhouse
 }
 
-func NewFakeThermometer(frq time.Duration) Thermometer {
+func NewFakeThermometer(frq time.Duration, c clock.Clock) Thermometer {
 	fake := &fakeThermometer{
 		frq:    frq,
+		clock:  c,
 		closed: make(chan struct{}),
 	}
 	return fake
 }
 
+// NewSimulatedThermometer creates a Thermometer reading the air temperature of a simulated greenhouse
+func NewSimulatedThermometer(greenhouse *simulation.Greenhouse, frq time.Duration, c clock.Clock) Thermometer {
+	fake := &fakeThermometer{
+		frq:        frq,
+		clock:      c,
+		closed:     make(chan struct{}),
+		greenhouse: greenhouse,
+	}
+	return fake
+}
+
 func (f *fakeThermometer) nextValue() Temperature {
-	temp := Temperature(theRand.Float64()*(fakeThermometerMax-fakeThermometerMin) + fakeThermometerMin)
+	var temp Temperature
+	if f.greenhouse != nil {
+		temp = Temperature(f.greenhouse.Temperature())
+	} else {
+		temp = Temperature(theRand.Float64()*(fakeThermometerMax-fakeThermometerMin) + fakeThermometerMin)
+	}
 	log.Printf("temp: %g", temp)
 	return temp
 }
@@ -37,7 +60,7 @@ func (f *fakeThermometer) Read() <-chan Temperature {
 			select {
 			case <-f.closed:
 				return
-			case <-time.After(f.frq):
+			case <-f.clock.After(f.frq):
 				results <- f.nextValue()
 			}
 		}
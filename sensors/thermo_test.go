@@ -1,6 +1,12 @@
 package sensors
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/simulation"
+)
 
 func TestTemperature_Celsius(t *testing.T) {
 	temp := Temperature(0)
@@ -24,3 +30,14 @@ func TestTemperature_Fahrenheit(t *testing.T) {
 		}
 	}
 }
+
+func TestSimulatedThermometer_Read(t *testing.T) {
+	noon := time.Date(2018, time.April, 21, 12, 0, 0, 0, time.Local)
+	greenhouse := simulation.NewGreenhouse(simulation.DefaultParams, clock.NewManual(noon))
+	thermometer := NewSimulatedThermometer(greenhouse, time.Millisecond, clock.Real)
+	defer thermometer.Close()
+
+	if temp := <-thermometer.Read(); temp.Celsius() != greenhouse.Temperature() {
+		t.Errorf("expected %g, got %g", greenhouse.Temperature(), temp.Celsius())
+	}
+}
@@ -0,0 +1,226 @@
+// Package simulation models the climate of a greenhouse, so that fake
+// sensors read plausible values that respond to the units acting on it
+package simulation
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+const (
+	// maxStep is the longest simulated time the model is integrated over at once
+	maxStep = time.Minute
+	// peakLux is the illuminance at noon on a bright day
+	peakLux = 60000
+)
+
+// Params describe a greenhouse and its surroundings. Rates are per hour.
+type Params struct {
+	// OutdoorTemperature is the mean outdoor temperature in celsius
+	OutdoorTemperature float64
+	// OutdoorSwing is how far the outdoor temperature rises above its mean
+	// in the afternoon and falls below it before dawn, in celsius
+	OutdoorSwing float64
+	// OutdoorHumidity is the relative humidity of outdoor air in percent
+	OutdoorHumidity float64
+
+	// Leakage is the fraction of the difference to outdoor air made
+	// up each hour with the fan off, from the thermal mass of the
+	// greenhouse and how well it is sealed
+	Leakage float64
+	// FanExchange is the fraction of the difference to outdoor air
+	// made up each hour by the fan at full speed
+	FanExchange float64
+	// SolarGain is how fast the sun at noon warms the greenhouse, in celsius
+	SolarGain float64
+
+	// Watering is the soil moisture added while watering, in percent
+	Watering float64
+	// Drying is the fraction of soil moisture lost with no sun
+	Drying float64
+	// Misting is the fraction of the vapor deficit of the air made up
+	// while watering, from the water evaporating off of leaves and soil
+	Misting float64
+	// Evaporation is the fraction of the vapor deficit of the air
+	// made up from saturated soil
+	Evaporation float64
+}
+
+var (
+	// DefaultParams are a small hobby greenhouse in a temperate spring
+	DefaultParams = Params{
+		OutdoorTemperature: 14,
+		OutdoorSwing:       6,
+		OutdoorHumidity:    65,
+		Leakage:            0.3,
+		FanExchange:        4,
+		SolarGain:          4,
+		Watering:           120,
+		Drying:             0.01,
+		Misting:            3,
+		Evaporation:        0.5,
+	}
+)
+
+// Greenhouse is a simulated greenhouse following a clock, which should
+// be shared with the sensors reading it so their readings are stamped
+// with simulated time. With a Manual clock the greenhouse only changes
+// when the clock is advanced, so a day can be simulated in no time.
+type Greenhouse struct {
+	Params Params
+
+	mu    *sync.Mutex
+	clock clock.Clock
+	// simulated is the time up to which the model has been integrated
+	simulated time.Time
+
+	temperature float64
+	// vapor is the vapor pressure of the air in kPa
+	vapor float64
+	soil  float64
+	fan   float64
+	water bool
+}
+
+// NewGreenhouse creates a greenhouse following clk, starting
+// at the current time in equilibrium with the outdoors
+func NewGreenhouse(params Params, clk clock.Clock) *Greenhouse {
+	start := clk.Now()
+	g := &Greenhouse{
+		Params:    params,
+		mu:        &sync.Mutex{},
+		clock:     clk,
+		simulated: start,
+		soil:      50,
+	}
+	g.temperature = g.outdoorTemperature(start)
+	g.vapor = saturationVaporPressure(g.temperature) * params.OutdoorHumidity / 100
+	return g
+}
+
+// saturationVaporPressure returns the saturation vapor pressure
+// in kPa of air at a temperature in celsius, by Tetens' equation
+func saturationVaporPressure(t float64) float64 {
+	return 0.6108 * math.Exp(17.27*t/(t+237.3))
+}
+
+// hours returns the hour of the day of t, with fractions
+func hours(t time.Time) float64 {
+	return float64(t.Hour()) + float64(t.Minute())/60 + float64(t.Second())/3600
+}
+
+// daylight returns the strength of the sun at t, from 0 at night to 1 at
+// noon, rising at 6am and setting at 6pm
+func daylight(t time.Time) float64 {
+	return math.Max(0, math.Sin(math.Pi*(hours(t)-6)/12))
+}
+
+// outdoorTemperature returns the outdoor temperature at t,
+// coldest at 3am and warmest at 3pm
+func (g *Greenhouse) outdoorTemperature(t time.Time) float64 {
+	return g.Params.OutdoorTemperature + g.Params.OutdoorSwing*math.Sin(2*math.Pi*(hours(t)-9)/24)
+}
+
+// sync integrates the model up to the time of its clock
+func (g *Greenhouse) sync() {
+	now := g.clock.Now()
+	for g.simulated.Before(now) {
+		dt := now.Sub(g.simulated)
+		if dt > maxStep {
+			dt = maxStep
+		}
+		g.step(g.simulated, dt.Hours())
+		g.simulated = g.simulated.Add(dt)
+	}
+}
+
+// step integrates the model over dt hours from t
+func (g *Greenhouse) step(t time.Time, dt float64) {
+	p := g.Params
+	sun := daylight(t)
+	outdoor := g.outdoorTemperature(t)
+	exchange := p.Leakage + p.FanExchange*g.fan
+	saturation := saturationVaporPressure(g.temperature)
+
+	var watering float64
+	if g.water {
+		watering = 1
+	}
+
+	// the sun warms the greenhouse while air exchange pulls it
+	// toward outdoors, more so with the fan running
+	g.temperature += (exchange*(outdoor-g.temperature) + p.SolarGain*sun) * dt
+
+	// moisture evaporates from the soil and water, drying the soil
+	// faster in the sun, while air exchange brings in outdoor air
+	outdoorVapor := saturationVaporPressure(outdoor) * p.OutdoorHumidity / 100
+	deficit := math.Max(0, saturation-g.vapor)
+	evaporation := p.Evaporation*g.soil/100 + p.Misting*watering
+	g.vapor += (exchange*(outdoorVapor-g.vapor) + evaporation*deficit) * dt
+
+	drying := p.Drying * g.soil * (1 + 2*sun)
+	g.soil += (p.Watering*watering*(100-g.soil)/100 - drying) * dt
+
+	// any more moisture than the air can hold condenses out
+	g.vapor = math.Min(g.vapor, saturationVaporPressure(g.temperature))
+	g.soil = math.Max(0, math.Min(100, g.soil))
+}
+
+// Temperature returns the air temperature in celsius
+func (g *Greenhouse) Temperature() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	return g.temperature
+}
+
+// Humidity returns the relative humidity of the air in percent
+func (g *Greenhouse) Humidity() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	return 100 * g.vapor / saturationVaporPressure(g.temperature)
+}
+
+// SoilMoisture returns the moisture of the soil in percent
+func (g *Greenhouse) SoilMoisture() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	return g.soil
+}
+
+// Light returns the illuminance in lux
+func (g *Greenhouse) Light() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	return peakLux * daylight(g.simulated)
+}
+
+// OutdoorTemperature returns the outdoor temperature in celsius
+func (g *Greenhouse) OutdoorTemperature() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	return g.outdoorTemperature(g.simulated)
+}
+
+// SetFan sets the speed of the fan, from 0 for off up to 1 for full speed
+func (g *Greenhouse) SetFan(level float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	g.fan = math.Max(0, math.Min(1, level))
+}
+
+// SetWater turns the water on or off
+func (g *Greenhouse) SetWater(on bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.sync()
+	g.water = on
+}
@@ -0,0 +1,139 @@
+package simulation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+var (
+	// simMidnight is the start of a day in spring
+	simMidnight = time.Date(2018, time.April, 21, 0, 0, 0, 0, time.Local)
+)
+
+func greenhouseTest(f func(t *testing.T, g *Greenhouse, clk *clock.Manual)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+		clk := clock.NewManual(simMidnight)
+		f(t, NewGreenhouse(DefaultParams, clk), clk)
+	}
+	return name, testFunc
+}
+
+func TestGreenhouse(t *testing.T) {
+	t.Parallel()
+	t.Run("Greenhouse", func(t *testing.T) {
+		t.Parallel()
+		t.Run(greenhouseTest(greenhouse_StartsInEquilibrium))
+		t.Run(greenhouseTest(greenhouse_Advance))
+		t.Run(greenhouseTest(greenhouse_WarmsInTheAfternoon))
+		t.Run(greenhouseTest(greenhouse_FanCools))
+		t.Run(greenhouseTest(greenhouse_WateringRaisesHumidity))
+		t.Run(greenhouseTest(greenhouse_SoilDries))
+		t.Run(greenhouseTest(greenhouse_StaysPlausible))
+	})
+}
+
+func greenhouse_StartsInEquilibrium(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	if g.Temperature() != g.OutdoorTemperature() {
+		t.Errorf("expected outdoor temperature %g, got %g", g.OutdoorTemperature(), g.Temperature())
+	}
+	if math.Abs(g.Humidity()-DefaultParams.OutdoorHumidity) > 1e-9 {
+		t.Errorf("expected outdoor humidity %g, got %g", DefaultParams.OutdoorHumidity, g.Humidity())
+	}
+	if g.Light() != 0 {
+		t.Errorf("unexpected light at midnight: %g", g.Light())
+	}
+}
+
+func greenhouse_Advance(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	// the greenhouse only changes as its clock moves
+	temp := g.Temperature()
+	if g.Temperature() != temp {
+		t.Fatal("greenhouse changed while its clock was stopped")
+	}
+	clk.Advance(12 * time.Hour)
+	if g.Temperature() == temp {
+		t.Error("greenhouse did not follow its clock")
+	}
+	if g.Light() != peakLux {
+		t.Errorf("expected peak light at noon, got %g", g.Light())
+	}
+}
+
+func greenhouse_WarmsInTheAfternoon(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	clk.Advance(5 * time.Hour)
+	dawn := g.Temperature()
+	clk.Advance(9 * time.Hour)
+	afternoon := g.Temperature()
+	if afternoon < dawn+10 {
+		t.Errorf("expected afternoon to be warmer than %g, got %g", dawn, afternoon)
+	}
+	if afternoon < g.OutdoorTemperature()+5 {
+		t.Errorf("expected afternoon to be warmer than outdoors %g, got %g", g.OutdoorTemperature(), afternoon)
+	}
+}
+
+func greenhouse_FanCools(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	ventilated := NewGreenhouse(DefaultParams, clk)
+	clk.Advance(11 * time.Hour)
+
+	ventilated.SetFan(1)
+	clk.Advance(2 * time.Hour)
+
+	if ventilated.Temperature() > g.Temperature()-5 {
+		t.Errorf("expected fan to cool from %g, got %g", g.Temperature(), ventilated.Temperature())
+	}
+	if ventilated.Temperature() < ventilated.OutdoorTemperature() {
+		t.Errorf("fan cooled below outdoors %g: %g", ventilated.OutdoorTemperature(), ventilated.Temperature())
+	}
+}
+
+func greenhouse_WateringRaisesHumidity(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	watered := NewGreenhouse(DefaultParams, clk)
+	clk.Advance(10 * time.Hour)
+
+	watered.SetWater(true)
+	clk.Advance(15 * time.Minute)
+	watered.SetWater(false)
+
+	if watered.SoilMoisture() < g.SoilMoisture()+10 {
+		t.Errorf("expected watering to wet the soil from %g, got %g", g.SoilMoisture(), watered.SoilMoisture())
+	}
+	if watered.Humidity() < g.Humidity()+10 {
+		t.Errorf("expected watering to raise humidity from %g, got %g", g.Humidity(), watered.Humidity())
+	}
+
+	// and the soil keeps the air more humid afterward
+	clk.Advance(time.Hour)
+	if watered.Humidity() <= g.Humidity() {
+		t.Errorf("expected wet soil to keep humidity above %g, got %g", g.Humidity(), watered.Humidity())
+	}
+}
+
+func greenhouse_SoilDries(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	soil := g.SoilMoisture()
+	clk.Advance(24 * time.Hour)
+	if g.SoilMoisture() >= soil {
+		t.Errorf("expected soil to dry from %g, got %g", soil, g.SoilMoisture())
+	}
+}
+
+func greenhouse_StaysPlausible(t *testing.T, g *Greenhouse, clk *clock.Manual) {
+	g.SetWater(true)
+	for i := 0; i < 48; i++ {
+		clk.Advance(time.Hour)
+		if h := g.Humidity(); h < 0 || h > 100 {
+			t.Fatalf("implausible humidity at %s: %g", clk.Now(), h)
+		}
+		if s := g.SoilMoisture(); s < 0 || s > 100 {
+			t.Fatalf("implausible soil moisture at %s: %g", clk.Now(), s)
+		}
+		if temp := g.Temperature(); temp < -20 || temp > 60 {
+			t.Fatalf("implausible temperature at %s: %g", clk.Now(), temp)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidBucket indicates that a bucket size is
+	// not a positive whole number of seconds
+	ErrInvalidBucket = errors.New("bucket must be a positive whole number of seconds")
+)
+
+// Aggregate summarizes the stats of a type in a bucket of time
+type Aggregate struct {
+	// Start is the beginning of the bucket
+	Start time.Time
+	Min   float64
+	Max   float64
+	Avg   float64
+	Count int
+}
+
+// Aggregator is a Storage that can summarize stats itself,
+// without reading every one of them
+type Aggregator interface {
+	// Aggregate summarizes stats like AggregateStats
+	Aggregate(statType StatType, start, end time.Time, bucket time.Duration) ([]Aggregate, error)
+}
+
+// bucketStart returns the beginning of the bucket containing a time.
+// Buckets are aligned to the unix epoch, so daily buckets are UTC days.
+func bucketStart(when time.Time, bucket time.Duration) time.Time {
+	nanos := when.UnixNano()
+	offset := nanos % int64(bucket)
+	if offset < 0 {
+		offset += int64(bucket)
+	}
+	return time.Unix(0, nanos-offset)
+}
+
+// bucketRange returns the beginning of the first bucket and the
+// end of the last bucket of a time frame, excluding that end
+func bucketRange(start, end time.Time, bucket time.Duration) (time.Time, time.Time) {
+	return bucketStart(start, bucket), bucketStart(end, bucket).Add(bucket)
+}
+
+// AggregateStats summarizes the stats of a type in buckets of time, from
+// the bucket containing start to the bucket containing end. Every bucket
+// summarizes all of its stats, even those outside of start and end.
+// Buckets without stats are left out. Storages that are Aggregators
+// summarize the stats themselves.
+func AggregateStats(storage Storage, statType StatType, start, end time.Time, bucket time.Duration) ([]Aggregate, error) {
+	if bucket < time.Second || bucket%time.Second != 0 {
+		return nil, ErrInvalidBucket
+	}
+	if aggregator, ok := storage.(Aggregator); ok {
+		return aggregator.Aggregate(statType, start, end, bucket)
+	}
+
+	from, to := bucketRange(start, end, bucket)
+	results := make([]Aggregate, 0, 100)
+	var sum float64
+	err := storage.EachStat([]StatType{statType}, from, to.Add(-1), func(stat Stat) error {
+		begin := bucketStart(stat.When, bucket)
+		if len(results) == 0 || !results[len(results)-1].Start.Equal(begin) {
+			if len(results) > 0 {
+				results[len(results)-1].Avg = sum / float64(results[len(results)-1].Count)
+			}
+			results = append(results, Aggregate{Start: begin, Min: stat.Value, Max: stat.Value})
+			sum = 0
+		}
+		current := &results[len(results)-1]
+		if stat.Value < current.Min {
+			current.Min = stat.Value
+		}
+		if stat.Value > current.Max {
+			current.Max = stat.Value
+		}
+		current.Count++
+		sum += stat.Value
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) > 0 {
+		results[len(results)-1].Avg = sum / float64(results[len(results)-1].Count)
+	}
+	return results, nil
+}
+
+const (
+	// pgAggregateStats summarizes stats in buckets of $4 seconds
+	pgAggregateStats = `SELECT floor(extract(epoch FROM timestamp) / $4) * $4, MIN(value), MAX(value), SUM(value), COUNT(*) FROM stats WHERE stat = $1 AND timestamp >= $2 AND timestamp < $3 GROUP BY 1 ORDER BY 1`
+
+	// pgAggregateRollup summarizes the aggregates of a table in buckets of $4 seconds
+	pgAggregateRollup = `SELECT floor(extract(epoch FROM bucket) / $4) * $4, MIN(min), MAX(max), SUM(sum), SUM(count) FROM %s WHERE stat = $1 AND bucket >= $2 AND bucket < $3 GROUP BY 1 ORDER BY 1`
+)
+
+// Aggregate summarizes stats from the daily or hourly aggregates when
+// a bucket is made of whole days or hours, or from the stats otherwise.
+// The aggregates are tables kept up to date by triggers as stats are
+// inserted, updated or deleted, rather than materialized views, which
+// would have to be refreshed in full to include the latest stats.
+func (pg *pgStorage) Aggregate(statType StatType, start, end time.Time, bucket time.Duration) ([]Aggregate, error) {
+	if bucket < time.Second || bucket%time.Second != 0 {
+		return nil, ErrInvalidBucket
+	}
+
+	query := pgAggregateStats
+	switch {
+	case bucket%(24*time.Hour) == 0:
+		query = fmt.Sprintf(pgAggregateRollup, "stats_daily")
+	case bucket%time.Hour == 0:
+		query = fmt.Sprintf(pgAggregateRollup, "stats_hourly")
+	}
+
+	from, to := bucketRange(start, end, bucket)
+	rows, err := pg.db.Query(query, statType, from, to, int64(bucket/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("error aggregating stats: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]Aggregate, 0, 100)
+	for rows.Next() {
+		var seconds, sum float64
+		var aggregate Aggregate
+		if err := rows.Scan(&seconds, &aggregate.Min, &aggregate.Max, &sum, &aggregate.Count); err != nil {
+			return nil, fmt.Errorf("error scanning aggregates: %v", err)
+		}
+		aggregate.Start = time.Unix(int64(seconds), 0)
+		aggregate.Avg = sum / float64(aggregate.Count)
+		results = append(results, aggregate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning aggregates: %v", err)
+	}
+	return results, nil
+}
@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func aggregateTest(f func(t *testing.T, s Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage, err := NewSqliteStorage(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.Close()
+
+		f(t, storage)
+	}
+	return name, testFunc
+}
+
+func TestAggregate(t *testing.T) {
+	t.Parallel()
+	t.Run("Aggregate", func(t *testing.T) {
+		t.Parallel()
+		t.Run(aggregateTest(aggregate_Buckets))
+		t.Run(aggregateTest(aggregate_WholeBuckets))
+		t.Run(aggregateTest(aggregate_InvalidBucket))
+	})
+}
+
+func aggregate_Buckets(t *testing.T, s Storage) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.RecordBatch([]Stat{
+		{StatType: StatTypeHumidity, When: day.Add(10 * time.Minute), Value: 40},
+		{StatType: StatTypeHumidity, When: day.Add(50 * time.Minute), Value: 60},
+		{StatType: StatTypeHumidity, When: day.Add(3*time.Hour + time.Minute), Value: 70},
+		{StatType: StatTypeTemperature, When: day.Add(10 * time.Minute), Value: 20},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	aggregates, err := AggregateStats(s, StatTypeHumidity, day, day.Add(23*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	need := []Aggregate{
+		{Start: day, Min: 40, Max: 60, Avg: 50, Count: 2},
+		{Start: day.Add(3 * time.Hour), Min: 70, Max: 70, Avg: 70, Count: 1},
+	}
+	if len(aggregates) != len(need) {
+		t.Fatalf("unexpected aggregates: %v", aggregates)
+	}
+	for i := range need {
+		have := aggregates[i]
+		if !have.Start.Equal(need[i].Start) || have.Min != need[i].Min || have.Max != need[i].Max || have.Avg != need[i].Avg || have.Count != need[i].Count {
+			t.Errorf("unexpected aggregate at %d\nneed: %v\nhave: %v", i, need[i], have)
+		}
+	}
+}
+
+func aggregate_WholeBuckets(t *testing.T, s Storage) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := s.RecordBatch([]Stat{
+		{StatType: StatTypeLight, When: day.Add(time.Hour), Value: 1},
+		{StatType: StatTypeLight, When: day.Add(23 * time.Hour), Value: 3},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket containing start is summarized in full
+	aggregates, err := AggregateStats(s, StatTypeLight, day.Add(12*time.Hour), day.Add(12*time.Hour), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aggregates) != 1 || !aggregates[0].Start.Equal(day) || aggregates[0].Count != 2 || aggregates[0].Avg != 2 {
+		t.Errorf("unexpected aggregates: %v", aggregates)
+	}
+}
+
+func aggregate_InvalidBucket(t *testing.T, s Storage) {
+	for _, bucket := range []time.Duration{0, -time.Hour, 1500 * time.Millisecond} {
+		if _, err := AggregateStats(s, StatTypeLight, time.Now(), time.Now(), bucket); err != ErrInvalidBucket {
+			t.Errorf("%s: unexpected error: %v", bucket, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package stats
+
+import "time"
+
+const (
+	// AuditCauseSchedule is a command given by a scheduled action
+	AuditCauseSchedule = "schedule"
+	// AuditCauseAPI is a command given by an API client
+	AuditCauseAPI = "api"
+	// AuditCausePolicy is a command given by an automatic control policy
+	AuditCausePolicy = "policy"
+	// AuditCauseSafety is a command given by a safety override
+	AuditCauseSafety = "safety"
+)
+
+// AuditEntry records a command given to a unit,
+// what caused it and what came of it
+type AuditEntry struct {
+	When time.Time
+	// Unit is the name of the unit
+	Unit string
+	// Cause is the kind of thing that gave the command, one of the AuditCause constants
+	Cause string
+	// Source identifies what gave the command within its Cause,
+	// such as a schedule id, API client or policy name
+	Source string
+	// Requested is the state the unit was commanded into
+	Requested string
+	// Actual is the state the unit was left in
+	Actual string
+	// Error is why the command failed, if it did
+	Error string
+}
+
+// AuditFilter selects entries of the audit trail.
+// Empty fields match every entry.
+type AuditFilter struct {
+	Start time.Time
+	End   time.Time
+	Unit  string
+	Cause string
+}
+
+// matches returns whether or not an entry is selected by this filter
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return between(entry.When, f.Start, f.End) &&
+		(f.Unit == "" || f.Unit == entry.Unit) &&
+		(f.Cause == "" || f.Cause == entry.Cause)
+}
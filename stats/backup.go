@@ -0,0 +1,309 @@
+package stats
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	backupPrefix     = "greenhouse-"
+	backupSuffix     = ".db"
+	backupTimeFormat = "20060102T150405.000000000"
+)
+
+var (
+	// sqliteJournalSuffixes are appended to the path of a
+	// database by sqlite to name the files kept alongside it
+	sqliteJournalSuffixes = []string{"-journal", "-wal", "-shm"}
+
+	// ErrBackupUnsupported indicates that a Storage cannot be backed up
+	ErrBackupUnsupported = errors.New("storage does not support backups")
+)
+
+// Backuper is a Storage that can be backed up while it is in use
+type Backuper interface {
+	// Backup writes a consistent snapshot of the Storage to a new file
+	Backup(path string) error
+}
+
+// Backup writes a consistent snapshot of the database to path with
+// VACUUM INTO, which does not block writers for the whole backup
+func (ss *sqliteStorage) Backup(path string) error {
+	if _, err := ss.db.Exec(`VACUUM INTO $1`, path); err != nil {
+		return fmt.Errorf("error backing up database: %v", err)
+	}
+	return nil
+}
+
+// Backup writes a consistent snapshot of the database to path
+// in a read transaction, which does not block writers
+func (bs *boltStorage) Backup(path string) error {
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	if err != nil {
+		return fmt.Errorf("error backing up database: %v", err)
+	}
+	return nil
+}
+
+// Backups makes backups of a Storage on a schedule, keeping
+// the most recent Keep of them in a directory
+type Backups struct {
+	// Clock times and names the backups, the real clock unless replaced
+	Clock clock.Clock
+
+	storage Storage
+	dir     string
+
+	// Keep is how many backups are kept, older ones are removed
+	Keep int
+
+	interval time.Duration
+	mu       *sync.Mutex
+	closed   chan struct{}
+}
+
+// NewBackups creates Backups of storage in dir every interval
+func NewBackups(storage Storage, dir string, keep int, interval time.Duration) (*Backups, error) {
+	if _, ok := storage.(Backuper); !ok {
+		return nil, ErrBackupUnsupported
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %v", err)
+	}
+	return &Backups{
+		Clock:    clock.Real,
+		storage:  storage,
+		dir:      dir,
+		Keep:     keep,
+		interval: interval,
+		mu:       &sync.Mutex{},
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Begin makes a backup every interval until closed
+func (b *Backups) Begin() {
+	ticker := b.Clock.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C():
+			if _, err := b.Run(); err != nil {
+				b.logWithPrintout(logging.LevelError, "backup: %v", err)
+			}
+		}
+	}
+}
+
+// Run makes a backup now and removes the oldest backups beyond
+// Keep, returning the path of the new backup
+func (b *Backups) Run() (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	name := backupPrefix + b.Clock.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	path := filepath.Join(b.dir, name)
+
+	// the backup is only given its name once it is complete, so
+	// that an interrupted backup is never mistaken for a good one
+	partial := path + ".partial"
+	os.Remove(partial)
+	if err := b.storage.(Backuper).Backup(partial); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("error naming backup: %v", err)
+	}
+	b.logWithPrintout(logging.LevelInfo, "backup: wrote %s", path)
+
+	if err := b.rotate(); err != nil {
+		return path, err
+	}
+	return path, nil
+}
+
+// List returns the paths of the backups from oldest to newest
+func (b *Backups) List() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(b.dir, backupPrefix+"*"+backupSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups: %v", err)
+	}
+	// names sort by the time they were made
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// rotate removes the oldest backups beyond Keep
+func (b *Backups) rotate() error {
+	if b.Keep <= 0 {
+		return nil
+	}
+	paths, err := b.List()
+	if err != nil {
+		return err
+	}
+	for len(paths) > b.Keep {
+		if err := os.Remove(paths[0]); err != nil {
+			return fmt.Errorf("error removing old backup: %v", err)
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// Close stops making backups
+func (b *Backups) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func (b *Backups) logWithPrintout(level logging.Level, format string, args ...interface{}) {
+	if _, err := b.storage.Log(level, format, args...); err != nil {
+		log.Printf(`error logging message "%s": %v`, fmt.Sprintf(format, args...), err)
+	}
+}
+
+// RestoreSqlite replaces the database at target with a backup. The backup
+// must pass an integrity check and must not have a newer schema than this
+// version understands. The replaced database is kept next to it with a
+// .before-restore suffix and the time of the restore, so that no earlier
+// one is overwritten, and its path is returned. Its journals are kept
+// with it. Nothing may be using the database meanwhile.
+func RestoreSqlite(backup, target string) (string, error) {
+	if err := checkSqliteBackup(backup); err != nil {
+		return "", err
+	}
+
+	// copy next to the target first so that the swap is a rename
+	staged := target + ".restore"
+	if err := copyFile(backup, staged); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+
+	var kept string
+	if _, err := os.Stat(target); err == nil {
+		kept = target + ".before-restore-" + time.Now().Format(backupTimeFormat)
+		if _, err := os.Stat(kept); err == nil {
+			os.Remove(staged)
+			return "", fmt.Errorf("error moving database aside: %s already exists", kept)
+		}
+		if err := os.Rename(target, kept); err != nil {
+			os.Remove(staged)
+			return "", fmt.Errorf("error moving database aside: %v", err)
+		}
+	}
+	// a journal left by the replaced database would corrupt the restored
+	// one, and the kept database may need it to roll back to a consistent state
+	for _, suffix := range sqliteJournalSuffixes {
+		journal := target + suffix
+		if _, err := os.Stat(journal); os.IsNotExist(err) {
+			continue
+		}
+		if kept == "" {
+			os.Remove(journal)
+			continue
+		}
+		if err := os.Rename(journal, kept+suffix); err != nil {
+			os.Remove(staged)
+			return kept, fmt.Errorf("error moving database journal aside: %v", err)
+		}
+	}
+
+	if err := os.Rename(staged, target); err != nil {
+		return kept, fmt.Errorf("error swapping in backup: %v", err)
+	}
+	if err := syncDir(filepath.Dir(target)); err != nil {
+		return kept, err
+	}
+	return kept, nil
+}
+
+// syncDir syncs a directory to disk, so that renames within it survive a power cut
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("error opening directory: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory: %v", err)
+	}
+	return nil
+}
+
+// checkSqliteBackup checks that a backup is intact and has a schema this version understands
+func checkSqliteBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error opening backup: %v", err)
+	}
+	db, err := sql.Open(sqliteDriver, "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening backup: %v", err)
+	}
+	defer db.Close()
+
+	var integrity string
+	if err := db.QueryRow(`PRAGMA integrity_check`).Scan(&integrity); err != nil {
+		return fmt.Errorf("error checking backup: %v", err)
+	}
+	if integrity != "ok" {
+		return fmt.Errorf("backup is corrupt: %s", integrity)
+	}
+
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM migrations`).Scan(&version); err != nil {
+		return fmt.Errorf("error reading backup schema version: %v", err)
+	}
+	if !version.Valid {
+		return errors.New("backup has no schema version")
+	}
+	if version.Int64 > versionSqliteLatest {
+		return fmt.Errorf("backup schema version %d is newer than the supported version %d", version.Int64, versionSqliteLatest)
+	}
+	return nil
+}
+
+// copyFile copies src to a new file dst and syncs it to disk
+func copyFile(src, dst string) error {
+	data, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error opening backup: %v", err)
+	}
+	defer data.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("error staging backup: %v", err)
+	}
+	if _, err := out.ReadFrom(data); err != nil {
+		out.Close()
+		return fmt.Errorf("error staging backup: %v", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("error staging backup: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error staging backup: %v", err)
+	}
+	return nil
+}
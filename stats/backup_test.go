@@ -0,0 +1,262 @@
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+)
+
+func backupTest(f func(t *testing.T, s Storage, dir string)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		storage, err := NewSqliteStorage(filepath.Join(dir, "greenhouse.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.Close()
+
+		f(t, storage, dir)
+	}
+	return name, testFunc
+}
+
+func TestBackup(t *testing.T) {
+	t.Parallel()
+	t.Run("Backup", func(t *testing.T) {
+		t.Parallel()
+		t.Run(backupTest(backup_Snapshot))
+		t.Run(backupTest(backup_Rotation))
+		t.Run(backupTest(backup_Schedule))
+		t.Run(backupTest(backup_Unsupported))
+	})
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+		t.Run(backupTest(restore_SwapsFiles))
+		t.Run(backupTest(restore_KeepsJournals))
+		t.Run(backupTest(restore_NewerSchema))
+		t.Run(backupTest(restore_NotADatabase))
+	})
+}
+
+func makeBackup(t *testing.T, s Storage, dir string) string {
+	t.Helper()
+	backups, err := NewBackups(s, filepath.Join(dir, "backups"), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := backups.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func backup_Snapshot(t *testing.T, s Storage, dir string) {
+	when := time.Now()
+	if err := s.Record(Stat{StatType: StatTypeTemperature, When: when, Value: 21}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := makeBackup(t, s, dir)
+
+	// writes after the backup are not in it
+	if err := s.Record(Stat{StatType: StatTypeTemperature, When: when.Add(time.Second), Value: 22}); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := NewSqliteStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	latest, err := backup.Latest(StatTypeTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 21 {
+		t.Errorf("unexpected stat in backup: %v", latest)
+	}
+}
+
+func backup_Rotation(t *testing.T, s Storage, dir string) {
+	backups, err := NewBackups(s, filepath.Join(dir, "backups"), 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var made []string
+	for i := 0; i < 3; i++ {
+		path, err := backups.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		made = append(made, path)
+	}
+
+	list, err := backups.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0] != made[1] || list[1] != made[2] {
+		t.Errorf("unexpected backups: %v", list)
+	}
+}
+
+func backup_Schedule(t *testing.T, s Storage, dir string) {
+	start := time.Date(2018, time.June, 21, 6, 0, 0, 0, time.UTC)
+	manual := clock.NewManual(start)
+	backups, err := NewBackups(s, filepath.Join(dir, "backups"), 2, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups.Clock = manual
+	go backups.Begin()
+	defer backups.Close()
+
+	manual.BlockUntil(1)
+	for day := 1; day <= 3; day++ {
+		manual.Advance(24 * time.Hour)
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			list, err := backups.List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) > 0 && strings.Contains(list[len(list)-1], start.AddDate(0, 0, day).Format(backupTimeFormat)) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("no backup made on day %d: %v", day, list)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// wait for the last backup to finish rotating
+	backups.mu.Lock()
+	backups.mu.Unlock()
+	list, err := backups.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Errorf("unexpected backups: %v", list)
+	}
+}
+
+func backup_Unsupported(t *testing.T, s Storage, dir string) {
+	if _, err := NewBackups(NewFakeStatsStorage(10), dir, 1, time.Hour); err != ErrBackupUnsupported {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func restore_SwapsFiles(t *testing.T, s Storage, dir string) {
+	if err := s.Record(Stat{StatType: StatTypeHumidity, When: time.Now(), Value: 40}); err != nil {
+		t.Fatal(err)
+	}
+	path := makeBackup(t, s, dir)
+
+	target := filepath.Join(dir, "restored.db")
+	if err := ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := RestoreSqlite(path, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(kept, target+".before-restore-") {
+		t.Errorf("unexpected path of the replaced database: %s", kept)
+	}
+	if old, err := ioutil.ReadFile(kept); err != nil || string(old) != "old" {
+		t.Errorf("replaced database was not kept: %v", err)
+	}
+
+	// restoring again keeps the database replaced the first time too
+	again, err := RestoreSqlite(path, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == kept {
+		t.Errorf("replaced database kept at the same path twice: %s", again)
+	}
+	if old, err := ioutil.ReadFile(kept); err != nil || string(old) != "old" {
+		t.Errorf("first replaced database was overwritten: %v", err)
+	}
+
+	restored, err := NewSqliteStorage(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if latest, err := restored.Latest(StatTypeHumidity); err != nil || latest.Value != 40 {
+		t.Errorf("unexpected restored stat %v: %v", latest, err)
+	}
+}
+
+func restore_KeepsJournals(t *testing.T, s Storage, dir string) {
+	path := makeBackup(t, s, dir)
+
+	target := filepath.Join(dir, "restored.db")
+	for _, file := range []string{target, target + "-journal", target + "-wal"} {
+		if err := ioutil.WriteFile(file, []byte(filepath.Base(file)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kept, err := RestoreSqlite(path, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, suffix := range []string{"-journal", "-wal"} {
+		if _, err := os.Stat(target + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s left next to the restored database: %v", suffix, err)
+		}
+		if journal, err := ioutil.ReadFile(kept + suffix); err != nil || string(journal) != "restored.db"+suffix {
+			t.Errorf("%s was not kept with the replaced database: %v", suffix, err)
+		}
+	}
+	if _, err := os.Stat(kept + "-shm"); !os.IsNotExist(err) {
+		t.Errorf("unexpected -shm kept: %v", err)
+	}
+}
+
+func restore_NewerSchema(t *testing.T, s Storage, dir string) {
+	// pretend the database was migrated by a newer version
+	if _, err := s.(*sqliteStorage).db.Exec(`UPDATE migrations SET version = $1 WHERE version = $2`, versionSqliteLatest+1, versionSqliteLatest); err != nil {
+		t.Fatal(err)
+	}
+	path := makeBackup(t, s, dir)
+
+	target := filepath.Join(dir, "restored.db")
+	_, err := RestoreSqlite(path, target)
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("database was replaced: %v", err)
+	}
+}
+
+func restore_NotADatabase(t *testing.T, s Storage, dir string) {
+	path := filepath.Join(dir, "garbage.db")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("garbage", 1000)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreSqlite(path, filepath.Join(dir, "restored.db")); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// fetchLimit is the most stats Storage.Fetch returns at once
+	fetchLimit = 1000
+
+	// eachPageSize is how many rows Storage.EachStat and Storage.EachLog
+	// read per query, so that no query holds the database for long
+	eachPageSize = 1000
+)
+
+// statTypesPlaceholders returns the placeholders and arguments of
+// a list of StatTypes in a query, numbered starting after offset
+func statTypesPlaceholders(statTypes []StatType, offset int) (string, []interface{}) {
+	placeholders := make([]string, len(statTypes))
+	args := make([]interface{}, len(statTypes))
+	for i, statType := range statTypes {
+		placeholders[i] = fmt.Sprintf("$%d", offset+i+1)
+		args[i] = statType
+	}
+	return strings.Join(placeholders, ", "), args
+}
+
+// FetchAll retrieves every Stat of a particular type for a given time
+// frame in chronological order, past the limit on how many stats
+// Fetch returns at once, keeping every stat that shares a time
+func FetchAll(storage Storage, statType StatType, start, end time.Time) ([]Stat, error) {
+	var all []Stat
+	err := storage.EachStat([]StatType{statType}, start, end, func(stat Stat) error {
+		all = append(all, stat)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %v", statType, err)
+	}
+	return all, nil
+}
@@ -18,13 +18,25 @@ func (storagePgMigrations) GetMigration(version int) migrations.Migration {
 	case versionPgInitial:
 		// empty string, downgrade not supported
 		return migrations.NewSimpleMigration("initial", upgradePgInitial, downgradePgInitial)
+	case versionPgAudit:
+		return migrations.NewSimpleMigration("audit", upgradePgAudit, downgradePgAudit)
+	case versionPgLogTime:
+		return migrations.NewSimpleMigration("log time index", upgradePgLogTime, downgradePgLogTime)
+	case versionPgAggregates:
+		return migrations.NewSimpleMigration("aggregates", upgradePgAggregates, downgradePgAggregates)
+	case versionPgReaggregate:
+		return migrations.NewSimpleMigration("reaggregate", upgradePgReaggregate, downgradePgReaggregate)
 	}
 	return nil
 }
 
 const (
-	versionPgInitial = 1
-	versionPgLatest  = versionPgInitial
+	versionPgInitial     = 1
+	versionPgAudit       = 2
+	versionPgLogTime     = 3
+	versionPgAggregates  = 4
+	versionPgReaggregate = 5
+	versionPgLatest      = versionPgReaggregate
 )
 
 const (
@@ -53,3 +65,178 @@ DROP TABLE logs;
 DROP TABLE stats;
 `
 )
+
+const (
+	upgradePgAudit = `
+CREATE TABLE audit (
+  id        BIGSERIAL PRIMARY KEY    NOT NULL,
+  timestamp TIMESTAMP WITH TIME ZONE NOT NULL,
+  unit      VARCHAR(64)              NOT NULL,
+  cause     VARCHAR(16)              NOT NULL,
+  source    VARCHAR(256)             NOT NULL,
+  requested VARCHAR(64)              NOT NULL,
+  actual    VARCHAR(64)              NOT NULL,
+  error     VARCHAR(1024)            NOT NULL
+);
+CREATE INDEX idx_audit_timestamp
+  ON audit (timestamp);
+`
+	downgradePgAudit = `
+DROP TABLE audit;
+`
+)
+
+const (
+	// logs are looked up by time when copied between storages
+	upgradePgLogTime = `
+CREATE INDEX idx_logs_timestamp
+  ON logs (timestamp);
+`
+	downgradePgLogTime = `
+DROP INDEX idx_logs_timestamp;
+`
+)
+
+const (
+	// stats are looked up by type and time, and summarized in hourly and
+	// daily UTC buckets kept up to date as stats are inserted
+	upgradePgAggregates = `
+DROP INDEX idx_stats_stat;
+CREATE INDEX idx_stats_stat_timestamp
+  ON stats (stat, timestamp);
+
+CREATE TABLE stats_hourly (
+  stat   INTEGER                  NOT NULL,
+  bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+  min    FLOAT                    NOT NULL,
+  max    FLOAT                    NOT NULL,
+  sum    FLOAT                    NOT NULL,
+  count  BIGINT                   NOT NULL,
+  PRIMARY KEY (stat, bucket)
+);
+
+CREATE TABLE stats_daily (
+  stat   INTEGER                  NOT NULL,
+  bucket TIMESTAMP WITH TIME ZONE NOT NULL,
+  min    FLOAT                    NOT NULL,
+  max    FLOAT                    NOT NULL,
+  sum    FLOAT                    NOT NULL,
+  count  BIGINT                   NOT NULL,
+  PRIMARY KEY (stat, bucket)
+);
+
+CREATE FUNCTION stats_aggregate() RETURNS TRIGGER AS $$
+BEGIN
+  INSERT INTO stats_hourly (stat, bucket, min, max, sum, count)
+    VALUES (NEW.stat, date_trunc('hour', NEW.timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', NEW.value, NEW.value, NEW.value, 1)
+    ON CONFLICT (stat, bucket) DO UPDATE SET
+      min   = LEAST(stats_hourly.min, EXCLUDED.min),
+      max   = GREATEST(stats_hourly.max, EXCLUDED.max),
+      sum   = stats_hourly.sum + EXCLUDED.sum,
+      count = stats_hourly.count + 1;
+  INSERT INTO stats_daily (stat, bucket, min, max, sum, count)
+    VALUES (NEW.stat, date_trunc('day', NEW.timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', NEW.value, NEW.value, NEW.value, 1)
+    ON CONFLICT (stat, bucket) DO UPDATE SET
+      min   = LEAST(stats_daily.min, EXCLUDED.min),
+      max   = GREATEST(stats_daily.max, EXCLUDED.max),
+      sum   = stats_daily.sum + EXCLUDED.sum,
+      count = stats_daily.count + 1;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stats_aggregate
+  AFTER INSERT ON stats
+  FOR EACH ROW EXECUTE PROCEDURE stats_aggregate();
+
+INSERT INTO stats_hourly (stat, bucket, min, max, sum, count)
+  SELECT stat, date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', MIN(value), MAX(value), SUM(value), COUNT(*)
+  FROM stats GROUP BY 1, 2;
+INSERT INTO stats_daily (stat, bucket, min, max, sum, count)
+  SELECT stat, date_trunc('day', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', MIN(value), MAX(value), SUM(value), COUNT(*)
+  FROM stats GROUP BY 1, 2;
+`
+	downgradePgAggregates = `
+DROP TRIGGER stats_aggregate ON stats;
+DROP FUNCTION stats_aggregate();
+DROP TABLE stats_daily;
+DROP TABLE stats_hourly;
+DROP INDEX idx_stats_stat_timestamp;
+CREATE INDEX idx_stats_stat
+  ON stats (stat);
+`
+)
+
+const (
+	// the aggregates of stats that are updated or deleted are summarized
+	// again, since a minimum or maximum cannot be taken back out of them.
+	// Hours are summarized from their stats and days from their hours.
+	// Transition tables need PostgreSQL 10.
+	upgradePgReaggregate = `
+CREATE FUNCTION stats_summarize(s INTEGER, hour_bucket TIMESTAMP WITH TIME ZONE) RETURNS VOID AS $$
+DECLARE
+  day_bucket TIMESTAMP WITH TIME ZONE := date_trunc('day', hour_bucket AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
+BEGIN
+  DELETE FROM stats_hourly WHERE stat = s AND bucket = hour_bucket;
+  INSERT INTO stats_hourly (stat, bucket, min, max, sum, count)
+    SELECT s, hour_bucket, MIN(value), MAX(value), SUM(value), COUNT(*)
+    FROM stats WHERE stat = s AND timestamp >= hour_bucket AND timestamp < hour_bucket + INTERVAL '1 hour'
+    HAVING COUNT(*) > 0;
+  DELETE FROM stats_daily WHERE stat = s AND bucket = day_bucket;
+  INSERT INTO stats_daily (stat, bucket, min, max, sum, count)
+    SELECT s, day_bucket, MIN(min), MAX(max), SUM(sum), SUM(count)
+    FROM stats_hourly WHERE stat = s AND bucket >= day_bucket AND bucket < day_bucket + INTERVAL '1 day'
+    HAVING COUNT(*) > 0;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION stats_reaggregate() RETURNS TRIGGER AS $$
+DECLARE
+  changed RECORD;
+BEGIN
+  FOR changed IN
+    SELECT DISTINCT stat, date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket FROM old_rows
+  LOOP
+    PERFORM stats_summarize(changed.stat, changed.bucket);
+  END LOOP;
+  IF TG_OP = 'UPDATE' THEN
+    FOR changed IN
+      SELECT DISTINCT stat, date_trunc('hour', timestamp AT TIME ZONE 'UTC') AT TIME ZONE 'UTC' AS bucket FROM new_rows
+    LOOP
+      PERFORM stats_summarize(changed.stat, changed.bucket);
+    END LOOP;
+  END IF;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stats_reaggregate_delete
+  AFTER DELETE ON stats
+  REFERENCING OLD TABLE AS old_rows
+  FOR EACH STATEMENT EXECUTE PROCEDURE stats_reaggregate();
+
+CREATE TRIGGER stats_reaggregate_update
+  AFTER UPDATE ON stats
+  REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+  FOR EACH STATEMENT EXECUTE PROCEDURE stats_reaggregate();
+
+CREATE FUNCTION stats_truncate() RETURNS TRIGGER AS $$
+BEGIN
+  TRUNCATE stats_hourly, stats_daily;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stats_truncate
+  AFTER TRUNCATE ON stats
+  FOR EACH STATEMENT EXECUTE PROCEDURE stats_truncate();
+`
+	downgradePgReaggregate = `
+DROP TRIGGER stats_truncate ON stats;
+DROP FUNCTION stats_truncate();
+DROP TRIGGER stats_reaggregate_update ON stats;
+DROP TRIGGER stats_reaggregate_delete ON stats;
+DROP FUNCTION stats_reaggregate();
+DROP FUNCTION stats_summarize(INTEGER, TIMESTAMP WITH TIME ZONE);
+`
+)
@@ -18,13 +18,22 @@ func (storageSqliteMigrations) GetMigration(version int) migrations.Migration {
 	case versionSqliteInitial:
 		// empty string, downgrade not supported
 		return migrations.NewSimpleMigration("initial", upgradeSqliteInitial, downgradeSqliteInitial)
+	case versionSqliteAudit:
+		return migrations.NewSimpleMigration("audit", upgradeSqliteAudit, downgradeSqliteAudit)
+	case versionSqliteStatTime:
+		return migrations.NewSimpleMigration("stat time index", upgradeSqliteStatTime, downgradeSqliteStatTime)
+	case versionSqliteLogTime:
+		return migrations.NewSimpleMigration("log time index", upgradeSqliteLogTime, downgradeSqliteLogTime)
 	}
 	return nil
 }
 
 const (
-	versionSqliteInitial = 1
-	versionSqliteLatest  = versionSqliteInitial
+	versionSqliteInitial  = 1
+	versionSqliteAudit    = 2
+	versionSqliteStatTime = 3
+	versionSqliteLogTime  = 4
+	versionSqliteLatest   = versionSqliteLogTime
 )
 
 const (
@@ -52,3 +61,45 @@ DROP TABLE logs;
 DROP TABLE stats;
 `
 )
+
+const (
+	upgradeSqliteAudit = `
+CREATE TABLE audit (
+  id        INTEGER PRIMARY KEY AUTOINCREMENT,
+  nanostamp INTEGER NOT NULL,
+  unit      TEXT    NOT NULL,
+  cause     TEXT    NOT NULL,
+  source    TEXT    NOT NULL,
+  requested TEXT    NOT NULL,
+  actual    TEXT    NOT NULL,
+  error     TEXT    NOT NULL
+);
+CREATE INDEX idx_audit_nanostamp
+  ON audit (nanostamp);
+`
+	downgradeSqliteAudit = `
+DROP TABLE audit;
+`
+)
+
+const (
+	// stats are looked up by type and time when imported
+	upgradeSqliteStatTime = `
+CREATE INDEX idx_stats_stat_nanostamp
+  ON stats (stat, nanostamp);
+`
+	downgradeSqliteStatTime = `
+DROP INDEX idx_stats_stat_nanostamp;
+`
+)
+
+const (
+	// logs are looked up by time when copied between storages
+	upgradeSqliteLogTime = `
+CREATE INDEX idx_logs_nanostamp
+  ON logs (nanostamp);
+`
+	downgradeSqliteLogTime = `
+DROP INDEX idx_logs_nanostamp;
+`
+)
@@ -1,14 +1,99 @@
 package stats
 
+import (
+	"errors"
+	"math"
+)
+
 const (
 	StatTypeTemperature StatType = 1 + iota
 	StatTypeHumidity    StatType = 1 + iota
 	StatTypeWater       StatType = 1 + iota
 	StatTypeFan         StatType = 1 + iota
+	StatTypeFanDuty     StatType = 1 + iota
+	StatTypeFlow        StatType = 1 + iota
+	StatTypeFlowTotal   StatType = 1 + iota
+	StatTypeLight       StatType = 1 + iota
+	StatTypeDLI         StatType = 1 + iota
+	StatTypeCO2         StatType = 1 + iota
+	StatTypeDewPoint    StatType = 1 + iota
+	StatTypeVPD         StatType = 1 + iota
+	StatTypeHeatIndex   StatType = 1 + iota
+
+	// remote readings are kept apart from the greenhouse sensors,
+	// so that they never drive the units in the greenhouse
+	StatTypeRemoteTemperature StatType = 1 + iota
+	StatTypeRemoteHumidity    StatType = 1 + iota
+	StatTypeRemoteLight       StatType = 1 + iota
+	StatTypeRemoteCO2         StatType = 1 + iota
 )
 
 type StatType uint8
 
+var (
+	// ErrUnknownStatType indicates that a name
+	// does not correspond to any StatType
+	ErrUnknownStatType = errors.New("unknown stat type")
+
+	// StatTypes is every known StatType
+	StatTypes = []StatType{
+		StatTypeTemperature,
+		StatTypeHumidity,
+		StatTypeWater,
+		StatTypeFan,
+		StatTypeFanDuty,
+		StatTypeFlow,
+		StatTypeFlowTotal,
+		StatTypeLight,
+		StatTypeDLI,
+		StatTypeCO2,
+		StatTypeDewPoint,
+		StatTypeVPD,
+		StatTypeHeatIndex,
+		StatTypeRemoteTemperature,
+		StatTypeRemoteHumidity,
+		StatTypeRemoteLight,
+		StatTypeRemoteCO2,
+	}
+)
+
+// Range returns the lowest and highest values a StatType can plausibly
+// have, readings outside of it are from faulty sensors or bad data
+func (st StatType) Range() (float64, float64) {
+	switch st {
+	case StatTypeTemperature, StatTypeDewPoint, StatTypeRemoteTemperature:
+		return -60, 80
+	case StatTypeHeatIndex:
+		return -60, 100
+	case StatTypeHumidity, StatTypeRemoteHumidity:
+		return 0, 100
+	case StatTypeWater, StatTypeFan, StatTypeFanDuty:
+		return 0, 1
+	case StatTypeFlow:
+		return 0, 1000
+	case StatTypeLight, StatTypeRemoteLight:
+		return 0, 200000
+	case StatTypeDLI:
+		return 0, 100
+	case StatTypeCO2, StatTypeRemoteCO2:
+		return 0, 10000
+	case StatTypeVPD:
+		return 0, 50
+	default:
+		return 0, math.MaxFloat64
+	}
+}
+
+// ParseStatType returns the StatType with the given name
+func ParseStatType(name string) (StatType, error) {
+	for _, st := range StatTypes {
+		if st.String() == name {
+			return st, nil
+		}
+	}
+	return StatType(0), ErrUnknownStatType
+}
+
 func (st StatType) String() string {
 	switch st {
 	case StatTypeTemperature:
@@ -19,6 +104,32 @@ func (st StatType) String() string {
 		return "water"
 	case StatTypeFan:
 		return "fan"
+	case StatTypeFanDuty:
+		return "fanduty"
+	case StatTypeFlow:
+		return "flow"
+	case StatTypeFlowTotal:
+		return "flowtotal"
+	case StatTypeLight:
+		return "light"
+	case StatTypeDLI:
+		return "dli"
+	case StatTypeCO2:
+		return "co2"
+	case StatTypeDewPoint:
+		return "dewpoint"
+	case StatTypeVPD:
+		return "vpd"
+	case StatTypeHeatIndex:
+		return "heatindex"
+	case StatTypeRemoteTemperature:
+		return "remotetemperature"
+	case StatTypeRemoteHumidity:
+		return "remotehumidity"
+	case StatTypeRemoteLight:
+		return "remotelight"
+	case StatTypeRemoteCO2:
+		return "remoteco2"
 	default:
 		return "unknown"
 	}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/logging"
 )
 
@@ -19,25 +20,72 @@ var (
 	ErrNoStats = errors.New("no stats found")
 )
 
+// Clocked is a Storage whose log entries are timestamped by a clock.Clock,
+// the real clock unless replaced. Every Storage of this package is Clocked.
+type Clocked interface {
+	// SetClock replaces the clock, before the Storage is used
+	SetClock(c clock.Clock)
+}
+
 // Storage is the database interface to
-// record and retrieve statistics
+// record and retrieve statistics.
+//
+// Every time frame includes its bounds. Rows that share a time are kept in
+// the order they were recorded. Times are kept to at least the microsecond.
+// The storagetest package checks that a Storage keeps this contract.
 type Storage interface {
 	logging.Logger
 
 	// Record puts a Stat record in the Storage
 	Record(stat Stat) error
 
-	// Fetch retrieves a list of a particular Stat for a given time frame
+	// RecordBatch puts Stat records in the Storage in a single transaction,
+	// skipping those with the same type and time as a Stat already recorded.
+	// It returns how many of them were recorded.
+	RecordBatch(stats []Stat) (int, error)
+
+	// Fetch retrieves a list of a particular Stat for a given time frame in
+	// chronological order. If there are more than 1000 of them, it retrieves
+	// the most recent 1000.
 	Fetch(statType StatType, start, end time.Time) ([]Stat, error)
 
 	// Latest fetches the latest Stat of a particular
 	// type from the Storage.  If there are no statistics
-	// of that type recorded, it should return ErrNoStats
+	// of that type recorded, it should return ErrNoStats.
+	// Of the stats that share the latest time, it fetches
+	// the last one recorded.
 	Latest(statType StatType) (Stat, error)
 
 	// Logs retrieves logs for a given time frame with a given minimum log level
+	// in chronological order. If there are more than 1000 of them, it
+	// retrieves the most recent 1000.
 	Logs(level logging.Level, start, end time.Time) ([]logging.LogEntry, error)
 
+	// RecordLogs puts log entries in the Storage in a single transaction,
+	// keeping their times and skipping those with the same time, level and
+	// message as an entry already recorded. It returns how many of them were
+	// recorded.
+	RecordLogs(entries []logging.LogEntry) (int, error)
+
+	// EachStat calls fn with every Stat of the given types in a time frame,
+	// including its bounds, in chronological order without holding them all
+	// in memory. It stops at the first error returned by fn and returns it.
+	EachStat(statTypes []StatType, start, end time.Time, fn func(Stat) error) error
+
+	// EachLog calls fn with every log entry with a given minimum log level in
+	// a time frame, including its bounds, in chronological order without
+	// holding them all in memory. It stops at the first error returned by fn
+	// and returns it.
+	EachLog(level logging.Level, start, end time.Time, fn func(logging.LogEntry) error) error
+
+	// Audit records an entry in the audit trail
+	Audit(entry AuditEntry) error
+
+	// AuditTrail retrieves the entries of the audit trail selected by a
+	// filter, most recent first. If there are more than 1000 of them, it
+	// retrieves the most recent 1000.
+	AuditTrail(filter AuditFilter) ([]AuditEntry, error)
+
 	// Close closes the underlying connection
 	Close() error
 }
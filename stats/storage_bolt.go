@@ -0,0 +1,494 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// boltOpenTimeout is how long to wait for another process to let go of the database
+	boltOpenTimeout = 5 * time.Second
+
+	// boltKeySize is the size of a key: a time followed by a sequence number
+	boltKeySize = 16
+)
+
+const (
+	boltVersionInitial = 1
+	boltVersionLatest  = boltVersionInitial
+)
+
+var (
+	boltBucketMeta  = []byte("meta")
+	boltBucketStats = []byte("stats")
+	boltBucketLogs  = []byte("logs")
+	boltBucketAudit = []byte("audit")
+
+	boltKeyVersion = []byte("version")
+)
+
+// boltStorage keeps stats in a bolt database, a pure-Go key-value store.
+//
+// Every row is keyed by its time followed by a sequence number, so a
+// bucket is ordered by time and then by the order rows were recorded.
+// The stats bucket has a bucket for every StatType, sharing the sequence
+// of the stats bucket so that stats of different types stay ordered too.
+type boltStorage struct {
+	db    *bolt.DB
+	clock clock.Clock
+}
+
+// NewBoltStorage opens or creates a bolt database at path
+func NewBoltStorage(path string) (Storage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening database storage: %v", err)
+	}
+	storage := &boltStorage{
+		db:    db,
+		clock: clock.Real,
+	}
+	if err := storage.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error preparing database storage: %v", err)
+	}
+	return storage, nil
+}
+
+func (bs *boltStorage) SetClock(c clock.Clock) {
+	bs.clock = c
+}
+
+// migrate creates the buckets and refuses databases written by a newer version
+func (bs *boltStorage) migrate() error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltBucketMeta)
+		if err != nil {
+			return err
+		}
+		if value := meta.Get(boltKeyVersion); value != nil {
+			if version := binary.BigEndian.Uint64(value); version > boltVersionLatest {
+				return fmt.Errorf("database version %d is newer than supported version %d", version, boltVersionLatest)
+			}
+		}
+		for _, name := range [][]byte{boltBucketStats, boltBucketLogs, boltBucketAudit} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, boltVersionLatest)
+		return meta.Put(boltKeyVersion, version)
+	})
+}
+
+// boltKey returns the key of a row, its time ordered
+// the same as the bytes of the key and a sequence number
+func boltKey(when time.Time, seq uint64) []byte {
+	key := make([]byte, boltKeySize)
+	binary.BigEndian.PutUint64(key, uint64(when.UnixNano())^(1<<63))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// boltKeyStamp returns the time of a key in nanoseconds
+func boltKeyStamp(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key) ^ (1 << 63))
+}
+
+// boltSameTime returns whether or not two keys have the same time
+func boltSameTime(a, b []byte) bool {
+	return bytes.Equal(a[:8], b[:8])
+}
+
+// boltSeek positions a cursor at the first key after a key from a previous
+// page, or at the first key at or after start if there was no previous page
+func boltSeek(c *bolt.Cursor, start time.Time, after []byte) ([]byte, []byte) {
+	if after == nil {
+		return c.Seek(boltKey(start, 0))
+	}
+	k, v := c.Seek(after)
+	if k != nil && bytes.Equal(k, after) {
+		return c.Next()
+	}
+	return k, v
+}
+
+// boltSeekBefore positions a cursor at the last key before a time
+func boltSeekBefore(c *bolt.Cursor, end time.Time) ([]byte, []byte) {
+	if k, _ := c.Seek(boltKey(end, 0)); k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
+
+func boltStatBucket(statType StatType) []byte {
+	return []byte{byte(statType)}
+}
+
+func encodeBoltStat(value float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(value))
+	return b
+}
+
+func decodeBoltStat(statType StatType, k, v []byte) Stat {
+	return Stat{
+		StatType: statType,
+		When:     time.Unix(0, boltKeyStamp(k)),
+		Value:    math.Float64frombits(binary.BigEndian.Uint64(v)),
+	}
+}
+
+func encodeBoltLog(entry logging.LogEntry) []byte {
+	return append([]byte{byte(entry.Level)}, entry.Message...)
+}
+
+func decodeBoltLog(k, v []byte) logging.LogEntry {
+	return logging.LogEntry{
+		Level:   logging.Level(v[0]),
+		When:    time.Unix(0, boltKeyStamp(k)),
+		Message: string(v[1:]),
+	}
+}
+
+// boltAudit is how an AuditEntry is kept, its time is in the key
+type boltAudit struct {
+	Unit      string
+	Cause     string
+	Source    string
+	Requested string
+	Actual    string
+	Error     string
+}
+
+// putStat records a Stat, skipping it if unique is set and
+// there is already a Stat of its type at the same time
+func (bs *boltStorage) putStat(tx *bolt.Tx, stat Stat, unique bool) (bool, error) {
+	stats := tx.Bucket(boltBucketStats)
+	bucket, err := stats.CreateBucketIfNotExists(boltStatBucket(stat.StatType))
+	if err != nil {
+		return false, err
+	}
+	if unique {
+		key := boltKey(stat.When, 0)
+		if k, _ := bucket.Cursor().Seek(key); k != nil && boltSameTime(k, key) {
+			return false, nil
+		}
+	}
+	seq, err := stats.NextSequence()
+	if err != nil {
+		return false, err
+	}
+	return true, bucket.Put(boltKey(stat.When, seq), encodeBoltStat(stat.Value))
+}
+
+func (bs *boltStorage) Record(stat Stat) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		_, err := bs.putStat(tx, stat, false)
+		return err
+	})
+}
+
+func (bs *boltStorage) RecordBatch(stats []Stat) (int, error) {
+	return bs.recordBatch(stats, true)
+}
+
+// insertBatch records every Stat in a single transaction, even those
+// with the same type and time as a Stat already recorded
+func (bs *boltStorage) insertBatch(stats []Stat) error {
+	_, err := bs.recordBatch(stats, false)
+	return err
+}
+
+// recordBatch records stats in a single transaction, skipping
+// those at the time of another Stat of their type if unique is set
+func (bs *boltStorage) recordBatch(stats []Stat, unique bool) (int, error) {
+	recorded := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, stat := range stats {
+			ok, err := bs.putStat(tx, stat, unique)
+			if err != nil {
+				return err
+			}
+			if ok {
+				recorded++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error recording batch: %v", err)
+	}
+	return recorded, nil
+}
+
+func (bs *boltStorage) Fetch(statType StatType, start, end time.Time) ([]Stat, error) {
+	results := make([]Stat, 0, 100)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketStats).Bucket(boltStatBucket(statType))
+		if bucket == nil {
+			return nil
+		}
+		// the most recent, read backwards
+		c := bucket.Cursor()
+		for k, v := boltSeekBefore(c, end.Add(1)); k != nil && boltKeyStamp(k) >= start.UnixNano() && len(results) < fetchLimit; k, v = c.Prev() {
+			results = append(results, decodeBoltStat(statType, k, v))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching stats: %v", err)
+	}
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results, nil
+}
+
+func (bs *boltStorage) Latest(statType StatType) (Stat, error) {
+	var stat Stat
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketStats).Bucket(boltStatBucket(statType))
+		if bucket == nil {
+			return ErrNoStats
+		}
+		k, v := bucket.Cursor().Last()
+		if k == nil {
+			return ErrNoStats
+		}
+		stat = decodeBoltStat(statType, k, v)
+		return nil
+	})
+	return stat, err
+}
+
+// putLog records a log entry, skipping it if unique is set and there
+// is already an entry with the same time, level and message
+func (bs *boltStorage) putLog(tx *bolt.Tx, entry logging.LogEntry, unique bool) (bool, error) {
+	bucket := tx.Bucket(boltBucketLogs)
+	value := encodeBoltLog(entry)
+	if unique {
+		key := boltKey(entry.When, 0)
+		c := bucket.Cursor()
+		for k, v := c.Seek(key); k != nil && boltSameTime(k, key); k, v = c.Next() {
+			if bytes.Equal(v, value) {
+				return false, nil
+			}
+		}
+	}
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return false, err
+	}
+	return true, bucket.Put(boltKey(entry.When, seq), value)
+}
+
+func (bs *boltStorage) Log(level logging.Level, format string, args ...interface{}) (logging.LogEntry, error) {
+	entry := logging.LogEntry{
+		Message: fmt.Sprintf(format, args...),
+		Level:   level,
+		// the time as it is read back, without a monotonic clock reading
+		When: bs.clock.Now().Round(0),
+	}
+	log.Println(entry.Message)
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		_, err := bs.putLog(tx, entry, false)
+		return err
+	})
+	return entry, err
+}
+
+func (bs *boltStorage) Logs(level logging.Level, start, end time.Time) ([]logging.LogEntry, error) {
+	results := make([]logging.LogEntry, 0, 100)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		// the most recent, read backwards
+		c := tx.Bucket(boltBucketLogs).Cursor()
+		for k, v := boltSeekBefore(c, end.Add(1)); k != nil && boltKeyStamp(k) >= start.UnixNano() && len(results) < fetchLimit; k, v = c.Prev() {
+			if logging.Level(v[0]) >= level {
+				results = append(results, decodeBoltLog(k, v))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching logs: %v", err)
+	}
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+	return results, nil
+}
+
+func (bs *boltStorage) RecordLogs(entries []logging.LogEntry) (int, error) {
+	recorded := 0
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		for _, entry := range entries {
+			ok, err := bs.putLog(tx, entry, true)
+			if err != nil {
+				return err
+			}
+			if ok {
+				recorded++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error recording batch: %v", err)
+	}
+	return recorded, nil
+}
+
+func (bs *boltStorage) EachStat(statTypes []StatType, start, end time.Time, fn func(Stat) error) error {
+	type row struct {
+		key  []byte
+		stat Stat
+	}
+
+	// every type continues after the last of its stats in the previous page
+	after := make(map[StatType][]byte, len(statTypes))
+	for {
+		var page []row
+		more := false
+		err := bs.db.View(func(tx *bolt.Tx) error {
+			stats := tx.Bucket(boltBucketStats)
+			for _, statType := range statTypes {
+				bucket := stats.Bucket(boltStatBucket(statType))
+				if bucket == nil {
+					continue
+				}
+				c := bucket.Cursor()
+				read := 0
+				for k, v := boltSeek(c, start, after[statType]); k != nil && boltKeyStamp(k) <= end.UnixNano(); k, v = c.Next() {
+					if read == eachPageSize {
+						more = true
+						break
+					}
+					page = append(page, row{key: append([]byte(nil), k...), stat: decodeBoltStat(statType, k, v)})
+					read++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching stats: %v", err)
+		}
+
+		// keys share a sequence, so they order stats of every type
+		sort.Slice(page, func(i, j int) bool { return bytes.Compare(page[i].key, page[j].key) < 0 })
+		if len(page) > eachPageSize {
+			page = page[:eachPageSize]
+			more = true
+		}
+		for _, r := range page {
+			after[r.stat.StatType] = r.key
+			if err := fn(r.stat); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+func (bs *boltStorage) EachLog(level logging.Level, start, end time.Time, fn func(logging.LogEntry) error) error {
+	var after []byte
+	for {
+		page := make([]logging.LogEntry, 0, eachPageSize)
+		more := false
+		err := bs.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(boltBucketLogs).Cursor()
+			for k, v := boltSeek(c, start, after); k != nil && boltKeyStamp(k) <= end.UnixNano(); k, v = c.Next() {
+				if len(page) == eachPageSize {
+					more = true
+					break
+				}
+				after = append(after[:0:0], k...)
+				if logging.Level(v[0]) >= level {
+					page = append(page, decodeBoltLog(k, v))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching logs: %v", err)
+		}
+
+		for _, entry := range page {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+	}
+}
+
+func (bs *boltStorage) Audit(entry AuditEntry) error {
+	value, err := json.Marshal(boltAudit{
+		Unit:      entry.Unit,
+		Cause:     entry.Cause,
+		Source:    entry.Source,
+		Requested: entry.Requested,
+		Actual:    entry.Actual,
+		Error:     entry.Error,
+	})
+	if err != nil {
+		return err
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketAudit)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltKey(entry.When, seq), value)
+	})
+}
+
+func (bs *boltStorage) AuditTrail(filter AuditFilter) ([]AuditEntry, error) {
+	results := make([]AuditEntry, 0, 100)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucketAudit).Cursor()
+		for k, v := boltSeekBefore(c, filter.End.Add(1)); k != nil && boltKeyStamp(k) >= filter.Start.UnixNano() && len(results) < fetchLimit; k, v = c.Prev() {
+			var stored boltAudit
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("error scanning audit trail: %v", err)
+			}
+			entry := AuditEntry{
+				When:      time.Unix(0, boltKeyStamp(k)),
+				Unit:      stored.Unit,
+				Cause:     stored.Cause,
+				Source:    stored.Source,
+				Requested: stored.Requested,
+				Actual:    stored.Actual,
+				Error:     stored.Error,
+			}
+			if filter.matches(entry) {
+				results = append(results, entry)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit trail: %v", err)
+	}
+	return results, nil
+}
+
+func (bs *boltStorage) Close() error {
+	return bs.db.Close()
+}
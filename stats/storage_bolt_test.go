@@ -0,0 +1,415 @@
+package stats
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/logging"
+	bolt "go.etcd.io/bbolt"
+)
+
+func boltTest(f func(t *testing.T, s *boltStorage, dir string)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := ioutil.TempDir("", "bolt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		storage, err := NewBoltStorage(filepath.Join(dir, "greenhouse.bolt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer storage.Close()
+
+		f(t, storage.(*boltStorage), dir)
+	}
+
+	return name, testFunc
+}
+
+func TestBoltStorage(t *testing.T) {
+	t.Parallel()
+	t.Run("Migrations", func(t *testing.T) {
+		t.Parallel()
+		t.Run(boltTest(bolt_Version))
+		t.Run(boltTest(bolt_NewerVersion))
+	})
+	t.Run("Storage", func(t *testing.T) {
+		t.Parallel()
+		t.Run(boltTest(bolt_RecordLatestFetch))
+		t.Run(boltTest(bolt_Logging))
+		t.Run(boltTest(bolt_Audit))
+		t.Run(boltTest(bolt_FetchAll))
+		t.Run(boltTest(bolt_EachStat))
+		t.Run(boltTest(bolt_EachStatStops))
+		t.Run(boltTest(bolt_EachLog))
+		t.Run(boltTest(bolt_RecordBatch))
+		t.Run(boltTest(bolt_RecordLogs))
+		t.Run(boltTest(bolt_NegativeTimes))
+		t.Run(boltTest(bolt_Backup))
+	})
+}
+
+func bolt_Version(t *testing.T, s *boltStorage, dir string) {
+	var version uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		version = binary.BigEndian.Uint64(tx.Bucket(boltBucketMeta).Get(boltKeyVersion))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != boltVersionLatest {
+		t.Error("migrations not correctly or fully run")
+	}
+}
+
+func bolt_NewerVersion(t *testing.T, s *boltStorage, dir string) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketMeta).Put(boltKeyVersion, []byte{0, 0, 0, 0, 0, 0, 0, boltVersionLatest + 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	_, err = NewBoltStorage(filepath.Join(dir, "greenhouse.bolt"))
+	if err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected a newer database to be refused, got %v", err)
+	}
+}
+
+func bolt_RecordLatestFetch(t *testing.T, s *boltStorage, dir string) {
+	fuzz := Stat{StatType: StatTypeFan, Value: 1, When: time.Now().Round(0)}
+	if err := s.Record(fuzz); err != nil {
+		t.Fatal(err)
+	}
+	stat := Stat{StatType: StatTypeWater, Value: 1, When: time.Now().Round(0)}
+	if err := s.Record(stat); err != nil {
+		t.Fatal(err)
+	}
+	latest, err := s.Latest(StatTypeWater)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stat, latest) {
+		t.Fatalf("unexpected stat\nneed: %#v\nhave: %#v", stat, latest)
+	}
+	if _, err := s.Latest(StatTypeLight); err != ErrNoStats {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	history, err := s.Fetch(StatTypeWater, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("unexpected history: %#v", history)
+	}
+	history, err = s.Fetch(StatTypeWater, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 {
+		t.Fatalf("unexpected history: %#v", history)
+	}
+	if !reflect.DeepEqual(stat, history[0]) {
+		t.Fatalf("unexpected history\nneed: %#v\nhave: %#v", stat, history[0])
+	}
+}
+
+func bolt_Logging(t *testing.T, s *boltStorage, dir string) {
+	if _, err := s.Log(logging.LevelDebug, "fuzz"); err != nil {
+		t.Fatal(err)
+	}
+	var log logging.LogEntry
+	var err error
+	if log, err = s.Log(logging.LevelInfo, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := s.Logs(logging.LevelWarn, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("unexpected log entries: %#v", logs)
+	}
+	logs, err = s.Logs(logging.LevelInfo, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 0 {
+		t.Fatalf("unexpected log entries: %#v", logs)
+	}
+	logs, err = s.Logs(logging.LevelInfo, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("unexpected log entries: %#v", logs)
+	}
+	if !reflect.DeepEqual(log, logs[0]) {
+		t.Fatalf("unexpected log entries\nneed: %#v\nhave: %#v", log, logs[0])
+	}
+}
+
+func bolt_Audit(t *testing.T, s *boltStorage, dir string) {
+	now := time.Unix(0, time.Now().UnixNano())
+	water := AuditEntry{When: now.Add(-time.Minute), Unit: "water", Cause: AuditCauseSchedule, Source: "1 (api admin)", Requested: "on", Actual: "on"}
+	fan := AuditEntry{When: now, Unit: "fan", Cause: AuditCauseAPI, Source: "admin", Requested: "on", Actual: "off", Error: "interlocked"}
+	for _, entry := range []AuditEntry{water, fan} {
+		if err := s.Audit(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trail, err := s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{fan, water}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{fan, water}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now, Unit: "water"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{water}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{water}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now, Cause: AuditCauseAPI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{fan}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{fan}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 0 {
+		t.Fatalf("unexpected audit trail: %#v", trail)
+	}
+}
+
+func bolt_FetchAll(t *testing.T, s *boltStorage, dir string) {
+	start := time.Now().Add(-time.Hour)
+	count := fetchLimit + fetchLimit/2
+	batch := make([]Stat, count)
+	for i := range batch {
+		batch[i] = Stat{StatType: StatTypeLight, Value: float64(i), When: start.Add(time.Duration(i) * time.Second)}
+	}
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := FetchAll(s, StatTypeLight, start.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != count {
+		t.Fatalf("expected %d stats, got %d", count, len(all))
+	}
+	for i, stat := range all {
+		if stat.Value != float64(i) {
+			t.Fatalf("stats out of order at %d: %g", i, stat.Value)
+		}
+	}
+}
+
+func bolt_EachStat(t *testing.T, s *boltStorage, dir string) {
+	start := time.Now().Add(-time.Hour)
+	count := eachPageSize + eachPageSize/2
+	for i := 0; i < count; i++ {
+		statType := StatTypeTemperature
+		if i%2 == 1 {
+			statType = StatTypeHumidity
+		}
+		// pairs of stats share a time, across page boundaries too
+		when := start.Add(time.Duration(i/2) * time.Second)
+		if err := s.Record(Stat{StatType: statType, Value: float64(i), When: when}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Record(Stat{StatType: StatTypeFan, Value: -1, When: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []Stat
+	err := s.EachStat([]StatType{StatTypeTemperature, StatTypeHumidity}, start, start.Add(time.Duration(count/2-1)*time.Second), func(stat Stat) error {
+		all = append(all, stat)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != count {
+		t.Fatalf("expected %d stats, got %d", count, len(all))
+	}
+	for i, stat := range all {
+		if stat.Value != float64(i) {
+			t.Fatalf("stats out of order at %d: %g", i, stat.Value)
+		}
+	}
+}
+
+func bolt_EachStatStops(t *testing.T, s *boltStorage, dir string) {
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := s.Record(Stat{StatType: StatTypeLight, Value: float64(i), When: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := s.EachStat([]StatType{StatTypeLight}, start, time.Now(), func(stat Stat) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func bolt_EachLog(t *testing.T, s *boltStorage, dir string) {
+	start := time.Now().Add(-time.Hour)
+	entries := make([]logging.LogEntry, 0, eachPageSize+2)
+	for i := 0; i < eachPageSize; i++ {
+		entries = append(entries, logging.LogEntry{Level: logging.LevelDebug, When: start.Add(time.Duration(i) * time.Millisecond), Message: "debug"})
+	}
+	entries = append(entries,
+		logging.LogEntry{Level: logging.LevelError, When: start.Add(time.Second), Message: "first"},
+		logging.LogEntry{Level: logging.LevelWarn, When: start.Add(time.Second), Message: "second"},
+	)
+	if _, err := s.RecordLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	err := s.EachLog(logging.LevelWarn, start, time.Now(), func(entry logging.LogEntry) error {
+		messages = append(messages, entry.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(messages, []string{"first", "second"}) {
+		t.Errorf("unexpected log entries: %v", messages)
+	}
+}
+
+func bolt_RecordBatch(t *testing.T, s *boltStorage, dir string) {
+	when := time.Now().Add(-time.Minute)
+	if err := s.Record(Stat{StatType: StatTypeCO2, Value: 400, When: when}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := s.RecordBatch([]Stat{
+		{StatType: StatTypeCO2, Value: 500, When: when},
+		{StatType: StatTypeCO2, Value: 600, When: when.Add(time.Second)},
+		{StatType: StatTypeCO2, Value: 600, When: when.Add(time.Second)},
+		{StatType: StatTypeVPD, Value: 1, When: when},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 stats recorded, got %d", recorded)
+	}
+
+	all, err := FetchAll(s, StatTypeCO2, when.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Value != 400 || all[1].Value != 600 {
+		t.Errorf("unexpected stats: %v", all)
+	}
+}
+
+func bolt_RecordLogs(t *testing.T, s *boltStorage, dir string) {
+	when := time.Now().Add(-time.Minute)
+	recorded, err := s.RecordLogs([]logging.LogEntry{
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelError, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when.Add(time.Second), Message: "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 3 {
+		t.Errorf("expected 3 log entries recorded, got %d", recorded)
+	}
+
+	var entries []logging.LogEntry
+	err = s.EachLog(logging.LevelDebug, when, time.Now(), func(entry logging.LogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !entries[0].When.Equal(when) || entries[2].Message != "second" {
+		t.Errorf("unexpected log entries: %v", entries)
+	}
+}
+
+func bolt_NegativeTimes(t *testing.T, s *boltStorage, dir string) {
+	// keys order times before the unix epoch before those after it
+	before := time.Unix(-10, 0)
+	after := time.Unix(10, 0)
+	if _, err := s.RecordBatch([]Stat{
+		{StatType: StatTypeFlow, Value: 2, When: after},
+		{StatType: StatTypeFlow, Value: 1, When: before},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := s.Latest(StatTypeFlow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 2 || !latest.When.Equal(after) {
+		t.Errorf("unexpected latest stat: %v", latest)
+	}
+}
+
+func bolt_Backup(t *testing.T, s *boltStorage, dir string) {
+	if err := s.Record(Stat{StatType: StatTypeTemperature, When: time.Now(), Value: 21}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := makeBackup(t, s, dir)
+
+	backup, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	latest, err := backup.Latest(StatTypeTemperature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Value != 21 {
+		t.Errorf("unexpected stat in backup: %v", latest)
+	}
+}
@@ -0,0 +1,51 @@
+package stats_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/explodes/greenhouse-pi/stats"
+	"github.com/explodes/greenhouse-pi/stats/storagetest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// opened closes a Storage after a test
+func opened(t *testing.T, storage stats.Storage, err error) stats.Storage {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.Close() })
+	return storage
+}
+
+func TestStorageContract(t *testing.T) {
+	t.Parallel()
+	t.Run("Sqlite", func(t *testing.T) {
+		t.Parallel()
+		storagetest.Run(t, func(t *testing.T) stats.Storage {
+			storage, err := stats.NewSqliteStorage(":memory:")
+			return opened(t, storage, err)
+		})
+	})
+	t.Run("Bolt", func(t *testing.T) {
+		t.Parallel()
+		storagetest.Run(t, func(t *testing.T) stats.Storage {
+			dir, err := ioutil.TempDir("", "bolt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(dir) })
+			storage, err := stats.NewBoltStorage(filepath.Join(dir, "greenhouse.bolt"))
+			return opened(t, storage, err)
+		})
+	})
+	t.Run("Fake", func(t *testing.T) {
+		t.Parallel()
+		storagetest.Run(t, func(t *testing.T) stats.Storage {
+			return stats.NewFakeStatsStorage(10000)
+		})
+	})
+}
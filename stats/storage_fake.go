@@ -3,9 +3,11 @@ package stats
 import (
 	"fmt"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/logging"
 )
 
@@ -13,18 +15,27 @@ type fakeStatsStorage struct {
 	mu      *sync.RWMutex
 	storage map[StatType][]Stat
 	logs    []logging.LogEntry
+	audit   []AuditEntry
 	limit   int
+	clock   clock.Clock
 }
 
 func NewFakeStatsStorage(limit int) Storage {
 	return &fakeStatsStorage{
 		mu:      &sync.RWMutex{},
 		storage: make(map[StatType][]Stat),
-		logs:    make([]logging.LogEntry, limit),
+		logs:    make([]logging.LogEntry, 0, limit),
 		limit:   limit,
+		clock:   clock.Real,
 	}
 }
 
+func (ss *fakeStatsStorage) SetClock(c clock.Clock) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.clock = c
+}
+
 func (ss *fakeStatsStorage) Record(stat Stat) error {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
@@ -45,6 +56,44 @@ func (ss *fakeStatsStorage) Record(stat Stat) error {
 	return nil
 }
 
+func (ss *fakeStatsStorage) RecordBatch(stats []Stat) (int, error) {
+	recorded := 0
+	for _, stat := range stats {
+		if ss.recorded(stat) {
+			continue
+		}
+		if err := ss.Record(stat); err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+	return recorded, nil
+}
+
+// insertBatch records every Stat, even those with the
+// same type and time as a Stat already recorded
+func (ss *fakeStatsStorage) insertBatch(stats []Stat) error {
+	for _, stat := range stats {
+		if err := ss.Record(stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recorded returns whether or not a Stat with the same type and time has been recorded
+func (ss *fakeStatsStorage) recorded(stat Stat) bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	for _, existing := range ss.storage[stat.StatType] {
+		if existing.When.Equal(stat.When) {
+			return true
+		}
+	}
+	return false
+}
+
 func between(when, start, end time.Time) bool {
 	return (when.Equal(start) || when.After(start)) && (when.Equal(end) || when.Before(end))
 }
@@ -65,6 +114,12 @@ func (ss *fakeStatsStorage) Fetch(statType StatType, start, end time.Time) ([]St
 		}
 	}
 
+	// the most recent, like the database storages
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].When.Before(filtered[j].When) })
+	if len(filtered) > fetchLimit {
+		filtered = filtered[len(filtered)-fetchLimit:]
+	}
+
 	return filtered, nil
 }
 
@@ -79,7 +134,7 @@ func (ss *fakeStatsStorage) Latest(statType StatType) (Stat, error) {
 
 	latest := list[0]
 	for _, stat := range list[1:] {
-		if stat.StatType == statType && stat.When.After(latest.When) {
+		if stat.StatType == statType && !stat.When.Before(latest.When) {
 			latest = stat
 		}
 	}
@@ -98,7 +153,7 @@ func (ss *fakeStatsStorage) Log(level logging.Level, format string, args ...inte
 	}
 
 	entry := logging.LogEntry{
-		When:    time.Now(),
+		When:    ss.clock.Now(),
 		Level:   level,
 		Message: msg,
 	}
@@ -121,6 +176,108 @@ func (ss *fakeStatsStorage) Logs(level logging.Level, start, end time.Time) ([]l
 		}
 	}
 
+	// the most recent, like the database storages
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].When.Before(filtered[j].When) })
+	if len(filtered) > fetchLimit {
+		filtered = filtered[len(filtered)-fetchLimit:]
+	}
+
+	return filtered, nil
+}
+
+func (ss *fakeStatsStorage) RecordLogs(entries []logging.LogEntry) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	recorded := 0
+	for _, entry := range entries {
+		duplicate := false
+		for _, existing := range ss.logs {
+			if existing.When.Equal(entry.When) && existing.Level == entry.Level && existing.Message == entry.Message {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		if len(ss.logs) > ss.limit {
+			ss.logs = ss.logs[1:]
+		}
+		ss.logs = append(ss.logs, entry)
+		recorded++
+	}
+	return recorded, nil
+}
+
+func (ss *fakeStatsStorage) EachStat(statTypes []StatType, start, end time.Time, fn func(Stat) error) error {
+	ss.mu.RLock()
+	var filtered []Stat
+	for _, statType := range statTypes {
+		for _, stat := range ss.storage[statType] {
+			if between(stat.When, start, end) {
+				filtered = append(filtered, stat)
+			}
+		}
+	}
+	ss.mu.RUnlock()
+
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].When.Before(filtered[j].When) })
+	for _, stat := range filtered {
+		if err := fn(stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *fakeStatsStorage) EachLog(level logging.Level, start, end time.Time, fn func(logging.LogEntry) error) error {
+	ss.mu.RLock()
+	var entries []logging.LogEntry
+	for _, entry := range ss.logs {
+		if entry.Level >= level && between(entry.When, start, end) {
+			entries = append(entries, entry)
+		}
+	}
+	ss.mu.RUnlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].When.Before(entries[j].When) })
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ss *fakeStatsStorage) Audit(entry AuditEntry) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.audit) >= ss.limit {
+		ss.audit = ss.audit[1:]
+	}
+	ss.audit = append(ss.audit, entry)
+
+	return nil
+}
+
+func (ss *fakeStatsStorage) AuditTrail(filter AuditFilter) ([]AuditEntry, error) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	// most recent first, like the database storages
+	filtered := make([]AuditEntry, 0, len(ss.audit))
+	for i := len(ss.audit) - 1; i >= 0; i-- {
+		if filter.matches(ss.audit[i]) {
+			filtered = append(filtered, ss.audit[i])
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool { return filtered[i].When.After(filtered[j].When) })
+	if len(filtered) > fetchLimit {
+		filtered = filtered[:fetchLimit]
+	}
+
 	return filtered, nil
 }
 
@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/logging"
 )
 
@@ -14,7 +15,8 @@ const (
 )
 
 type pgStorage struct {
-	db *sql.DB
+	db    *sql.DB
+	clock clock.Clock
 }
 
 func NewPostgresStorage(conn string) (Storage, error) {
@@ -23,7 +25,8 @@ func NewPostgresStorage(conn string) (Storage, error) {
 		return nil, fmt.Errorf("error opening database storage: %v", err)
 	}
 	storage := &pgStorage{
-		db: db,
+		db:    db,
+		clock: clock.Real,
 	}
 	if err := storage.migrate(); err != nil {
 		return nil, fmt.Errorf("error preparing database storage: %v", err)
@@ -31,6 +34,10 @@ func NewPostgresStorage(conn string) (Storage, error) {
 	return storage, nil
 }
 
+func (pg *pgStorage) SetClock(c clock.Clock) {
+	pg.clock = c
+}
+
 func (pg *pgStorage) migrate() error {
 	return migratePgDatabase(pg.db)
 }
@@ -40,12 +47,57 @@ func (pg *pgStorage) Record(stat Stat) error {
 	return err
 }
 
+func (pg *pgStorage) RecordBatch(stats []Stat) (int, error) {
+	return pg.recordBatch(stats, `INSERT INTO stats (stat, value, timestamp) SELECT $1::INTEGER, $2::FLOAT, $3::TIMESTAMP WITH TIME ZONE WHERE NOT EXISTS (SELECT 1 FROM stats WHERE stat = $1 AND timestamp = $3)`)
+}
+
+// insertBatch records every Stat in a single transaction, even those
+// with the same type and time as a Stat already recorded
+func (pg *pgStorage) insertBatch(stats []Stat) error {
+	_, err := pg.recordBatch(stats, `INSERT INTO stats (stat, value, timestamp) VALUES($1, $2, $3)`)
+	return err
+}
+
+// recordBatch records stats in a single transaction with the given
+// statement, returning how many rows it inserted
+func (pg *pgStorage) recordBatch(stats []Stat, query string) (int, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning batch: %v", err)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error preparing batch: %v", err)
+	}
+	defer stmt.Close()
+
+	recorded := 0
+	for _, stat := range stats {
+		result, err := stmt.Exec(stat.StatType, stat.Value, stat.When)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		recorded += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing batch: %v", err)
+	}
+	return recorded, nil
+}
+
 func (pg *pgStorage) Fetch(statType StatType, start, end time.Time) ([]Stat, error) {
 	scan := struct {
 		value     float64
 		timestamp time.Time
 	}{}
-	rows, err := pg.db.Query(`SELECT value, timestamp FROM stats WHERE stat = $1 AND timestamp BETWEEN $2 AND $3 ORDER BY timestamp DESC LIMIT 1000`, statType, start, end)
+	rows, err := pg.db.Query(`SELECT value, timestamp FROM (SELECT id, value, timestamp FROM stats WHERE stat = $1 AND timestamp BETWEEN $2 AND $3 ORDER BY timestamp DESC, id DESC LIMIT 1000) AS recent ORDER BY timestamp, id`, statType, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching stats: %v", err)
 	}
@@ -63,6 +115,9 @@ func (pg *pgStorage) Fetch(statType StatType, start, end time.Time) ([]Stat, err
 		}
 		results = append(results, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning stats: %v", err)
+	}
 	return results, nil
 }
 
@@ -71,10 +126,13 @@ func (pg *pgStorage) Latest(statType StatType) (Stat, error) {
 		value     float64
 		timestamp time.Time
 	}{}
-	err := pg.db.QueryRow(`SELECT value, timestamp FROM stats WHERE stat = $1 ORDER BY timestamp DESC LIMIT 1`, statType).Scan(&scan.value, &scan.timestamp)
+	err := pg.db.QueryRow(`SELECT value, timestamp FROM stats WHERE stat = $1 ORDER BY timestamp DESC, id DESC LIMIT 1`, statType).Scan(&scan.value, &scan.timestamp)
 	if err == sql.ErrNoRows {
 		return Stat{}, ErrNoStats
 	}
+	if err != nil {
+		return Stat{}, fmt.Errorf("error fetching latest stat: %v", err)
+	}
 	stat := Stat{
 		StatType: statType,
 		When:     scan.timestamp,
@@ -87,7 +145,7 @@ func (pg *pgStorage) Log(level logging.Level, format string, args ...interface{}
 	entry := logging.LogEntry{
 		Message: fmt.Sprintf(format, args...),
 		Level:   level,
-		When:    time.Now(),
+		When:    pg.clock.Now(),
 	}
 	log.Println(entry.Message)
 	if _, err := pg.db.Exec(`INSERT INTO logs (message, timestamp, level) VALUES($1, $2, $3)`, entry.Message, entry.When, entry.Level); err != nil {
@@ -102,7 +160,7 @@ func (pg *pgStorage) Logs(level logging.Level, start, end time.Time) ([]logging.
 		message string
 		when    time.Time
 	}{}
-	rows, err := pg.db.Query(`SELECT message, timestamp, level FROM logs WHERE level >= $1 AND timestamp BETWEEN $2 AND $3 ORDER BY timestamp DESC LIMIT 1000`, level, start, end)
+	rows, err := pg.db.Query(`SELECT message, timestamp, level FROM (SELECT id, message, timestamp, level FROM logs WHERE level >= $1 AND timestamp BETWEEN $2 AND $3 ORDER BY timestamp DESC, id DESC LIMIT 1000) AS recent ORDER BY timestamp, id`, level, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching logs: %v", err)
 	}
@@ -120,6 +178,144 @@ func (pg *pgStorage) Logs(level logging.Level, start, end time.Time) ([]logging.
 		}
 		results = append(results, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning logs: %v", err)
+	}
+	return results, nil
+}
+
+func (pg *pgStorage) RecordLogs(entries []logging.LogEntry) (int, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning batch: %v", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO logs (message, timestamp, level) SELECT $1::TEXT, $2::TIMESTAMP WITH TIME ZONE, $3::INTEGER WHERE NOT EXISTS (SELECT 1 FROM logs WHERE timestamp = $2 AND level = $3 AND message = $1)`)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error preparing batch: %v", err)
+	}
+	defer stmt.Close()
+
+	recorded := 0
+	for _, entry := range entries {
+		result, err := stmt.Exec(entry.Message, entry.When, entry.Level)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		recorded += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing batch: %v", err)
+	}
+	return recorded, nil
+}
+
+func (pg *pgStorage) EachStat(statTypes []StatType, start, end time.Time, fn func(Stat) error) error {
+	if len(statTypes) == 0 {
+		return nil
+	}
+	placeholders, statArgs := statTypesPlaceholders(statTypes, 3)
+	query := fmt.Sprintf(`SELECT id, stat, value, timestamp FROM stats WHERE (timestamp > $1 OR (timestamp = $1 AND id > $2)) AND timestamp <= $3 AND stat IN (%s) ORDER BY timestamp, id LIMIT %d`, placeholders, eachPageSize)
+
+	// pages continue after the last row of the previous page
+	lastTimestamp, lastID := start, int64(-1)
+	for {
+		page := make([]Stat, 0, eachPageSize)
+		args := append([]interface{}{lastTimestamp, lastID, end}, statArgs...)
+		rows, err := pg.db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("error fetching stats: %v", err)
+		}
+		for rows.Next() {
+			var stat Stat
+			if err := rows.Scan(&lastID, &stat.StatType, &stat.Value, &stat.When); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning stats: %v", err)
+			}
+			lastTimestamp = stat.When
+			page = append(page, stat)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("error fetching stats: %v", err)
+		}
+
+		for _, stat := range page {
+			if err := fn(stat); err != nil {
+				return err
+			}
+		}
+		if len(page) < eachPageSize {
+			return nil
+		}
+	}
+}
+
+func (pg *pgStorage) EachLog(level logging.Level, start, end time.Time, fn func(logging.LogEntry) error) error {
+	query := fmt.Sprintf(`SELECT id, message, timestamp, level FROM logs WHERE (timestamp > $1 OR (timestamp = $1 AND id > $2)) AND timestamp <= $3 AND level >= $4 ORDER BY timestamp, id LIMIT %d`, eachPageSize)
+
+	// pages continue after the last row of the previous page
+	lastTimestamp, lastID := start, int64(-1)
+	for {
+		page := make([]logging.LogEntry, 0, eachPageSize)
+		rows, err := pg.db.Query(query, lastTimestamp, lastID, end, level)
+		if err != nil {
+			return fmt.Errorf("error fetching logs: %v", err)
+		}
+		for rows.Next() {
+			var entry logging.LogEntry
+			if err := rows.Scan(&lastID, &entry.Message, &entry.When, &entry.Level); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning logs: %v", err)
+			}
+			lastTimestamp = entry.When
+			page = append(page, entry)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("error fetching logs: %v", err)
+		}
+
+		for _, entry := range page {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if len(page) < eachPageSize {
+			return nil
+		}
+	}
+}
+
+func (pg *pgStorage) Audit(entry AuditEntry) error {
+	_, err := pg.db.Exec(`INSERT INTO audit (timestamp, unit, cause, source, requested, actual, error) VALUES($1, $2, $3, $4, $5, $6, $7)`,
+		entry.When, entry.Unit, entry.Cause, entry.Source, entry.Requested, entry.Actual, entry.Error)
+	return err
+}
+
+func (pg *pgStorage) AuditTrail(filter AuditFilter) ([]AuditEntry, error) {
+	rows, err := pg.db.Query(`SELECT timestamp, unit, cause, source, requested, actual, error FROM audit WHERE timestamp BETWEEN $1 AND $2 AND ($3 = '' OR unit = $3) AND ($4 = '' OR cause = $4) ORDER BY timestamp DESC, id DESC LIMIT 1000`,
+		filter.Start, filter.End, filter.Unit, filter.Cause)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit trail: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]AuditEntry, 0, 100)
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.When, &entry.Unit, &entry.Cause, &entry.Source, &entry.Requested, &entry.Actual, &entry.Error); err != nil {
+			return nil, fmt.Errorf("error scanning audit trail: %v", err)
+		}
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning audit trail: %v", err)
+	}
 	return results, nil
 }
 
@@ -0,0 +1,54 @@
+//go:build integration
+// +build integration
+
+package stats_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/explodes/greenhouse-pi/stats"
+	"github.com/explodes/greenhouse-pi/stats/storagetest"
+	_ "github.com/lib/pq"
+)
+
+func TestPostgresStorageContract(t *testing.T) {
+	conn := os.Getenv("TEST_PG_CONNECTION")
+	if conn == "" {
+		t.Fatal("TEST_PG_CONNECTION not set. Need database connection string.")
+	}
+
+	storagetest.Run(t, func(t *testing.T) stats.Storage {
+		storage, err := stats.NewPostgresStorage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			storage.Close()
+			// every test starts from an empty database
+			db, err := sql.Open("postgres", conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			for _, drop := range []string{
+				`DROP TABLE IF EXISTS stats`,
+				`DROP FUNCTION IF EXISTS stats_aggregate()`,
+				`DROP FUNCTION IF EXISTS stats_reaggregate()`,
+				`DROP FUNCTION IF EXISTS stats_summarize(INTEGER, TIMESTAMP WITH TIME ZONE)`,
+				`DROP FUNCTION IF EXISTS stats_truncate()`,
+				`DROP TABLE IF EXISTS stats_hourly`,
+				`DROP TABLE IF EXISTS stats_daily`,
+				`DROP TABLE IF EXISTS logs`,
+				`DROP TABLE IF EXISTS audit`,
+				`DROP TABLE IF EXISTS migrations`,
+			} {
+				if _, err := db.Exec(drop); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+		return storage
+	})
+}
@@ -1,9 +1,11 @@
-//+build integration
+//go:build integration
+// +build integration
 
 package stats
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"reflect"
 	"testing"
@@ -28,14 +30,21 @@ func pgTest(f func(t *testing.T, s *pgStorage)) (string, func(*testing.T)) {
 		pg := storage.(*pgStorage)
 		defer func() {
 			errs := []error{}
-			if _, err := pg.db.Exec(`drop table if exists stats`); err != nil {
-				errs = append(errs, err)
-			}
-			if _, err := pg.db.Exec(`drop table if exists logs`); err != nil {
-				errs = append(errs, err)
-			}
-			if _, err := pg.db.Exec(`drop table if exists migrations`); err != nil {
-				errs = append(errs, err)
+			for _, drop := range []string{
+				`drop table if exists stats`,
+				`drop function if exists stats_aggregate()`,
+				`drop function if exists stats_reaggregate()`,
+				`drop function if exists stats_summarize(integer, timestamp with time zone)`,
+				`drop function if exists stats_truncate()`,
+				`drop table if exists stats_hourly`,
+				`drop table if exists stats_daily`,
+				`drop table if exists logs`,
+				`drop table if exists audit`,
+				`drop table if exists migrations`,
+			} {
+				if _, err := pg.db.Exec(drop); err != nil {
+					errs = append(errs, err)
+				}
 			}
 			if err := storage.Close(); err != nil {
 				errs = append(errs, err)
@@ -64,6 +73,8 @@ func TestPostgresStorage(t *testing.T) {
 	t.Run("Storage", func(t *testing.T) {
 		t.Run(pgTest(pg_RecordLatestFetch))
 		t.Run(pgTest(pg_Logging))
+		t.Run(pgTest(pg_Aggregates))
+		t.Run(pgTest(pg_AggregatesChanged))
 	})
 }
 
@@ -73,7 +84,7 @@ func pg_FullMigration(t *testing.T, s *pgStorage) {
 		t.Fatal(err)
 	}
 
-	if version != versionSqliteLatest {
+	if version != versionPgLatest {
 		t.Error("migrations not correctly or fully run")
 	}
 }
@@ -160,3 +171,94 @@ func pg_Logging(t *testing.T, s *pgStorage) {
 		t.Fatalf("unexpected log entries\nneed: %#v\nhave: %#v", log, logs[0])
 	}
 }
+
+// expectPgAggregates checks that the aggregates of the storage summarize
+// the same stats as the raw stats recorded in need, in every kind of bucket
+func expectPgAggregates(t *testing.T, s *pgStorage, need []Stat, start, end time.Time) {
+	t.Helper()
+	for _, bucket := range []time.Duration{30 * time.Minute, time.Hour, 3 * time.Hour, 24 * time.Hour} {
+		fake := NewFakeStatsStorage(1000)
+		if _, err := fake.RecordBatch(need); err != nil {
+			t.Fatal(err)
+		}
+		want, err := AggregateStats(fake, StatTypeTemperature, start, end, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have, err := s.Aggregate(StatTypeTemperature, start, end, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(have) != len(want) {
+			t.Fatalf("%s: expected %d aggregates, got %d", bucket, len(want), len(have))
+		}
+		for i := range want {
+			if !have[i].Start.Equal(want[i].Start) || have[i].Min != want[i].Min || have[i].Max != want[i].Max ||
+				have[i].Count != want[i].Count || math.Abs(have[i].Avg-want[i].Avg) > 1e-9 {
+				t.Fatalf("%s: unexpected aggregate at %d\nneed: %#v\nhave: %#v", bucket, i, want[i], have[i])
+			}
+		}
+	}
+}
+
+// aggregatesBatch returns two temperatures every hour for two days starting at day
+func aggregatesBatch(day time.Time) []Stat {
+	var batch []Stat
+	for i := 0; i < 48; i++ {
+		when := day.Add(time.Duration(i)*time.Hour + 15*time.Minute)
+		batch = append(batch,
+			Stat{StatType: StatTypeTemperature, When: when, Value: float64(i)},
+			Stat{StatType: StatTypeTemperature, When: when.Add(30 * time.Minute), Value: float64(i) + 0.5},
+		)
+	}
+	return batch
+}
+
+func pg_Aggregates(t *testing.T, s *pgStorage) {
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2)
+	batch := aggregatesBatch(day)
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the hourly and daily aggregates summarize the same stats as the raw stats
+	expectPgAggregates(t, s, batch, day, day.Add(47*time.Hour))
+}
+
+func pg_AggregatesChanged(t *testing.T, s *pgStorage) {
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -2)
+	batch := aggregatesBatch(day)
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole of the fourth hour and the highest stat of the first day are deleted
+	var kept []Stat
+	for _, stat := range batch {
+		hour := stat.When.Sub(day) / time.Hour
+		if hour != 3 && stat.Value != 23.5 {
+			kept = append(kept, stat)
+		}
+	}
+	if _, err := s.db.Exec(`DELETE FROM stats WHERE stat = $1 AND ((timestamp >= $2 AND timestamp < $3) OR value = 23.5)`,
+		StatTypeTemperature, day.Add(3*time.Hour), day.Add(4*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	expectPgAggregates(t, s, kept, day, day.Add(47*time.Hour))
+
+	// the lowest stat is raised above every other, and the next lowest moved into another day
+	kept[0].Value = 100
+	kept[1].When = kept[1].When.Add(24*time.Hour + 5*time.Minute)
+	if _, err := s.db.Exec(`UPDATE stats SET value = 100 WHERE stat = $1 AND value = 0`, StatTypeTemperature); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`UPDATE stats SET timestamp = timestamp + INTERVAL '1 day 5 minutes' WHERE stat = $1 AND value = 0.5`, StatTypeTemperature); err != nil {
+		t.Fatal(err)
+	}
+	expectPgAggregates(t, s, kept, day, day.Add(47*time.Hour))
+
+	if _, err := s.db.Exec(`TRUNCATE stats`); err != nil {
+		t.Fatal(err)
+	}
+	expectPgAggregates(t, s, nil, day, day.Add(47*time.Hour))
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/explodes/greenhouse-pi/clock"
 	"github.com/explodes/greenhouse-pi/logging"
 )
 
@@ -14,7 +15,8 @@ const (
 )
 
 type sqliteStorage struct {
-	db *sql.DB
+	db    *sql.DB
+	clock clock.Clock
 }
 
 func NewSqliteStorage(conn string) (Storage, error) {
@@ -23,7 +25,8 @@ func NewSqliteStorage(conn string) (Storage, error) {
 		return nil, fmt.Errorf("error opening database storage: %v", err)
 	}
 	storage := &sqliteStorage{
-		db: db,
+		db:    db,
+		clock: clock.Real,
 	}
 	if err := storage.migrate(); err != nil {
 		return nil, fmt.Errorf("error preparing database storage: %v", err)
@@ -31,6 +34,10 @@ func NewSqliteStorage(conn string) (Storage, error) {
 	return storage, nil
 }
 
+func (ss *sqliteStorage) SetClock(c clock.Clock) {
+	ss.clock = c
+}
+
 func (ss *sqliteStorage) migrate() error {
 	return migrateSqliteDatabase(ss.db)
 }
@@ -40,12 +47,57 @@ func (ss *sqliteStorage) Record(stat Stat) error {
 	return err
 }
 
+func (ss *sqliteStorage) RecordBatch(stats []Stat) (int, error) {
+	return ss.recordBatch(stats, `INSERT INTO stats (stat, value, nanostamp) SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM stats WHERE stat = $1 AND nanostamp = $3)`)
+}
+
+// insertBatch records every Stat in a single transaction, even those
+// with the same type and time as a Stat already recorded
+func (ss *sqliteStorage) insertBatch(stats []Stat) error {
+	_, err := ss.recordBatch(stats, `INSERT INTO stats (stat, value, nanostamp) VALUES($1, $2, $3)`)
+	return err
+}
+
+// recordBatch records stats in a single transaction with the given
+// statement, returning how many rows it inserted
+func (ss *sqliteStorage) recordBatch(stats []Stat, query string) (int, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning batch: %v", err)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error preparing batch: %v", err)
+	}
+	defer stmt.Close()
+
+	recorded := 0
+	for _, stat := range stats {
+		result, err := stmt.Exec(stat.StatType, stat.Value, stat.When.UnixNano())
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		recorded += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing batch: %v", err)
+	}
+	return recorded, nil
+}
+
 func (ss *sqliteStorage) Fetch(statType StatType, start, end time.Time) ([]Stat, error) {
 	scan := struct {
 		value     float64
 		nanostamp int64
 	}{}
-	rows, err := ss.db.Query(`SELECT value, nanostamp FROM stats WHERE stat = $1 AND nanostamp > $2 AND nanostamp < $3 ORDER BY nanostamp DESC LIMIT 1000`, statType, start.UnixNano(), end.UnixNano())
+	rows, err := ss.db.Query(`SELECT value, nanostamp FROM (SELECT id, value, nanostamp FROM stats WHERE stat = $1 AND nanostamp >= $2 AND nanostamp <= $3 ORDER BY nanostamp DESC, id DESC LIMIT 1000) ORDER BY nanostamp, id`, statType, start.UnixNano(), end.UnixNano())
 	if err != nil {
 		return nil, fmt.Errorf("error fetching stats: %v", err)
 	}
@@ -63,6 +115,9 @@ func (ss *sqliteStorage) Fetch(statType StatType, start, end time.Time) ([]Stat,
 		}
 		results = append(results, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning stats: %v", err)
+	}
 	return results, nil
 }
 
@@ -71,10 +126,13 @@ func (ss *sqliteStorage) Latest(statType StatType) (Stat, error) {
 		value     float64
 		nanostamp int64
 	}{}
-	err := ss.db.QueryRow(`SELECT value, nanostamp FROM stats WHERE stat = $1 ORDER BY nanostamp DESC LIMIT 1`, statType).Scan(&scan.value, &scan.nanostamp)
+	err := ss.db.QueryRow(`SELECT value, nanostamp FROM stats WHERE stat = $1 ORDER BY nanostamp DESC, id DESC LIMIT 1`, statType).Scan(&scan.value, &scan.nanostamp)
 	if err == sql.ErrNoRows {
 		return Stat{}, ErrNoStats
 	}
+	if err != nil {
+		return Stat{}, fmt.Errorf("error fetching latest stat: %v", err)
+	}
 	stat := Stat{
 		StatType: statType,
 		When:     time.Unix(0, scan.nanostamp),
@@ -87,7 +145,7 @@ func (ss *sqliteStorage) Log(level logging.Level, format string, args ...interfa
 	entry := logging.LogEntry{
 		Message: fmt.Sprintf(format, args...),
 		Level:   level,
-		When:    time.Now(),
+		When:    ss.clock.Now(),
 	}
 	log.Println(entry.Message)
 	if _, err := ss.db.Exec(`INSERT INTO logs (message, nanostamp, level) VALUES($1, $2, $3)`, entry.Message, entry.When.UnixNano(), entry.Level); err != nil {
@@ -102,7 +160,7 @@ func (ss *sqliteStorage) Logs(level logging.Level, start, end time.Time) ([]logg
 		message   string
 		nanostamp int64
 	}{}
-	rows, err := ss.db.Query(`SELECT message, nanostamp, level FROM logs WHERE level >= $1 AND nanostamp > $2 AND nanostamp < $3 ORDER BY nanostamp DESC LIMIT 1000`, level, start.UnixNano(), end.UnixNano())
+	rows, err := ss.db.Query(`SELECT message, nanostamp, level FROM (SELECT id, message, nanostamp, level FROM logs WHERE level >= $1 AND nanostamp >= $2 AND nanostamp <= $3 ORDER BY nanostamp DESC, id DESC LIMIT 1000) ORDER BY nanostamp, id`, level, start.UnixNano(), end.UnixNano())
 	if err != nil {
 		return nil, fmt.Errorf("error fetching logs: %v", err)
 	}
@@ -120,6 +178,146 @@ func (ss *sqliteStorage) Logs(level logging.Level, start, end time.Time) ([]logg
 		}
 		results = append(results, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning logs: %v", err)
+	}
+	return results, nil
+}
+
+func (ss *sqliteStorage) RecordLogs(entries []logging.LogEntry) (int, error) {
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error beginning batch: %v", err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO logs (message, nanostamp, level) SELECT $1, $2, $3 WHERE NOT EXISTS (SELECT 1 FROM logs WHERE nanostamp = $2 AND level = $3 AND message = $1)`)
+	if err != nil {
+		tx.Rollback()
+		return 0, fmt.Errorf("error preparing batch: %v", err)
+	}
+	defer stmt.Close()
+
+	recorded := 0
+	for _, entry := range entries {
+		result, err := stmt.Exec(entry.Message, entry.When.UnixNano(), entry.Level)
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return 0, fmt.Errorf("error recording batch: %v", err)
+		}
+		recorded += int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing batch: %v", err)
+	}
+	return recorded, nil
+}
+
+func (ss *sqliteStorage) EachStat(statTypes []StatType, start, end time.Time, fn func(Stat) error) error {
+	if len(statTypes) == 0 {
+		return nil
+	}
+	placeholders, statArgs := statTypesPlaceholders(statTypes, 3)
+	query := fmt.Sprintf(`SELECT id, stat, value, nanostamp FROM stats WHERE (nanostamp > $1 OR (nanostamp = $1 AND id > $2)) AND nanostamp <= $3 AND stat IN (%s) ORDER BY nanostamp, id LIMIT %d`, placeholders, eachPageSize)
+
+	// pages continue after the last row of the previous page
+	lastStamp, lastID := start.UnixNano(), int64(-1)
+	for {
+		page := make([]Stat, 0, eachPageSize)
+		args := append([]interface{}{lastStamp, lastID, end.UnixNano()}, statArgs...)
+		rows, err := ss.db.Query(query, args...)
+		if err != nil {
+			return fmt.Errorf("error fetching stats: %v", err)
+		}
+		for rows.Next() {
+			var stat Stat
+			if err := rows.Scan(&lastID, &stat.StatType, &stat.Value, &lastStamp); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning stats: %v", err)
+			}
+			stat.When = time.Unix(0, lastStamp)
+			page = append(page, stat)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("error fetching stats: %v", err)
+		}
+
+		for _, stat := range page {
+			if err := fn(stat); err != nil {
+				return err
+			}
+		}
+		if len(page) < eachPageSize {
+			return nil
+		}
+	}
+}
+
+func (ss *sqliteStorage) EachLog(level logging.Level, start, end time.Time, fn func(logging.LogEntry) error) error {
+	query := fmt.Sprintf(`SELECT id, message, nanostamp, level FROM logs WHERE (nanostamp > $1 OR (nanostamp = $1 AND id > $2)) AND nanostamp <= $3 AND level >= $4 ORDER BY nanostamp, id LIMIT %d`, eachPageSize)
+
+	// pages continue after the last row of the previous page
+	lastStamp, lastID := start.UnixNano(), int64(-1)
+	for {
+		page := make([]logging.LogEntry, 0, eachPageSize)
+		rows, err := ss.db.Query(query, lastStamp, lastID, end.UnixNano(), level)
+		if err != nil {
+			return fmt.Errorf("error fetching logs: %v", err)
+		}
+		for rows.Next() {
+			var entry logging.LogEntry
+			if err := rows.Scan(&lastID, &entry.Message, &lastStamp, &entry.Level); err != nil {
+				rows.Close()
+				return fmt.Errorf("error scanning logs: %v", err)
+			}
+			entry.When = time.Unix(0, lastStamp)
+			page = append(page, entry)
+		}
+		if err := rows.Close(); err != nil {
+			return fmt.Errorf("error fetching logs: %v", err)
+		}
+
+		for _, entry := range page {
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		if len(page) < eachPageSize {
+			return nil
+		}
+	}
+}
+
+func (ss *sqliteStorage) Audit(entry AuditEntry) error {
+	_, err := ss.db.Exec(`INSERT INTO audit (nanostamp, unit, cause, source, requested, actual, error) VALUES($1, $2, $3, $4, $5, $6, $7)`,
+		entry.When.UnixNano(), entry.Unit, entry.Cause, entry.Source, entry.Requested, entry.Actual, entry.Error)
+	return err
+}
+
+func (ss *sqliteStorage) AuditTrail(filter AuditFilter) ([]AuditEntry, error) {
+	rows, err := ss.db.Query(`SELECT nanostamp, unit, cause, source, requested, actual, error FROM audit WHERE nanostamp >= $1 AND nanostamp <= $2 AND ($3 = '' OR unit = $3) AND ($4 = '' OR cause = $4) ORDER BY nanostamp DESC, id DESC LIMIT 1000`,
+		filter.Start.UnixNano(), filter.End.UnixNano(), filter.Unit, filter.Cause)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching audit trail: %v", err)
+	}
+	defer rows.Close()
+
+	results := make([]AuditEntry, 0, 100)
+	for rows.Next() {
+		var entry AuditEntry
+		var nanostamp int64
+		if err := rows.Scan(&nanostamp, &entry.Unit, &entry.Cause, &entry.Source, &entry.Requested, &entry.Actual, &entry.Error); err != nil {
+			return nil, fmt.Errorf("error scanning audit trail: %v", err)
+		}
+		entry.When = time.Unix(0, nanostamp)
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error scanning audit trail: %v", err)
+	}
 	return results, nil
 }
 
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 	"time"
@@ -36,6 +37,13 @@ func TestSqliteStorage(t *testing.T) {
 		t.Parallel()
 		t.Run(sqliteTest(sqlite_RecordLatestFetch))
 		t.Run(sqliteTest(sqlite_Logging))
+		t.Run(sqliteTest(sqlite_Audit))
+		t.Run(sqliteTest(sqlite_FetchAll))
+		t.Run(sqliteTest(sqlite_EachStat))
+		t.Run(sqliteTest(sqlite_EachStatStops))
+		t.Run(sqliteTest(sqlite_EachLog))
+		t.Run(sqliteTest(sqlite_RecordBatch))
+		t.Run(sqliteTest(sqlite_RecordLogs))
 	})
 }
 
@@ -120,3 +128,202 @@ func sqlite_Logging(t *testing.T, s *sqliteStorage) {
 		t.Fatalf("unexpected log entries\nneed: %#v\nhave: %#v", log, logs[0])
 	}
 }
+
+func sqlite_Audit(t *testing.T, s *sqliteStorage) {
+	now := time.Unix(0, time.Now().UnixNano())
+	water := AuditEntry{When: now.Add(-time.Minute), Unit: "water", Cause: AuditCauseSchedule, Source: "1 (api admin)", Requested: "on", Actual: "on"}
+	fan := AuditEntry{When: now, Unit: "fan", Cause: AuditCauseAPI, Source: "admin", Requested: "on", Actual: "off", Error: "interlocked"}
+	for _, entry := range []AuditEntry{water, fan} {
+		if err := s.Audit(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trail, err := s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{fan, water}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{fan, water}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now, Unit: "water"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{water}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{water}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-time.Hour), End: now, Cause: AuditCauseAPI})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]AuditEntry{fan}, trail) {
+		t.Fatalf("unexpected audit trail\nneed: %#v\nhave: %#v", []AuditEntry{fan}, trail)
+	}
+
+	trail, err = s.AuditTrail(AuditFilter{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trail) != 0 {
+		t.Fatalf("unexpected audit trail: %#v", trail)
+	}
+}
+
+func sqlite_FetchAll(t *testing.T, s *sqliteStorage) {
+	start := time.Now().Add(-time.Hour)
+	count := fetchLimit + fetchLimit/2
+	for i := 0; i < count; i++ {
+		if err := s.Record(Stat{StatType: StatTypeLight, Value: float64(i), When: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := FetchAll(s, StatTypeLight, start.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != count {
+		t.Fatalf("expected %d stats, got %d", count, len(all))
+	}
+	for i, stat := range all {
+		if stat.Value != float64(i) {
+			t.Fatalf("stats out of order at %d: %g", i, stat.Value)
+		}
+	}
+}
+
+func sqlite_EachStat(t *testing.T, s *sqliteStorage) {
+	start := time.Now().Add(-time.Hour)
+	count := eachPageSize + eachPageSize/2
+	for i := 0; i < count; i++ {
+		statType := StatTypeTemperature
+		if i%2 == 1 {
+			statType = StatTypeHumidity
+		}
+		// pairs of stats share a time, across page boundaries too
+		when := start.Add(time.Duration(i/2) * time.Second)
+		if err := s.Record(Stat{StatType: statType, Value: float64(i), When: when}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Record(Stat{StatType: StatTypeFan, Value: -1, When: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	var all []Stat
+	err := s.EachStat([]StatType{StatTypeTemperature, StatTypeHumidity}, start, start.Add(time.Duration(count/2-1)*time.Second), func(stat Stat) error {
+		all = append(all, stat)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != count {
+		t.Fatalf("expected %d stats, got %d", count, len(all))
+	}
+	for i, stat := range all {
+		if stat.Value != float64(i) {
+			t.Fatalf("stats out of order at %d: %g", i, stat.Value)
+		}
+	}
+}
+
+func sqlite_EachStatStops(t *testing.T, s *sqliteStorage) {
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := s.Record(Stat{StatType: StatTypeLight, Value: float64(i), When: start.Add(time.Duration(i) * time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := s.EachStat([]StatType{StatTypeLight}, start, time.Now(), func(stat Stat) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func sqlite_EachLog(t *testing.T, s *sqliteStorage) {
+	start := time.Now().Add(-time.Second)
+	s.Log(logging.LevelDebug, "debug")
+	s.Log(logging.LevelError, "first")
+	s.Log(logging.LevelWarn, "second")
+
+	var messages []string
+	err := s.EachLog(logging.LevelWarn, start, time.Now(), func(entry logging.LogEntry) error {
+		messages = append(messages, entry.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(messages, []string{"first", "second"}) {
+		t.Errorf("unexpected log entries: %v", messages)
+	}
+}
+
+func sqlite_RecordBatch(t *testing.T, s *sqliteStorage) {
+	when := time.Now().Add(-time.Minute)
+	if err := s.Record(Stat{StatType: StatTypeCO2, Value: 400, When: when}); err != nil {
+		t.Fatal(err)
+	}
+
+	recorded, err := s.RecordBatch([]Stat{
+		{StatType: StatTypeCO2, Value: 500, When: when},
+		{StatType: StatTypeCO2, Value: 600, When: when.Add(time.Second)},
+		{StatType: StatTypeCO2, Value: 600, When: when.Add(time.Second)},
+		{StatType: StatTypeVPD, Value: 1, When: when},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 stats recorded, got %d", recorded)
+	}
+
+	all, err := FetchAll(s, StatTypeCO2, when.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Value != 400 || all[1].Value != 600 {
+		t.Errorf("unexpected stats: %v", all)
+	}
+}
+
+func sqlite_RecordLogs(t *testing.T, s *sqliteStorage) {
+	when := time.Now().Add(-time.Minute)
+	recorded, err := s.RecordLogs([]logging.LogEntry{
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelError, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when.Add(time.Second), Message: "second"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 3 {
+		t.Errorf("expected 3 log entries recorded, got %d", recorded)
+	}
+
+	var entries []logging.LogEntry
+	err = s.EachLog(logging.LevelDebug, when, time.Now(), func(entry logging.LogEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || !entries[0].When.Equal(when) || entries[2].Message != "second" {
+		t.Errorf("unexpected log entries: %v", entries)
+	}
+}
@@ -0,0 +1,443 @@
+// Package storagetest checks that a stats.Storage keeps the
+// contract documented on stats.Storage
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/logging"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// limit is the most rows Fetch and Logs return at once
+	limit = 1000
+
+	// pageSize is more rows than EachStat and EachLog read at once
+	pageSize = 1500
+)
+
+// Factory creates an empty Storage for a single test. Anything that
+// needs to be cleaned up afterwards should be registered with t.Cleanup.
+type Factory func(t *testing.T) stats.Storage
+
+// Run runs every conformance test against Storages created by factory.
+// The tests run one at a time, so a factory may reuse a single database.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s stats.Storage)
+	}{
+		{"LatestEmpty", latestEmpty},
+		{"Latest", latest},
+		{"LatestTie", latestTie},
+		{"FetchBounds", fetchBounds},
+		{"FetchLimit", fetchLimit},
+		{"RecordBatch", recordBatch},
+		{"Log", logRecorded},
+		{"LogClock", logClock},
+		{"LogsBounds", logsBounds},
+		{"LogsLimit", logsLimit},
+		{"RecordLogs", recordLogs},
+		{"EachStat", eachStat},
+		{"EachStatStops", eachStatStops},
+		{"FetchAll", fetchAll},
+		{"EachLog", eachLog},
+		{"AuditTrail", auditTrail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+// base returns a time an hour ago at a precision every Storage keeps
+func base() time.Time {
+	return time.Now().Add(-time.Hour).Truncate(time.Second)
+}
+
+func record(t *testing.T, s stats.Storage, all ...stats.Stat) {
+	t.Helper()
+	for _, stat := range all {
+		if err := s.Record(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectStats(t *testing.T, have []stats.Stat, need ...stats.Stat) {
+	t.Helper()
+	if len(have) != len(need) {
+		t.Fatalf("expected %d stats, got %d: %v", len(need), len(have), have)
+	}
+	for i := range need {
+		if have[i].StatType != need[i].StatType || !have[i].When.Equal(need[i].When) || have[i].Value != need[i].Value {
+			t.Fatalf("unexpected stat at %d\nneed: %v\nhave: %v", i, need[i], have[i])
+		}
+	}
+}
+
+func expectLogs(t *testing.T, have []logging.LogEntry, need ...logging.LogEntry) {
+	t.Helper()
+	if len(have) != len(need) {
+		t.Fatalf("expected %d log entries, got %d: %v", len(need), len(have), have)
+	}
+	for i := range need {
+		if have[i].Level != need[i].Level || !have[i].When.Equal(need[i].When) || have[i].Message != need[i].Message {
+			t.Fatalf("unexpected log entry at %d\nneed: %v\nhave: %v", i, need[i], have[i])
+		}
+	}
+}
+
+func latestEmpty(t *testing.T, s stats.Storage) {
+	if _, err := s.Latest(stats.StatTypeWater); err != stats.ErrNoStats {
+		t.Errorf("expected ErrNoStats, got %v", err)
+	}
+}
+
+func latest(t *testing.T, s stats.Storage) {
+	when := base()
+	newest := stats.Stat{StatType: stats.StatTypeWater, When: when.Add(2 * time.Second), Value: 2}
+	record(t, s,
+		stats.Stat{StatType: stats.StatTypeWater, When: when, Value: 0},
+		newest,
+		stats.Stat{StatType: stats.StatTypeWater, When: when.Add(time.Second), Value: 1},
+		stats.Stat{StatType: stats.StatTypeFan, When: when.Add(time.Minute), Value: 1},
+	)
+
+	stat, err := s.Latest(stats.StatTypeWater)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, []stats.Stat{stat}, newest)
+}
+
+func latestTie(t *testing.T, s stats.Storage) {
+	when := base()
+	last := stats.Stat{StatType: stats.StatTypeWater, When: when, Value: 2}
+	record(t, s, stats.Stat{StatType: stats.StatTypeWater, When: when, Value: 1}, last)
+
+	stat, err := s.Latest(stats.StatTypeWater)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, []stats.Stat{stat}, last)
+}
+
+func fetchBounds(t *testing.T, s stats.Storage) {
+	when := base()
+	var all []stats.Stat
+	for i := 0; i < 4; i++ {
+		all = append(all, stats.Stat{StatType: stats.StatTypeLight, When: when.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+	// recorded out of order, along with another type
+	record(t, s, all[3], all[1], all[0], all[2])
+	record(t, s, stats.Stat{StatType: stats.StatTypeFan, When: when.Add(time.Second), Value: 1})
+
+	fetched, err := s.Fetch(stats.StatTypeLight, all[1].When, all[2].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, fetched, all[1], all[2])
+
+	fetched, err = s.Fetch(stats.StatTypeLight, when.Add(-time.Hour), when.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, fetched)
+}
+
+func fetchLimit(t *testing.T, s stats.Storage) {
+	when := base()
+	batch := make([]stats.Stat, limit+limit/2)
+	for i := range batch {
+		batch[i] = stats.Stat{StatType: stats.StatTypeLight, When: when.Add(time.Duration(i) * time.Second), Value: float64(i)}
+	}
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	fetched, err := s.Fetch(stats.StatTypeLight, when, batch[len(batch)-1].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the most recent of them, in chronological order
+	expectStats(t, fetched, batch[len(batch)-limit:]...)
+}
+
+func recordBatch(t *testing.T, s stats.Storage) {
+	when := base()
+	first := stats.Stat{StatType: stats.StatTypeCO2, When: when, Value: 400}
+	record(t, s, first)
+
+	second := stats.Stat{StatType: stats.StatTypeCO2, When: when.Add(time.Second), Value: 600}
+	recorded, err := s.RecordBatch([]stats.Stat{
+		{StatType: stats.StatTypeCO2, When: when, Value: 500},
+		second,
+		{StatType: stats.StatTypeCO2, When: when.Add(time.Second), Value: 700},
+		{StatType: stats.StatTypeVPD, When: when, Value: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 2 {
+		t.Errorf("expected 2 stats recorded, got %d", recorded)
+	}
+
+	fetched, err := s.Fetch(stats.StatTypeCO2, when, when.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, fetched, first, second)
+}
+
+func logRecorded(t *testing.T, s stats.Storage) {
+	entry, err := s.Log(logging.LevelWarn, "hello %s", "world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Level != logging.LevelWarn || entry.Message != "hello world" {
+		t.Fatalf("unexpected log entry: %v", entry)
+	}
+
+	logs, err := s.Logs(logging.LevelDebug, entry.When.Add(-time.Second), entry.When.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Level != entry.Level || logs[0].Message != entry.Message {
+		t.Fatalf("unexpected log entries: %v", logs)
+	}
+	// some storages keep times at a lower precision
+	if skew := logs[0].When.Sub(entry.When); skew < -time.Microsecond || skew > time.Microsecond {
+		t.Errorf("log entry recorded at %s, expected %s", logs[0].When, entry.When)
+	}
+}
+
+func logClock(t *testing.T, s stats.Storage) {
+	clocked, ok := s.(stats.Clocked)
+	if !ok {
+		t.Skip("storage is not clocked")
+	}
+	when := base()
+	clocked.SetClock(clock.NewManual(when))
+
+	entry, err := s.Log(logging.LevelInfo, "then")
+	if err != nil {
+		t.Fatal(err)
+	}
+	need := logging.LogEntry{Level: logging.LevelInfo, When: when, Message: "then"}
+	expectLogs(t, []logging.LogEntry{entry}, need)
+
+	logs, err := s.Logs(logging.LevelDebug, when, when)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogs(t, logs, need)
+}
+
+func logsBounds(t *testing.T, s stats.Storage) {
+	when := base()
+	entries := []logging.LogEntry{
+		{Level: logging.LevelDebug, When: when, Message: "debug"},
+		{Level: logging.LevelInfo, When: when.Add(time.Second), Message: "info"},
+		{Level: logging.LevelError, When: when.Add(2 * time.Second), Message: "error"},
+		{Level: logging.LevelWarn, When: when.Add(3 * time.Second), Message: "warn"},
+	}
+	if _, err := s.RecordLogs([]logging.LogEntry{entries[3], entries[1], entries[0], entries[2]}); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := s.Logs(logging.LevelDebug, entries[1].When, entries[2].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogs(t, logs, entries[1], entries[2])
+
+	logs, err = s.Logs(logging.LevelWarn, when, entries[3].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogs(t, logs, entries[2], entries[3])
+}
+
+func logsLimit(t *testing.T, s stats.Storage) {
+	when := base()
+	entries := make([]logging.LogEntry, limit+limit/2)
+	for i := range entries {
+		entries[i] = logging.LogEntry{Level: logging.LevelInfo, When: when.Add(time.Duration(i) * time.Second), Message: fmt.Sprintf("entry %d", i)}
+	}
+	if _, err := s.RecordLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	logs, err := s.Logs(logging.LevelDebug, when, entries[len(entries)-1].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the most recent of them, in chronological order
+	expectLogs(t, logs, entries[len(entries)-limit:]...)
+}
+
+func recordLogs(t *testing.T, s stats.Storage) {
+	when := base()
+	entries := []logging.LogEntry{
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when, Message: "first"},
+		{Level: logging.LevelError, When: when, Message: "first"},
+		{Level: logging.LevelInfo, When: when.Add(time.Second), Message: "second"},
+	}
+	recorded, err := s.RecordLogs(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 3 {
+		t.Errorf("expected 3 log entries recorded, got %d", recorded)
+	}
+	recorded, err = s.RecordLogs(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recorded != 0 {
+		t.Errorf("expected no log entries recorded again, got %d", recorded)
+	}
+
+	logs, err := s.Logs(logging.LevelDebug, when, when.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogs(t, logs, entries[0], entries[2], entries[3])
+}
+
+func eachStat(t *testing.T, s stats.Storage) {
+	when := base()
+	batch := make([]stats.Stat, pageSize)
+	for i := range batch {
+		statType := stats.StatTypeTemperature
+		if i%2 == 1 {
+			statType = stats.StatTypeHumidity
+		}
+		// pairs of stats share a time, across page boundaries too
+		batch[i] = stats.Stat{StatType: statType, When: when.Add(time.Duration(i/2) * time.Second), Value: float64(i)}
+	}
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	record(t, s, stats.Stat{StatType: stats.StatTypeFan, When: when, Value: -1})
+
+	var all []stats.Stat
+	err := s.EachStat([]stats.StatType{stats.StatTypeTemperature, stats.StatTypeHumidity}, when, batch[len(batch)-1].When, func(stat stats.Stat) error {
+		all = append(all, stat)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, all, batch...)
+}
+
+func eachStatStops(t *testing.T, s stats.Storage) {
+	when := base()
+	for i := 0; i < 3; i++ {
+		record(t, s, stats.Stat{StatType: stats.StatTypeLight, When: when.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := s.EachStat([]stats.StatType{stats.StatTypeLight}, when, when.Add(time.Minute), func(stat stats.Stat) error {
+		calls++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+func fetchAll(t *testing.T, s stats.Storage) {
+	when := base()
+	batch := make([]stats.Stat, pageSize)
+	for i := range batch {
+		// pairs of stats share a time, across the limit of Fetch too
+		batch[i] = stats.Stat{StatType: stats.StatTypeWater, When: when.Add(time.Duration(i/2) * time.Second), Value: float64(i)}
+	}
+	record(t, s, batch...)
+
+	all, err := stats.FetchAll(s, stats.StatTypeWater, when, batch[len(batch)-1].When)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStats(t, all, batch...)
+}
+
+func eachLog(t *testing.T, s stats.Storage) {
+	when := base()
+	entries := make([]logging.LogEntry, pageSize+1)
+	for i := range entries {
+		level := logging.LevelDebug
+		if i%3 == 0 {
+			level = logging.LevelError
+		}
+		entries[i] = logging.LogEntry{Level: level, When: when.Add(time.Duration(i) * time.Millisecond), Message: fmt.Sprintf("entry %d", i)}
+	}
+	if _, err := s.RecordLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// every third entry is an error, including those at the bounds
+	var need []logging.LogEntry
+	for i := 3; i < len(entries); i += 3 {
+		need = append(need, entries[i])
+	}
+
+	var all []logging.LogEntry
+	err := s.EachLog(logging.LevelError, entries[3].When, entries[len(entries)-1].When, func(entry logging.LogEntry) error {
+		all = append(all, entry)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectLogs(t, all, need...)
+}
+
+func auditTrail(t *testing.T, s stats.Storage) {
+	when := base()
+	water := stats.AuditEntry{When: when.Add(-time.Minute), Unit: "water", Cause: stats.AuditCauseSchedule, Source: "1 (api admin)", Requested: "on", Actual: "on"}
+	fan := stats.AuditEntry{When: when, Unit: "fan", Cause: stats.AuditCauseAPI, Source: "admin", Requested: "on", Actual: "off", Error: "interlocked"}
+	for _, entry := range []stats.AuditEntry{fan, water} {
+		if err := s.Audit(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expect := func(filter stats.AuditFilter, need ...stats.AuditEntry) {
+		t.Helper()
+		trail, err := s.AuditTrail(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(trail) != len(need) {
+			t.Fatalf("expected %d audit entries, got %d: %v", len(need), len(trail), trail)
+		}
+		for i := range need {
+			have := trail[i]
+			have.When = need[i].When
+			if !trail[i].When.Equal(need[i].When) || have != need[i] {
+				t.Fatalf("unexpected audit entry at %d\nneed: %v\nhave: %v", i, need[i], trail[i])
+			}
+		}
+	}
+
+	// most recent first, including the bounds
+	expect(stats.AuditFilter{Start: water.When, End: fan.When}, fan, water)
+	expect(stats.AuditFilter{Start: water.When, End: fan.When, Unit: "water"}, water)
+	expect(stats.AuditFilter{Start: water.When, End: fan.When, Cause: stats.AuditCauseAPI}, fan)
+	expect(stats.AuditFilter{Start: when.Add(-time.Hour), End: water.When.Add(-time.Second)})
+}
@@ -0,0 +1,357 @@
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/logging"
+)
+
+const (
+	// DefaultTransferBatchSize is how many rows a Transfer writes per transaction
+	DefaultTransferBatchSize = 1000
+)
+
+var (
+	// transferStart is the earliest time a Transfer copies from
+	transferStart = time.Unix(0, 0)
+)
+
+// TimePrecision returns the resolution a Storage keeps times at
+func TimePrecision(storage Storage) time.Duration {
+	if _, ok := storage.(*pgStorage); ok {
+		return time.Microsecond
+	}
+	return time.Nanosecond
+}
+
+// batchInserter is a Storage that can record a batch of stats in a single
+// transaction without skipping those that share a time, as RecordBatch does
+type batchInserter interface {
+	insertBatch(stats []Stat) error
+}
+
+// Transfer copies every stat and log entry from one Storage to another.
+// Times are truncated to the precision of the destination. A Transfer can
+// be run again after being interrupted, it resumes after the rows already
+// in the destination.
+//
+// Every stat is copied, including those that share a time. Stats are copied
+// in order, so the destination has a stat type's stats up to its latest time,
+// and as many of those at that time as it holds, which is where a Transfer
+// resumes. Log entries are recorded with RecordLogs, so entries with the same
+// time, level and message are copied once.
+type Transfer struct {
+	src, dst Storage
+
+	// End is the latest time rows are copied up to
+	End time.Time
+	// BatchSize is how many rows are written per transaction
+	BatchSize int
+	// Progress is optional, if set it is told about the progress of the Transfer
+	Progress func(format string, args ...interface{})
+
+	precision time.Duration
+}
+
+// NewTransfer creates a Transfer of every row recorded until now from src to dst
+func NewTransfer(src, dst Storage) *Transfer {
+	return &Transfer{
+		src:       src,
+		dst:       dst,
+		End:       time.Now(),
+		BatchSize: DefaultTransferBatchSize,
+		precision: TimePrecision(dst),
+	}
+}
+
+func (t *Transfer) progress(format string, args ...interface{}) {
+	if t.Progress != nil {
+		t.Progress(format, args...)
+	}
+}
+
+// Run copies the stats and logs
+func (t *Transfer) Run() error {
+	for _, statType := range StatTypes {
+		if err := t.copyStats(statType); err != nil {
+			return err
+		}
+	}
+	return t.copyLogs()
+}
+
+// copyStats copies the stats of a type, resuming after those in the destination
+func (t *Transfer) copyStats(statType StatType) error {
+	start, skip, err := t.resumeStats(statType)
+	if err != nil {
+		return err
+	}
+
+	read, written := 0, 0
+	batch := make([]Stat, 0, t.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := t.insert(batch); err != nil {
+			return err
+		}
+		written += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	err = t.src.EachStat([]StatType{statType}, start, t.End, func(stat Stat) error {
+		read++
+		stat.When = stat.When.Truncate(t.precision)
+		if skip > 0 && stat.When.Equal(start) {
+			// copied before the Transfer was interrupted
+			skip--
+			return nil
+		}
+		batch = append(batch, stat)
+		if len(batch) < t.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("error copying %s: %v", statType, err)
+	}
+	t.progress("%s: read %d, copied %d", statType, read, written)
+	return nil
+}
+
+// resumeStats returns the time to resume copying stats of a
+// type from, and how many stats at that time were already copied
+func (t *Transfer) resumeStats(statType StatType) (time.Time, int, error) {
+	latest, err := t.dst.Latest(statType)
+	if err == ErrNoStats {
+		return transferStart, 0, nil
+	} else if err != nil {
+		return time.Time{}, 0, fmt.Errorf("error reading latest %s of destination: %v", statType, err)
+	}
+	copied := 0
+	err = t.dst.EachStat([]StatType{statType}, latest.When, latest.When, func(Stat) error {
+		copied++
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("error counting latest %s of destination: %v", statType, err)
+	}
+	return latest.When, copied, nil
+}
+
+// insert records every Stat of a batch in the destination, in a single
+// transaction if it is a Storage of this package
+func (t *Transfer) insert(batch []Stat) error {
+	if inserter, ok := t.dst.(batchInserter); ok {
+		return inserter.insertBatch(batch)
+	}
+	for _, stat := range batch {
+		if err := t.dst.Record(stat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyLogs copies the logs, resuming from the latest in the destination
+func (t *Transfer) copyLogs() error {
+	start := transferStart
+	latest, err := latestLog(t.dst, t.End)
+	if err != nil {
+		return fmt.Errorf("error reading latest log of destination: %v", err)
+	}
+	if latest.After(start) {
+		start = latest
+	}
+
+	read, written := 0, 0
+	batch := make([]logging.LogEntry, 0, t.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := t.dst.RecordLogs(batch)
+		if err != nil {
+			return err
+		}
+		written += n
+		batch = batch[:0]
+		return nil
+	}
+
+	err = t.src.EachLog(logging.LevelDebug, start, t.End, func(entry logging.LogEntry) error {
+		read++
+		entry.When = entry.When.Truncate(t.precision)
+		batch = append(batch, entry)
+		if len(batch) < t.BatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return fmt.Errorf("error copying logs: %v", err)
+	}
+	t.progress("logs: read %d, copied %d", read, written)
+	return nil
+}
+
+// latestLog returns the time of the latest log entry of a Storage, or the zero time if there are none
+func latestLog(storage Storage, end time.Time) (time.Time, error) {
+	// Logs returns the most recent entries when there are too many
+	entries, err := storage.Logs(logging.LevelDebug, transferStart, end)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var latest time.Time
+	for _, entry := range entries {
+		if entry.When.After(latest) {
+			latest = entry.When
+		}
+	}
+	return latest, nil
+}
+
+// Summary is the number of rows of a kind and a checksum of their contents
+type Summary struct {
+	Rows     int
+	Checksum string
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d rows, checksum %s", s.Rows, s.Checksum)
+}
+
+// Verify compares the stats and logs of the source and destination, as they
+// would be after the Transfer, returning an error describing any difference.
+// Stats whose times are only the same once truncated to the precision of the
+// destination are reported, since they can no longer be told apart by time.
+func (t *Transfer) Verify() error {
+	for _, statType := range StatTypes {
+		src, merged, err := t.summarizeStats(t.src, statType)
+		if err != nil {
+			return err
+		}
+		dst, _, err := t.summarizeStats(t.dst, statType)
+		if err != nil {
+			return err
+		}
+		if src != dst {
+			return fmt.Errorf("%s differs, source has %s, destination has %s", statType, src, dst)
+		}
+		t.progress("%s: verified %s", statType, src)
+		if merged > 0 {
+			t.progress("%s: %d rows share their time with an earlier row once truncated to %s", statType, merged, t.precision)
+		}
+	}
+
+	src, err := t.summarizeLogs(t.src)
+	if err != nil {
+		return err
+	}
+	dst, err := t.summarizeLogs(t.dst)
+	if err != nil {
+		return err
+	}
+	if src != dst {
+		return fmt.Errorf("logs differ, source has %s, destination has %s", src, dst)
+	}
+	t.progress("logs: verified %s", src)
+	return nil
+}
+
+// summarizeStats summarizes every stat of a type as the destination keeps
+// them, with times truncated to its precision. It also returns how many
+// stats have the time of the stat before them only once truncated.
+func (t *Transfer) summarizeStats(storage Storage, statType StatType) (Summary, int, error) {
+	h := sha256.New()
+	rows, merged := 0, 0
+	var last time.Time
+	err := storage.EachStat([]StatType{statType}, transferStart, t.End, func(stat Stat) error {
+		when := stat.When.Truncate(t.precision)
+		if rows > 0 && when.Equal(last.Truncate(t.precision)) && !stat.When.Equal(last) {
+			merged++
+		}
+		last = stat.When
+		rows++
+		writeUint64(h, uint64(when.UnixNano()))
+		writeUint64(h, math.Float64bits(stat.Value))
+		return nil
+	})
+	if err != nil {
+		return Summary{}, 0, fmt.Errorf("error summarizing %s: %v", statType, err)
+	}
+	return Summary{Rows: rows, Checksum: hex.EncodeToString(h.Sum(nil))[:16]}, merged, nil
+}
+
+// summarizeLogs summarizes the logs as the destination keeps them, with times
+// truncated to its precision and without entries repeated at the same time
+func (t *Transfer) summarizeLogs(storage Storage) (Summary, error) {
+	type key struct {
+		level   logging.Level
+		message string
+	}
+
+	// every entry sharing a time is combined without depending on their order
+	h := sha256.New()
+	rows := 0
+	var last time.Time
+	seen := make(map[key]bool)
+	var group [sha256.Size]byte
+	flush := func() {
+		h.Write(group[:])
+		group = [sha256.Size]byte{}
+	}
+	err := storage.EachLog(logging.LevelDebug, transferStart, t.End, func(entry logging.LogEntry) error {
+		when := entry.When.Truncate(t.precision)
+		if !when.Equal(last) {
+			if len(seen) > 0 {
+				flush()
+			}
+			last = when
+			seen = make(map[key]bool)
+		}
+		k := key{level: entry.Level, message: entry.Message}
+		if seen[k] {
+			return nil
+		}
+		seen[k] = true
+		rows++
+
+		eh := sha256.New()
+		writeUint64(eh, uint64(when.UnixNano()))
+		writeUint64(eh, uint64(entry.Level))
+		eh.Write([]byte(entry.Message))
+		for i, b := range eh.Sum(nil) {
+			group[i] ^= b
+		}
+		return nil
+	})
+	if err != nil {
+		return Summary{}, fmt.Errorf("error summarizing logs: %v", err)
+	}
+	if len(seen) > 0 {
+		flush()
+	}
+	return Summary{Rows: rows, Checksum: hex.EncodeToString(h.Sum(nil))[:16]}, nil
+}
+
+func writeUint64(h hash.Hash, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	h.Write(b[:])
+}
@@ -0,0 +1,254 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/logging"
+)
+
+func transferTest(f func(t *testing.T, src, dst Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		src, err := NewSqliteStorage(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+
+		dst, err := NewSqliteStorage(":memory:")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+
+		f(t, src, dst)
+	}
+	return name, testFunc
+}
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+	t.Run("Transfer", func(t *testing.T) {
+		t.Parallel()
+		t.Run(transferTest(transfer_Copy))
+		t.Run(transferTest(transfer_Fake))
+		t.Run(transferTest(transfer_Resume))
+		t.Run(transferTest(transfer_Duplicates))
+		t.Run(transferTest(transfer_ResumeSharedTime))
+		t.Run(transferTest(transfer_VerifyMismatch))
+		t.Run(transferTest(transfer_Precision))
+	})
+}
+
+// fillTransferSource records stats of a few types and logs, spaced a second apart
+func fillTransferSource(t *testing.T, s Storage, base time.Time, count int) {
+	t.Helper()
+	var batch []Stat
+	var entries []logging.LogEntry
+	for i := 0; i < count; i++ {
+		when := base.Add(time.Duration(i) * time.Second)
+		batch = append(batch,
+			Stat{StatType: StatTypeTemperature, When: when, Value: 20 + float64(i)/10},
+			Stat{StatType: StatTypeHumidity, When: when, Value: 50 - float64(i)/10},
+		)
+		entries = append(entries, logging.LogEntry{Level: logging.LevelInfo, When: when, Message: "entry"})
+	}
+	if _, err := s.RecordBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RecordLogs(entries); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func transfer_Copy(t *testing.T, src, dst Storage) {
+	base := time.Now().Add(-time.Hour).Round(time.Second).Add(123456789)
+	fillTransferSource(t, src, base, 25)
+
+	transfer := NewTransfer(src, dst)
+	transfer.BatchSize = 7
+	if err := transfer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := FetchAll(dst, StatTypeTemperature, base.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 25 {
+		t.Fatalf("expected 25 stats, got %d", len(all))
+	}
+	if !all[0].When.Equal(base) || all[0].Value != 20 {
+		t.Errorf("unexpected first stat: %v", all[0])
+	}
+}
+
+func transfer_Fake(t *testing.T, src, _ Storage) {
+	base := time.Now().Add(-time.Hour)
+	fillTransferSource(t, src, base, 10)
+
+	dst := NewFakeStatsStorage(100)
+	transfer := NewTransfer(src, dst)
+	if err := transfer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func transfer_Resume(t *testing.T, src, dst Storage) {
+	base := time.Now().Add(-time.Hour)
+	fillTransferSource(t, src, base, 10)
+
+	first := NewTransfer(src, dst)
+	first.End = base.Add(4 * time.Second)
+	if err := first.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	second := NewTransfer(src, dst)
+	second.Progress = func(format string, args ...interface{}) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+	if err := second.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second run starts from the latest stat already copied
+	if messages[0] != "temperature: read 6, copied 5" {
+		t.Errorf("unexpected progress: %v", messages)
+	}
+}
+
+func transfer_Duplicates(t *testing.T, src, dst Storage) {
+	when := time.Now().Add(-time.Minute)
+	for _, value := range []float64{1, 2} {
+		if err := src.Record(Stat{StatType: StatTypeFan, When: when, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src.Log(logging.LevelWarn, "repeated")
+
+	for i := 0; i < 2; i++ {
+		transfer := NewTransfer(src, dst)
+		if err := transfer.Run(); err != nil {
+			t.Fatal(err)
+		}
+		if err := transfer.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// stats that share a time are all copied, once
+	all, err := FetchAll(dst, StatTypeFan, when.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Value != 1 || all[1].Value != 2 {
+		t.Errorf("unexpected stats: %v", all)
+	}
+}
+
+func transfer_ResumeSharedTime(t *testing.T, src, dst Storage) {
+	when := time.Now().Add(-time.Minute)
+	for _, value := range []float64{1, 2, 3} {
+		if err := src.Record(Stat{StatType: StatTypeFan, When: when, Value: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// interrupted after copying the first of the stats at the same time
+	if err := dst.Record(Stat{StatType: StatTypeFan, When: when, Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := NewTransfer(src, dst)
+	if err := transfer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := FetchAll(dst, StatTypeFan, when.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Value != 1 || all[1].Value != 2 || all[2].Value != 3 {
+		t.Errorf("unexpected stats: %v", all)
+	}
+}
+
+func transfer_VerifyMismatch(t *testing.T, src, dst Storage) {
+	base := time.Now().Add(-time.Hour)
+	fillTransferSource(t, src, base, 5)
+
+	transfer := NewTransfer(src, dst)
+	if err := transfer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Record(Stat{StatType: StatTypeHumidity, When: base.Add(-time.Minute), Value: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := transfer.Verify()
+	if err == nil || !strings.Contains(err.Error(), "humidity differs") {
+		t.Errorf("expected humidity to differ, got %v", err)
+	}
+}
+
+func transfer_Precision(t *testing.T, src, dst Storage) {
+	var messages []string
+	transfer := NewTransfer(src, dst)
+	transfer.precision = time.Microsecond
+	transfer.Progress = func(format string, args ...interface{}) {
+		messages = append(messages, fmt.Sprintf(format, args...))
+	}
+
+	when := time.Now().Add(-time.Minute).Truncate(time.Microsecond).Add(999)
+	for i, value := range []float64{1, 2} {
+		stat := Stat{StatType: StatTypeLight, When: when.Add(time.Duration(-i) * 500), Value: value}
+		if err := src.Record(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := transfer.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// both stats are kept at the same time once truncated
+	all, err := FetchAll(dst, StatTypeLight, when.Add(-time.Second), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[0].Value != 2 || all[1].Value != 1 {
+		t.Fatalf("unexpected stats: %v", all)
+	}
+	for _, stat := range all {
+		if !stat.When.Equal(when.Truncate(time.Microsecond)) {
+			t.Errorf("expected time truncated to microseconds, got %v", stat.When)
+		}
+	}
+	reported := false
+	for _, message := range messages {
+		reported = reported || message == "light: 1 rows share their time with an earlier row once truncated to 1µs"
+	}
+	if !reported {
+		t.Errorf("truncation was not reported: %v", messages)
+	}
+}
@@ -0,0 +1,194 @@
+package usage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+const (
+	// PeriodDay is a calendar day starting at midnight
+	PeriodDay Period = 1 + iota
+	// PeriodWeek is a calendar week starting on Monday
+	PeriodWeek
+	// PeriodMonth is a calendar month
+	PeriodMonth
+
+	// DefaultLookback is how far before a period the state of
+	// a unit at the start of the period is searched for
+	DefaultLookback = 7 * 24 * time.Hour
+)
+
+// Period is a span of the calendar that usage is accounted over
+type Period uint8
+
+// Periods is every Period, shortest first
+var Periods = []Period{PeriodDay, PeriodWeek, PeriodMonth}
+
+// Bounds returns the start and end of the Period containing t
+func (p Period) Bounds(t time.Time) (time.Time, time.Time) {
+	year, month, day := t.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	switch p {
+	case PeriodWeek:
+		// time.Sunday is 0, weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		start := midnight.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		start := time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return midnight, midnight.AddDate(0, 0, 1)
+	}
+}
+
+func (p Period) String() string {
+	switch p {
+	case PeriodDay:
+		return "day"
+	case PeriodWeek:
+		return "week"
+	case PeriodMonth:
+		return "month"
+	default:
+		return "unknown"
+	}
+}
+
+// Usage is how long a unit was on during a span of time
+type Usage struct {
+	Start  time.Time
+	End    time.Time
+	OnTime time.Duration
+	// Liters is the estimated volume used, if the unit has a flow rate
+	Liters float64
+}
+
+// Accountant computes how long units were on from their on/off
+// transitions, which are recorded as stats of the unit's StatType
+// with a value above zero for on and zero for off
+type Accountant struct {
+	storage stats.Storage
+
+	// Lookback is how far before a span of time the state
+	// of a unit at its start is searched for. Units with no
+	// transition within the Lookback are assumed to be off.
+	Lookback time.Duration
+
+	mu        *sync.RWMutex
+	flowRates map[stats.StatType]float64
+}
+
+// NewAccountant creates an Accountant reading transitions from storage
+func NewAccountant(storage stats.Storage) *Accountant {
+	return &Accountant{
+		storage:   storage,
+		Lookback:  DefaultLookback,
+		mu:        &sync.RWMutex{},
+		flowRates: make(map[stats.StatType]float64),
+	}
+}
+
+// SetFlowRate sets the liters per minute a unit uses while it is on
+func (a *Accountant) SetFlowRate(unit stats.StatType, litersPerMinute float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.flowRates[unit] = litersPerMinute
+}
+
+// FlowRate returns the liters per minute a unit uses
+// while it is on, and whether or not it is known
+func (a *Accountant) FlowRate(unit stats.StatType) (float64, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	rate, ok := a.flowRates[unit]
+	return rate, ok
+}
+
+// Period returns the usage of a unit during the Period containing now,
+// up until now. A unit that is still on counts as on until now.
+func (a *Accountant) Period(unit stats.StatType, period Period, now time.Time) (Usage, error) {
+	start, end := period.Bounds(now)
+	if end.After(now) {
+		end = now
+	}
+	return a.Usage(unit, start, end)
+}
+
+// Usage returns the usage of a unit between start and end. A unit
+// that was on at the start or is still on at the end is counted as
+// on from the start or until the end, respectively.
+func (a *Accountant) Usage(unit stats.StatType, start, end time.Time) (Usage, error) {
+	on, err := a.stateAt(unit, start)
+	if err != nil {
+		return Usage{}, err
+	}
+	transitions, err := a.transitions(unit, start, end)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	usage := Usage{
+		Start:  start,
+		End:    end,
+		OnTime: onTime(on, transitions, start, end),
+	}
+	if rate, ok := a.FlowRate(unit); ok {
+		usage.Liters = usage.OnTime.Minutes() * rate
+	}
+	return usage, nil
+}
+
+// stateAt returns whether or not a unit was on just before t
+func (a *Accountant) stateAt(unit stats.StatType, t time.Time) (bool, error) {
+	before, err := a.transitions(unit, t.Add(-a.Lookback), t)
+	if err != nil {
+		return false, err
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		if before[i].When.Before(t) {
+			return before[i].Value > 0, nil
+		}
+	}
+	return false, nil
+}
+
+// transitions returns every transition of a unit
+// between start and end in chronological order
+func (a *Accountant) transitions(unit stats.StatType, start, end time.Time) ([]stats.Stat, error) {
+	transitions, err := stats.FetchAll(a.storage, unit, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching transitions: %v", err)
+	}
+	return transitions, nil
+}
+
+// onTime sums the time a unit was on between start and end given its
+// state at the start and its transitions in chronological order
+func onTime(on bool, transitions []stats.Stat, start, end time.Time) time.Duration {
+	var total time.Duration
+	since := start
+	for _, transition := range transitions {
+		when := transition.When
+		if when.Before(start) {
+			when = start
+		}
+		if when.After(end) {
+			break
+		}
+		if on {
+			total += when.Sub(since)
+		}
+		on = transition.Value > 0
+		since = when
+	}
+	if on && end.After(since) {
+		total += end.Sub(since)
+	}
+	return total
+}
@@ -0,0 +1,241 @@
+package usage
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/explodes/greenhouse-pi/clock"
+	"github.com/explodes/greenhouse-pi/controllers"
+	"github.com/explodes/greenhouse-pi/stats"
+)
+
+var (
+	// a Wednesday
+	usageNow = time.Date(2018, time.March, 14, 12, 0, 0, 0, time.UTC)
+)
+
+func accountantTest(f func(t *testing.T, a *Accountant, storage stats.Storage)) (string, func(*testing.T)) {
+	name := testFunctionName(f)
+	testFunc := func(t *testing.T) {
+		t.Parallel()
+
+		storage := stats.NewFakeStatsStorage(100)
+		defer storage.Close()
+
+		f(t, NewAccountant(storage), storage)
+	}
+	return name, testFunc
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+	t.Run("Period", func(t *testing.T) {
+		t.Parallel()
+		t.Run("Bounds", period_Bounds)
+	})
+	t.Run("Accountant", func(t *testing.T) {
+		t.Parallel()
+		t.Run(accountantTest(accountant_NoTransitions))
+		t.Run(accountantTest(accountant_OnOff))
+		t.Run(accountantTest(accountant_OnAtStart))
+		t.Run(accountantTest(accountant_StillOn))
+		t.Run(accountantTest(accountant_FlowRate))
+		t.Run(accountantTest(accountant_Periods))
+		t.Run(accountantTest(accountant_Controller))
+	})
+}
+
+func record(t *testing.T, storage stats.Storage, unit stats.StatType, when time.Time, value float64) {
+	if err := storage.Record(stats.Stat{StatType: unit, When: when, Value: value}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func period_Bounds(t *testing.T) {
+	for _, tc := range []struct {
+		period Period
+		start  time.Time
+		end    time.Time
+	}{
+		{PeriodDay, time.Date(2018, time.March, 14, 0, 0, 0, 0, time.UTC), time.Date(2018, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{PeriodWeek, time.Date(2018, time.March, 12, 0, 0, 0, 0, time.UTC), time.Date(2018, time.March, 19, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonth, time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, time.April, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		start, end := tc.period.Bounds(usageNow)
+		if !start.Equal(tc.start) || !end.Equal(tc.end) {
+			t.Errorf("%s: expected %s - %s, got %s - %s", tc.period, tc.start, tc.end, start, end)
+		}
+	}
+
+	// sunday belongs to the week that started the monday before
+	sunday := time.Date(2018, time.March, 18, 23, 0, 0, 0, time.UTC)
+	if start, _ := PeriodWeek.Bounds(sunday); !start.Equal(time.Date(2018, time.March, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected start of week: %s", start)
+	}
+}
+
+func accountant_NoTransitions(t *testing.T, a *Accountant, storage stats.Storage) {
+	u, err := a.Usage(stats.StatTypeWater, usageNow.Add(-time.Hour), usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OnTime != 0 {
+		t.Errorf("unexpected on-time: %s", u.OnTime)
+	}
+}
+
+func accountant_OnOff(t *testing.T, a *Accountant, storage stats.Storage) {
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-50*time.Minute), 1)
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-40*time.Minute), 0)
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-30*time.Minute), 1)
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-25*time.Minute), 0)
+	// other units are not counted
+	record(t, storage, stats.StatTypeFan, usageNow.Add(-50*time.Minute), 1)
+
+	u, err := a.Usage(stats.StatTypeWater, usageNow.Add(-time.Hour), usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OnTime != 15*time.Minute {
+		t.Errorf("unexpected on-time: %s", u.OnTime)
+	}
+}
+
+func accountant_OnAtStart(t *testing.T, a *Accountant, storage stats.Storage) {
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-2*time.Hour), 1)
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-50*time.Minute), 0)
+
+	u, err := a.Usage(stats.StatTypeWater, usageNow.Add(-time.Hour), usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OnTime != 10*time.Minute {
+		t.Errorf("unexpected on-time: %s", u.OnTime)
+	}
+}
+
+func accountant_StillOn(t *testing.T, a *Accountant, storage stats.Storage) {
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-20*time.Minute), 1)
+
+	u, err := a.Usage(stats.StatTypeWater, usageNow.Add(-time.Hour), usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OnTime != 20*time.Minute {
+		t.Errorf("unexpected on-time: %s", u.OnTime)
+	}
+}
+
+func accountant_FlowRate(t *testing.T, a *Accountant, storage stats.Storage) {
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-20*time.Minute), 1)
+	record(t, storage, stats.StatTypeWater, usageNow.Add(-10*time.Minute), 0)
+
+	if _, ok := a.FlowRate(stats.StatTypeWater); ok {
+		t.Fatal("unexpected flow rate")
+	}
+	a.SetFlowRate(stats.StatTypeWater, 2.5)
+
+	u, err := a.Usage(stats.StatTypeWater, usageNow.Add(-time.Hour), usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(u.Liters-25) > 1e-9 {
+		t.Errorf("unexpected liters: %g", u.Liters)
+	}
+}
+
+func accountant_Periods(t *testing.T, a *Accountant, storage stats.Storage) {
+	// on across midnight into today, and once earlier in the week
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.March, 12, 8, 0, 0, 0, time.UTC), 1)
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.March, 12, 8, 30, 0, 0, time.UTC), 0)
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.March, 13, 23, 0, 0, 0, time.UTC), 1)
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.March, 14, 1, 0, 0, 0, time.UTC), 0)
+	// last month
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.February, 28, 8, 0, 0, 0, time.UTC), 1)
+	record(t, storage, stats.StatTypeWater, time.Date(2018, time.February, 28, 9, 0, 0, 0, time.UTC), 0)
+
+	for _, tc := range []struct {
+		period   Period
+		expected time.Duration
+	}{
+		{PeriodDay, time.Hour},
+		{PeriodWeek, 2*time.Hour + 30*time.Minute},
+		{PeriodMonth, 2*time.Hour + 30*time.Minute},
+	} {
+		u, err := a.Period(stats.StatTypeWater, tc.period, usageNow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.OnTime != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.period, tc.expected, u.OnTime)
+		}
+		if !u.End.Equal(usageNow) {
+			t.Errorf("%s: period should end now, got %s", tc.period, u.End)
+		}
+	}
+}
+
+// relayUnit is a Unit that records nothing itself, like real hardware
+type relayUnit struct {
+	on       bool
+	switched chan bool
+}
+
+func (u *relayUnit) Name() string {
+	return stats.StatTypeWater.String()
+}
+
+func (u *relayUnit) On() error {
+	u.on = true
+	u.switched <- true
+	return nil
+}
+
+func (u *relayUnit) Off() error {
+	u.on = false
+	u.switched <- false
+	return nil
+}
+
+func (u *relayUnit) Status() (controllers.UnitStatus, error) {
+	if u.on {
+		return controllers.UnitStatusOn, nil
+	}
+	return controllers.UnitStatusOff, nil
+}
+
+func (u *relayUnit) Close() error {
+	return nil
+}
+
+func accountant_Controller(t *testing.T, a *Accountant, storage stats.Storage) {
+	start := usageNow.Add(-time.Hour)
+	manual := clock.NewManual(start)
+	scheduler := controllers.NewScheduler()
+	scheduler.Clock = manual
+	defer scheduler.CancelAll()
+	unit := &relayUnit{switched: make(chan bool, 1)}
+	c, err := controllers.NewController(unit, storage, scheduler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.TurnUnitOn(controllers.APICause("test"), 10*time.Minute, 20*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	manual.Advance(10 * time.Minute)
+	<-unit.switched
+	manual.Advance(20 * time.Minute)
+	<-unit.switched
+	// the transition is recorded while the Controller is locked
+	c.IsOn()
+
+	u, err := a.Usage(stats.StatTypeWater, start, usageNow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OnTime != 20*time.Minute {
+		t.Errorf("unexpected on-time: %s", u.OnTime)
+	}
+}